@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/rahveiz/topomate/frr"
+	"github.com/rahveiz/topomate/project"
+	"github.com/rahveiz/topomate/utils"
+	"github.com/spf13/cobra"
+)
+
+// applyCmd represents the apply command
+var applyCmd = &cobra.Command{
+	Use:   "apply [config file]",
+	Short: "Apply the changes of a configuration file on a running topology",
+	Long: `Compare the configuration file with the running topology and apply only the
+differences: containers and links are added or removed, and the FRR
+configuration of changed routers is reloaded without restarting them
+(using frr-reload.py, or vtysh -b if it is not available).`,
+	Run: func(cmd *cobra.Command, args []string) {
+		old, err := project.LoadState()
+		if err != nil {
+			utils.Fatalln(err)
+		}
+		p := getConfig(cmd, args)
+		noGenerate, _ := cmd.Flags().GetBool("no-generate")
+
+		var configs [][]*frr.FRRConfig
+		var st *project.RunningState
+		if noGenerate {
+			st = p.State(nil)
+		} else {
+			configs = frr.GenerateConfig(p)
+			st = p.State(frr.RenderAll(configs))
+		}
+
+		plan := project.ComputePlan(old, st)
+		plan.Write(os.Stdout)
+		if onlyPlan, _ := cmd.Flags().GetBool("plan"); onlyPlan || plan.Empty() {
+			return
+		}
+
+		if !noGenerate {
+			frr.WriteAll(configs)
+		}
+		if err := p.ApplyPlan(plan, st); err != nil {
+			utils.Fatalln(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(applyCmd)
+	applyCmd.Flags().StringP("project", "p", "", "Project name")
+	applyCmd.Flags().Bool("plan", false, "Only display the changes")
+	applyCmd.Flags().Bool("no-generate", false, "Use the configuration files present in the configuration directory")
+}
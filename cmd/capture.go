@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/rahveiz/topomate/internal/link"
+	"github.com/rahveiz/topomate/utils"
+	"github.com/spf13/cobra"
+)
+
+// captureCmd represents the capture command
+var captureCmd = &cobra.Command{
+	Use:   "capture <node>[:<interface>] [config file]",
+	Short: "Capture the packets of a link",
+	Long: `Capture the packets of an interface of a running topology with tcpdump.
+The capture is done on the host side of the veth pair, or inside the
+network namespace of the container for direct veth pairs (veth backend).
+The interface is only needed if the node has more than one link.
+
+With --mirror, all the traffic of a bridge (an IXP bridge ixp-<asn> for
+example) is captured. OVS bridges are mirrored to a temporary port, which
+is removed when the capture stops.
+
+Examples:
+  topomate capture AS1-R1:eth0 topo.yml -w r1.pcap --filter "tcp port 179"
+  topomate capture --mirror ixp-65000 -w - | wireshark -k -i -`,
+	Run: func(cmd *cobra.Command, args []string) {
+		bridge, _ := cmd.Flags().GetString("mirror")
+		file, _ := cmd.Flags().GetString("write")
+		filter, _ := cmd.Flags().GetString("filter")
+		opts := link.CaptureOptions{
+			File:   file,
+			Filter: filter,
+			Output: os.Stdout,
+			Errors: os.Stderr,
+		}
+
+		ctx, cancel := interruptContext()
+		defer cancel()
+
+		if bridge != "" {
+			m, err := link.ReadSaved()
+			if err != nil {
+				utils.Fatalln(err)
+			}
+			backend, err := link.SegmentBackend(m, bridge)
+			if err != nil {
+				utils.Fatalln(err)
+			}
+			if err := link.CaptureBridge(ctx, bridge, backend, opts); err != nil {
+				utils.Fatalln(err)
+			}
+			return
+		}
+
+		if len(args) == 0 {
+			utils.Fatalln("No interface specified (<node>[:<interface>] or --mirror <bridge>)")
+		}
+		p := getConfig(cmd, args[1:])
+		port, err := p.InterfacePort(args[0])
+		if err != nil {
+			utils.Fatalln(err)
+		}
+		if err := link.Capture(ctx, port, opts); err != nil {
+			utils.Fatalln(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(captureCmd)
+	captureCmd.Flags().StringP("project", "p", "", "Project name")
+	captureCmd.Flags().StringP("write", "w", "", "Write the packets to a pcap file (- for the standard output)")
+	captureCmd.Flags().StringP("filter", "f", "", "BPF filter expression")
+	captureCmd.Flags().String("mirror", "", "Capture all the traffic of a bridge")
+}
@@ -16,27 +16,32 @@ limitations under the License.
 package cmd
 
 import (
-	"context"
 	"fmt"
 	"os"
 	"sync"
 
 	"github.com/rahveiz/topomate/internal/ovsdocker"
 
-	"github.com/digitalocean/go-openvswitch/ovs"
 	"github.com/rahveiz/topomate/config"
+	"github.com/rahveiz/topomate/project"
 	"github.com/rahveiz/topomate/utils"
 
-	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/client"
+	"github.com/rahveiz/topomate/internal/runtime"
 	"github.com/spf13/cobra"
 )
 
 // cleanupCmd represents the cleanup command
 var cleanupCmd = &cobra.Command{
-	Use:   "cleanup",
+	Use:   "cleanup [config file]",
 	Short: "Removes elements created by topomate (interfaces, containers).",
+	Long: `Removes elements created by topomate (interfaces, containers).
+If a project is specified, only the resources recorded by a start of the
+project which did not complete are removed.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		if cmd.Flags().Changed("project") || len(args) > 0 {
+			cleanJournal(getConfig(cmd, args).Name)
+			return
+		}
 		cleanContainers()
 		cleanOVS()
 	},
@@ -44,16 +49,24 @@ var cleanupCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(cleanupCmd)
+	cleanupCmd.Flags().StringP("project", "p", "", "Project name")
 }
 
-func cleanContainers() {
-	ctx := context.Background()
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-	if err != nil {
-		panic(err)
+func cleanJournal(name string) {
+	if _, err := os.Stat(project.JournalFile(name)); os.IsNotExist(err) {
+		fmt.Println("Nothing to clean up for", name)
+		return
+	}
+	fmt.Println("Removing the resources recorded for", name+"...")
+	if err := project.CleanupJournal(name); err != nil {
+		utils.Fatalln(err)
 	}
+	fmt.Println("Done.")
+}
 
-	containers, err := cli.ContainerList(ctx, types.ContainerListOptions{})
+func cleanContainers() {
+	rt := runtime.Current()
+	containers, err := rt.List(nil)
 	if err != nil {
 		panic(err)
 	}
@@ -61,17 +74,17 @@ func cleanContainers() {
 	fmt.Println("Stopping and removing containers...")
 	var wg sync.WaitGroup
 	for _, container := range containers {
-		if container.Image == config.DockerRSImage || container.Image == config.DockerRouterImage {
+		// containers created by older versions have no label
+		if container.Labels[runtime.LabelManaged] == "true" ||
+			runtime.ImageName(container.Image) == config.DockerRSImage ||
+			runtime.ImageName(container.Image) == config.DockerRouterImage {
 			wg.Add(1)
-			go func(w *sync.WaitGroup, id string) {
-				if err := cli.ContainerStop(ctx, id, nil); err != nil {
-					fmt.Fprintln(os.Stderr, err)
-				}
-				if err := cli.ContainerRemove(ctx, id, types.ContainerRemoveOptions{}); err != nil {
+			go func(w *sync.WaitGroup, name string) {
+				if err := rt.Remove(name); err != nil {
 					fmt.Fprintln(os.Stderr, err)
 				}
 				w.Done()
-			}(&wg, container.ID)
+			}(&wg, container.Name)
 		}
 
 	}
@@ -80,7 +93,7 @@ func cleanContainers() {
 }
 
 func cleanOVS() {
-	cli := ovs.New(ovs.Sudo())
+	cli := utils.OVSClient()
 	bridges, err := cli.VSwitch.ListBridges()
 	if err != nil {
 		utils.Fatalln(err)
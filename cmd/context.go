@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/rahveiz/topomate/config"
+	"github.com/spf13/cobra"
+)
+
+// interruptContext returns a context cancelled on the first Ctrl-C, the
+// second one terminates the program
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-c:
+			fmt.Fprintln(os.Stderr, "Interrupted, waiting for running tasks (Ctrl-C again to abort)")
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(c)
+	}()
+	return ctx, cancel
+}
+
+// addJobsFlag adds the flag setting the number of containers handled at
+// the same time
+func addJobsFlag(cmd *cobra.Command) {
+	cmd.Flags().IntVarP(&config.Jobs, "jobs", "j", 8, "Maximum number of containers handled at the same time (0 for no limit)")
+}
@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rahveiz/topomate/internal/convergence"
+	"github.com/rahveiz/topomate/internal/link"
+	"github.com/rahveiz/topomate/project"
+	"github.com/rahveiz/topomate/utils"
+	"github.com/spf13/cobra"
+)
+
+// convergenceCmd represents the convergence command
+var convergenceCmd = &cobra.Command{
+	Use:   "convergence",
+	Short: "Measure the convergence time after an event",
+	Long: `Trigger an event on a running topology and measure the convergence
+time of each router. FIB changes are recorded with "ip monitor route" in the
+network namespace of each router, and the network is considered converged
+when no change has been seen for the quiet period. The number of BGP updates
+received during the measurement is also reported.`,
+}
+
+var convergenceLinkDownCmd = &cobra.Command{
+	Use:   "link-down <endpoint-a> <endpoint-b> [config file]",
+	Short: "Bring a link down and measure the convergence",
+	Args:  cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		p := getConfig(cmd, args[2:])
+		method := getLinkMethod(cmd)
+		a, b, flows, err := p.LinkPorts(args[0], args[1])
+		if err != nil {
+			utils.Fatalln(err)
+		}
+		measureConvergence(cmd, p, "link down "+a.String()+" <-> "+b.String(), func() error {
+			return link.Down(a, b, flows, method)
+		})
+	},
+}
+
+var convergenceLinkUpCmd = &cobra.Command{
+	Use:   "link-up <endpoint-a> <endpoint-b> [config file]",
+	Short: "Bring a link back up and measure the convergence",
+	Args:  cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		p := getConfig(cmd, args[2:])
+		a, b, _, err := p.LinkPorts(args[0], args[1])
+		if err != nil {
+			utils.Fatalln(err)
+		}
+		measureConvergence(cmd, p, "link up "+a.String()+" <-> "+b.String(), func() error {
+			return link.Up(a, b)
+		})
+	},
+}
+
+var convergenceRestartCmd = &cobra.Command{
+	Use:   "restart <node> [config file]",
+	Short: "Restart a node and measure the convergence",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		p := getConfig(cmd, args[1:])
+		n, ok := p.FindNode(args[0])
+		if !ok {
+			utils.Fatalf("node %s not found\n", args[0])
+		}
+		measureConvergence(cmd, p, "restart "+n.ContainerName, func() error {
+			return link.RestartContainer(n.ContainerName)
+		})
+	},
+}
+
+var convergenceVtyshCmd = &cobra.Command{
+	Use:   "vtysh <node> [config file] -- <command>...",
+	Short: "Run vtysh commands on a router and measure the convergence",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dash := cmd.ArgsLenAtDash()
+		if dash < 1 || dash == len(args) {
+			utils.Fatalln("usage: topomate convergence vtysh <node> [config file] -- <command>...")
+		}
+		p := getConfig(cmd, args[1:dash])
+		n, ok := p.FindNode(args[0])
+		if !ok {
+			utils.Fatalf("node %s not found\n", args[0])
+		}
+		commands := args[dash:]
+		measureConvergence(cmd, p, "vtysh "+strings.Join(commands, "; "), func() error {
+			res := n.Vtysh(commands...)
+			if res.Failed() {
+				return fmt.Errorf("%s: %s %v", n.ContainerName, strings.TrimSpace(res.Output), res.Err)
+			}
+			return nil
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(convergenceCmd)
+	convergenceCmd.AddCommand(convergenceLinkDownCmd, convergenceLinkUpCmd,
+		convergenceRestartCmd, convergenceVtyshCmd)
+	for _, c := range convergenceCmd.Commands() {
+		c.Flags().StringP("project", "p", "", "Project name")
+		c.Flags().Duration("quiet", convergence.DefaultQuiet, "Time without FIB changes after which the network is converged")
+		c.Flags().Duration("timeout", convergence.DefaultTimeout, "Maximum duration of the measurement")
+		c.Flags().Bool("json", false, "Output the report in JSON format")
+		addNodeFilterFlags(c)
+	}
+	convergenceLinkDownCmd.Flags().StringP("method", "m", link.MethodVeth, "Method used to bring the link down (veth|flow)")
+}
+
+// measureConvergence measures the convergence of the selected routers after
+// the event triggered by trigger and displays the report
+func measureConvergence(cmd *cobra.Command, p *project.Project, event string, trigger func() error) {
+	opts := convergence.DefaultOptions()
+	opts.Quiet, _ = cmd.Flags().GetDuration("quiet")
+	opts.Timeout, _ = cmd.Flags().GetDuration("timeout")
+
+	report, err := convergence.Measure(p.SelectNodes(getNodeFilter(cmd)), event, trigger, opts)
+	if err != nil {
+		utils.Fatalln(err)
+	}
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		if err := report.WriteJSON(os.Stdout); err != nil {
+			utils.Fatalln(err)
+		}
+	} else {
+		report.Write(os.Stdout)
+	}
+	if !report.Converged {
+		os.Exit(1)
+	}
+}
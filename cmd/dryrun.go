@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/rahveiz/topomate/utils"
+	"github.com/spf13/cobra"
+)
+
+// addDryRunFlags adds the flags used to display the operations of a command
+// instead of running them
+func addDryRunFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("dry-run", false, "Display the Docker, ip and OVS operations without running them")
+	cmd.Flags().Bool("json", false, "Output the operations of --dry-run in JSON format")
+}
+
+// startDryRun replaces the runner by a recorder if --dry-run is set, and
+// returns nil otherwise
+func startDryRun(cmd *cobra.Command) *utils.Recorder {
+	if dry, _ := cmd.Flags().GetBool("dry-run"); !dry {
+		return nil
+	}
+	rec := utils.NewRecorder()
+	utils.SetRunner(rec)
+	return rec
+}
+
+// writeDryRun displays the operations recorded
+func writeDryRun(cmd *cobra.Command, rec *utils.Recorder) {
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		if err := rec.WriteJSON(os.Stdout); err != nil {
+			utils.Fatalln(err)
+		}
+		return
+	}
+	rec.Write(os.Stdout)
+}
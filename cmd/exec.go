@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rahveiz/topomate/project"
+	"github.com/rahveiz/topomate/utils"
+	"github.com/spf13/cobra"
+)
+
+// execCmd represents the exec command
+var execCmd = &cobra.Command{
+	Use:   "exec [config file] -- <command>",
+	Short: "Execute a command on multiple nodes",
+	Long: `Execute a shell or vtysh command in parallel on the selected nodes of a
+running project. Nodes can be selected by AS, by role (P, PE, CE, RS, host)
+or by name using glob patterns. Without any selector, all nodes are used.
+
+Example:
+  topomate exec topo.yml --as 1 --role PE --vtysh -- show ip bgp summary`,
+	Run: func(cmd *cobra.Command, args []string) {
+		dash := cmd.ArgsLenAtDash()
+		if dash < 0 {
+			utils.Fatalln("No command specified (use -- before the command)")
+		}
+		command := args[dash:]
+		if len(command) == 0 {
+			utils.Fatalln("No command specified")
+		}
+		p := getConfig(cmd, args[:dash])
+		nodes := p.SelectNodes(getNodeFilter(cmd))
+		if len(nodes) == 0 {
+			utils.Fatalln("No node matching the selection")
+		}
+
+		vtysh, _ := cmd.Flags().GetBool("vtysh")
+		asJSON, _ := cmd.Flags().GetBool("json")
+		parallel, _ := cmd.Flags().GetInt("parallel")
+
+		res := project.ExecAll(nodes, parallel, func(n project.Node) project.ExecResult {
+			if vtysh {
+				return n.Vtysh(strings.Join(command, " "))
+			}
+			return n.Exec(command...)
+		})
+
+		failed := 0
+		if asJSON {
+			failed = printExecJSON(res)
+		} else {
+			printExecText(res)
+		}
+
+		if printExecSummary(res) > 0 || failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(execCmd)
+	execCmd.Flags().StringP("project", "p", "", "Project name")
+	addNodeFilterFlags(execCmd)
+	execCmd.Flags().BoolP("vtysh", "s", false, "Run the command with vtysh")
+	execCmd.Flags().Bool("json", false, "Parse the output of each node as JSON and print a single JSON document")
+	execCmd.Flags().Int("parallel", project.DefaultParallelism, "Maximum number of commands run at the same time")
+}
+
+// addNodeFilterFlags adds the flags used to select nodes of a project
+func addNodeFilterFlags(cmd *cobra.Command) {
+	cmd.Flags().IntSlice("as", nil, "Select nodes by AS number")
+	cmd.Flags().StringSlice("role", nil, "Select nodes by role (P, PE, CE, RS, host)")
+	cmd.Flags().StringSlice("name", nil, "Select nodes by container name or hostname (glob patterns allowed)")
+}
+
+// getNodeFilter returns the filter corresponding to the flags added by
+// addNodeFilterFlags
+func getNodeFilter(cmd *cobra.Command) project.NodeFilter {
+	asn, err := cmd.Flags().GetIntSlice("as")
+	if err != nil {
+		utils.Fatalln(err)
+	}
+	roles, err := cmd.Flags().GetStringSlice("role")
+	if err != nil {
+		utils.Fatalln(err)
+	}
+	names, err := cmd.Flags().GetStringSlice("name")
+	if err != nil {
+		utils.Fatalln(err)
+	}
+	return project.NodeFilter{
+		ASN:      asn,
+		Roles:    roles,
+		Patterns: names,
+	}
+}
+
+func printExecText(res []project.ExecResult) {
+	for _, r := range res {
+		fmt.Printf("===== %s (exit %d) =====\n", r.Node, r.ExitCode)
+		if r.Err != nil {
+			fmt.Println(r.Err)
+		}
+		fmt.Print(r.Output)
+		if !strings.HasSuffix(r.Output, "\n") {
+			fmt.Println()
+		}
+	}
+}
+
+type execJSONResult struct {
+	project.ExecResult
+	Error string `json:"error,omitempty"`
+}
+
+// printExecJSON prints the results as a JSON document and returns the number
+// of successful commands whose output is not valid JSON
+func printExecJSON(res []project.ExecResult) int {
+	invalid := 0
+	out := make([]execJSONResult, len(res))
+	for i, r := range res {
+		out[i].ExecResult = r
+		switch {
+		case r.Err != nil:
+			out[i].Error = r.Err.Error()
+		case r.ExitCode == 0:
+			if err := out[i].ParseJSON(); err != nil {
+				utils.PrintError(err)
+				out[i].Error = err.Error()
+				invalid++
+				continue
+			}
+			out[i].Output = ""
+		}
+	}
+	j, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		utils.Fatalln(err)
+	}
+	fmt.Println(string(j))
+	return invalid
+}
+
+// printExecSummary prints the nodes on which the command failed and returns
+// their number
+func printExecSummary(res []project.ExecResult) int {
+	failed := 0
+	for _, r := range res {
+		if r.Failed() {
+			failed++
+		}
+	}
+	if failed == 0 {
+		return 0
+	}
+	utils.PrintError(fmt.Sprintf("\n%d/%d node(s) failed:", failed, len(res)))
+	for _, r := range res {
+		if !r.Failed() {
+			continue
+		}
+		if r.Err != nil {
+			utils.PrintError(" ", r.Node+":", r.Err)
+		} else {
+			utils.PrintError(" ", r.Node+": exit code", r.ExitCode)
+		}
+	}
+	return failed
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rahveiz/topomate/internal/exporter"
+	"github.com/rahveiz/topomate/internal/monitor"
+	"github.com/rahveiz/topomate/project"
+	"github.com/rahveiz/topomate/utils"
+	"github.com/spf13/cobra"
+)
+
+// exporterCmd represents the exporter command
+var exporterCmd = &cobra.Command{
+	Use:   "exporter [config file]",
+	Short: "Serve the state of a running topology as Prometheus metrics",
+	Long: `Serve the metrics of a running topology on /metrics in the Prometheus text
+format until Ctrl-C is pressed. The routers are queried at each scrape:
+state, uptime and prefixes of the BGP sessions, RIB sizes, OSPF and IS-IS
+adjacencies, LDP sessions and RPKI cache connection. The counters of the
+linked interfaces are also exported (use --source none to disable them).
+
+Example:
+  topomate exporter topo.yml --listen 127.0.0.1:9273 --as 1`,
+	Run: func(cmd *cobra.Command, args []string) {
+		p := getConfig(cmd, args)
+		nodes := p.SelectNodes(getNodeFilter(cmd))
+		if len(nodes) == 0 {
+			utils.Fatalln("No node matching the selection")
+		}
+		addr, _ := cmd.Flags().GetString("listen")
+		source, _ := cmd.Flags().GetString("source")
+		switch source {
+		case "none":
+			source = ""
+		case monitor.SourceSysfs, monitor.SourceOVS:
+		default:
+			utils.Fatalf("Unknown counter source %s (sysfs, ovs or none)\n", source)
+		}
+		parallel, _ := cmd.Flags().GetInt("parallel")
+		maxAge, _ := cmd.Flags().GetDuration("max-age")
+
+		e := &exporter.Exporter{
+			Project:  p,
+			Nodes:    nodes,
+			Source:   source,
+			Parallel: parallel,
+			MaxAge:   maxAge,
+		}
+		srv := &http.Server{Addr: addr, Handler: e.Handler()}
+
+		ctx, cancel := interruptContext()
+		defer cancel()
+		go func() {
+			<-ctx.Done()
+			shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			srv.Shutdown(shutdown)
+		}()
+
+		fmt.Printf("Exporting the metrics of %d node(s) on http://%s/metrics (Ctrl-C to stop)\n", len(nodes), addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			utils.Fatalln(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(exporterCmd)
+	exporterCmd.Flags().StringP("project", "p", "", "Project name")
+	addNodeFilterFlags(exporterCmd)
+	exporterCmd.Flags().String("listen", exporter.DefaultAddress, "Listening address")
+	exporterCmd.Flags().String("source", monitor.SourceSysfs, "Source of the interface counters (sysfs, ovs or none)")
+	exporterCmd.Flags().Int("parallel", project.DefaultParallelism, "Maximum number of nodes queried at the same time")
+	exporterCmd.Flags().Duration("max-age", time.Second, "Reuse the metrics collected less than this duration ago")
+}
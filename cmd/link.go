@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rahveiz/topomate/internal/link"
+	"github.com/rahveiz/topomate/utils"
+	"github.com/spf13/cobra"
+)
+
+// linkCmd represents the link command
+var linkCmd = &cobra.Command{
+	Use:   "link",
+	Short: "Bring links of a running topology down or up",
+	Long: `Simulate link failures on a running topology. Endpoints use the format
+<node>[:<interface>], where node is a container name or a hostname. The
+interface is only needed if the two nodes are linked more than once.
+
+Two methods are available:
+  veth  the host side of the veth pairs is set down (the containers lose
+        the carrier on their interface)
+  flow  the traffic is dropped on the OVS bridge (the interfaces stay up
+        and the failure is detected by the protocols timers)`,
+}
+
+var linkDownCmd = &cobra.Command{
+	Use:   "down <endpoint-a> <endpoint-b> [config file]",
+	Short: "Bring a link down",
+	Args:  cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		a, b, flows := resolveLink(cmd, args)
+		method := getLinkMethod(cmd)
+		if err := link.Down(a, b, flows, method); err != nil {
+			utils.Fatalln(err)
+		}
+		fmt.Printf("%s <-> %s down (%s)\n", a, b, method)
+	},
+}
+
+var linkUpCmd = &cobra.Command{
+	Use:   "up <endpoint-a> <endpoint-b> [config file]",
+	Short: "Bring a link back up",
+	Args:  cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		a, b, _ := resolveLink(cmd, args)
+		if err := link.Up(a, b); err != nil {
+			utils.Fatalln(err)
+		}
+		fmt.Printf("%s <-> %s up\n", a, b)
+	},
+}
+
+var linkFlapCmd = &cobra.Command{
+	Use:   "flap <endpoint-a> <endpoint-b> [config file]",
+	Short: "Bring a link down and up again",
+	Args:  cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		a, b, flows := resolveLink(cmd, args)
+		method := getLinkMethod(cmd)
+		down, _ := cmd.Flags().GetDuration("down")
+		interval, _ := cmd.Flags().GetDuration("interval")
+		count, _ := cmd.Flags().GetInt("count")
+		for i := 0; i < count; i++ {
+			if i > 0 {
+				time.Sleep(interval)
+			}
+			if err := link.Down(a, b, flows, method); err != nil {
+				utils.Fatalln(err)
+			}
+			fmt.Printf("%s <-> %s down (%s)\n", a, b, method)
+			time.Sleep(down)
+			if err := link.Up(a, b); err != nil {
+				utils.Fatalln(err)
+			}
+			fmt.Printf("%s <-> %s up\n", a, b)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(linkCmd)
+	linkCmd.AddCommand(linkDownCmd, linkUpCmd, linkFlapCmd)
+	for _, c := range linkCmd.Commands() {
+		c.Flags().StringP("project", "p", "", "Project name")
+	}
+	for _, c := range []*cobra.Command{linkDownCmd, linkFlapCmd} {
+		c.Flags().StringP("method", "m", link.MethodVeth, "Method used to bring the link down (veth|flow)")
+	}
+	linkFlapCmd.Flags().Duration("down", 5*time.Second, "Time during which the link stays down")
+	linkFlapCmd.Flags().Duration("interval", 5*time.Second, "Time between two flaps")
+	linkFlapCmd.Flags().IntP("count", "c", 1, "Number of flaps")
+}
+
+func getLinkMethod(cmd *cobra.Command) string {
+	method, _ := cmd.Flags().GetString("method")
+	if method != link.MethodVeth && method != link.MethodFlow {
+		utils.Fatalf("unknown method %s (must be %s or %s)\n", method, link.MethodVeth, link.MethodFlow)
+	}
+	return method
+}
+
+// resolveLink finds the link between the two endpoints given as arguments
+// and returns its ports on the host
+func resolveLink(cmd *cobra.Command, args []string) (link.Port, link.Port, bool) {
+	p := getConfig(cmd, args[2:])
+	a, b, flows, err := p.LinkPorts(args[0], args[1])
+	if err != nil {
+		utils.Fatalln(err)
+	}
+	return a, b, flows
+}
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/rahveiz/topomate/internal/monitor"
+	"github.com/rahveiz/topomate/utils"
+	"github.com/spf13/cobra"
+)
+
+// monitorCmd represents the monitor command
+var monitorCmd = &cobra.Command{
+	Use:   "monitor [config file]",
+	Short: "Sample the interface counters of a running topology",
+	Long: `Read the counters of the interfaces of a running topology every interval
+until Ctrl-C is pressed, and display the busiest links. The counters are
+read from /sys/class/net on the host side of the veth pairs (sysfs source)
+or from the OVS port statistics (ovs source). The traffic of each interval
+is stored in the "monitor" directory of the project, use "monitor report"
+to display the utilisation, drops and errors of each link afterwards.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		p := getConfig(cmd, args)
+		source, _ := cmd.Flags().GetString("source")
+		interval, _ := cmd.Flags().GetDuration("interval")
+		top, _ := cmd.Flags().GetInt("top")
+		quiet, _ := cmd.Flags().GetBool("quiet")
+		if reset, _ := cmd.Flags().GetBool("reset"); reset {
+			if err := monitor.Reset(); err != nil {
+				utils.Fatalln(err)
+			}
+		}
+
+		speeds := p.PortSpeeds()
+		s := &monitor.Sampler{
+			Source:   source,
+			Interval: interval,
+			Links:    p.LinkNames(),
+			OnSample: func(points []monitor.Point) {
+				if quiet {
+					return
+				}
+				usages := monitor.Usages(points, speeds)
+				sort.SliceStable(usages, func(i, j int) bool {
+					return usages[i].Avg > usages[j].Avg
+				})
+				if top > 0 && len(usages) > top {
+					usages = usages[:top]
+				}
+				fmt.Printf("===== %s =====\n", time.Now().Format("15:04:05"))
+				monitor.WriteUsages(os.Stdout, usages, false)
+				fmt.Println()
+			},
+		}
+		ctx, cancel := interruptContext()
+		defer cancel()
+		fmt.Printf("Sampling the %s counters every %v (Ctrl-C to stop)\n", source, s.Interval)
+		if err := s.Run(ctx); err != nil {
+			utils.Fatalln(err)
+		}
+	},
+}
+
+var monitorReportCmd = &cobra.Command{
+	Use:   "report [config file]",
+	Short: "Display the utilisation, drops and errors of each link",
+	Run: func(cmd *cobra.Command, args []string) {
+		p := getConfig(cmd, args)
+		var since time.Time
+		if d, _ := cmd.Flags().GetDuration("since"); d > 0 {
+			since = time.Now().Add(-d)
+		}
+		points, err := monitor.Read(since)
+		if err != nil {
+			utils.Fatalln(err)
+		}
+		usages := monitor.Usages(points, p.PortSpeeds())
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			j, err := json.MarshalIndent(usages, "", "  ")
+			if err != nil {
+				utils.Fatalln(err)
+			}
+			fmt.Println(string(j))
+			return
+		}
+		all, _ := cmd.Flags().GetBool("all")
+		monitor.WriteUsages(os.Stdout, usages, all)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(monitorCmd)
+	monitorCmd.AddCommand(monitorReportCmd)
+	for _, c := range []*cobra.Command{monitorCmd, monitorReportCmd} {
+		c.Flags().StringP("project", "p", "", "Project name")
+	}
+	monitorCmd.Flags().String("source", monitor.SourceSysfs, "Source of the counters (sysfs or ovs)")
+	monitorCmd.Flags().Duration("interval", monitor.DefaultInterval, "Sampling interval")
+	monitorCmd.Flags().Int("top", 10, "Number of links displayed at each interval (0 for all)")
+	monitorCmd.Flags().BoolP("quiet", "q", false, "Only store the counters")
+	monitorCmd.Flags().Bool("reset", false, "Remove the counters of previous runs")
+	monitorReportCmd.Flags().Duration("since", 0, "Only use the counters of this last period")
+	monitorReportCmd.Flags().Bool("all", false, "Also display the links without traffic")
+	monitorReportCmd.Flags().Bool("json", false, "Output the report in JSON format")
+}
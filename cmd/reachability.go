@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"os"
+	"strings"
+
+	"github.com/rahveiz/topomate/project"
+	"github.com/rahveiz/topomate/utils"
+	"github.com/spf13/cobra"
+)
+
+// reachabilityCmd represents the reachability command
+var reachabilityCmd = &cobra.Command{
+	Use:   "reachability [config file]",
+	Short: "Check the reachability between all nodes",
+	Long: `Ping every router loopback and host address from every other node of a
+running topology, and display the results as a matrix with the RTT in ms.
+A node is reachable if all its addresses answer, the highest RTT is shown.
+CE routers are only tested against the other CE routers of their VPNs.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		p := getConfig(cmd, args)
+
+		format, _ := cmd.Flags().GetString("format")
+		format = strings.ToLower(format)
+		if format != "text" && format != "csv" && format != "json" {
+			utils.Fatalln("Unknown format", format, "(text, csv or json)")
+		}
+		count, _ := cmd.Flags().GetInt("count")
+		parallel, _ := cmd.Flags().GetInt("parallel")
+
+		m := p.Reachability(count, parallel)
+
+		var err error
+		switch format {
+		case "csv":
+			err = m.WriteCSV(os.Stdout)
+		case "json":
+			err = m.WriteJSON(os.Stdout)
+		default:
+			m.WriteText(os.Stdout)
+		}
+		if err != nil {
+			utils.Fatalln(err)
+		}
+
+		if failed, _ := m.Failures(); failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(reachabilityCmd)
+	reachabilityCmd.Flags().StringP("project", "p", "", "Project name")
+	reachabilityCmd.Flags().StringP("format", "f", "text", "Output format (text, csv, json)")
+	reachabilityCmd.Flags().IntP("count", "c", 2, "Number of pings for each address of each pair of nodes")
+	reachabilityCmd.Flags().Int("parallel", project.DefaultParallelism, "Maximum number of pings run at the same time")
+}
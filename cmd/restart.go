@@ -1,16 +1,7 @@
 package cmd
 
 import (
-	"context"
-	"encoding/json"
-	"io/ioutil"
-	"strings"
-
-	"github.com/digitalocean/go-openvswitch/ovs"
-
-	"github.com/docker/docker/client"
-	"github.com/rahveiz/topomate/internal/ovsdocker"
-
+	"github.com/rahveiz/topomate/internal/link"
 	"github.com/rahveiz/topomate/utils"
 	"github.com/spf13/cobra"
 )
@@ -46,30 +37,7 @@ func init() {
 }
 
 func restartContainer(name string) {
-	content, err := ioutil.ReadFile(utils.GetDirectoryFromKey("MainDir", "") + "/links.json")
-	if err != nil {
-		utils.Fatalln(err)
-	}
-	m := ovsdocker.OVSBulk{}
-	err = json.Unmarshal(content, &m)
-	if err != nil {
+	if err := link.RestartContainer(name); err != nil {
 		utils.Fatalln(err)
 	}
-
-	// Stop container
-	ctx := context.Background()
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-	utils.Check(err)
-	if err := cli.ContainerRestart(ctx, name, nil); err != nil {
-		panic(err)
-	}
-
-	c := ovs.New(ovs.Sudo())
-	d := ovsdocker.New(name)
-	for _, v := range m[name] {
-		c.VSwitch.DeletePort(v.Bridge, v.HostIface)
-		d.Portname = strings.TrimSuffix(v.HostIface, "_l")
-		d.AddPort(v.Bridge, v.ContainerIface, v.Settings, nil, true)
-	}
-	utils.StartFrr(name)
 }
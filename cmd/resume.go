@@ -1,16 +1,9 @@
 package cmd
 
 import (
-	"context"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"strings"
-	"sync"
 
-	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/client"
-	"github.com/rahveiz/topomate/internal/ovsdocker"
+	"github.com/rahveiz/topomate/internal/link"
 	"github.com/rahveiz/topomate/utils"
 	"github.com/spf13/cobra"
 )
@@ -23,12 +16,13 @@ var resumeCmd = &cobra.Command{
 the links.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		fmt.Println("resume called")
+		name := ""
 		if len(args) > 0 {
-			resumeContainers(args[0])
-		} else {
-			resumeContainers("")
+			name = args[0]
+		}
+		if err := link.Resume(name); err != nil {
+			utils.Fatalln(err)
 		}
-
 	},
 }
 
@@ -45,53 +39,3 @@ func init() {
 	// is called directly, e.g.:
 	// resumeCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 }
-
-func resumeContainers(name string) {
-	content, err := ioutil.ReadFile(utils.GetDirectoryFromKey("MainDir", "") + "/links.json")
-	if err != nil {
-		utils.Fatalln(err)
-	}
-	m := ovsdocker.OVSBulk{}
-	err = json.Unmarshal(content, &m)
-	if err != nil {
-		utils.Fatalln(err)
-	}
-
-	ctx := context.Background()
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-	utils.Check(err)
-
-	// Start container(s)
-
-	// If container name is specified, start the container
-	if name != "" {
-		d := ovsdocker.New(name)
-		if err := cli.ContainerStart(ctx, name, types.ContainerStartOptions{}); err != nil {
-			panic(err)
-		}
-		for _, v := range m[name] {
-			d.Portname = strings.TrimSuffix(v.HostIface, "_l")
-			d.AddPort(v.Bridge, v.ContainerIface, v.Settings, nil, true)
-		}
-		utils.StartFrr(name)
-	} else { // Name not specified, start all the containers
-		wg := sync.WaitGroup{}
-		for cName, lks := range m {
-			wg.Add(1)
-			go func(w *sync.WaitGroup, c *context.Context, name string, links []ovsdocker.OVSInterface) {
-				if err := cli.ContainerStart(*c, name, types.ContainerStartOptions{}); err != nil {
-					panic(err)
-				}
-				d := ovsdocker.New(name)
-				for _, v := range links {
-					d.Portname = strings.TrimSuffix(v.HostIface, "_l")
-					d.AddPort(v.Bridge, v.ContainerIface, v.Settings, nil, true)
-				}
-				utils.StartFrr(name)
-				w.Done()
-			}(&wg, &ctx, cName, lks)
-		}
-		wg.Wait()
-	}
-
-}
@@ -106,7 +106,9 @@ func getTarget(cmd *cobra.Command, args []string) string {
 	return args[0]
 }
 
-func getConfig(cmd *cobra.Command, args []string) *project.Project {
+// getConfigPath returns the path of the configuration file designated by the
+// project flag or the first argument
+func getConfigPath(cmd *cobra.Command, args []string) string {
 	if cmd.Flags().Changed("project") {
 		var err error
 		target, err := cmd.Flags().GetString("project")
@@ -114,12 +116,16 @@ func getConfig(cmd *cobra.Command, args []string) *project.Project {
 			utils.Fatalln(err)
 		}
 		viper.Set("ConfigDir", viper.GetString("ConfigDir")+"/"+target)
-		return project.ReadConfig(utils.GetDirectoryFromKey("ProjectDir", "") + "/" + target + ".yml")
+		return utils.GetDirectoryFromKey("ProjectDir", "") + "/" + target + ".yml"
 	}
 
 	if len(args) == 0 {
 		log.Fatalln("File or project not specified")
 	}
 
-	return project.ReadConfig(args[0])
+	return args[0]
+}
+
+func getConfig(cmd *cobra.Command, args []string) *project.Project {
+	return project.ReadConfig(getConfigPath(cmd, args))
 }
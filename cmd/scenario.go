@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/rahveiz/topomate/internal/scenario"
+	"github.com/rahveiz/topomate/utils"
+	"github.com/spf13/cobra"
+)
+
+// scenarioCmd represents the scenario command
+var scenarioCmd = &cobra.Command{
+	Use:   "scenario",
+	Short: "Run timed scenarios against a running topology",
+	Long: `Run a scenario file listing timed actions (link failures, restarts,
+configuration changes, prefix announcements, netem changes, snapshots...)
+against a running topology. The timestamps of each action are recorded in the
+"scenarios" directory of the project, and snapshots are named after the run.`,
+}
+
+var scenarioRunCmd = &cobra.Command{
+	Use:   "run <scenario file> [config file]",
+	Short: "Run a scenario",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		p := getConfig(cmd, args[1:])
+		f := readScenario(args[0])
+		rec, err := scenario.Run(p, f, os.Stdout)
+		if err != nil {
+			utils.Fatalln(err)
+		}
+		fmt.Printf("\nScenario %s finished in %v, record saved as %s\n",
+			rec.Scenario, rec.End.Sub(rec.Start).Round(time.Second), rec.ID)
+		if n := rec.Failed(); n > 0 {
+			utils.Fatalf("%d step(s) failed\n", n)
+		}
+	},
+}
+
+var scenarioValidateCmd = &cobra.Command{
+	Use:   "validate <scenario file> [config file]",
+	Short: "Check a scenario against a topology without running it",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		p := getConfig(cmd, args[1:])
+		f := readScenario(args[0])
+		if err := f.Validate(p); err != nil {
+			utils.Fatalln(err)
+		}
+		fmt.Printf("Scenario %s is valid (%d steps).\n", f.Name, len(f.Steps))
+	},
+}
+
+func readScenario(path string) *scenario.File {
+	f, err := scenario.ReadFile(path)
+	if err != nil {
+		utils.Fatalln(err)
+	}
+	return f
+}
+
+func init() {
+	rootCmd.AddCommand(scenarioCmd)
+	scenarioCmd.AddCommand(scenarioRunCmd, scenarioValidateCmd)
+	for _, c := range scenarioCmd.Commands() {
+		c.Flags().StringP("project", "p", "", "Project name")
+	}
+}
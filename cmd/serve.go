@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/rahveiz/topomate/internal/runtime"
+	"github.com/rahveiz/topomate/internal/server"
+	"github.com/rahveiz/topomate/utils"
+	"github.com/spf13/cobra"
+)
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve [config file]",
+	Short: "Serve an HTTP/JSON API to manage a topology",
+	Long: `Serve an HTTP/JSON API to load, validate, generate, start, stop, pause
+and resume a project, run commands on its nodes, bring its links down or up
+and manage its snapshots. The operations changing the topology are run as
+jobs, one at a time: the request returns the job, whose state and progress
+are available on /api/v1/jobs/<id>.
+
+The API has no authentication, it listens on the loopback interface by
+default. A project can be loaded at startup with a configuration file or -p.
+
+Example:
+  topomate serve topo.yml
+  curl -X POST localhost:8273/api/v1/start -d '{"wait": true}'`,
+	Run: func(cmd *cobra.Command, args []string) {
+		addr, _ := cmd.Flags().GetString("listen")
+		keep, _ := cmd.Flags().GetInt("keep-jobs")
+
+		ctx, cancel := interruptContext()
+		defer cancel()
+
+		// the runtime is initialized once, a configuration error stops
+		// the server before it starts
+		if err := runtime.Init(); err != nil {
+			utils.Fatalln(err)
+		}
+		// fatal errors of the project and frr packages must fail the
+		// requests and not stop the server
+		utils.SetFatalRecoverable(true)
+		s := server.New(ctx, keep)
+
+		name, _ := cmd.Flags().GetString("project")
+		path := ""
+		if len(args) > 0 {
+			path = args[0]
+		}
+		if name != "" || path != "" {
+			p, err := s.Load(path, name)
+			if err != nil {
+				utils.PrintError(err)
+				os.Exit(1)
+			}
+			fmt.Printf("Project %s loaded (%d nodes)\n", p.Name, len(p.Nodes()))
+		}
+
+		srv := &http.Server{Addr: addr, Handler: s.Handler()}
+		go func() {
+			<-ctx.Done()
+			shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			srv.Shutdown(shutdown)
+		}()
+
+		fmt.Printf("Serving the API on http://%s/api/v1 (Ctrl-C to stop)\n", addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			utils.PrintError(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().StringP("project", "p", "", "Project name")
+	serveCmd.Flags().String("listen", server.DefaultAddress, "Listening address")
+	serveCmd.Flags().Int("keep-jobs", server.DefaultKeepJobs, "Number of finished jobs kept")
+	addJobsFlag(serveCmd)
+}
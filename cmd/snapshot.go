@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/rahveiz/topomate/config"
+	"github.com/rahveiz/topomate/internal/snapshot"
+	"github.com/rahveiz/topomate/utils"
+	"github.com/spf13/cobra"
+)
+
+// snapshotCmd represents the snapshot command
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Save and compare routing tables of a running topology",
+	Long: `Save the BGP, RIB, VRF, MPLS and IGP tables of all routers of a running
+topology in a snapshot directory, and compare snapshots between runs.
+Snapshots are stored in the "snapshots" directory of the project.`,
+}
+
+var snapshotSaveCmd = &cobra.Command{
+	Use:   "save <name> [config file]",
+	Short: "Save the routing tables in a new snapshot",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		p := getConfig(cmd, args[1:])
+		force, _ := cmd.Flags().GetBool("force")
+		meta, err := snapshot.Save(p, args[0], p.SelectNodes(getNodeFilter(cmd)), force)
+		if err != nil {
+			utils.Fatalln(err)
+		}
+		fmt.Printf("Snapshot %s saved (%d routers).\n", meta.Name, len(meta.Nodes))
+		if len(meta.Errors) > 0 {
+			fmt.Printf("%d table(s) could not be collected", len(meta.Errors))
+			if config.VFlag {
+				fmt.Println(":")
+				for k, v := range meta.Errors {
+					fmt.Printf("  %s: %s\n", k, v)
+				}
+			} else {
+				fmt.Println(" (use -v for details).")
+			}
+		}
+	},
+}
+
+var snapshotDiffCmd = &cobra.Command{
+	Use:   "diff <a> <b> [config file]",
+	Short: "Display the route-level changes between two snapshots",
+	Args:  cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		getConfig(cmd, args[2:])
+		changes, err := snapshot.Diff(args[0], args[1])
+		if err != nil {
+			utils.Fatalln(err)
+		}
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			j, err := json.MarshalIndent(changes, "", "  ")
+			if err != nil {
+				utils.Fatalln(err)
+			}
+			fmt.Println(string(j))
+			return
+		}
+		snapshot.WriteChanges(os.Stdout, changes)
+	},
+}
+
+var snapshotListCmd = &cobra.Command{
+	Use:   "list [config file]",
+	Short: "List the snapshots of a project",
+	Run: func(cmd *cobra.Command, args []string) {
+		getConfig(cmd, args)
+		list, err := snapshot.List()
+		if err != nil {
+			utils.Fatalln(err)
+		}
+		for _, m := range list {
+			fmt.Printf("%-20s %s  %d routers\n", m.Name, m.Date.Format("2006-01-02 15:04:05"), len(m.Nodes))
+		}
+	},
+}
+
+var snapshotDeleteCmd = &cobra.Command{
+	Use:   "delete <name> [config file]",
+	Short: "Delete a snapshot",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		getConfig(cmd, args[1:])
+		if err := snapshot.Delete(args[0]); err != nil {
+			utils.Fatalln(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(snapshotCmd)
+	snapshotCmd.AddCommand(snapshotSaveCmd, snapshotDiffCmd, snapshotListCmd, snapshotDeleteCmd)
+	for _, c := range snapshotCmd.Commands() {
+		c.Flags().StringP("project", "p", "", "Project name")
+	}
+	addNodeFilterFlags(snapshotSaveCmd)
+	snapshotSaveCmd.Flags().BoolP("force", "f", false, "Overwrite an existing snapshot")
+	snapshotDiffCmd.Flags().Bool("json", false, "Output the changes in JSON format")
+}
@@ -1,7 +1,10 @@
 package cmd
 
 import (
+	"os"
+
 	"github.com/rahveiz/topomate/config"
+	"github.com/rahveiz/topomate/internal/runtime"
 	"github.com/rahveiz/topomate/utils"
 	"github.com/spf13/cobra"
 )
@@ -14,9 +17,11 @@ var startCmd = &cobra.Command{
 Automatically creates Docker containers, network links and FRR configuration files.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		newConf := getConfig(cmd, args)
+		rec := startDryRun(cmd)
 		// setConfigDir(newConf.Name)
 		if n, err := cmd.Flags().GetBool("no-generate"); err == nil {
-			if !n {
+			// configuration files are not written in dry-run mode
+			if !n && rec == nil {
 				generateConfigs(newConf)
 			}
 		} else {
@@ -28,12 +33,34 @@ Automatically creates Docker containers, network links and FRR configuration fil
 		}
 		if nopull, err := cmd.Flags().GetBool("no-pull"); err == nil {
 			if !nopull {
-				utils.PullImages()
+				if err := runtime.PullImages(); err != nil {
+					utils.Fatalln(err)
+				}
+			}
+		} else {
+			utils.Fatalln(err)
+		}
+		ctx, cancel := interruptContext()
+		defer cancel()
+		newConf = newConf.OnlyAS(config.ASOnly)
+		err = newConf.StartAll(ctx, links)
+		if rec != nil {
+			writeDryRun(cmd, rec)
+		}
+		if err != nil {
+			utils.Fatalln(err)
+		}
+		if rec != nil {
+			return
+		}
+
+		if wait, err := cmd.Flags().GetBool("wait"); err == nil {
+			if wait && !waitReady(newConf, getReadyOptions(cmd, "wait-")) {
+				os.Exit(1)
 			}
 		} else {
 			utils.Fatalln(err)
 		}
-		newConf.StartAll(links)
 	},
 }
 
@@ -44,4 +71,9 @@ func init() {
 	startCmd.Flags().String("links", "all", `Restrict which links should be applied (all, internal, external, none). Defaults to all.`)
 	startCmd.Flags().Bool("no-generate", false, "Do not generate configuration files")
 	startCmd.Flags().Bool("no-pull", false, "Do not pull docker image from DockerHub.")
+	startCmd.Flags().Bool("wait", false, "Wait until the BGP sessions are established and the RIBs are stable")
+	addReadyFlags(startCmd, "wait-")
+	addJobsFlag(startCmd)
+	startCmd.Flags().BoolVar(&config.KeepPartial, "no-rollback", false, "Keep the created resources if the start fails (remove them with cleanup -p)")
+	addDryRunFlags(startCmd)
 }
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/rahveiz/topomate/internal/link"
+	"github.com/rahveiz/topomate/internal/runtime"
+	"github.com/rahveiz/topomate/utils"
+	"github.com/spf13/cobra"
+)
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status [config file]",
+	Short: "Display the state of a running topology",
+	Long: `Display the state of the containers of a topology and the links that
+have been brought down using the link command.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		p := getConfig(cmd, args)
+
+		li, err := runtime.Current().List(nil)
+		if err != nil {
+			utils.Fatalln(err)
+		}
+		states := make(map[string]string, len(li))
+		for _, c := range li {
+			states[c.Name] = c.State
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "NODE\tROLE\tSTATE")
+		for _, n := range p.Nodes() {
+			state, ok := states[n.ContainerName]
+			if !ok {
+				state = "absent"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", n.ContainerName, n.Role, state)
+		}
+		w.Flush()
+
+		down, err := link.LoadStates()
+		if err != nil {
+			utils.Fatalln(err)
+		}
+		if len(down) == 0 {
+			return
+		}
+		list := make([]link.State, 0, len(down))
+		for _, s := range down {
+			list = append(list, s)
+		}
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].Key() < list[j].Key()
+		})
+		fmt.Printf("\nLinks down:\n")
+		for _, s := range list {
+			fmt.Printf("  %s (%s, since %s)\n", s, s.Method, s.Since.Format("15:04:05"))
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+	statusCmd.Flags().StringP("project", "p", "", "Project name")
+}
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"github.com/rahveiz/topomate/utils"
 	"github.com/spf13/cobra"
 )
 
@@ -16,13 +17,24 @@ This application is a tool to generate the needed files
 to quickly create a Cobra application.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		newConf := getConfig(cmd, args)
-		newConf.StopAll()
+		rec := startDryRun(cmd)
+		ctx, cancel := interruptContext()
+		defer cancel()
+		err := newConf.StopAll(ctx)
+		if rec != nil {
+			writeDryRun(cmd, rec)
+		}
+		if err != nil {
+			utils.Fatalln(err)
+		}
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(stopCmd)
 	stopCmd.Flags().StringP("project", "p", "", "Project name")
+	addDryRunFlags(stopCmd)
+	addJobsFlag(stopCmd)
 
 	// Here you will define your flags and configuration settings.
 
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rahveiz/topomate/internal/labtest"
+	"github.com/rahveiz/topomate/utils"
+	"github.com/spf13/cobra"
+)
+
+// testCmd represents the test command
+var testCmd = &cobra.Command{
+	Use:   "test [config file]",
+	Short: "Check expectations against a running topology",
+	Long: `Evaluate the expectations of a test file (BGP sessions, routes, ping,
+traceroute, RPKI states) against a running topology. The lab is first given
+time to converge (see the wait command), then each expectation is retried
+until it is met or the timeout of the test file expires.
+
+The test file defaults to <config file name>.test.yml (or .test.yaml), next
+to the configuration file.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		path := getConfigPath(cmd, args)
+		p := getConfig(cmd, args)
+
+		testFile, err := cmd.Flags().GetString("file")
+		if err != nil {
+			utils.Fatalln(err)
+		}
+		if testFile == "" {
+			testFile = defaultTestFile(path)
+		}
+		f, err := labtest.ReadFile(testFile)
+		if err != nil {
+			utils.Fatalln(testFile+":", err)
+		}
+
+		if noWait, err := cmd.Flags().GetBool("no-wait"); err == nil {
+			if !noWait {
+				waitReady(p, getReadyOptions(cmd, "wait-"))
+			}
+		} else {
+			utils.Fatalln(err)
+		}
+
+		results := labtest.Run(p, f)
+		failed := 0
+		for _, r := range results {
+			if r.Passed() {
+				fmt.Printf("PASS  %s (%.1fs)\n", r.Name, r.Duration.Seconds())
+			} else {
+				failed++
+				fmt.Printf("FAIL  %s: %v\n", r.Name, r.Err)
+			}
+		}
+		fmt.Printf("\n%d passed, %d failed\n", len(results)-failed, failed)
+
+		junit, err := cmd.Flags().GetString("junit")
+		if err != nil {
+			utils.Fatalln(err)
+		}
+		if junit != "" {
+			suite := f.Name
+			if suite == "" {
+				suite = p.Name
+			}
+			out, err := os.Create(junit)
+			if err != nil {
+				utils.Fatalln(err)
+			}
+			if err := labtest.WriteJUnit(out, suite, results); err != nil {
+				utils.Fatalln(err)
+			}
+			out.Close()
+		}
+
+		if failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(testCmd)
+	testCmd.Flags().StringP("project", "p", "", "Project name")
+	testCmd.Flags().StringP("file", "f", "", "Test file (defaults to <config>.test.yml or <config>.test.yaml)")
+	testCmd.Flags().String("junit", "", "Write the results in JUnit XML format to this file")
+	testCmd.Flags().Bool("no-wait", false, "Do not wait for the lab to converge before running the tests")
+	addReadyFlags(testCmd, "wait-")
+}
+
+// defaultTestFile returns the test file matching the configuration file
+// path: the first existing of <config>.test.yml and <config>.test.yaml, the
+// extension of the configuration file being tried first
+func defaultTestFile(path string) string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext) + ".test"
+	for _, e := range []string{ext, ".yml", ".yaml"} {
+		if _, err := os.Stat(base + e); err == nil {
+			return base + e
+		}
+	}
+	return base + ".yml"
+}
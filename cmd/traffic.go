@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/rahveiz/topomate/internal/flows"
+	"github.com/rahveiz/topomate/internal/link"
+	"github.com/rahveiz/topomate/internal/traffic"
+	"github.com/rahveiz/topomate/project"
+	"github.com/rahveiz/topomate/utils"
+	"github.com/spf13/cobra"
+)
+
+// trafficCmd represents the traffic command
+var trafficCmd = &cobra.Command{
+	Use:   "traffic",
+	Short: "Generate and observe the traffic of a running topology",
+	Long: `Generate the iperf3 flows of the traffic section of the configuration file.
+Export sFlow or IPFIX samples from the internal, external and IXP bridges
+of a running topology (OVS backend) to a built-in collector, and summarise the
+top talkers and the utilisation of each link. The records are stored in the
+"traffic" directory of the project.`,
+}
+
+var trafficRunCmd = &cobra.Command{
+	Use:   "run [config file]",
+	Short: "Run the traffic flows of the configuration",
+	Long: `Start the iperf3 servers and clients of the flows listed in the traffic
+section of the configuration file, each client being started at its offset.
+The iperf3 results are saved in the "traffic/runs" directory of the project,
+and the throughput and loss of each flow are displayed at the end.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		p := getConfig(cmd, args)
+		names, _ := cmd.Flags().GetStringSlice("flow")
+		fl := p.Traffic
+		if len(names) > 0 {
+			fl = make([]project.TrafficFlow, 0, len(names))
+			for _, n := range names {
+				f, ok := p.FindFlow(n)
+				if !ok {
+					utils.Fatalf("flow %s not found\n", n)
+				}
+				fl = append(fl, f)
+			}
+		}
+		if len(fl) == 0 {
+			utils.Fatalln("No traffic flow in the configuration")
+		}
+
+		ctx, cancel := interruptContext()
+		defer cancel()
+		run, err := traffic.Start(ctx, p, fl, os.Stdout)
+		if run != nil {
+			fmt.Println()
+			run.Write(os.Stdout)
+			fmt.Printf("\nResults saved in %s\n", filepath.Join(traffic.Dir(), run.ID))
+		}
+		if err != nil {
+			utils.Fatalln(err)
+		}
+		if n := run.Failed(); n > 0 {
+			utils.Fatalf("%d flow(s) failed\n", n)
+		}
+	},
+}
+
+var trafficCollectCmd = &cobra.Command{
+	Use:   "collect [config file]",
+	Short: "Enable the flow export on the bridges and collect the samples",
+	Long: `Enable sFlow or IPFIX on the bridges of a running topology and run the
+collector until Ctrl-C is pressed. The export is removed from the bridges
+when the collector stops.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		p := getConfig(cmd, args)
+		protocol, _ := cmd.Flags().GetString("protocol")
+		sampling, _ := cmd.Flags().GetInt("sampling")
+		interval, _ := cmd.Flags().GetDuration("interval")
+		listen, _ := cmd.Flags().GetString("listen")
+		target, _ := cmd.Flags().GetString("target")
+
+		port := flows.DefaultSFlowPort
+		if protocol == flows.IPFIX {
+			port = flows.DefaultIPFIXPort
+		}
+		if listen == "" {
+			listen = ":" + strconv.Itoa(port)
+		}
+		if target == "" {
+			_, lport, err := net.SplitHostPort(listen)
+			if err != nil {
+				utils.Fatalln(err)
+			}
+			target = net.JoinHostPort("127.0.0.1", lport)
+		}
+
+		bridges, err := p.ExportBridges()
+		if err != nil {
+			utils.Fatalln(err)
+		}
+		resolve, err := p.FlowResolver(protocol, bridges)
+		if err != nil {
+			utils.Fatalln(err)
+		}
+		if reset, _ := cmd.Flags().GetBool("reset"); reset {
+			if err := flows.Reset(); err != nil {
+				utils.Fatalln(err)
+			}
+		}
+
+		settings := link.ExportSettings{
+			Protocol: protocol,
+			Target:   target,
+			Sampling: sampling,
+			Interval: int(interval.Seconds()),
+		}
+		if err := link.EnableExport(bridges, settings); err != nil {
+			utils.Fatalln(err)
+		}
+		defer func() {
+			if err := link.DisableExport(bridges); err != nil {
+				utils.PrintError(err)
+			}
+		}()
+
+		c := &flows.Collector{
+			Protocol: protocol,
+			Sampling: sampling,
+			Interval: interval,
+			Resolve:  resolve,
+			OnFlush: func(points []flows.Point) {
+				var bps float64
+				for _, pt := range points {
+					bps += pt.Bps()
+				}
+				fmt.Printf("%s  %d port(s)  %s\n", time.Now().Format("15:04:05"),
+					len(points), flows.FormatBps(bps))
+			},
+		}
+		ctx, cancel := interruptContext()
+		defer cancel()
+		fmt.Printf("Collecting %s samples from %d bridge(s) on %s (Ctrl-C to stop)\n",
+			protocol, len(bridges), listen)
+		if err := c.Run(ctx, listen); err != nil {
+			utils.PrintError(err)
+			return
+		}
+		fmt.Printf("%d datagram(s) received, %d undecodable, %d sample(s) from unknown ports\n",
+			c.Datagrams, c.Errors, c.Unknown)
+	},
+}
+
+var trafficReportCmd = &cobra.Command{
+	Use:   "report [config file]",
+	Short: "Summarise the top talkers and the links utilisation",
+	Run: func(cmd *cobra.Command, args []string) {
+		p := getConfig(cmd, args)
+		top, _ := cmd.Flags().GetInt("top")
+		sum, err := flows.Report(p.PortSpeeds(), top)
+		if err != nil {
+			utils.Fatalln(err)
+		}
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			j, err := json.MarshalIndent(sum, "", "  ")
+			if err != nil {
+				utils.Fatalln(err)
+			}
+			fmt.Println(string(j))
+			return
+		}
+		sum.Write(os.Stdout)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(trafficCmd)
+	trafficCmd.AddCommand(trafficRunCmd, trafficCollectCmd, trafficReportCmd)
+	for _, c := range trafficCmd.Commands() {
+		c.Flags().StringP("project", "p", "", "Project name")
+	}
+	trafficRunCmd.Flags().StringSlice("flow", nil, "Run only the flows with these names")
+	trafficCollectCmd.Flags().String("protocol", flows.SFlow, "Export protocol (sflow or ipfix)")
+	trafficCollectCmd.Flags().Int("sampling", 64, "Sampling rate (1 packet out of N)")
+	trafficCollectCmd.Flags().Duration("interval", flows.DefaultInterval, "Interval of the throughput time series")
+	trafficCollectCmd.Flags().String("listen", "", "Address of the collector (default :6343 for sFlow, :4739 for IPFIX)")
+	trafficCollectCmd.Flags().String("target", "", "Address the bridges export to (default 127.0.0.1 and the listen port)")
+	trafficCollectCmd.Flags().Bool("reset", false, "Remove the records of previous collections")
+	trafficReportCmd.Flags().Int("top", 10, "Number of top talkers displayed (0 for all)")
+	trafficReportCmd.Flags().Bool("json", false, "Output the report in JSON format")
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/rahveiz/topomate/frr"
+	"github.com/rahveiz/topomate/project"
+	"github.com/rahveiz/topomate/utils"
+	"github.com/spf13/cobra"
+)
+
+// waitCmd represents the wait command
+var waitCmd = &cobra.Command{
+	Use:   "wait [config file]",
+	Short: "Wait until a running topology has converged",
+	Long: `Wait until every BGP session of a running topology is established and the
+RIB sizes of all routers have been stable for a given duration. Exits with a
+non-zero status and lists the sessions that never came up if the timeout
+expires.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		p := getConfig(cmd, args)
+		if !waitReady(p, getReadyOptions(cmd, "")) {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(waitCmd)
+	waitCmd.Flags().StringP("project", "p", "", "Project name")
+	addReadyFlags(waitCmd, "")
+}
+
+// addReadyFlags adds the flags used to configure the readiness checks.
+// The flags names are prefixed by prefix.
+func addReadyFlags(cmd *cobra.Command, prefix string) {
+	def := frr.DefaultReadyOptions()
+	cmd.Flags().Duration(prefix+"timeout", def.Timeout, "Maximum time to wait for the lab to be ready")
+	cmd.Flags().Duration(prefix+"interval", def.Interval, "Time between two checks")
+	cmd.Flags().Duration(prefix+"stable", def.Stable, "Time during which the RIB sizes must not change")
+}
+
+// getReadyOptions returns the readiness settings from the flags added by
+// addReadyFlags
+func getReadyOptions(cmd *cobra.Command, prefix string) frr.ReadyOptions {
+	opts := frr.DefaultReadyOptions()
+	var err error
+	if opts.Timeout, err = cmd.Flags().GetDuration(prefix + "timeout"); err != nil {
+		utils.Fatalln(err)
+	}
+	if opts.Interval, err = cmd.Flags().GetDuration(prefix + "interval"); err != nil {
+		utils.Fatalln(err)
+	}
+	if opts.Stable, err = cmd.Flags().GetDuration(prefix + "stable"); err != nil {
+		utils.Fatalln(err)
+	}
+	return opts
+}
+
+// waitReady waits for the project to be ready, displays the report and
+// returns true if the lab is ready
+func waitReady(p *project.Project, opts frr.ReadyOptions) bool {
+	report := frr.WaitReady(p, opts)
+	if report.Ready {
+		report.Write(os.Stdout)
+	} else {
+		report.Write(os.Stderr)
+	}
+	return report.Ready
+}
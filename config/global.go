@@ -4,6 +4,14 @@ var VFlag bool
 var ASOnly []int
 var ConfigDir string
 
+// Jobs is the maximum number of containers started or stopped at the same
+// time (no limit if 0)
+var Jobs int
+
+// KeepPartial keeps the resources created by a failed start instead of
+// removing them
+var KeepPartial bool
+
 var DefaultBGPSettings GlobalBGPConfig
 
 const (
@@ -14,6 +14,23 @@ type BaseConfig struct {
 	External     []ExternalLink        `yaml:"external_links"`
 	IXPs         []IXPConfig           `yaml:"ixps"`
 	RPKI         map[string]RPKIConfig `yaml:"rpki"`
+	// LinkBackend is the backend used to create links (ovs, veth or bridge)
+	LinkBackend string `yaml:"link_backend,omitempty"`
+	// Traffic contains the flows generated by the traffic command
+	Traffic []TrafficFlow `yaml:"traffic,omitempty"`
+}
+
+// TrafficFlow is a flow generated with iperf3. The destination is a node
+// (its loopback is used) or an address of a node. Rate, duration and start
+// use the iperf3 bitrate format (10M, 1G) and Go durations (30s, 1m).
+type TrafficFlow struct {
+	Name        string `yaml:"name,omitempty"`
+	Source      string `yaml:"source"`
+	Destination string `yaml:"destination"`
+	Protocol    string `yaml:"protocol,omitempty"`
+	Rate        string `yaml:"rate,omitempty"`
+	Duration    string `yaml:"duration,omitempty"`
+	Start       string `yaml:"start,omitempty"`
 }
 
 type GlobalConfig struct {
@@ -85,12 +102,26 @@ type VPNConfig struct {
 type ExternalLinkItem struct {
 	ASN      int `yaml:"asn"`
 	RouterID int `yaml:"router_id"`
+	MTU      int `yaml:"mtu,omitempty"`
 }
 
 type ExternalLink struct {
 	From         ExternalLinkItem `yaml:"from"`
 	To           ExternalLinkItem `yaml:"to"`
 	Relationship string           `yaml:"rel"`
+	MTU          int              `yaml:"mtu,omitempty"`
+	Netem        NetemConfig      `yaml:",inline"`
+}
+
+// NetemConfig describes the network conditions emulated on a link
+// (delay and jitter as durations, loss and corrupt in percent, rate using
+// the tc syntax, e.g. "100mbit")
+type NetemConfig struct {
+	Delay   string  `yaml:"delay,omitempty"`
+	Jitter  string  `yaml:"jitter,omitempty"`
+	Loss    float64 `yaml:"loss,omitempty"`
+	Corrupt float64 `yaml:"corrupt,omitempty"`
+	Rate    string  `yaml:"rate,omitempty"`
 }
 
 type InternalLinks struct {
@@ -100,13 +131,17 @@ type InternalLinks struct {
 	Filepath string              `yaml:"file"`
 	Speed    int                 `yaml:"speed"`
 	Cost     int                 `yaml:"cost"`
+	MTU      int                 `yaml:"mtu,omitempty"`
+	Netem    NetemConfig         `yaml:",inline"`
 }
 
 type IXPConfig struct {
-	ASN      int      `yaml:"asn"`
-	Peers    []string `yaml:"peers,flow"`
-	Prefix   string   `yaml:"prefix"`
-	Loopback string   `yaml:"loopback"`
+	ASN      int         `yaml:"asn"`
+	Peers    []string    `yaml:"peers,flow"`
+	Prefix   string      `yaml:"prefix"`
+	Loopback string      `yaml:"loopback"`
+	MTU      int         `yaml:"mtu,omitempty"`
+	Netem    NetemConfig `yaml:",inline"`
 }
 
 type ISISConfig struct {
@@ -143,8 +178,10 @@ type RPKIConfig struct {
 	Address string `yaml:"server_address"`
 	// NeighborAS []string `yaml:"neighbors_as,flow"`
 	RouterLink struct {
-		ASN      int `yaml:"asn"`
-		RouterID int `yaml:"router_id"`
+		ASN      int         `yaml:"asn"`
+		RouterID int         `yaml:"router_id"`
+		MTU      int         `yaml:"mtu,omitempty"`
+		Netem    NetemConfig `yaml:",inline"`
 	} `yaml:"linked_to"`
 	CacheFile string `yaml:"cache_file"`
 	ROAs      []ROA  `yaml:"roas"`
@@ -5,6 +5,7 @@ import (
 	"io"
 	"net"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/rahveiz/topomate/config"
@@ -18,7 +19,16 @@ var nextRouteDescriptor = 1
 func GenerateConfig(p *project.Project) [][]*FRRConfig {
 	configs := make([][]*FRRConfig, len(p.AS)+1)
 	idx := 0
-	for i, as := range p.AS {
+	// generic router IDs are allocated in ASN order, so the same
+	// configuration is generated on each run
+	genericID = net.ParseIP("10.1.1.1")
+	asns := make([]int, 0, len(p.AS))
+	for asn := range p.AS {
+		asns = append(asns, asn)
+	}
+	sort.Ints(asns)
+	for _, i := range asns {
+		as := p.AS[i]
 		n := as.TotalContainers()
 		is4 := as.Network.IPNet.IP.To4() != nil
 
@@ -124,6 +134,7 @@ func GenerateConfig(p *project.Project) [][]*FRRConfig {
 					IPs:         []net.IPNet{iface.IP},
 					Description: iface.Description,
 					Speed:       iface.Speed,
+					MTU:         iface.MTU,
 					External:    iface.External,
 					IGPConfig:   make([]IGPIfConfig, 0, 5),
 				}
@@ -254,7 +265,7 @@ func generateIXPConfigs(p *project.Project) []*FRRConfig {
 			c.Interfaces["lo"] = IfConfig{
 				IPs: ips,
 			}
-			is4 = ixp.RouteServer.Loopback[0].IP.To4 != nil
+			is4 = ixp.RouteServer.Loopback[0].IP.To4() != nil
 		}
 
 		// BGP
@@ -282,6 +293,7 @@ func generateIXPConfigs(p *project.Project) []*FRRConfig {
 				IPs:         []net.IPNet{iface.IP},
 				Description: iface.Description,
 				Speed:       iface.Speed,
+				MTU:         iface.MTU,
 				External:    iface.External,
 			}
 			c.Interfaces[iface.IfName] = ifCfg
@@ -358,6 +370,10 @@ func writeInterface(dst io.Writer, name string, c IfConfig) {
 	if c.Description != "" {
 		fmt.Fprintln(dst, " description", c.Description)
 	}
+	// zebra reads the MTU from the kernel interface, it is only recorded here
+	if c.MTU != 0 && c.MTU != project.DefaultMTU {
+		fmt.Fprintln(dst, " ! mtu", c.MTU)
+	}
 	for _, ip := range c.IPs {
 		if len(ip.IP) > 0 {
 			fmt.Fprintln(dst, " ip address", ip.String())
@@ -398,14 +414,17 @@ func (c *FRRConfig) writeMPLS(dst io.Writer) {
 	sep(dst)
 }
 
-func WriteConfig(c FRRConfig) {
+// Filename returns the path of the configuration file of the router
+func (c FRRConfig) Filename() string {
 	genDir := utils.GetDirectoryFromKey("ConfigDir", "")
-	var filename string
 	if c.BGP.ASN == 0 {
-		filename = fmt.Sprintf("%s/conf_cust_%s", genDir, c.Hostname)
-	} else {
-		filename = fmt.Sprintf("%s/conf_%d_%s", genDir, c.BGP.ASN, c.Hostname)
+		return fmt.Sprintf("%s/conf_cust_%s", genDir, c.Hostname)
 	}
+	return fmt.Sprintf("%s/conf_%d_%s", genDir, c.BGP.ASN, c.Hostname)
+}
+
+func WriteConfig(c FRRConfig) {
+	filename := c.Filename()
 	if config.VFlag {
 		fmt.Println("writing", filename)
 	}
@@ -415,6 +434,11 @@ func WriteConfig(c FRRConfig) {
 	}
 	defer file.Close()
 
+	file.WriteString(c.Render())
+}
+
+// Render returns the content of the configuration file of the router
+func (c FRRConfig) Render() string {
 	dst := &strings.Builder{}
 
 	fmt.Fprintf(dst,
@@ -463,8 +487,7 @@ password topomate
 
 	fmt.Fprintln(dst, "line vty")
 
-	file.WriteString(dst.String())
-
+	return dst.String()
 }
 
 func WriteAll(configs [][]*FRRConfig) {
@@ -475,6 +498,17 @@ func WriteAll(configs [][]*FRRConfig) {
 	}
 }
 
+// RenderAll returns the content of the configuration files, indexed by path
+func RenderAll(configs [][]*FRRConfig) map[string]string {
+	res := make(map[string]string, 64)
+	for _, asCfg := range configs {
+		for _, cfg := range asCfg {
+			res[cfg.Filename()] = cfg.Render()
+		}
+	}
+	return res
+}
+
 /* OSPF CONFIGURATION */
 
 func getOSPFConfig(routerID string, process int) OSPFConfig {
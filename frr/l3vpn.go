@@ -128,6 +128,7 @@ func generateVPNConfig(as *project.AutonomousSystem, ASconfigs []*FRRConfig) []*
 					IPs:         []net.IPNet{iface.IP},
 					Description: iface.Description,
 					Speed:       iface.Speed,
+					MTU:         iface.MTU,
 					IGPConfig:   make([]IGPIfConfig, 0, 5),
 				}
 				switch igp {
@@ -145,6 +146,7 @@ func generateVPNConfig(as *project.AutonomousSystem, ASconfigs []*FRRConfig) []*
 							IPs:         []net.IPNet{parentIf.IP},
 							Description: parentIf.Description,
 							Speed:       parentIf.Speed,
+							MTU:         parentIf.MTU,
 							IGPConfig:   make([]IGPIfConfig, 0, 5),
 							External:    true,
 							VRF:         parentIf.VRF,
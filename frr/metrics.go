@@ -0,0 +1,207 @@
+package frr
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rahveiz/topomate/project"
+)
+
+// BGPSession is the state of a BGP session of a running router
+type BGPSession struct {
+	Neighbor string
+	RemoteAS int
+	State    string
+	// Time since the session is established (0 if it is not)
+	Uptime time.Duration
+	// Number of prefixes received and accepted by address family (e.g.
+	// "ipv4Unicast")
+	Received map[string]int
+	Accepted map[string]int
+}
+
+// Established returns true if the session is established
+func (s BGPSession) Established() bool {
+	return s.State == "Established"
+}
+
+// BGPSessions returns the BGP sessions of the node in the default VRF
+func BGPSessions(n project.Node) ([]BGPSession, error) {
+	var nbrs map[string]struct {
+		RemoteAS int    `json:"remoteAs"`
+		BGPState string `json:"bgpState"`
+		UpMsec   int64  `json:"bgpTimerUpMsec"`
+		AFIs     map[string]struct {
+			Accepted int `json:"acceptedPrefixCounter"`
+		} `json:"addressFamilyInfo"`
+	}
+	if err := vtyshJSON(n, "show bgp neighbors json", &nbrs); err != nil {
+		return nil, err
+	}
+	var summary map[string]json.RawMessage
+	if err := vtyshJSON(n, "show bgp summary json", &summary); err != nil {
+		return nil, err
+	}
+
+	res := make([]BGPSession, 0, len(nbrs))
+	idx := make(map[string]int, len(nbrs))
+	for ip, nbr := range nbrs {
+		s := BGPSession{
+			Neighbor: ip,
+			RemoteAS: nbr.RemoteAS,
+			State:    nbr.BGPState,
+			Received: make(map[string]int, len(nbr.AFIs)),
+			Accepted: make(map[string]int, len(nbr.AFIs)),
+		}
+		if s.Established() {
+			s.Uptime = time.Duration(nbr.UpMsec) * time.Millisecond
+		}
+		for afi, info := range nbr.AFIs {
+			s.Accepted[afi] = info.Accepted
+		}
+		idx[ip] = len(res)
+		res = append(res, s)
+	}
+
+	// The summary contains one entry per address family, with the number of
+	// prefixes received from each peer
+	for afi, raw := range summary {
+		var af struct {
+			Peers map[string]struct {
+				PfxRcd int `json:"pfxRcd"`
+			} `json:"peers"`
+		}
+		if err := json.Unmarshal(raw, &af); err != nil {
+			continue
+		}
+		for ip, peer := range af.Peers {
+			if i, ok := idx[ip]; ok {
+				res[i].Received[afi] = peer.PfxRcd
+			}
+		}
+	}
+	return res, nil
+}
+
+// RIBSizes returns the number of routes in the IPv4 and IPv6 RIB of the node,
+// indexed by "ipv4" and "ipv6"
+func RIBSizes(n project.Node) (map[string]int, error) {
+	res := make(map[string]int, 2)
+	for afi, command := range map[string]string{
+		"ipv4": "show ip route summary json",
+		"ipv6": "show ipv6 route summary json",
+	} {
+		var summary struct {
+			RoutesTotal int `json:"routesTotal"`
+		}
+		if err := vtyshJSON(n, command, &summary); err != nil {
+			return nil, err
+		}
+		res[afi] = summary.RoutesTotal
+	}
+	return res, nil
+}
+
+// OSPFNeighbors returns the number of OSPF neighbors of the node and the
+// number of them in the Full state
+func OSPFNeighbors(n project.Node) (total, full int, err error) {
+	var res struct {
+		Neighbors map[string]json.RawMessage `json:"neighbors"`
+	}
+	if err := vtyshJSON(n, "show ip ospf neighbor json", &res); err != nil {
+		return 0, 0, err
+	}
+	type ospfNbr struct {
+		State    string `json:"state"`
+		NbrState string `json:"nbrState"`
+	}
+	for _, raw := range res.Neighbors {
+		// Recent FRR versions output a list of neighbors by router ID
+		// (one per interface), older ones a single object
+		var list []ospfNbr
+		if err := json.Unmarshal(raw, &list); err != nil {
+			var nbr ospfNbr
+			if err := json.Unmarshal(raw, &nbr); err != nil {
+				continue
+			}
+			list = []ospfNbr{nbr}
+		}
+		for _, nbr := range list {
+			total++
+			state := nbr.NbrState
+			if state == "" {
+				state = nbr.State
+			}
+			if strings.HasPrefix(state, "Full") {
+				full++
+			}
+		}
+	}
+	return total, full, nil
+}
+
+// ISISAdjacencies returns the number of IS-IS adjacencies of the node and the
+// number of them in the Up state
+func ISISAdjacencies(n project.Node) (total, up int, err error) {
+	res := n.Vtysh("show isis neighbor")
+	if res.Err != nil {
+		return 0, 0, res.Err
+	}
+	if res.ExitCode != 0 {
+		return 0, 0, fmt.Errorf("%s: show isis neighbor: %s", n.ContainerName, strings.TrimSpace(res.Output))
+	}
+
+	// Output format:
+	// Area 1:
+	//   System Id           Interface   L  State        Holdtime SNPA
+	//   R2                  eth0        2  Up            28       2020.2020.2020
+	for _, line := range strings.Split(res.Output, "\n") {
+		f := strings.Fields(line)
+		if len(f) < 5 || f[0] == "System" {
+			continue
+		}
+		switch f[3] {
+		case "Up":
+			up++
+			total++
+		case "Initializing", "Down":
+			total++
+		}
+	}
+	return total, up, nil
+}
+
+// LDPSessions returns the number of LDP neighbors of the node and the number
+// of sessions in the OPERATIONAL state
+func LDPSessions(n project.Node) (total, operational int, err error) {
+	var res struct {
+		Neighbors []struct {
+			State string `json:"state"`
+		} `json:"neighbors"`
+	}
+	if err := vtyshJSON(n, "show mpls ldp neighbor json", &res); err != nil {
+		return 0, 0, err
+	}
+	for _, nbr := range res.Neighbors {
+		total++
+		if strings.EqualFold(nbr.State, "OPERATIONAL") {
+			operational++
+		}
+	}
+	return total, operational, nil
+}
+
+// RPKIConnected returns true if the node is connected to one of its RPKI
+// caches
+func RPKIConnected(n project.Node) (bool, error) {
+	res := n.Vtysh("show rpki cache-connection")
+	if res.Err != nil {
+		return false, res.Err
+	}
+	if res.ExitCode != 0 {
+		return false, fmt.Errorf("%s: show rpki cache-connection: %s", n.ContainerName, strings.TrimSpace(res.Output))
+	}
+	return strings.Contains(res.Output, "Connected to group"), nil
+}
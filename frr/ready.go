@@ -0,0 +1,214 @@
+package frr
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rahveiz/topomate/config"
+	"github.com/rahveiz/topomate/project"
+	"github.com/rahveiz/topomate/utils"
+)
+
+// Default values used when waiting for a lab to be ready
+const (
+	DefaultReadyTimeout  = 5 * time.Minute
+	DefaultReadyInterval = 2 * time.Second
+	DefaultReadyStable   = 10 * time.Second
+)
+
+// ReadyOptions contains the settings used by WaitReady
+type ReadyOptions struct {
+	// Maximum time to wait
+	Timeout time.Duration
+	// Time between two polls
+	Interval time.Duration
+	// Time during which the RIB sizes must not change
+	Stable time.Duration
+}
+
+// SessionStatus is the state of an expected BGP session
+type SessionStatus struct {
+	Node     string
+	Neighbor string
+	State    string
+}
+
+// ReadyReport is the result of WaitReady
+type ReadyReport struct {
+	Ready    bool
+	Elapsed  time.Duration
+	Sessions int
+	Pending  []SessionStatus
+	Unstable []string
+	Errors   map[string]error
+}
+
+// nodeState is the last known state of a router
+type nodeState struct {
+	sessions   map[string]string
+	ribSize    int
+	lastChange time.Time
+	err        error
+}
+
+// DefaultReadyOptions returns the default settings used by WaitReady
+func DefaultReadyOptions() ReadyOptions {
+	return ReadyOptions{
+		Timeout:  DefaultReadyTimeout,
+		Interval: DefaultReadyInterval,
+		Stable:   DefaultReadyStable,
+	}
+}
+
+// ReadyNodes returns the nodes running BGP for which WaitReady checks the
+// sessions and the RIB
+func ReadyNodes(p *project.Project) []project.Node {
+	all := p.Nodes()
+	res := make([]project.Node, 0, len(all))
+	for _, n := range all {
+		if !n.IsRouter() {
+			continue
+		}
+		if as, ok := p.AS[n.ASN]; ok && as.BGP.Disabled && n.Role != project.RoleCE {
+			continue
+		}
+		res = append(res, n)
+	}
+	return res
+}
+
+// WaitReady polls the routers of the project until every expected BGP session
+// (from Router.Neighbors) is established and the RIB sizes did not change
+// for opts.Stable, or until opts.Timeout expires.
+func WaitReady(p *project.Project, opts ReadyOptions) ReadyReport {
+	nodes := ReadyNodes(p)
+	states := make([]nodeState, len(nodes))
+	start := time.Now()
+	deadline := start.Add(opts.Timeout)
+
+	var report ReadyReport
+	for {
+		now := time.Now()
+		pollStates(nodes, states, now)
+		report = buildReport(nodes, states, now, opts.Stable)
+		report.Elapsed = time.Since(start)
+		if config.VFlag {
+			fmt.Printf("[%4.0fs] %d/%d sessions established, %d unstable RIB(s)\n",
+				report.Elapsed.Seconds(), report.Sessions-len(report.Pending),
+				report.Sessions, len(report.Unstable))
+		}
+		if report.Ready || now.Add(opts.Interval).After(deadline) {
+			break
+		}
+		time.Sleep(opts.Interval)
+	}
+	return report
+}
+
+// pollStates updates the states of all nodes
+func pollStates(nodes []project.Node, states []nodeState, now time.Time) {
+	sem := make(chan struct{}, project.DefaultParallelism)
+	var wg sync.WaitGroup
+	wg.Add(len(nodes))
+	for i := range nodes {
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			s := &states[i]
+			var sessions map[string]string
+			var err error
+			if perr := utils.Catch(func() { sessions, err = BGPNeighborStates(nodes[i]) }); perr != nil {
+				err = perr
+			}
+			if err != nil {
+				s.err = err
+				s.lastChange = now
+				return
+			}
+			var size int
+			if perr := utils.Catch(func() { size, err = RIBSize(nodes[i]) }); perr != nil {
+				err = perr
+			}
+			if err != nil {
+				s.err = err
+				s.lastChange = now
+				return
+			}
+			if s.lastChange.IsZero() || size != s.ribSize || s.err != nil {
+				s.lastChange = now
+			}
+			s.sessions = sessions
+			s.ribSize = size
+			s.err = nil
+		}(i)
+	}
+	wg.Wait()
+}
+
+func buildReport(nodes []project.Node, states []nodeState, now time.Time, stable time.Duration) ReadyReport {
+	report := ReadyReport{
+		Pending: make([]SessionStatus, 0, 8),
+		Errors:  make(map[string]error),
+	}
+	for i, n := range nodes {
+		s := states[i]
+		if s.err != nil {
+			report.Errors[n.ContainerName] = s.err
+		}
+		if now.Sub(s.lastChange) < stable {
+			report.Unstable = append(report.Unstable, n.ContainerName)
+		}
+		for ip := range n.Router.Neighbors {
+			report.Sessions++
+			state, ok := s.sessions[ip]
+			if !ok {
+				state = "Unknown"
+			}
+			if state != "Established" {
+				report.Pending = append(report.Pending, SessionStatus{
+					Node:     n.ContainerName,
+					Neighbor: ip,
+					State:    state,
+				})
+			}
+		}
+	}
+	sort.Slice(report.Pending, func(i, j int) bool {
+		if report.Pending[i].Node != report.Pending[j].Node {
+			return report.Pending[i].Node < report.Pending[j].Node
+		}
+		return report.Pending[i].Neighbor < report.Pending[j].Neighbor
+	})
+	report.Ready = len(report.Pending) == 0 && len(report.Unstable) == 0 &&
+		len(report.Errors) == 0
+	return report
+}
+
+// Write displays the report
+func (r ReadyReport) Write(dst io.Writer) {
+	if r.Ready {
+		fmt.Fprintf(dst, "Lab ready after %.0fs (%d BGP sessions established).\n",
+			r.Elapsed.Seconds(), r.Sessions)
+		return
+	}
+	fmt.Fprintf(dst, "Lab not ready after %.0fs: %d/%d BGP sessions established.\n",
+		r.Elapsed.Seconds(), r.Sessions-len(r.Pending), r.Sessions)
+	if len(r.Pending) > 0 {
+		fmt.Fprintln(dst, "Sessions not established:")
+		for _, s := range r.Pending {
+			fmt.Fprintf(dst, "  %-20s %-40s %s\n", s.Node, s.Neighbor, s.State)
+		}
+	}
+	if len(r.Unstable) > 0 {
+		fmt.Fprintln(dst, "RIB still changing on:", r.Unstable)
+	}
+	for n, err := range r.Errors {
+		fmt.Fprintf(dst, "  %s: %v\n", n, err)
+	}
+}
@@ -0,0 +1,258 @@
+package frr
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/rahveiz/topomate/project"
+)
+
+// BGPPath is a path of a prefix in the BGP table of a running router
+type BGPPath struct {
+	Valid       bool
+	Best        bool
+	ASPath      string
+	Communities string
+	NextHops    []string
+}
+
+// ROAEntry is an entry of the RPKI table of a running router
+type ROAEntry struct {
+	Prefix    net.IPNet
+	MaxLength int
+	ASN       int
+}
+
+// RPKI validation states
+const (
+	RPKIValid    = "valid"
+	RPKIInvalid  = "invalid"
+	RPKINotFound = "notfound"
+)
+
+type bgpPathJSON struct {
+	Valid  bool `json:"valid"`
+	ASPath struct {
+		String string `json:"string"`
+	} `json:"aspath"`
+	Community struct {
+		String string `json:"string"`
+	} `json:"community"`
+	BestPath struct {
+		Overall bool `json:"overall"`
+	} `json:"bestpath"`
+	NextHops []struct {
+		IP string `json:"ip"`
+	} `json:"nexthops"`
+}
+
+// vtyshJSON runs a vtysh command on the node and unmarshals its output in v
+func vtyshJSON(n project.Node, command string, v interface{}) error {
+	res := n.Vtysh(command)
+	if res.Err != nil {
+		return res.Err
+	}
+	if res.ExitCode != 0 {
+		return fmt.Errorf("%s: %s: %s", n.ContainerName, command, strings.TrimSpace(res.Output))
+	}
+	if err := json.Unmarshal([]byte(res.Output), v); err != nil {
+		return fmt.Errorf("%s: %s: %v", n.ContainerName, command, err)
+	}
+	return nil
+}
+
+// afiOf returns the BGP address family name matching the prefix or address
+func afiOf(s string) string {
+	ip := net.ParseIP(strings.SplitN(s, "/", 2)[0])
+	if ip != nil && ip.To4() == nil {
+		return "ipv6"
+	}
+	return "ipv4"
+}
+
+// BGPNeighborState returns the state of the BGP session between the node and
+// the neighbor identified by its address (e.g. "Established")
+func BGPNeighborState(n project.Node, ip string) (string, error) {
+	var res map[string]struct {
+		BGPState string `json:"bgpState"`
+	}
+	if err := vtyshJSON(n, "show bgp neighbors "+ip+" json", &res); err != nil {
+		return "", err
+	}
+	nbr, ok := res[ip]
+	if !ok {
+		return "", fmt.Errorf("%s: neighbor %s not configured", n.ContainerName, ip)
+	}
+	return nbr.BGPState, nil
+}
+
+// BGPNeighborStates returns the state of all the BGP sessions of the node
+// in the default VRF, indexed by neighbor address
+func BGPNeighborStates(n project.Node) (map[string]string, error) {
+	var res map[string]struct {
+		BGPState string `json:"bgpState"`
+	}
+	if err := vtyshJSON(n, "show bgp neighbors json", &res); err != nil {
+		return nil, err
+	}
+	states := make(map[string]string, len(res))
+	for ip, nbr := range res {
+		states[ip] = nbr.BGPState
+	}
+	return states, nil
+}
+
+// BGPUpdatesReceived returns the number of BGP UPDATE messages received by
+// the node from all its neighbors in the default VRF
+func BGPUpdatesReceived(n project.Node) (int, error) {
+	var res map[string]struct {
+		MessageStats struct {
+			UpdatesRecv int `json:"updatesRecv"`
+		} `json:"messageStats"`
+	}
+	if err := vtyshJSON(n, "show bgp neighbors json", &res); err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, nbr := range res {
+		total += nbr.MessageStats.UpdatesRecv
+	}
+	return total, nil
+}
+
+// RIBSize returns the number of IPv4 and IPv6 prefixes in the RIB of the node
+func RIBSize(n project.Node) (int, error) {
+	total := 0
+	for _, command := range []string{"show ip route json", "show ipv6 route json"} {
+		var rib map[string]json.RawMessage
+		if err := vtyshJSON(n, command, &rib); err != nil {
+			return 0, err
+		}
+		total += len(rib)
+	}
+	return total, nil
+}
+
+// BGPRoute returns the paths of prefix in the BGP table of the node. If vrf
+// is not empty, the VRF table is used instead of the default one.
+func BGPRoute(n project.Node, vrf, prefix string) ([]BGPPath, error) {
+	command := "show bgp "
+	if vrf != "" {
+		command += "vrf " + vrf + " "
+	}
+	command += afiOf(prefix) + " unicast " + prefix + " json"
+
+	res := n.Vtysh(command)
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	// FRR does not output JSON when the prefix is not found
+	if strings.Contains(res.Output, "not in table") {
+		return nil, nil
+	}
+	var table struct {
+		Prefix string        `json:"prefix"`
+		Paths  []bgpPathJSON `json:"paths"`
+	}
+	if err := json.Unmarshal([]byte(res.Output), &table); err != nil {
+		return nil, fmt.Errorf("%s: %s: %v", n.ContainerName, command, err)
+	}
+	if table.Prefix != "" && table.Prefix != prefix {
+		// a covering prefix has been returned
+		return nil, nil
+	}
+	paths := make([]BGPPath, len(table.Paths))
+	for i, p := range table.Paths {
+		paths[i] = BGPPath{
+			Valid:       p.Valid,
+			Best:        p.BestPath.Overall,
+			ASPath:      p.ASPath.String,
+			Communities: p.Community.String,
+			NextHops:    make([]string, len(p.NextHops)),
+		}
+		for j, nh := range p.NextHops {
+			paths[i].NextHops[j] = nh.IP
+		}
+	}
+	return paths, nil
+}
+
+// OriginAS returns the origin AS of the path, or 0 if the route is local
+func (p BGPPath) OriginAS() int {
+	fields := strings.Fields(p.ASPath)
+	if len(fields) == 0 {
+		return 0
+	}
+	asn, err := strconv.Atoi(strings.Trim(fields[len(fields)-1], "{}"))
+	if err != nil {
+		return 0
+	}
+	return asn
+}
+
+// RPKIPrefix returns the ROA entries covering prefix known by the node
+func RPKIPrefix(n project.Node, prefix string) ([]ROAEntry, error) {
+	res := n.Vtysh("show rpki prefix " + prefix)
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	if res.ExitCode != 0 {
+		return nil, fmt.Errorf("%s: show rpki prefix: %s", n.ContainerName, strings.TrimSpace(res.Output))
+	}
+
+	// Output format:
+	// Prefix             Prefix Length  Origin-AS
+	// 10.1.1.0              22 -  32        100
+	entries := make([]ROAEntry, 0, 4)
+	for _, line := range strings.Split(res.Output, "\n") {
+		f := strings.Fields(line)
+		if len(f) != 5 || f[2] != "-" {
+			continue
+		}
+		ip := net.ParseIP(f[0])
+		min, err1 := strconv.Atoi(f[1])
+		max, err2 := strconv.Atoi(f[3])
+		asn, err3 := strconv.Atoi(f[4])
+		if ip == nil || err1 != nil || err2 != nil || err3 != nil {
+			continue
+		}
+		bits := 32
+		if ip.To4() == nil {
+			bits = 128
+		}
+		entries = append(entries, ROAEntry{
+			Prefix:    net.IPNet{IP: ip, Mask: net.CIDRMask(min, bits)},
+			MaxLength: max,
+			ASN:       asn,
+		})
+	}
+	return entries, nil
+}
+
+// RPKIState returns the validation state of the prefix originated by origin
+// based on the covering ROA entries (RFC 6811)
+func RPKIState(roas []ROAEntry, prefix string, origin int) (string, error) {
+	_, n, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return "", err
+	}
+	length, _ := n.Mask.Size()
+	covered := false
+	for _, roa := range roas {
+		roaLen, _ := roa.Prefix.Mask.Size()
+		if roaLen > length || !roa.Prefix.Contains(n.IP) {
+			continue
+		}
+		covered = true
+		if roa.ASN == origin && origin != 0 && length <= roa.MaxLength {
+			return RPKIValid, nil
+		}
+	}
+	if covered {
+		return RPKIInvalid, nil
+	}
+	return RPKINotFound, nil
+}
@@ -34,6 +34,7 @@ type IfConfig struct {
 	IPs         []net.IPNet
 	IGPConfig   []IGPIfConfig
 	Speed       int
+	MTU         int
 	External    bool
 	VRF         string
 }
@@ -0,0 +1,274 @@
+package convergence
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rahveiz/topomate/frr"
+	"github.com/rahveiz/topomate/internal/ovsdocker"
+	"github.com/rahveiz/topomate/project"
+	"github.com/rahveiz/topomate/utils"
+)
+
+// Default measurement settings
+const (
+	DefaultQuiet   = 10 * time.Second
+	DefaultTimeout = 5 * time.Minute
+	pollInterval   = 200 * time.Millisecond
+	// time given to the monitors to start before the event is triggered
+	monitorDelay = time.Second
+)
+
+// Options contains the settings used by Measure
+type Options struct {
+	// Time without any FIB change after which the network is considered
+	// converged
+	Quiet time.Duration
+	// Maximum duration of the measurement
+	Timeout time.Duration
+}
+
+// DefaultOptions returns the default measurement settings
+func DefaultOptions() Options {
+	return Options{
+		Quiet:   DefaultQuiet,
+		Timeout: DefaultTimeout,
+	}
+}
+
+// NodeResult contains the changes observed on a router after the event.
+// Durations are relative to the event.
+type NodeResult struct {
+	Node        string        `json:"node"`
+	FIBUpdates  int           `json:"fib_updates"`
+	BGPUpdates  int           `json:"bgp_updates"`
+	First       time.Duration `json:"first_change,omitempty"`
+	Convergence time.Duration `json:"convergence"`
+}
+
+// Report is the result of a convergence measurement
+type Report struct {
+	Event       string        `json:"event"`
+	Start       time.Time     `json:"start"`
+	Converged   bool          `json:"converged"`
+	Convergence time.Duration `json:"convergence"`
+	FIBUpdates  int           `json:"fib_updates"`
+	BGPUpdates  int           `json:"bgp_updates"`
+	Nodes       []NodeResult  `json:"nodes"`
+}
+
+// monitor records the FIB changes of a router using "ip monitor route" in
+// its network namespace
+type monitor struct {
+	node    project.Node
+	cmd     *exec.Cmd
+	lock    sync.Mutex
+	updates int
+	first   time.Time
+	last    time.Time
+	done    chan struct{}
+}
+
+func startMonitor(n project.Node) (*monitor, error) {
+	c, err := ovsdocker.New(n.ContainerName)
+	if err != nil {
+		return nil, err
+	}
+	m := &monitor{
+		node: n,
+		cmd: utils.ExecSudo("nsenter", "-t", strconv.Itoa(c.PID), "-n",
+			"ip", "monitor", "route"),
+		done: make(chan struct{}),
+	}
+	out, err := m.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := m.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("%s: %v", n.ContainerName, err)
+	}
+	go func() {
+		defer close(m.done)
+		scanner := bufio.NewScanner(out)
+		for scanner.Scan() {
+			line := scanner.Text()
+			// multipath routes are followed by indented nexthop lines
+			if line == "" || line[0] == ' ' || line[0] == '\t' {
+				continue
+			}
+			now := time.Now()
+			m.lock.Lock()
+			if m.updates == 0 {
+				m.first = now
+			}
+			m.updates++
+			m.last = now
+			m.lock.Unlock()
+		}
+	}()
+	return m, nil
+}
+
+func (m *monitor) lastChange() time.Time {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.last
+}
+
+func (m *monitor) stop() {
+	m.cmd.Process.Signal(syscall.SIGTERM)
+	<-m.done
+	m.cmd.Wait()
+}
+
+// bgpUpdates returns the number of BGP updates received by each node
+func bgpUpdates(nodes []project.Node) []int {
+	res := make([]int, len(nodes))
+	var wg sync.WaitGroup
+	wg.Add(len(nodes))
+	for i, n := range nodes {
+		go func(i int, n project.Node) {
+			defer wg.Done()
+			utils.Catch(func() { res[i], _ = frr.BGPUpdatesReceived(n) })
+		}(i, n)
+	}
+	wg.Wait()
+	return res
+}
+
+// Measure records the FIB changes of the routers after the event triggered
+// by trigger, until no change is seen for opts.Quiet or opts.Timeout
+// expires. The convergence time of a router is the time of its last FIB
+// change after the event.
+func Measure(nodes []project.Node, event string, trigger func() error, opts Options) (*Report, error) {
+	routers := make([]project.Node, 0, len(nodes))
+	for _, n := range nodes {
+		if n.IsRouter() {
+			routers = append(routers, n)
+		}
+	}
+
+	monitors := make([]*monitor, len(routers))
+	errs := make([]error, len(routers))
+	var wg sync.WaitGroup
+	wg.Add(len(routers))
+	for i, n := range routers {
+		go func(i int, n project.Node) {
+			defer wg.Done()
+			if err := utils.Catch(func() { monitors[i], errs[i] = startMonitor(n) }); err != nil {
+				errs[i] = err
+			}
+		}(i, n)
+	}
+	wg.Wait()
+	stopAll := func() {
+		for _, m := range monitors {
+			if m != nil {
+				m.stop()
+			}
+		}
+	}
+	for _, err := range errs {
+		if err != nil {
+			stopAll()
+			return nil, err
+		}
+	}
+	before := bgpUpdates(routers)
+	time.Sleep(monitorDelay)
+
+	report := &Report{
+		Event: event,
+		Start: time.Now(),
+		Nodes: make([]NodeResult, len(routers)),
+	}
+	if err := trigger(); err != nil {
+		stopAll()
+		return nil, err
+	}
+
+	for {
+		time.Sleep(pollInterval)
+		now := time.Now()
+		last := report.Start
+		for _, m := range monitors {
+			if l := m.lastChange(); l.After(last) {
+				last = l
+			}
+		}
+		if now.Sub(last) >= opts.Quiet {
+			report.Converged = true
+			break
+		}
+		if now.Sub(report.Start) >= opts.Timeout {
+			break
+		}
+	}
+	stopAll()
+	after := bgpUpdates(routers)
+
+	for i, m := range monitors {
+		res := NodeResult{
+			Node:       m.node.ContainerName,
+			FIBUpdates: m.updates,
+			BGPUpdates: after[i] - before[i],
+		}
+		if m.updates > 0 {
+			res.First = m.first.Sub(report.Start)
+			res.Convergence = m.last.Sub(report.Start)
+		}
+		if res.Convergence > report.Convergence {
+			report.Convergence = res.Convergence
+		}
+		report.FIBUpdates += res.FIBUpdates
+		report.BGPUpdates += res.BGPUpdates
+		report.Nodes[i] = res
+	}
+	sort.Slice(report.Nodes, func(i, j int) bool {
+		return report.Nodes[i].Convergence > report.Nodes[j].Convergence
+	})
+	return report, nil
+}
+
+func ms(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 0, 64) + "ms"
+}
+
+// Write displays the report, routers being sorted by convergence time
+func (r *Report) Write(dst io.Writer) {
+	w := tabwriter.NewWriter(dst, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tFIB UPDATES\tBGP UPDATES\tFIRST CHANGE\tCONVERGENCE")
+	for _, n := range r.Nodes {
+		if n.FIBUpdates == 0 {
+			fmt.Fprintf(w, "%s\t0\t%d\t-\t-\n", n.Node, n.BGPUpdates)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\n", n.Node, n.FIBUpdates, n.BGPUpdates, ms(n.First), ms(n.Convergence))
+	}
+	w.Flush()
+	status := "converged"
+	if !r.Converged {
+		status = "NOT converged (timeout)"
+	}
+	fmt.Fprintf(dst, "\n%s: %s in %s, %d FIB update(s), %d BGP update(s)\n",
+		r.Event, status, ms(r.Convergence), r.FIBUpdates, r.BGPUpdates)
+}
+
+// WriteJSON writes the report in JSON format
+func (r *Report) WriteJSON(dst io.Writer) error {
+	j, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(dst, string(j))
+	return err
+}
@@ -0,0 +1,316 @@
+package exporter
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rahveiz/topomate/config"
+	"github.com/rahveiz/topomate/frr"
+	"github.com/rahveiz/topomate/internal/monitor"
+	"github.com/rahveiz/topomate/project"
+	"github.com/rahveiz/topomate/utils"
+)
+
+// DefaultAddress is the default listening address of the exporter
+const DefaultAddress = ":9273"
+
+// Exporter collects the state of the routing protocols and the interface
+// counters of a running topology
+type Exporter struct {
+	Project *project.Project
+	// Nodes whose metrics are exported
+	Nodes []project.Node
+	// Source of the interface counters (monitor.SourceSysfs or
+	// monitor.SourceOVS), empty to disable them
+	Source string
+	// Maximum number of nodes queried at the same time
+	Parallel int
+	// Metrics collected less than MaxAge ago are reused
+	MaxAge time.Duration
+
+	mu     sync.Mutex
+	last   time.Time
+	cached []byte
+}
+
+// nodeStats contains the metrics collected on a node
+type nodeStats struct {
+	sessions []frr.BGPSession
+	rib      map[string]int
+	ospf     *[2]int
+	isis     *[2]int
+	ldp      *[2]int
+	rpki     *bool
+	errors   []error
+}
+
+func (s *nodeStats) fail(err error) {
+	s.errors = append(s.errors, err)
+}
+
+// collectNode queries the protocols running on a router
+func (e *Exporter) collectNode(n project.Node) *nodeStats {
+	s := &nodeStats{}
+	as := e.Project.AS[n.ASN]
+
+	if as == nil || !as.BGP.Disabled || n.Role == project.RoleCE {
+		if sessions, err := frr.BGPSessions(n); err != nil {
+			s.fail(err)
+		} else {
+			s.sessions = sessions
+		}
+	}
+	if rib, err := frr.RIBSizes(n); err != nil {
+		s.fail(err)
+	} else {
+		s.rib = rib
+	}
+	if as == nil {
+		return s
+	}
+
+	pair := func(total, ok int, err error) *[2]int {
+		if err != nil {
+			s.fail(err)
+			return nil
+		}
+		return &[2]int{total, ok}
+	}
+	switch as.IGPType() {
+	case project.IGPOSPF:
+		s.ospf = pair(frr.OSPFNeighbors(n))
+	case project.IGPISIS:
+		s.isis = pair(frr.ISISAdjacencies(n))
+	}
+	if as.MPLS {
+		s.ldp = pair(frr.LDPSessions(n))
+	}
+	if len(as.RPKI.Servers) > 0 {
+		if connected, err := frr.RPKIConnected(n); err != nil {
+			s.fail(err)
+		} else {
+			s.rpki = &connected
+		}
+	}
+	return s
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Collect queries the nodes and returns the metric families
+func (e *Exporter) Collect() []*Family {
+	start := time.Now()
+
+	var (
+		nodeUp      = NewFamily("topomate_node_up", Gauge, "Whether the routing daemons of the node answered")
+		errs        = NewFamily("topomate_collector_errors", Gauge, "Number of commands that failed on the node during the last collection")
+		bgpUp       = NewFamily("topomate_bgp_session_established", Gauge, "Whether the BGP session is established, the state label contains the FSM state")
+		bgpUptime   = NewFamily("topomate_bgp_session_uptime_seconds", Gauge, "Time since the BGP session is established")
+		bgpRcvd     = NewFamily("topomate_bgp_prefixes_received", Gauge, "Number of prefixes received from the BGP neighbor")
+		bgpAccepted = NewFamily("topomate_bgp_prefixes_accepted", Gauge, "Number of prefixes received from the BGP neighbor accepted by the inbound policy")
+		rib         = NewFamily("topomate_rib_routes", Gauge, "Number of routes in the RIB of the node")
+		ospfNbrs    = NewFamily("topomate_ospf_neighbors", Gauge, "Number of OSPF neighbors of the node")
+		ospfFull    = NewFamily("topomate_ospf_adjacencies_full", Gauge, "Number of OSPF adjacencies in the Full state")
+		isisAdjs    = NewFamily("topomate_isis_adjacencies", Gauge, "Number of IS-IS adjacencies of the node")
+		isisUp      = NewFamily("topomate_isis_adjacencies_up", Gauge, "Number of IS-IS adjacencies in the Up state")
+		ldpNbrs     = NewFamily("topomate_ldp_neighbors", Gauge, "Number of LDP neighbors of the node")
+		ldpOper     = NewFamily("topomate_ldp_sessions_operational", Gauge, "Number of LDP sessions in the OPERATIONAL state")
+		rpki        = NewFamily("topomate_rpki_cache_connected", Gauge, "Whether the node is connected to one of its RPKI caches")
+		duration    = NewFamily("topomate_collector_duration_seconds", Gauge, "Duration of the last collection")
+	)
+
+	routers := make([]project.Node, 0, len(e.Nodes))
+	for _, n := range e.Nodes {
+		if n.IsRouter() {
+			routers = append(routers, n)
+		}
+	}
+	stats := make([]*nodeStats, len(routers))
+	parallel := e.Parallel
+	if parallel < 1 {
+		parallel = project.DefaultParallelism
+	}
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	wg.Add(len(routers))
+	for i := range routers {
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			stats[i] = e.collectNode(routers[i])
+		}(i)
+	}
+	wg.Wait()
+
+	for i, n := range routers {
+		s := stats[i]
+		node := n.ContainerName
+		nodeUp.Add(boolValue(s.rib != nil), "node", node, "asn", strconv.Itoa(n.ASN), "role", n.Role)
+		errs.Add(float64(len(s.errors)), "node", node)
+		if config.VFlag {
+			for _, err := range s.errors {
+				utils.PrintError(err)
+			}
+		}
+
+		sort.Slice(s.sessions, func(i, j int) bool {
+			return s.sessions[i].Neighbor < s.sessions[j].Neighbor
+		})
+		for _, sess := range s.sessions {
+			bgpUp.Add(boolValue(sess.Established()), "node", node, "neighbor", sess.Neighbor,
+				"remote_as", strconv.Itoa(sess.RemoteAS), "state", sess.State)
+			bgpUptime.Add(sess.Uptime.Seconds(), "node", node, "neighbor", sess.Neighbor)
+			for _, afi := range sortedKeys(sess.Received) {
+				bgpRcvd.Add(float64(sess.Received[afi]), "node", node, "neighbor", sess.Neighbor, "afi", afi)
+			}
+			for _, afi := range sortedKeys(sess.Accepted) {
+				bgpAccepted.Add(float64(sess.Accepted[afi]), "node", node, "neighbor", sess.Neighbor, "afi", afi)
+			}
+		}
+		for _, afi := range sortedKeys(s.rib) {
+			rib.Add(float64(s.rib[afi]), "node", node, "afi", afi)
+		}
+		if s.ospf != nil {
+			ospfNbrs.Add(float64(s.ospf[0]), "node", node)
+			ospfFull.Add(float64(s.ospf[1]), "node", node)
+		}
+		if s.isis != nil {
+			isisAdjs.Add(float64(s.isis[0]), "node", node)
+			isisUp.Add(float64(s.isis[1]), "node", node)
+		}
+		if s.ldp != nil {
+			ldpNbrs.Add(float64(s.ldp[0]), "node", node)
+			ldpOper.Add(float64(s.ldp[1]), "node", node)
+		}
+		if s.rpki != nil {
+			rpki.Add(boolValue(*s.rpki), "node", node)
+		}
+	}
+
+	families := []*Family{nodeUp, errs, bgpUp, bgpUptime, bgpRcvd, bgpAccepted,
+		rib, ospfNbrs, ospfFull, isisAdjs, isisUp, ldpNbrs, ldpOper, rpki}
+	if e.Source != "" {
+		families = append(families, e.interfaceFamilies()...)
+	}
+	duration.Add(time.Since(start).Seconds())
+	return append(families, duration)
+}
+
+// interfaceFamilies returns the counters of the linked interfaces of the
+// selected nodes, seen from the nodes
+func (e *Exporter) interfaceFamilies() []*Family {
+	counters, err := monitor.ReadCounters(e.Source)
+	if err != nil {
+		if config.VFlag {
+			utils.PrintError(err)
+		}
+		return nil
+	}
+	selected := make(map[string]bool, len(e.Nodes))
+	for _, n := range e.Nodes {
+		selected[n.ContainerName] = true
+	}
+	links := e.Project.LinkNames()
+
+	newCounter := func(dir, name, help string) *Family {
+		return NewFamily("topomate_interface_"+dir+"_"+name+"_total", Counter, help)
+	}
+	var (
+		rxBytes   = newCounter("receive", "bytes", "Number of bytes received on the interface")
+		txBytes   = newCounter("transmit", "bytes", "Number of bytes sent on the interface")
+		rxPackets = newCounter("receive", "packets", "Number of packets received on the interface")
+		txPackets = newCounter("transmit", "packets", "Number of packets sent on the interface")
+		rxDropped = newCounter("receive", "drops", "Number of received packets dropped")
+		txDropped = newCounter("transmit", "drops", "Number of sent packets dropped")
+		rxErrors  = newCounter("receive", "errors", "Number of receive errors")
+		txErrors  = newCounter("transmit", "errors", "Number of transmit errors")
+	)
+
+	keys := make([]string, 0, len(counters))
+	for k := range counters {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		name, linked := links[k]
+		node, iface := splitPort(k)
+		if !linked || !selected[node] {
+			continue
+		}
+		c := counters[k]
+		labels := []string{"node", node, "interface", iface, "link", name}
+		rxBytes.Add(float64(c.RxBytes), labels...)
+		txBytes.Add(float64(c.TxBytes), labels...)
+		rxPackets.Add(float64(c.RxPackets), labels...)
+		txPackets.Add(float64(c.TxPackets), labels...)
+		rxDropped.Add(float64(c.RxDropped), labels...)
+		txDropped.Add(float64(c.TxDropped), labels...)
+		rxErrors.Add(float64(c.RxErrors), labels...)
+		txErrors.Add(float64(c.TxErrors), labels...)
+	}
+	return []*Family{rxBytes, txBytes, rxPackets, txPackets, rxDropped, txDropped, rxErrors, txErrors}
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	if e.cached == nil || time.Since(e.last) >= e.MaxAge {
+		var buf bytes.Buffer
+		if err := Write(&buf, e.Collect()); err != nil {
+			e.mu.Unlock()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		e.cached, e.last = buf.Bytes(), time.Now()
+	}
+	body := e.cached
+	e.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(body)
+}
+
+// Handler returns the HTTP handler serving the metrics on /metrics
+func (e *Exporter) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintln(w, `<html><body><a href="/metrics">Metrics</a></body></html>`)
+	})
+	return mux
+}
+
+func sortedKeys(m map[string]int) []string {
+	res := make([]string, 0, len(m))
+	for k := range m {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// splitPort splits a <container>:<interface> key
+func splitPort(k string) (string, string) {
+	if idx := strings.LastIndex(k, ":"); idx >= 0 {
+		return k[:idx], k[idx+1:]
+	}
+	return k, ""
+}
@@ -0,0 +1,74 @@
+package exporter
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Metric types
+const (
+	Gauge   = "gauge"
+	Counter = "counter"
+)
+
+// Sample is a value of a metric with its labels, stored as name/value pairs
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+// Family is a metric with all its samples
+type Family struct {
+	Name    string
+	Help    string
+	Type    string
+	Samples []Sample
+}
+
+// NewFamily returns an empty metric family
+func NewFamily(name, typ, help string) *Family {
+	return &Family{Name: name, Type: typ, Help: help, Samples: make([]Sample, 0, 16)}
+}
+
+// Add adds a sample to the family. labels contains the names and values of
+// the labels (name1, value1, name2, value2...).
+func (f *Family) Add(value float64, labels ...string) {
+	f.Samples = append(f.Samples, Sample{Labels: labels, Value: value})
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// Write writes the families in the Prometheus text exposition format.
+// Families without samples are skipped.
+func Write(dst io.Writer, families []*Family) error {
+	w := bufio.NewWriter(dst)
+	for _, f := range families {
+		if len(f.Samples) == 0 {
+			continue
+		}
+		w.WriteString("# HELP " + f.Name + " " + helpEscaper.Replace(f.Help) + "\n")
+		w.WriteString("# TYPE " + f.Name + " " + f.Type + "\n")
+		for _, s := range f.Samples {
+			w.WriteString(f.Name)
+			if len(s.Labels) > 1 {
+				w.WriteByte('{')
+				for i := 0; i+1 < len(s.Labels); i += 2 {
+					if i > 0 {
+						w.WriteByte(',')
+					}
+					w.WriteString(s.Labels[i] + `="` + labelEscaper.Replace(s.Labels[i+1]) + `"`)
+				}
+				w.WriteByte('}')
+			}
+			w.WriteByte(' ')
+			w.WriteString(strconv.FormatFloat(s.Value, 'g', -1, 64))
+			w.WriteByte('\n')
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,177 @@
+package flows
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"time"
+
+	"github.com/rahveiz/topomate/utils"
+)
+
+// DefaultInterval is the default aggregation interval of the collector
+const DefaultInterval = 10 * time.Second
+
+// maxDatagram is the maximum size of a UDP datagram
+const maxDatagram = 65535
+
+type decoder interface {
+	Decode(b []byte) ([]Sample, error)
+}
+
+// Collector receives the sFlow or IPFIX datagrams exported by the bridges,
+// and writes the flow records and the throughput of each port every interval
+type Collector struct {
+	Protocol string
+	// Sampling is the sampling rate configured on the bridges, used to scale
+	// the IPFIX counters
+	Sampling int
+	Interval time.Duration
+	Resolve  Resolver
+	// OnFlush is called after the records of an interval are written
+	OnFlush func(points []Point)
+
+	// Datagrams is the number of datagrams received
+	Datagrams int
+	// Unknown is the number of samples whose port is not part of the project
+	Unknown int
+	// Errors is the number of datagrams which could not be decoded
+	Errors int
+
+	records map[recordKey]*Record
+	points  map[string]*Point
+}
+
+type recordKey struct {
+	port string
+	key  Key
+}
+
+func (c *Collector) decoder() (decoder, error) {
+	switch c.Protocol {
+	case SFlow:
+		return sflowDecoder{}, nil
+	case IPFIX:
+		return newIPFIXDecoder(c.Sampling), nil
+	}
+	return nil, fmt.Errorf("unknown flow export protocol %s (sflow or ipfix)", c.Protocol)
+}
+
+// Run listens on addr until ctx is cancelled. The records received during
+// the last interval are written before returning.
+func (c *Collector) Run(ctx context.Context, addr string) error {
+	dec, err := c.decoder()
+	if err != nil {
+		return err
+	}
+	if c.Interval <= 0 {
+		c.Interval = DefaultInterval
+	}
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	c.records = make(map[recordKey]*Record)
+	c.points = make(map[string]*Point)
+
+	datagrams := make(chan []byte, 64)
+	go func() {
+		defer close(datagrams)
+		for {
+			buf := make([]byte, maxDatagram)
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			datagrams <- buf[:n]
+		}
+	}()
+
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+	start := time.Now()
+	for {
+		select {
+		case b, ok := <-datagrams:
+			if !ok {
+				return c.flush(time.Since(start))
+			}
+			c.Datagrams++
+			samples, err := dec.Decode(b)
+			if err != nil {
+				c.Errors++
+			}
+			c.add(samples)
+		case now := <-ticker.C:
+			if err := c.flush(now.Sub(start)); err != nil {
+				conn.Close()
+				return err
+			}
+			start = now
+		case <-ctx.Done():
+			// the reader returns once the socket is closed
+			conn.Close()
+			for range datagrams {
+			}
+			return c.flush(time.Since(start))
+		}
+	}
+}
+
+func (c *Collector) add(samples []Sample) {
+	for _, s := range samples {
+		port, ok := c.Resolve(s.Domain, s.Iface)
+		if !ok {
+			c.Unknown++
+			continue
+		}
+		k := recordKey{port.Name, s.Flow}
+		r, ok := c.records[k]
+		if !ok {
+			r = &Record{Port: port.Name, Link: port.Link, Key: s.Flow}
+			c.records[k] = r
+		}
+		r.Bytes += s.Bytes
+		r.Packets += s.Packets
+
+		p, ok := c.points[port.Name]
+		if !ok {
+			p = &Point{Port: port.Name, Link: port.Link}
+			c.points[port.Name] = p
+		}
+		p.Bytes += s.Bytes
+		p.Packets += s.Packets
+	}
+}
+
+// flush writes the records of the interval which lasted d
+func (c *Collector) flush(d time.Duration) error {
+	now := time.Now()
+	records := make([]interface{}, 0, len(c.records))
+	for _, r := range c.records {
+		r.Time = now
+		records = append(records, r)
+	}
+	points := make([]Point, 0, len(c.points))
+	values := make([]interface{}, 0, len(c.points))
+	for _, p := range c.points {
+		p.Time = now
+		p.Interval = d.Seconds()
+		points = append(points, *p)
+		values = append(values, p)
+	}
+	c.records = make(map[recordKey]*Record)
+	c.points = make(map[string]*Point)
+
+	if err := utils.AppendJSONLines(filepath.Join(Dir(), flowsFile), records); err != nil {
+		return err
+	}
+	if err := utils.AppendJSONLines(filepath.Join(Dir(), throughputFile), values); err != nil {
+		return err
+	}
+	if c.OnFlush != nil {
+		c.OnFlush(points)
+	}
+	return nil
+}
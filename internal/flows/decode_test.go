@@ -0,0 +1,343 @@
+package flows
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// builder writes big-endian fields
+type builder struct {
+	bytes.Buffer
+}
+
+func (b *builder) u8(v uint8) *builder {
+	b.WriteByte(v)
+	return b
+}
+
+func (b *builder) u16(v uint16) *builder {
+	binary.Write(b, binary.BigEndian, v)
+	return b
+}
+
+func (b *builder) u32(v uint32) *builder {
+	binary.Write(b, binary.BigEndian, v)
+	return b
+}
+
+func (b *builder) u64(v uint64) *builder {
+	binary.Write(b, binary.BigEndian, v)
+	return b
+}
+
+func (b *builder) raw(v []byte) *builder {
+	b.Write(v)
+	return b
+}
+
+// opaque writes a length-prefixed field padded to 4 bytes (XDR)
+func (b *builder) opaque(v []byte) *builder {
+	b.u32(uint32(len(v)))
+	b.Write(v)
+	b.Write(make([]byte, (4-len(v)%4)%4))
+	return b
+}
+
+// ethernetIPv4 returns an Ethernet frame header carrying a TCP segment
+func ethernetIPv4(src, dst [4]byte, sport, dport uint16) []byte {
+	b := &builder{}
+	b.raw(make([]byte, 12)).u16(etherIPv4)
+	// version/IHL, TOS, total length, ID, flags/fragment offset, TTL
+	b.u8(0x45).u8(0).u16(60).u16(1).u16(0x4000).u8(64)
+	b.u8(protoTCP).u16(0).raw(src[:]).raw(dst[:])
+	b.u16(sport).u16(dport).raw(make([]byte, 16))
+	return b.Bytes()
+}
+
+// ethernetVLANIPv6 returns a tagged Ethernet frame header carrying a UDP
+// datagram
+func ethernetVLANIPv6(src, dst [16]byte, sport, dport uint16) []byte {
+	b := &builder{}
+	b.raw(make([]byte, 12)).u16(etherVLAN).u16(10).u16(etherIPv6)
+	// version/class/label, payload length, next header, hop limit
+	b.u32(6 << 28).u16(8).u8(protoUDP).u8(64).raw(src[:]).raw(dst[:])
+	b.u16(sport).u16(dport).u16(8).u16(0)
+	return b.Bytes()
+}
+
+// rawHeaderRecord returns a sampled header flow record
+func rawHeaderRecord(frameLen uint32, header []byte) []byte {
+	b := &builder{}
+	b.u32(sflowEthernet).u32(frameLen).u32(4).opaque(header)
+	return b.Bytes()
+}
+
+// flowSample returns a compact flow sample with the given records
+func flowSample(rate, input uint32, records ...[]byte) []byte {
+	b := &builder{}
+	// sequence, source ID, rate, pool, drops, input, output
+	b.u32(1).u32(input).u32(rate).u32(1000).u32(0).u32(input).u32(0)
+	b.u32(uint32(len(records)))
+	for _, r := range records {
+		b.u32(sflowRawHeader).opaque(r)
+	}
+	return b.Bytes()
+}
+
+// expandedFlowSample returns an expanded flow sample with the given records
+func expandedFlowSample(rate, input uint32, records ...[]byte) []byte {
+	b := &builder{}
+	// sequence, source type and index, rate, pool, drops
+	b.u32(1).u32(0).u32(input).u32(rate).u32(1000).u32(0)
+	// input and output format and value
+	b.u32(0).u32(input).u32(0).u32(0)
+	b.u32(uint32(len(records)))
+	for _, r := range records {
+		b.u32(sflowRawHeader).opaque(r)
+	}
+	return b.Bytes()
+}
+
+type sflowSample struct {
+	format uint32
+	data   []byte
+}
+
+// sflowDatagram returns an sFlow v5 datagram sent by an IPv4 agent
+func sflowDatagram(samples ...sflowSample) []byte {
+	b := &builder{}
+	b.u32(sflowVersion).u32(sflowAddrIPv4).raw([]byte{192, 0, 2, 1})
+	// sub-agent, sequence, uptime
+	b.u32(0).u32(7).u32(123456)
+	b.u32(uint32(len(samples)))
+	for _, s := range samples {
+		b.u32(s.format).opaque(s.data)
+	}
+	return b.Bytes()
+}
+
+var (
+	v4Src = [4]byte{10, 0, 0, 1}
+	v4Dst = [4]byte{10, 0, 0, 2}
+	v6Src = [16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}
+	v6Dst = [16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 2}
+)
+
+func TestSFlowDecode(t *testing.T) {
+	tcp := Key{Src: "10.0.0.1", Dst: "10.0.0.2", Proto: protoTCP, SrcPort: 1234, DstPort: 80}
+	udp := Key{Src: "2001:db8::1", Dst: "2001:db8::2", Proto: protoUDP, SrcPort: 5000, DstPort: 53}
+	valid := sflowDatagram(
+		sflowSample{sflowFlowSample, flowSample(64, 5, rawHeaderRecord(100, ethernetIPv4(v4Src, v4Dst, 1234, 80)))},
+		// counter sample
+		sflowSample{2, make([]byte, 16)},
+		// enterprise sample
+		sflowSample{1<<12 | sflowFlowSample, make([]byte, 8)},
+		sflowSample{sflowExpandedSample, expandedFlowSample(10, 7, rawHeaderRecord(90, ethernetVLANIPv6(v6Src, v6Dst, 5000, 53)))},
+	)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		samples []Sample
+		err     bool
+	}{
+		{
+			name: "flow samples",
+			data: valid,
+			samples: []Sample{
+				{Iface: 5, Flow: tcp, Bytes: 6400, Packets: 64},
+				{Iface: 7, Flow: udp, Bytes: 900, Packets: 10},
+			},
+		},
+		{
+			name: "no sample",
+			data: sflowDatagram(),
+		},
+		{
+			name: "unsupported version",
+			data: append([]byte{0, 0, 0, 4}, valid[4:]...),
+			err:  true,
+		},
+		{
+			name: "too many samples",
+			data: (&builder{}).u32(sflowVersion).u32(sflowAddrIPv4).u32(0).
+				u32(0).u32(0).u32(0).u32(0xffffffff).Bytes(),
+			err: true,
+		},
+		{
+			name: "too many flow records",
+			data: sflowDatagram(sflowSample{sflowFlowSample,
+				(&builder{}).raw(make([]byte, 28)).u32(0xffffffff).Bytes()}),
+			err: true,
+		},
+		{
+			name: "sample longer than the datagram",
+			data: (&builder{}).raw(sflowDatagram()[:24]).u32(1).
+				u32(sflowFlowSample).u32(0xfffffff0).Bytes(),
+			err: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			samples, err := sflowDecoder{}.Decode(tt.data)
+			if (err != nil) != tt.err {
+				t.Fatalf("error = %v, want error %v", err, tt.err)
+			}
+			if !tt.err && len(samples)+len(tt.samples) > 0 && !reflect.DeepEqual(samples, tt.samples) {
+				t.Errorf("samples = %+v, want %+v", samples, tt.samples)
+			}
+		})
+	}
+
+	// every truncation of the datagram is detected
+	for i := 0; i < len(valid); i++ {
+		if _, err := (sflowDecoder{}).Decode(valid[:i]); err == nil {
+			t.Errorf("datagram truncated to %d bytes: no error", i)
+		}
+	}
+}
+
+type ipfixSet struct {
+	id   uint16
+	body []byte
+}
+
+// ipfixMessage returns an IPFIX message of the observation domain 1
+func ipfixMessage(sets ...ipfixSet) []byte {
+	body := &builder{}
+	for _, s := range sets {
+		body.u16(s.id).u16(uint16(len(s.body) + 4)).raw(s.body)
+	}
+	b := &builder{}
+	b.u16(ipfixVersion).u16(uint16(ipfixHeaderSize + body.Len()))
+	b.u32(1600000000).u32(1).u32(1).raw(body.Bytes())
+	return b.Bytes()
+}
+
+// ovsTemplate is a template similar to the one of the OVS exporter, with an
+// enterprise field and a variable-length field
+func ovsTemplate(id uint16) []byte {
+	b := &builder{}
+	b.u16(id).u16(9)
+	b.u16(ieIngress).u16(4)
+	b.u16(ieSrcIPv4).u16(4)
+	b.u16(ieDstIPv4).u16(4)
+	b.u16(ieProtocol).u16(1)
+	b.u16(ieSrcPort).u16(2)
+	b.u16(ieDstPort).u16(2)
+	b.u16(ieL2OctetDelta).u16(8)
+	b.u16(iePacketDelta).u16(8)
+	b.u16(ipfixEnterpriseBit | 100).u16(ipfixVariableLength).u32(6876)
+	return b.Bytes()
+}
+
+// ovsRecord returns a data record of ovsTemplate
+func ovsRecord(iface uint32, sport uint16, bytes, packets uint64) []byte {
+	b := &builder{}
+	b.u32(iface).raw(v4Src[:]).raw(v4Dst[:]).u8(protoTCP).u16(sport).u16(80)
+	b.u64(bytes).u64(packets)
+	b.u8(3).raw([]byte("ovs"))
+	return b.Bytes()
+}
+
+func TestIPFIXDecode(t *testing.T) {
+	tcp := func(sport uint16) Key {
+		return Key{Src: "10.0.0.1", Dst: "10.0.0.2", Proto: protoTCP, SrcPort: sport, DstPort: 80}
+	}
+	template := ipfixSet{ipfixTemplateSet, ovsTemplate(256)}
+	data := ipfixSet{256, append(ovsRecord(3, 1234, 1500, 1), ovsRecord(4, 1235, 3000, 2)...)}
+	valid := ipfixMessage(template, data)
+
+	tests := []struct {
+		name string
+		// messages decoded before data
+		before  [][]byte
+		data    []byte
+		samples []Sample
+		err     bool
+	}{
+		{
+			name: "template and data",
+			data: valid,
+			samples: []Sample{
+				{Domain: 1, Iface: 3, Flow: tcp(1234), Bytes: 15000, Packets: 10},
+				{Domain: 1, Iface: 4, Flow: tcp(1235), Bytes: 30000, Packets: 20},
+			},
+		},
+		{
+			name:   "template received before",
+			before: [][]byte{ipfixMessage(template)},
+			data:   ipfixMessage(data),
+			samples: []Sample{
+				{Domain: 1, Iface: 3, Flow: tcp(1234), Bytes: 15000, Packets: 10},
+				{Domain: 1, Iface: 4, Flow: tcp(1235), Bytes: 30000, Packets: 20},
+			},
+		},
+		{
+			name: "unknown template",
+			data: ipfixMessage(data),
+		},
+		{
+			name: "withdrawn template",
+			before: [][]byte{ipfixMessage(template,
+				ipfixSet{ipfixTemplateSet, (&builder{}).u16(256).u16(0).Bytes()})},
+			data: ipfixMessage(data),
+		},
+		{
+			name: "options set ignored",
+			data: ipfixMessage(ipfixSet{ipfixOptionsSet, make([]byte, 12)}),
+		},
+		{
+			name: "unsupported version",
+			data: append([]byte{0, 9}, valid[2:]...),
+			err:  true,
+		},
+		{
+			name: "message length longer than the datagram",
+			data: append(valid[:2:2], append([]byte{0xff, 0xff}, valid[4:]...)...),
+			err:  true,
+		},
+		{
+			name: "message length shorter than the header",
+			data: append(valid[:2:2], append([]byte{0, 8}, valid[4:]...)...),
+			err:  true,
+		},
+		{
+			name: "set length shorter than its header",
+			data: (&builder{}).u16(ipfixVersion).u16(ipfixHeaderSize + 4).
+				u32(0).u32(0).u32(1).u16(256).u16(2).Bytes(),
+			err: true,
+		},
+		{
+			name: "too many template fields",
+			data: ipfixMessage(ipfixSet{ipfixTemplateSet,
+				(&builder{}).u16(256).u16(0xffff).Bytes()}, data),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newIPFIXDecoder(10)
+			for _, b := range tt.before {
+				if _, err := d.Decode(b); err != nil {
+					t.Fatal(err)
+				}
+			}
+			samples, err := d.Decode(tt.data)
+			if (err != nil) != tt.err {
+				t.Fatalf("error = %v, want error %v", err, tt.err)
+			}
+			if !tt.err && len(samples)+len(tt.samples) > 0 && !reflect.DeepEqual(samples, tt.samples) {
+				t.Errorf("samples = %+v, want %+v", samples, tt.samples)
+			}
+		})
+	}
+
+	// every truncation of the message is detected
+	for i := 0; i < len(valid); i++ {
+		if _, err := newIPFIXDecoder(1).Decode(valid[:i]); err == nil {
+			t.Errorf("message truncated to %d bytes: no error", i)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package flows
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rahveiz/topomate/utils"
+)
+
+// Export protocols
+const (
+	SFlow = "sflow"
+	IPFIX = "ipfix"
+)
+
+// Default ports of the collector
+const (
+	DefaultSFlowPort = 6343
+	DefaultIPFIXPort = 4739
+)
+
+const (
+	flowsFile      = "flows.jsonl"
+	throughputFile = "throughput.jsonl"
+)
+
+// Key identifies a flow. Non-IP packets have an empty key.
+type Key struct {
+	Src     string `json:"src,omitempty"`
+	Dst     string `json:"dst,omitempty"`
+	Proto   uint8  `json:"proto,omitempty"`
+	SrcPort uint16 `json:"sport,omitempty"`
+	DstPort uint16 `json:"dport,omitempty"`
+}
+
+// Sample is a decoded flow sample. Bytes and packets are already scaled by
+// the sampling rate.
+type Sample struct {
+	// Domain is the IPFIX observation domain (0 with sFlow)
+	Domain uint32
+	// Iface is the input interface: the ifindex of the host interface with
+	// sFlow, the OpenFlow port with IPFIX
+	Iface   uint32
+	Flow    Key
+	Bytes   uint64
+	Packets uint64
+}
+
+// Port is an OVS port mapped to a link of the project
+type Port struct {
+	// Name is the container interface (<container>:<interface>)
+	Name string
+	// Link is the name of the logical link
+	Link string
+}
+
+// Resolver returns the port matching the interface of a sample
+type Resolver func(domain, iface uint32) (Port, bool)
+
+// Record is the traffic of a flow entering the bridge through a port during
+// an interval
+type Record struct {
+	Time time.Time `json:"time"`
+	Port string    `json:"port"`
+	Link string    `json:"link"`
+	Key
+	Bytes   uint64 `json:"bytes"`
+	Packets uint64 `json:"packets"`
+}
+
+// Point is the throughput of a port (traffic entering the bridge) during an
+// interval
+type Point struct {
+	Time     time.Time `json:"time"`
+	Port     string    `json:"port"`
+	Link     string    `json:"link"`
+	Interval float64   `json:"interval"`
+	Bytes    uint64    `json:"bytes"`
+	Packets  uint64    `json:"packets"`
+}
+
+// Bps returns the throughput in bits per second
+func (p Point) Bps() float64 {
+	if p.Interval <= 0 {
+		return 0
+	}
+	return float64(p.Bytes) * 8 / p.Interval
+}
+
+// Dir returns the directory where the flow records of the current project
+// are stored
+func Dir() string {
+	return filepath.Join(utils.GetDirectoryFromKey("ConfigDir", ""), "traffic")
+}
+
+// Reset removes the stored records
+func Reset() error {
+	for _, f := range []string{flowsFile, throughputFile} {
+		if err := os.Remove(filepath.Join(Dir(), f)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// readLines decodes the JSON lines of a file of the directory, a missing
+// file is empty
+func readLines(name string, fn func(*json.Decoder) error) error {
+	f, err := os.Open(filepath.Join(Dir(), name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for dec.More() {
+		if err := fn(dec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
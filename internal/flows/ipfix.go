@@ -0,0 +1,197 @@
+package flows
+
+import (
+	"fmt"
+	"net"
+)
+
+// IPFIX structures (RFC 7011) and information elements (RFC 7012)
+const (
+	ipfixVersion        = 10
+	ipfixHeaderSize     = 16
+	ipfixTemplateSet    = 2
+	ipfixOptionsSet     = 3
+	ipfixMinDataSet     = 256
+	ipfixVariableLength = 65535
+	ipfixEnterpriseBit  = 0x8000
+
+	ieOctetDelta     = 1
+	iePacketDelta    = 2
+	ieProtocol       = 4
+	ieSrcPort        = 7
+	ieSrcIPv4        = 8
+	ieIngress        = 10
+	ieDstPort        = 11
+	ieDstIPv4        = 12
+	ieSrcIPv6        = 27
+	ieDstIPv6        = 28
+	ieL2OctetDelta   = 352
+	ipfixMaxTemplate = 512
+)
+
+type ipfixField struct {
+	id         uint16
+	length     uint16
+	enterprise bool
+}
+
+// ipfixDecoder keeps the templates received for each observation domain.
+// OVS exports the counters of the sampled packets, they are multiplied by
+// scale (the sampling rate).
+type ipfixDecoder struct {
+	templates map[uint64][]ipfixField
+	scale     uint64
+}
+
+func newIPFIXDecoder(scale int) *ipfixDecoder {
+	if scale < 1 {
+		scale = 1
+	}
+	return &ipfixDecoder{
+		templates: make(map[uint64][]ipfixField),
+		scale:     uint64(scale),
+	}
+}
+
+func templateKey(domain uint32, id uint16) uint64 {
+	return uint64(domain)<<16 | uint64(id)
+}
+
+// Decode returns the flow records of an IPFIX message. Data sets whose
+// template has not been received yet are ignored.
+func (d *ipfixDecoder) Decode(b []byte) ([]Sample, error) {
+	r := &reader{b: b}
+	if v := r.u16(); v != ipfixVersion {
+		return nil, fmt.Errorf("unsupported IPFIX version %d", v)
+	}
+	length := int(r.u16())
+	// export time, sequence number
+	r.next(8)
+	domain := r.u32()
+	if r.err != nil || length < ipfixHeaderSize || length > len(b) {
+		return nil, errTruncated
+	}
+	r.b = b[ipfixHeaderSize:length]
+
+	res := make([]Sample, 0, 8)
+	for len(r.b) >= 4 && r.err == nil {
+		id := r.u16()
+		setLen := int(r.u16())
+		body := r.next(setLen - 4)
+		if r.err != nil {
+			break
+		}
+		switch {
+		case id == ipfixTemplateSet:
+			d.readTemplates(domain, body)
+		case id == ipfixOptionsSet:
+		case id >= ipfixMinDataSet:
+			fields, ok := d.templates[templateKey(domain, id)]
+			if !ok {
+				continue
+			}
+			res = append(res, d.readData(domain, fields, body)...)
+		}
+	}
+	return res, r.err
+}
+
+func (d *ipfixDecoder) readTemplates(domain uint32, b []byte) {
+	r := &reader{b: b}
+	for len(r.b) >= 4 && r.err == nil {
+		id := r.u16()
+		count := int(r.u16())
+		if count == 0 {
+			// template withdrawal
+			delete(d.templates, templateKey(domain, id))
+			continue
+		}
+		if count > ipfixMaxTemplate {
+			return
+		}
+		fields := make([]ipfixField, count)
+		for i := range fields {
+			f := ipfixField{id: r.u16(), length: r.u16()}
+			if f.id&ipfixEnterpriseBit != 0 {
+				f.id &^= ipfixEnterpriseBit
+				f.enterprise = true
+				r.u32()
+			}
+			fields[i] = f
+		}
+		if r.err == nil {
+			d.templates[templateKey(domain, id)] = fields
+		}
+	}
+}
+
+// readUint decodes an unsigned integer, possibly using reduced-size encoding
+func readUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+func (d *ipfixDecoder) readData(domain uint32, fields []ipfixField, b []byte) []Sample {
+	r := &reader{b: b}
+	res := make([]Sample, 0, 4)
+	for len(r.b) > 0 && r.err == nil {
+		left := len(r.b)
+		s := Sample{Domain: domain}
+		var l2Bytes uint64
+		for _, f := range fields {
+			n := int(f.length)
+			if f.length == ipfixVariableLength {
+				l := r.next(1)
+				if l == nil {
+					return res
+				}
+				n = int(l[0])
+				if n == 255 {
+					n = int(r.u16())
+				}
+			}
+			v := r.next(n)
+			if r.err != nil {
+				// padding at the end of the set
+				return res
+			}
+			if f.enterprise {
+				continue
+			}
+			switch f.id {
+			case ieOctetDelta:
+				s.Bytes = readUint(v)
+			case ieL2OctetDelta:
+				l2Bytes = readUint(v)
+			case iePacketDelta:
+				s.Packets = readUint(v)
+			case ieProtocol:
+				s.Flow.Proto = uint8(readUint(v))
+			case ieSrcPort:
+				s.Flow.SrcPort = uint16(readUint(v))
+			case ieDstPort:
+				s.Flow.DstPort = uint16(readUint(v))
+			case ieSrcIPv4, ieSrcIPv6:
+				s.Flow.Src = net.IP(v).String()
+			case ieDstIPv4, ieDstIPv6:
+				s.Flow.Dst = net.IP(v).String()
+			case ieIngress:
+				s.Iface = uint32(readUint(v))
+			}
+		}
+		if s.Bytes == 0 {
+			s.Bytes = l2Bytes
+		}
+		if len(r.b) == left {
+			// template without any field length
+			break
+		}
+		s.Bytes *= d.scale
+		s.Packets *= d.scale
+		res = append(res, s)
+	}
+	return res
+}
@@ -0,0 +1,87 @@
+package flows
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+// EtherTypes and IP protocols decoded
+const (
+	etherIPv4   = 0x0800
+	etherIPv6   = 0x86dd
+	etherVLAN   = 0x8100
+	etherQinQ   = 0x88a8
+	etherMPLS   = 0x8847
+	protoTCP    = 6
+	protoUDP    = 17
+	ipv6HdrSize = 40
+)
+
+// parseEthernet returns the flow key of a sampled Ethernet header. VLAN tags
+// and MPLS labels are skipped.
+func parseEthernet(h []byte) Key {
+	if len(h) < 14 {
+		return Key{}
+	}
+	et := binary.BigEndian.Uint16(h[12:])
+	off := 14
+	for (et == etherVLAN || et == etherQinQ) && len(h) >= off+4 {
+		et = binary.BigEndian.Uint16(h[off+2:])
+		off += 4
+	}
+	if et == etherMPLS {
+		for len(h) >= off+4 {
+			bottom := h[off+2]&1 == 1
+			off += 4
+			if bottom {
+				break
+			}
+		}
+		if len(h) <= off {
+			return Key{}
+		}
+		switch h[off] >> 4 {
+		case 4:
+			et = etherIPv4
+		case 6:
+			et = etherIPv6
+		}
+	}
+	return parseIP(et, h[off:])
+}
+
+// parseIP returns the flow key of an IP header
+func parseIP(et uint16, ip []byte) Key {
+	var k Key
+	var l4 []byte
+	switch et {
+	case etherIPv4:
+		if len(ip) < 20 {
+			return k
+		}
+		k.Proto = ip[9]
+		k.Src = net.IP(ip[12:16]).String()
+		k.Dst = net.IP(ip[16:20]).String()
+		// only the first fragment contains the transport header
+		if binary.BigEndian.Uint16(ip[6:])&0x1fff == 0 {
+			if ihl := int(ip[0]&0xf) * 4; len(ip) >= ihl {
+				l4 = ip[ihl:]
+			}
+		}
+	case etherIPv6:
+		if len(ip) < ipv6HdrSize {
+			return k
+		}
+		k.Proto = ip[6]
+		k.Src = net.IP(ip[8:24]).String()
+		k.Dst = net.IP(ip[24:40]).String()
+		l4 = ip[ipv6HdrSize:]
+	default:
+		return k
+	}
+	if (k.Proto == protoTCP || k.Proto == protoUDP) && len(l4) >= 4 {
+		k.SrcPort = binary.BigEndian.Uint16(l4)
+		k.DstPort = binary.BigEndian.Uint16(l4[2:])
+	}
+	return k
+}
@@ -0,0 +1,193 @@
+package flows
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// Talker is the traffic between two addresses
+type Talker struct {
+	Src     string `json:"src"`
+	Dst     string `json:"dst"`
+	Proto   uint8  `json:"proto"`
+	Bytes   uint64 `json:"bytes"`
+	Packets uint64 `json:"packets"`
+}
+
+// LinkUsage is the traffic sent on a link through one of its ports
+type LinkUsage struct {
+	Link  string `json:"link"`
+	Port  string `json:"port"`
+	Bytes uint64 `json:"bytes"`
+	// Average and peak throughput in bits per second
+	Avg  float64 `json:"avg_bps"`
+	Peak float64 `json:"peak_bps"`
+	// Speed of the link in Mbps (0 if unknown)
+	Speed int `json:"speed,omitempty"`
+}
+
+// Utilisation returns the average utilisation of the link in percent
+func (u LinkUsage) Utilisation() float64 {
+	if u.Speed == 0 {
+		return 0
+	}
+	return u.Avg / float64(u.Speed*1000000) * 100
+}
+
+// Summary summarises the records stored by the collector
+type Summary struct {
+	Start   time.Time   `json:"start"`
+	End     time.Time   `json:"end"`
+	Talkers []Talker    `json:"talkers"`
+	Links   []LinkUsage `json:"links"`
+}
+
+// Report reads the stored records and returns the top talkers (all of them
+// if top is 0) and the usage of each port. speeds contains the speed of the
+// ports in Mbps.
+func Report(speeds map[string]int, top int) (*Summary, error) {
+	sum := &Summary{}
+	// a flow is sampled on every port it enters a bridge through, so the
+	// traffic is summed by port and counted once, on the port where most of
+	// it has been sampled
+	sampled := make(map[recordKey]*Talker)
+	err := readLines(flowsFile, func(dec *json.Decoder) error {
+		var r Record
+		if err := dec.Decode(&r); err != nil {
+			return fmt.Errorf("%s: %v", flowsFile, err)
+		}
+		if r.Src == "" {
+			return nil
+		}
+		k := recordKey{r.Port, Key{Src: r.Src, Dst: r.Dst, Proto: r.Proto}}
+		t, ok := sampled[k]
+		if !ok {
+			t = &Talker{Src: r.Src, Dst: r.Dst, Proto: r.Proto}
+			sampled[k] = t
+		}
+		t.Bytes += r.Bytes
+		t.Packets += r.Packets
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	talkers := make(map[Key]*Talker)
+	for k, t := range sampled {
+		if max, ok := talkers[k.key]; !ok || t.Bytes > max.Bytes {
+			talkers[k.key] = t
+		}
+	}
+
+	usage := make(map[string]*LinkUsage)
+	err = readLines(throughputFile, func(dec *json.Decoder) error {
+		var p Point
+		if err := dec.Decode(&p); err != nil {
+			return fmt.Errorf("%s: %v", throughputFile, err)
+		}
+		start := p.Time.Add(-time.Duration(p.Interval * float64(time.Second)))
+		if sum.Start.IsZero() || start.Before(sum.Start) {
+			sum.Start = start
+		}
+		if p.Time.After(sum.End) {
+			sum.End = p.Time
+		}
+		u, ok := usage[p.Port]
+		if !ok {
+			u = &LinkUsage{Link: p.Link, Port: p.Port, Speed: speeds[p.Port]}
+			usage[p.Port] = u
+		}
+		u.Bytes += p.Bytes
+		if bps := p.Bps(); bps > u.Peak {
+			u.Peak = bps
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sum.Talkers = make([]Talker, 0, len(talkers))
+	for _, t := range talkers {
+		sum.Talkers = append(sum.Talkers, *t)
+	}
+	sort.Slice(sum.Talkers, func(i, j int) bool {
+		return sum.Talkers[i].Bytes > sum.Talkers[j].Bytes
+	})
+	if top > 0 && len(sum.Talkers) > top {
+		sum.Talkers = sum.Talkers[:top]
+	}
+
+	d := sum.End.Sub(sum.Start).Seconds()
+	sum.Links = make([]LinkUsage, 0, len(usage))
+	for _, u := range usage {
+		if d > 0 {
+			u.Avg = float64(u.Bytes) * 8 / d
+		}
+		sum.Links = append(sum.Links, *u)
+	}
+	sort.Slice(sum.Links, func(i, j int) bool {
+		return sum.Links[i].Avg > sum.Links[j].Avg
+	})
+	return sum, nil
+}
+
+// FormatBps returns a throughput in a human readable format
+func FormatBps(bps float64) string {
+	switch {
+	case bps >= 1e9:
+		return fmt.Sprintf("%.2f Gbps", bps/1e9)
+	case bps >= 1e6:
+		return fmt.Sprintf("%.2f Mbps", bps/1e6)
+	case bps >= 1e3:
+		return fmt.Sprintf("%.2f kbps", bps/1e3)
+	}
+	return fmt.Sprintf("%.0f bps", bps)
+}
+
+func protoName(p uint8) string {
+	switch p {
+	case 1:
+		return "icmp"
+	case protoTCP:
+		return "tcp"
+	case protoUDP:
+		return "udp"
+	case 58:
+		return "icmpv6"
+	}
+	return fmt.Sprint(p)
+}
+
+// Write displays the summary
+func (s *Summary) Write(dst io.Writer) {
+	if len(s.Links) == 0 {
+		fmt.Fprintln(dst, "No traffic recorded.")
+		return
+	}
+	fmt.Fprintf(dst, "Traffic from %s to %s (%v)\n\n", s.Start.Format(time.RFC3339),
+		s.End.Format(time.RFC3339), s.End.Sub(s.Start).Round(time.Second))
+
+	w := tabwriter.NewWriter(dst, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "SOURCE\tDESTINATION\tPROTO\tBYTES\tPACKETS")
+	for _, t := range s.Talkers {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\n", t.Src, t.Dst, protoName(t.Proto), t.Bytes, t.Packets)
+	}
+	w.Flush()
+	fmt.Fprintln(dst)
+
+	w = tabwriter.NewWriter(dst, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "LINK\tFROM\tAVERAGE\tPEAK\tUTILISATION")
+	for _, u := range s.Links {
+		util := "-"
+		if u.Speed > 0 {
+			util = fmt.Sprintf("%.1f%%", u.Utilisation())
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", u.Link, u.Port, FormatBps(u.Avg), FormatBps(u.Peak), util)
+	}
+	w.Flush()
+}
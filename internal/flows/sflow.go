@@ -0,0 +1,158 @@
+package flows
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var errTruncated = errors.New("truncated datagram")
+
+// reader decodes big-endian fields, the first out of bounds read sets err
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || len(r.b) < n {
+		r.err = errTruncated
+		r.b = nil
+		return nil
+	}
+	res := r.b[:n]
+	r.b = r.b[n:]
+	return res
+}
+
+func (r *reader) u16() uint16 {
+	if b := r.next(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *reader) u32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+// opaque reads a field padded to 4 bytes (XDR)
+func (r *reader) opaque(n int) []byte {
+	res := r.next(n)
+	r.next((4 - n%4) % 4)
+	return res
+}
+
+// sFlow v5 structures (https://sflow.org/sflow_version_5.txt)
+const (
+	sflowVersion          = 5
+	sflowFlowSample       = 1
+	sflowExpandedSample   = 3
+	sflowRawHeader        = 1
+	sflowEthernet         = 1
+	sflowAddrIPv4         = 1
+	sflowAddrIPv6         = 2
+	sflowIfIndexMask      = 0x3fffffff
+	sflowMaxHeaderRecords = 64
+	sflowMaxSamples       = 256
+)
+
+type sflowDecoder struct{}
+
+// Decode returns the flow samples of an sFlow datagram. Counter samples are
+// ignored.
+func (sflowDecoder) Decode(b []byte) ([]Sample, error) {
+	r := &reader{b: b}
+	if v := r.u32(); v != sflowVersion {
+		return nil, fmt.Errorf("unsupported sFlow version %d", v)
+	}
+	switch r.u32() {
+	case sflowAddrIPv4:
+		r.next(4)
+	case sflowAddrIPv6:
+		r.next(16)
+	}
+	// sub-agent, sequence number, uptime
+	r.next(12)
+	n := r.u32()
+	if n > sflowMaxSamples {
+		return nil, fmt.Errorf("invalid number of samples %d", n)
+	}
+	res := make([]Sample, 0, n)
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		format := r.u32()
+		data := r.opaque(int(r.u32()))
+		if r.err != nil {
+			break
+		}
+		// enterprise 0 only
+		if format>>12 != 0 {
+			continue
+		}
+		switch format {
+		case sflowFlowSample, sflowExpandedSample:
+			s, err := decodeFlowSample(data, format == sflowExpandedSample)
+			if err != nil {
+				return res, err
+			}
+			res = append(res, s...)
+		}
+	}
+	return res, r.err
+}
+
+func decodeFlowSample(b []byte, expanded bool) ([]Sample, error) {
+	r := &reader{b: b}
+	var in uint32
+	if expanded {
+		// sequence, source type and index
+		r.next(12)
+	} else {
+		r.next(8)
+	}
+	rate := uint64(r.u32())
+	// sample pool, drops
+	r.next(8)
+	if expanded {
+		r.u32()
+		in = r.u32()
+		r.next(8)
+	} else {
+		in = r.u32() & sflowIfIndexMask
+		r.u32()
+	}
+	n := r.u32()
+	if n > sflowMaxHeaderRecords {
+		return nil, fmt.Errorf("invalid number of flow records %d", n)
+	}
+	res := make([]Sample, 0, 1)
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		format := r.u32()
+		data := r.opaque(int(r.u32()))
+		if r.err != nil || format != sflowRawHeader {
+			continue
+		}
+		h := &reader{b: data}
+		proto := h.u32()
+		frameLen := uint64(h.u32())
+		// stripped bytes
+		h.u32()
+		header := h.next(int(h.u32()))
+		if h.err != nil || proto != sflowEthernet {
+			continue
+		}
+		res = append(res, Sample{
+			Iface:   in,
+			Flow:    parseEthernet(header),
+			Bytes:   frameLen * rate,
+			Packets: rate,
+		})
+	}
+	return res, r.err
+}
@@ -0,0 +1,128 @@
+// Package journal records the resources created while a topology is
+// started, so that they can be removed if the start fails.
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Kinds of resources
+const (
+	// Container created by topomate
+	Container = "container"
+	// Started is an existing container started by topomate
+	Started = "started"
+	// OVSBridge is an Open vSwitch bridge
+	OVSBridge = "ovs-bridge"
+	// LinuxBridge is a Linux bridge
+	LinuxBridge = "linux-bridge"
+	// Iface is an interface of the host (end of a veth pair)
+	Iface = "iface"
+	// NetNS is a symlink in /var/run/netns
+	NetNS = "netns"
+)
+
+// Entry is a created resource
+type Entry struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+// Journal is a file where the resources are appended as they are created
+type Journal struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+}
+
+var (
+	active   *Journal
+	activeMu sync.RWMutex
+)
+
+// SetActive sets the journal used by Record, nil disables the recording
+func SetActive(j *Journal) {
+	activeMu.Lock()
+	active = j
+	activeMu.Unlock()
+}
+
+// Enabled returns true if a journal is active
+func Enabled() bool {
+	activeMu.RLock()
+	defer activeMu.RUnlock()
+	return active != nil
+}
+
+// Record appends a resource to the active journal, if any
+func Record(kind, name string) {
+	activeMu.RLock()
+	j := active
+	activeMu.RUnlock()
+	if j != nil {
+		j.Record(kind, name)
+	}
+}
+
+// Open opens the journal file path. Entries are appended to the existing
+// ones, so that the resources of a previous failed start are kept.
+func Open(path string) (*Journal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{path: path, f: f}, nil
+}
+
+// Path returns the path of the journal file
+func (j *Journal) Path() string {
+	return j.path
+}
+
+// Record appends a resource to the journal. The file is synced so that the
+// entry is kept if the program exits.
+func (j *Journal) Record(kind, name string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	b, _ := json.Marshal(Entry{Kind: kind, Name: name})
+	j.f.Write(append(b, '\n'))
+	j.f.Sync()
+}
+
+// Close closes the journal file
+func (j *Journal) Close() error {
+	return j.f.Close()
+}
+
+// Remove closes and deletes the journal file
+func (j *Journal) Remove() error {
+	j.f.Close()
+	return os.Remove(j.path)
+}
+
+// Read returns the entries of the journal file path, in creation order
+func Read(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	res := make([]Entry, 0, 64)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var e Entry
+		// the last line may be truncated if the program was killed
+		if err := json.Unmarshal(s.Bytes(), &e); err == nil {
+			res = append(res, e)
+		}
+	}
+	return res, s.Err()
+}
@@ -0,0 +1,310 @@
+package labtest
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/rahveiz/topomate/frr"
+	"github.com/rahveiz/topomate/project"
+)
+
+const defaultPingCount = 3
+
+// BGPSessionTest checks that the BGP session between Router and Neighbor
+// (a node name or an address) is established
+type BGPSessionTest struct {
+	Router   string `yaml:"router"`
+	Neighbor string `yaml:"neighbor"`
+	State    string `yaml:"state"`
+}
+
+// RouteTest checks the presence (or absence) of Prefix in the BGP table of
+// Router. If ASPath or Community are set, the best path must match them.
+type RouteTest struct {
+	Router    string `yaml:"router"`
+	Prefix    string `yaml:"prefix"`
+	VRF       string `yaml:"vrf"`
+	Absent    bool   `yaml:"absent"`
+	ASPath    string `yaml:"as_path"`
+	Community string `yaml:"community"`
+}
+
+// PingTest checks that To (a node name or an address) answers to pings
+// sent from From
+type PingTest struct {
+	From  string `yaml:"from"`
+	To    string `yaml:"to"`
+	Count int    `yaml:"count"`
+	Fail  bool   `yaml:"fail"`
+}
+
+// TracerouteTest checks that the path from From to To traverses Via
+type TracerouteTest struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+	Via  string `yaml:"via"`
+}
+
+// RPKITest checks the RPKI validation state of Prefix on Router. If Origin
+// is not set, the origin AS of the best path is used.
+type RPKITest struct {
+	Router string `yaml:"router"`
+	Prefix string `yaml:"prefix"`
+	Origin int    `yaml:"origin"`
+	State  string `yaml:"state"`
+}
+
+func findNode(p *project.Project, name string) (project.Node, error) {
+	n, ok := p.FindNode(name)
+	if !ok {
+		return n, fmt.Errorf("node %s not found", name)
+	}
+	return n, nil
+}
+
+func findRouter(p *project.Project, name string) (project.Node, error) {
+	n, err := findNode(p, name)
+	if err != nil {
+		return n, err
+	}
+	if !n.IsRouter() {
+		return n, fmt.Errorf("%s is not a router", name)
+	}
+	return n, nil
+}
+
+/******************************* BGP session *******************************/
+
+func (t *BGPSessionTest) kind() string { return "bgp_session" }
+
+func (t *BGPSessionTest) describe() string {
+	return fmt.Sprintf("BGP session %s <-> %s", t.Router, t.Neighbor)
+}
+
+func (t *BGPSessionTest) check(p *project.Project) error {
+	n, err := findRouter(p, t.Router)
+	if err != nil {
+		return err
+	}
+	ip, err := p.NeighborAddress(n, t.Neighbor)
+	if err != nil {
+		return err
+	}
+	state, err := frr.BGPNeighborState(n, ip)
+	if err != nil {
+		return err
+	}
+	expected := t.State
+	if expected == "" {
+		expected = "Established"
+	}
+	if !strings.EqualFold(state, expected) {
+		return fmt.Errorf("session with %s is %s (expected %s)", ip, state, expected)
+	}
+	return nil
+}
+
+/********************************** Route **********************************/
+
+func (t *RouteTest) kind() string { return "route" }
+
+func (t *RouteTest) describe() string {
+	if t.Absent {
+		return fmt.Sprintf("%s absent from %s", t.Prefix, t.Router)
+	}
+	return fmt.Sprintf("%s present in %s", t.Prefix, t.Router)
+}
+
+func (t *RouteTest) check(p *project.Project) error {
+	n, err := findRouter(p, t.Router)
+	if err != nil {
+		return err
+	}
+	paths, err := frr.BGPRoute(n, t.VRF, t.Prefix)
+	if err != nil {
+		return err
+	}
+	if t.Absent {
+		if len(paths) > 0 {
+			return fmt.Errorf("%s found in the BGP table (%d paths)", t.Prefix, len(paths))
+		}
+		return nil
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("%s not found in the BGP table", t.Prefix)
+	}
+
+	var best *frr.BGPPath
+	for i := range paths {
+		if paths[i].Best {
+			best = &paths[i]
+			break
+		}
+	}
+	if best == nil {
+		return fmt.Errorf("%s has no best path", t.Prefix)
+	}
+	if t.ASPath != "" && strings.Join(strings.Fields(best.ASPath), " ") != strings.Join(strings.Fields(t.ASPath), " ") {
+		return fmt.Errorf("AS path is \"%s\" (expected \"%s\")", best.ASPath, t.ASPath)
+	}
+	if t.Community != "" {
+		found := false
+		for _, c := range strings.Fields(best.Communities) {
+			if c == t.Community {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("community %s not found (communities: \"%s\")", t.Community, best.Communities)
+		}
+	}
+	return nil
+}
+
+/********************************** Ping ***********************************/
+
+func (t *PingTest) kind() string { return "ping" }
+
+func (t *PingTest) describe() string {
+	return fmt.Sprintf("ping %s -> %s", t.From, t.To)
+}
+
+func (t *PingTest) check(p *project.Project) error {
+	n, err := findNode(p, t.From)
+	if err != nil {
+		return err
+	}
+	ip, err := p.ResolveAddress(t.To)
+	if err != nil {
+		return err
+	}
+	count := t.Count
+	if count < 1 {
+		count = defaultPingCount
+	}
+	args := []string{"ping", "-c", strconv.Itoa(count), "-W", "1", ip}
+	if net.ParseIP(ip).To4() == nil {
+		args[0] = "ping6"
+	}
+	res := n.Exec(args...)
+	if res.Err != nil {
+		return res.Err
+	}
+	if t.Fail {
+		if res.ExitCode == 0 {
+			return fmt.Errorf("%s reachable (expected failure)", ip)
+		}
+		return nil
+	}
+	if res.ExitCode != 0 {
+		return fmt.Errorf("%s unreachable: %s", ip, strings.TrimSpace(res.Output))
+	}
+	return nil
+}
+
+/******************************* Traceroute ********************************/
+
+func (t *TracerouteTest) kind() string { return "traceroute" }
+
+func (t *TracerouteTest) describe() string {
+	return fmt.Sprintf("traceroute %s -> %s via %s", t.From, t.To, t.Via)
+}
+
+func (t *TracerouteTest) check(p *project.Project) error {
+	n, err := findNode(p, t.From)
+	if err != nil {
+		return err
+	}
+	via, err := findNode(p, t.Via)
+	if err != nil {
+		return err
+	}
+	ip, err := p.ResolveAddress(t.To)
+	if err != nil {
+		return err
+	}
+	res := n.Exec("traceroute", "-n", "-q", "1", "-w", "1", ip)
+	if res.Err != nil {
+		return res.Err
+	}
+	if res.ExitCode != 0 {
+		return fmt.Errorf("traceroute failed: %s", strings.TrimSpace(res.Output))
+	}
+	hops := parseTraceroute(res.Output)
+	for _, h := range hops {
+		if via.HasAddress(h) {
+			return nil
+		}
+	}
+	return fmt.Errorf("path to %s does not traverse %s (hops: %v)", ip, via.ContainerName, hops)
+}
+
+// parseTraceroute returns the addresses of the hops of a traceroute output
+func parseTraceroute(out string) []net.IP {
+	res := make([]net.IP, 0, 16)
+	for _, line := range strings.Split(out, "\n") {
+		f := strings.Fields(line)
+		if len(f) < 2 {
+			continue
+		}
+		if _, err := strconv.Atoi(f[0]); err != nil {
+			continue
+		}
+		if ip := net.ParseIP(f[1]); ip != nil {
+			res = append(res, ip)
+		}
+	}
+	return res
+}
+
+/********************************** RPKI ***********************************/
+
+func (t *RPKITest) kind() string { return "rpki" }
+
+func (t *RPKITest) describe() string {
+	return fmt.Sprintf("RPKI state of %s on %s is %s", t.Prefix, t.Router, t.State)
+}
+
+func (t *RPKITest) check(p *project.Project) error {
+	switch strings.ToLower(t.State) {
+	case frr.RPKIValid, frr.RPKIInvalid, frr.RPKINotFound:
+		break
+	default:
+		return errors.New("rpki state must be valid, invalid or notfound")
+	}
+	n, err := findRouter(p, t.Router)
+	if err != nil {
+		return err
+	}
+	origin := t.Origin
+	if origin == 0 {
+		paths, err := frr.BGPRoute(n, "", t.Prefix)
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			if path.Best {
+				origin = path.OriginAS()
+			}
+		}
+		if origin == 0 {
+			return fmt.Errorf("cannot find origin AS of %s", t.Prefix)
+		}
+	}
+	roas, err := frr.RPKIPrefix(n, t.Prefix)
+	if err != nil {
+		return err
+	}
+	state, err := frr.RPKIState(roas, t.Prefix, origin)
+	if err != nil {
+		return err
+	}
+	if state != strings.ToLower(t.State) {
+		return fmt.Errorf("%s from AS%d is %s (expected %s)", t.Prefix, origin, state, t.State)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package labtest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Time      string      `xml:"time,attr"`
+	Timestamp string      `xml:"timestamp,attr"`
+	Cases     []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Content string `xml:",chardata"`
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// WriteJUnit writes the results in the JUnit XML format
+func WriteJUnit(w io.Writer, suite string, results []Result) error {
+	s := junitSuite{
+		Name:      suite,
+		Tests:     len(results),
+		Timestamp: time.Now().Format(time.RFC3339),
+		Cases:     make([]junitCase, len(results)),
+	}
+	var total time.Duration
+	for i, r := range results {
+		if r.Duration > total {
+			total = r.Duration // tests are run in parallel
+		}
+		s.Cases[i] = junitCase{
+			Name:      r.Name,
+			ClassName: suite + "." + r.Kind,
+			Time:      seconds(r.Duration),
+		}
+		if !r.Passed() {
+			s.Failures++
+			s.Cases[i].Failure = &junitFailure{
+				Message: r.Err.Error(),
+				Type:    r.Kind,
+				Content: fmt.Sprintf("%v (after %d attempts)", r.Err, r.Attempts),
+			}
+		}
+	}
+	s.Time = seconds(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitSuites{Suites: []junitSuite{s}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
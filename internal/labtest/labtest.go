@@ -0,0 +1,155 @@
+package labtest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/rahveiz/topomate/project"
+	"github.com/rahveiz/topomate/utils"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	defaultTimeout  = 2 * time.Minute
+	defaultInterval = 5 * time.Second
+)
+
+// File is the content of a test file
+type File struct {
+	Name     string     `yaml:"name"`
+	Timeout  string     `yaml:"timeout"`
+	Interval string     `yaml:"interval"`
+	Tests    []TestCase `yaml:"tests"`
+}
+
+// TestCase is a single expectation of a test file. Exactly one of the
+// pointer fields must be set.
+type TestCase struct {
+	Name       string          `yaml:"name"`
+	BGPSession *BGPSessionTest `yaml:"bgp_session"`
+	Route      *RouteTest      `yaml:"route"`
+	Ping       *PingTest       `yaml:"ping"`
+	Traceroute *TracerouteTest `yaml:"traceroute"`
+	RPKI       *RPKITest       `yaml:"rpki"`
+}
+
+// Result is the result of a TestCase
+type Result struct {
+	Name     string
+	Kind     string
+	Err      error
+	Attempts int
+	Duration time.Duration
+}
+
+// Passed returns true if the test succeeded
+func (r Result) Passed() bool {
+	return r.Err == nil
+}
+
+type checker interface {
+	kind() string
+	describe() string
+	check(p *project.Project) error
+}
+
+// ReadFile reads and validates a test file
+func ReadFile(path string) (*File, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &File{}
+	if err := yaml.UnmarshalStrict(data, f); err != nil {
+		return nil, err
+	}
+	for i, t := range f.Tests {
+		if _, err := t.checker(); err != nil {
+			return nil, fmt.Errorf("test %d: %v", i+1, err)
+		}
+	}
+	if _, _, err := f.durations(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *File) durations() (timeout, interval time.Duration, err error) {
+	timeout, interval = defaultTimeout, defaultInterval
+	if f.Timeout != "" {
+		if timeout, err = time.ParseDuration(f.Timeout); err != nil {
+			return
+		}
+	}
+	if f.Interval != "" {
+		if interval, err = time.ParseDuration(f.Interval); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (t TestCase) checker() (checker, error) {
+	res := make([]checker, 0, 1)
+	if t.BGPSession != nil {
+		res = append(res, t.BGPSession)
+	}
+	if t.Route != nil {
+		res = append(res, t.Route)
+	}
+	if t.Ping != nil {
+		res = append(res, t.Ping)
+	}
+	if t.Traceroute != nil {
+		res = append(res, t.Traceroute)
+	}
+	if t.RPKI != nil {
+		res = append(res, t.RPKI)
+	}
+	if len(res) != 1 {
+		return nil, fmt.Errorf("exactly one expectation must be set (found %d)", len(res))
+	}
+	return res[0], nil
+}
+
+// Run evaluates all the tests of f against the running project p. Each test
+// is retried until it succeeds or the timeout of the file expires, which
+// gives time for the lab to converge.
+func Run(p *project.Project, f *File) []Result {
+	timeout, interval, _ := f.durations()
+	deadline := time.Now().Add(timeout)
+
+	res := make([]Result, len(f.Tests))
+	sem := make(chan struct{}, project.DefaultParallelism)
+	var wg sync.WaitGroup
+	wg.Add(len(f.Tests))
+	for i, t := range f.Tests {
+		go func(i int, t TestCase) {
+			defer wg.Done()
+			c, _ := t.checker()
+			name := t.Name
+			if name == "" {
+				name = c.describe()
+			}
+			res[i] = Result{Name: name, Kind: c.kind()}
+			start := time.Now()
+			for {
+				res[i].Attempts++
+				sem <- struct{}{}
+				if err := utils.Catch(func() { res[i].Err = c.check(p) }); err != nil {
+					res[i].Err = err
+				}
+				<-sem
+				if res[i].Err == nil || time.Now().Add(interval).After(deadline) {
+					break
+				}
+				time.Sleep(interval)
+			}
+			res[i].Duration = time.Since(start)
+		}(i, t)
+	}
+	wg.Wait()
+	return res
+}
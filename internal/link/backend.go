@@ -0,0 +1,321 @@
+package link
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	"github.com/rahveiz/topomate/internal/journal"
+	"github.com/rahveiz/topomate/internal/ovsdocker"
+	"github.com/rahveiz/topomate/utils"
+)
+
+// Link backends
+const (
+	// BackendOVS links containers through Open vSwitch bridges
+	BackendOVS = "ovs"
+	// BackendVeth links containers with direct veth pairs, multi-access
+	// segments use Linux bridges
+	BackendVeth = "veth"
+	// BackendBridge links containers through Linux bridges
+	BackendBridge = "bridge"
+)
+
+// Endpoint is an interface of a container to connect
+type Endpoint struct {
+	Container string
+	IfName    string
+	Settings  ovsdocker.PortSettings
+}
+
+// Backend creates the links between containers. Links are identified by a
+// segment name (the bridge name with OVS). The interfaces created are
+// returned so they can be saved and recreated when a container restarts.
+type Backend interface {
+	Name() string
+	// Connect creates a point-to-point link between a and b
+	Connect(segment string, a, b Endpoint) ([]ovsdocker.OVSInterface, error)
+	// Attach connects an endpoint to a multi-access segment, created if
+	// needed
+	Attach(segment string, e Endpoint) (ovsdocker.OVSInterface, error)
+	// Detach removes the interface of a port
+	Detach(p Port) error
+	// RemoveSegment removes a segment
+	RemoveSegment(segment string) error
+	// Flush applies the changes which are batched by the backend
+	Flush() error
+}
+
+// NewBackend returns the backend name (OVS if name is empty)
+func NewBackend(name string) (Backend, error) {
+	switch strings.ToLower(name) {
+	case "", BackendOVS:
+		return &ovsBackend{txn: ovsdocker.NewTxn()}, nil
+	case BackendVeth:
+		return vethBackend{}, nil
+	case BackendBridge:
+		return bridgeBackend{}, nil
+	}
+	return nil, fmt.Errorf("unknown link backend %s (ovs, veth or bridge)", name)
+}
+
+// BackendOf returns the backend used to create a saved interface
+func BackendOf(i ovsdocker.OVSInterface) (Backend, error) {
+	return NewBackend(i.Backend)
+}
+
+// SupportsFlows returns true if the backend forwards the traffic with
+// OpenFlow rules (needed by the flow method of the link command)
+func SupportsFlows(backend string) bool {
+	return backend == "" || backend == BackendOVS
+}
+
+// ovsBackend batches the OVS changes, the ports are added to the bridges
+// and the flows installed by Flush
+type ovsBackend struct {
+	txn *ovsdocker.Txn
+	// existing OVS interfaces, read once
+	ports map[string]ovsdocker.PortInfo
+}
+
+func (*ovsBackend) Name() string {
+	return BackendOVS
+}
+
+func (b *ovsBackend) Connect(segment string, x, y Endpoint) ([]ovsdocker.OVSInterface, error) {
+	i, err := b.Attach(segment, x)
+	if err != nil {
+		return nil, err
+	}
+	j, err := b.Attach(segment, y)
+	if err != nil {
+		return nil, err
+	}
+	return []ovsdocker.OVSInterface{i, j}, nil
+}
+
+func (b *ovsBackend) Attach(segment string, e Endpoint) (ovsdocker.OVSInterface, error) {
+	hostIf := ovsdocker.OVSInterface{}
+	if b.ports == nil {
+		ports, err := ovsdocker.ListPorts()
+		if err != nil {
+			return hostIf, err
+		}
+		b.ports = ports
+	}
+	c, err := ovsdocker.New(e.Container)
+	if err != nil {
+		return hostIf, err
+	}
+	if _, ok := b.ports[ovsdocker.PortKey(e.Container, e.IfName)]; ok {
+		return hostIf, &ovsdocker.LinkError{Op: "add", Iface: e.IfName, PID: c.PID, Err: ovsdocker.ErrIfaceExists}
+	}
+	b.txn.AddBridge(segment)
+	if err := c.AddPortWith(segment, e.IfName, e.Settings, &hostIf, nil); err != nil {
+		return hostIf, err
+	}
+	b.txn.AddPort(e.Container, hostIf)
+	b.ports[ovsdocker.PortKey(e.Container, e.IfName)] = ovsdocker.PortInfo{Name: hostIf.HostIface}
+	return hostIf, nil
+}
+
+func (*ovsBackend) Detach(p Port) error {
+	return utils.OVSClient().VSwitch.DeletePort(p.Bridge, p.HostIface)
+}
+
+func (*ovsBackend) RemoveSegment(segment string) error {
+	return utils.OVSClient().VSwitch.DeleteBridge(segment)
+}
+
+func (b *ovsBackend) Flush() error {
+	b.ports = nil
+	return b.txn.Commit()
+}
+
+// QueueFlow forwards the traffic between two ports of the bridge br with
+// OpenFlow rules. With the OVS backend, the rules are installed by Flush.
+func QueueFlow(b Backend, br, containerA, ifA, containerB, ifB string) error {
+	if o, ok := b.(*ovsBackend); ok {
+		o.txn.AddFlow(br, ovsdocker.PortKey(containerA, ifA), ovsdocker.PortKey(containerB, ifB))
+		return nil
+	}
+	return AddFlow(br, containerA, ifA, containerB, ifB)
+}
+
+// LinuxBridgeName returns the name of the Linux bridge of a segment. Names
+// longer than the interface name limit (15 characters) are hashed.
+func LinuxBridgeName(segment string) string {
+	if len(segment) <= 15 {
+		return segment
+	}
+	h := fnv.New32a()
+	h.Write([]byte(segment))
+	return fmt.Sprintf("tm-%08x", h.Sum32())
+}
+
+type bridgeBackend struct{}
+
+func (bridgeBackend) Name() string {
+	return BackendBridge
+}
+
+// createBridge creates the Linux bridge of a segment if it does not exist
+func createBridge(segment string) error {
+	name := LinuxBridgeName(segment)
+	if err := ovsdocker.ExecLink("show", name); err == nil && !utils.DryRun() {
+		return nil
+	}
+	if err := ovsdocker.ExecLink("add", "name", name, "type", "bridge"); err != nil {
+		return err
+	}
+	journal.Record(journal.LinuxBridge, name)
+	return ovsdocker.ExecLink("set", name, "up")
+}
+
+func (b bridgeBackend) Connect(segment string, x, y Endpoint) ([]ovsdocker.OVSInterface, error) {
+	i, err := b.Attach(segment, x)
+	if err != nil {
+		return nil, err
+	}
+	j, err := b.Attach(segment, y)
+	if err != nil {
+		return nil, err
+	}
+	return []ovsdocker.OVSInterface{i, j}, nil
+}
+
+func (bridgeBackend) Attach(segment string, e Endpoint) (ovsdocker.OVSInterface, error) {
+	hostIf := ovsdocker.OVSInterface{}
+	if err := createBridge(segment); err != nil {
+		return hostIf, err
+	}
+	c, err := ovsdocker.New(e.Container)
+	if err != nil {
+		return hostIf, err
+	}
+	err = attachBridgePort(c, segment, e.IfName, e.Settings, &hostIf)
+	hostIf.Backend = BackendBridge
+	return hostIf, err
+}
+
+// attachBridgePort adds a port to the container with its host side in the
+// Linux bridge of the segment
+func attachBridgePort(c *ovsdocker.OVSDockerClient, segment, ifName string, settings ovsdocker.PortSettings, hostIf *ovsdocker.OVSInterface) error {
+	return c.AddPortWith(segment, ifName, settings, hostIf, func(host string) error {
+		return ovsdocker.ExecLink("set", host, "master", LinuxBridgeName(segment))
+	})
+}
+
+func (bridgeBackend) Detach(p Port) error {
+	// the container side is removed with the host side
+	return ovsdocker.ExecLink("del", p.HostIface)
+}
+
+func (bridgeBackend) RemoveSegment(segment string) error {
+	return ovsdocker.ExecLink("del", LinuxBridgeName(segment))
+}
+
+func (bridgeBackend) Flush() error {
+	return nil
+}
+
+type vethBackend struct{}
+
+func (vethBackend) Name() string {
+	return BackendVeth
+}
+
+func (vethBackend) Connect(segment string, x, y Endpoint) ([]ovsdocker.OVSInterface, error) {
+	a, err := ovsdocker.New(x.Container)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ovsdocker.New(y.Container)
+	if err != nil {
+		return nil, err
+	}
+	if err := ovsdocker.ConnectVeth(a, b, x.IfName, y.IfName, x.Settings, y.Settings); err != nil {
+		return nil, err
+	}
+	return []ovsdocker.OVSInterface{
+		{ContainerIface: x.IfName, Bridge: segment, Settings: x.Settings,
+			Backend: BackendVeth, Peer: y.Container + ":" + y.IfName},
+		{ContainerIface: y.IfName, Bridge: segment, Settings: y.Settings,
+			Backend: BackendVeth, Peer: x.Container + ":" + x.IfName},
+	}, nil
+}
+
+// Attach uses a Linux bridge, as veth pairs only link two interfaces
+func (vethBackend) Attach(segment string, e Endpoint) (ovsdocker.OVSInterface, error) {
+	return bridgeBackend{}.Attach(segment, e)
+}
+
+func (vethBackend) Detach(p Port) error {
+	if p.HostIface != "" {
+		return bridgeBackend{}.Detach(p)
+	}
+	// deleting one end of the pair deletes the other one, so the interface
+	// may already be gone if its peer has been detached
+	c, err := ovsdocker.New(p.Container)
+	if err != nil {
+		return err
+	}
+	return c.WithNetNS(func() error {
+		c.ExecNS("ip", "link", "del", p.Iface)
+		return nil
+	})
+}
+
+func (vethBackend) Flush() error {
+	return nil
+}
+
+// RemoveSegment removes the Linux bridge of multi-access segments, veth
+// pairs are removed with the containers
+func (vethBackend) RemoveSegment(segment string) error {
+	name := LinuxBridgeName(segment)
+	if err := ovsdocker.ExecLink("show", name); err != nil {
+		return nil
+	}
+	return ovsdocker.ExecLink("del", name)
+}
+
+// Reattach recreates a saved interface of a container after it has been
+// restarted. Direct veth pairs are recreated with their peer, whose end has
+// been removed with the namespace of the container.
+func Reattach(container string, i ovsdocker.OVSInterface, m ovsdocker.OVSBulk) error {
+	c, err := ovsdocker.New(container)
+	if err != nil {
+		return err
+	}
+	if i.HostIface != "" {
+		// keep the same host interface name
+		c.Portname = strings.TrimSuffix(i.HostIface, "_l")
+	}
+	switch {
+	case i.Backend == BackendVeth && i.HostIface == "":
+		idx := strings.LastIndex(i.Peer, ":")
+		if idx < 0 {
+			return fmt.Errorf("%s: invalid peer %s", i.ContainerIface, i.Peer)
+		}
+		peer, peerIf := i.Peer[:idx], i.Peer[idx+1:]
+		var settings ovsdocker.PortSettings
+		for _, v := range m[peer] {
+			if v.ContainerIface == peerIf && v.Peer == container+":"+i.ContainerIface {
+				settings = v.Settings
+			}
+		}
+		pc, err := ovsdocker.New(peer)
+		if err != nil {
+			return err
+		}
+		return ovsdocker.ConnectVeth(c, pc, i.ContainerIface, peerIf, i.Settings, settings)
+	case i.Backend == BackendVeth || i.Backend == BackendBridge:
+		// the host side may remain if the container was not stopped
+		ovsdocker.ExecLink("del", i.HostIface)
+		return attachBridgePort(c, i.Bridge, i.ContainerIface, i.Settings, nil)
+	default:
+		utils.OVSClient().VSwitch.DeletePort(i.Bridge, i.HostIface)
+		return c.AddPort(i.Bridge, i.ContainerIface, i.Settings, nil, true)
+	}
+}
@@ -0,0 +1,176 @@
+package link
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/rahveiz/topomate/config"
+	"github.com/rahveiz/topomate/internal/ovsdocker"
+	"github.com/rahveiz/topomate/utils"
+)
+
+// CaptureOptions are the options of a packet capture
+type CaptureOptions struct {
+	// File is the pcap file written, packets are printed if it is empty
+	// ("-" writes the pcap to Output)
+	File string
+	// Filter is a BPF filter expression
+	Filter string
+	Output io.Writer
+	Errors io.Writer
+}
+
+// tcpdumpArgs returns the tcpdump command capturing on iface
+func tcpdumpArgs(iface string, opts CaptureOptions) []string {
+	args := []string{"tcpdump", "-i", iface, "-n"}
+	if opts.File != "" {
+		// write packets as soon as they are received, so the capture can be
+		// piped to wireshark
+		args = append(args, "-U", "-w", opts.File)
+	} else {
+		args = append(args, "-l")
+	}
+	if opts.Filter != "" {
+		args = append(args, opts.Filter)
+	}
+	return args
+}
+
+// runCapture runs the capture until it stops or ctx is cancelled. tcpdump is
+// interrupted instead of killed so it flushes the pcap file.
+func runCapture(ctx context.Context, cmd *exec.Cmd, opts CaptureOptions) error {
+	cmd.Stdout, cmd.Stderr = opts.Output, opts.Errors
+	if config.VFlag {
+		fmt.Println(cmd.String())
+	}
+	if utils.DryRun() {
+		return utils.Run(cmd)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			cmd.Process.Signal(os.Interrupt)
+		case <-done:
+		}
+	}()
+	err := cmd.Wait()
+	if ctx.Err() != nil {
+		// stopped by the user
+		return nil
+	}
+	return err
+}
+
+// Capture captures the packets of a port. The host side of the veth pair is
+// used, or the interface inside the container network namespace for direct
+// veth pairs.
+func Capture(ctx context.Context, p Port, opts CaptureOptions) error {
+	if p.HostIface != "" {
+		return runCapture(ctx, utils.ExecSudo(tcpdumpArgs(p.HostIface, opts)...), opts)
+	}
+	c, err := ovsdocker.New(p.Container)
+	if err != nil {
+		return err
+	}
+	return c.WithNetNS(func() error {
+		return runCapture(ctx, c.NSCommand(tcpdumpArgs(p.Iface, opts)...), opts)
+	})
+}
+
+// MirrorPortName returns the name of the port receiving the mirrored traffic
+// of an OVS bridge
+func MirrorPortName(bridge string) string {
+	h := fnv.New32a()
+	h.Write([]byte(bridge))
+	return fmt.Sprintf("mir-%08x", h.Sum32())
+}
+
+func execVSCtl(args ...string) error {
+	var stderr bytes.Buffer
+	cmd := utils.ExecSudo(append([]string{"ovs-vsctl"}, args...)...)
+	cmd.Stderr = &stderr
+	if config.VFlag {
+		fmt.Println(cmd.String())
+	}
+	if err := utils.Run(cmd); err != nil {
+		return fmt.Errorf("ovs-vsctl: %s\n%s%s", cmd.String(), string(stderr.Bytes()), err)
+	}
+	return nil
+}
+
+// addMirror mirrors all the traffic of an OVS bridge to an internal port. A
+// mirror left by a capture which has not been stopped properly is replaced.
+func addMirror(bridge, port string) error {
+	if !utils.DryRun() && utils.Run(utils.ExecSudo("ovs-vsctl", "get", "mirror", port, "name")) == nil {
+		delMirror(bridge, port)
+	}
+	err := execVSCtl(
+		"--", "add-port", bridge, port,
+		"--", "set", "interface", port, "type=internal",
+		"--", "--id=@p", "get", "port", port,
+		"--", "--id=@m", "create", "mirror", "name="+port, "select-all=true", "output-port=@p",
+		"--", "add", "bridge", bridge, "mirrors", "@m",
+	)
+	if err != nil {
+		return err
+	}
+	if err := ovsdocker.ExecLink("set", port, "up"); err != nil {
+		delMirror(bridge, port)
+		return err
+	}
+	return nil
+}
+
+// delMirror removes the mirror and its port, the mirror record is deleted by
+// OVS once it is no longer referenced by the bridge
+func delMirror(bridge, port string) error {
+	return execVSCtl(
+		"--", "--id=@m", "get", "mirror", port,
+		"--", "remove", "bridge", bridge, "mirrors", "@m",
+		"--", "del-port", bridge, port,
+	)
+}
+
+// SegmentBackend returns the backend of a segment from the saved links
+func SegmentBackend(m ovsdocker.OVSBulk, segment string) (string, error) {
+	for _, ifaces := range m {
+		for _, i := range ifaces {
+			if i.Bridge != segment {
+				continue
+			}
+			if i.HostIface == "" {
+				return "", fmt.Errorf("%s is a direct veth pair, capture one of its interfaces instead", segment)
+			}
+			return i.Backend, nil
+		}
+	}
+	return "", fmt.Errorf("bridge %s not found in the saved links", segment)
+}
+
+// CaptureBridge captures all the traffic of a segment. OVS bridges are
+// mirrored to a temporary port, removed when the capture stops. Linux
+// bridges are captured directly.
+func CaptureBridge(ctx context.Context, bridge, backend string, opts CaptureOptions) error {
+	if backend != "" && backend != BackendOVS {
+		return runCapture(ctx, utils.ExecSudo(tcpdumpArgs(LinuxBridgeName(bridge), opts)...), opts)
+	}
+	port := MirrorPortName(bridge)
+	if err := addMirror(bridge, port); err != nil {
+		return err
+	}
+	err := runCapture(ctx, utils.ExecSudo(tcpdumpArgs(port, opts)...), opts)
+	if e := delMirror(bridge, port); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
@@ -0,0 +1,74 @@
+package link
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/rahveiz/topomate/internal/flows"
+)
+
+// ExportSettings are the settings of the sFlow or IPFIX export of the OVS
+// bridges
+type ExportSettings struct {
+	// Protocol is sflow or ipfix
+	Protocol string
+	// Target is the address of the collector (host:port)
+	Target string
+	// Sampling rate (1 packet out of Sampling)
+	Sampling int
+	// Interval is the sFlow counters polling interval, or the IPFIX active
+	// flow timeout, in seconds
+	Interval int
+}
+
+// ExportDomain returns the IPFIX observation domain of the i-th bridge
+// passed to EnableExport
+func ExportDomain(i int) uint32 {
+	return uint32(i + 1)
+}
+
+// EnableExport enables sFlow or IPFIX on the bridges with a single
+// ovs-vsctl transaction. The bridges share the sFlow record, IPFIX uses a
+// different observation domain for each bridge (see ExportDomain).
+func EnableExport(bridges []string, s ExportSettings) error {
+	if len(bridges) == 0 {
+		return nil
+	}
+	target := fmt.Sprintf(`targets="%s"`, s.Target)
+	sampling := "sampling=" + strconv.Itoa(s.Sampling)
+	args := make([]string, 0, 8*len(bridges))
+	switch s.Protocol {
+	case flows.SFlow:
+		args = append(args, "--", "--id=@s", "create", "sflow", target, sampling,
+			"polling="+strconv.Itoa(s.Interval), "header=128")
+		for _, br := range bridges {
+			args = append(args, "--", "set", "bridge", br, "sflow=@s")
+		}
+	case flows.IPFIX:
+		for i, br := range bridges {
+			id := "@i" + strconv.Itoa(i)
+			args = append(args,
+				"--", "--id="+id, "create", "ipfix", target, sampling,
+				"obs_domain_id="+strconv.FormatUint(uint64(ExportDomain(i)), 10),
+				"cache_active_timeout="+strconv.Itoa(s.Interval),
+				"--", "set", "bridge", br, "ipfix="+id,
+			)
+		}
+	default:
+		return fmt.Errorf("unknown flow export protocol %s (sflow or ipfix)", s.Protocol)
+	}
+	return execVSCtl(args...)
+}
+
+// DisableExport removes the sFlow and IPFIX configuration of the bridges,
+// the records are deleted by OVS once they are no longer referenced
+func DisableExport(bridges []string) error {
+	if len(bridges) == 0 {
+		return nil
+	}
+	args := make([]string, 0, 6*len(bridges))
+	for _, br := range bridges {
+		args = append(args, "--", "clear", "bridge", br, "sflow", "ipfix")
+	}
+	return execVSCtl(args...)
+}
@@ -1,103 +1,74 @@
 package link
 
 import (
-	"bytes"
 	"fmt"
-	"log"
 
-	"github.com/digitalocean/go-openvswitch/ovs"
-	"github.com/rahveiz/topomate/config"
 	"github.com/rahveiz/topomate/internal/ovsdocker"
 	"github.com/rahveiz/topomate/utils"
 )
 
-func CreateBridge(name string) {
-
-	c := ovs.New(ovs.Sudo())
-
-	if err := c.VSwitch.AddBridge(name); err != nil {
-		log.Fatalf("failed to add bridge: %v", err)
-	}
-
-}
-
-func DeleteBridge(name string) {
-	c := ovs.New(ovs.Sudo())
+func DeleteBridge(name string) error {
+	c := utils.OVSClient()
 
 	if err := c.VSwitch.DeleteBridge(name); err != nil {
-		log.Fatalf("failed to delete bridge: %v", err)
-	}
-}
-
-// AddPortToContainer links a container to an OVS bridge, creating an interface on the container network namespace
-// using a veth pair.
-func AddPortToContainer(brName, ifName, containerName string,
-	settings ovsdocker.PortSettings, hostIf *ovsdocker.OVSInterface,
-	bridge bool) {
-	c := ovsdocker.New(containerName)
-	if err := c.AddPort(brName, ifName, settings, hostIf, bridge); err != nil {
-		utils.Fatalln("AddPort:", err)
+		return fmt.Errorf("failed to delete bridge: %w", err)
 	}
+	return nil
 }
 
 // DelPortFromContainer removes an OVS port from a container
-func DelPortFromContainer(brName, ifName, containerName string) {
-	out, err := utils.ExecSudo(
+func DelPortFromContainer(brName, ifName, containerName string) error {
+	out, err := utils.CombinedOutput(utils.ExecSudo(
 		"ovs-docker",
 		"del-port",
 		brName,
 		ifName,
 		containerName,
-	).CombinedOutput()
+	))
 	if err != nil {
-		fmt.Println(string(out))
-		log.Fatalf("error using ovs-docker: %s\n", err)
+		return fmt.Errorf("error using ovs-docker: %w: %s", err, out)
 	}
+	return nil
 }
 
-// ClearPortsFromContainer removes all OVS ports from a container
-func ClearPortsFromContainer(brName, containerName string) {
-	out, err := utils.ExecSudo(
-		"ovs-docker",
-		"del-ports",
-		brName,
-		containerName,
-	).CombinedOutput()
-	if err != nil {
-		fmt.Println(string(out))
-		log.Fatalf("error using ovs-docker: %s\n", err)
+// AddFlow forwards the traffic between two ports of the bridge brName with
+// OpenFlow rules
+func AddFlow(brName, containerA, ifA, containerB, ifB string) error {
+	t := ovsdocker.NewTxn()
+	t.AddFlow(brName, ovsdocker.PortKey(containerA, ifA), ovsdocker.PortKey(containerB, ifB))
+	if err := t.Commit(); err != nil {
+		return fmt.Errorf("AddFlow: %w", err)
 	}
+	return nil
 }
 
-func AddFlow(brName, containerA, ifA, containerB, ifB string) {
-	portA, _ := ovsdocker.GetOFPort(containerA, ifA)
-	portB, _ := ovsdocker.GetOFPort(containerB, ifB)
-	var stderr bytes.Buffer
-	cmd := utils.ExecSudo(
-		"ovs-ofctl",
-		"add-flow", brName,
-		"in_port="+portA+",actions=output:"+portB,
-	)
-	cmd.Stderr = &stderr
-	if config.VFlag {
-		fmt.Println(cmd.String())
+// RemovePort removes a port created by a backend: its OpenFlow rules if
+// flows is true, the bridge port and the veth pair
+func RemovePort(p Port, flows bool) error {
+	if !SupportsFlows(p.Backend) {
+		b, err := BackendOf(ovsdocker.OVSInterface{Backend: p.Backend})
+		if err != nil {
+			return err
+		}
+		return b.Detach(p)
 	}
-	err := cmd.Run()
-	if err != nil {
-		utils.Fatalln("AddFlow:", string(stderr.Bytes()), err)
-	}
-	cmd = utils.ExecSudo(
-		"ovs-ofctl",
-		"add-flow", brName,
-		"in_port="+portB+",actions=output:"+portA,
-	)
-	cmd.Stderr = &stderr
-	if config.VFlag {
-		fmt.Println(cmd.String())
+	if flows {
+		port, ok, err := ovsdocker.GetOFPort(p.Container, p.Iface)
+		if err != nil {
+			return err
+		}
+		if ok {
+			if err := execOFCtl("del-flows", p.Bridge, "in_port="+port); err != nil {
+				return err
+			}
+		}
 	}
-	err = cmd.Run()
-	if err != nil {
-		utils.Fatalln("AddFlow:", string(stderr.Bytes()), err)
+	c := utils.OVSClient()
+	if err := c.VSwitch.DeletePort(p.Bridge, p.HostIface); err != nil {
+		return err
 	}
-
+	// the container side is removed with the host side, the pair may
+	// already be gone if the container has been removed
+	ovsdocker.ExecLink("del", p.HostIface)
+	return nil
 }
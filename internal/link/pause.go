@@ -0,0 +1,167 @@
+package link
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/rahveiz/topomate/internal/ovsdocker"
+	"github.com/rahveiz/topomate/internal/runtime"
+	"github.com/rahveiz/topomate/utils"
+)
+
+// errorList collects the errors of concurrent operations
+type errorList struct {
+	mu   sync.Mutex
+	errs []string
+}
+
+func (l *errorList) add(name string, err error) {
+	l.mu.Lock()
+	l.errs = append(l.errs, name+": "+err.Error())
+	l.mu.Unlock()
+}
+
+// run calls fn in a goroutine added to wg. Its error, or the panic raised
+// (fatal errors included), is added to the list.
+func (l *errorList) run(wg *sync.WaitGroup, name string, fn func() error) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		var err error
+		if perr := utils.Catch(func() { err = fn() }); perr != nil {
+			err = perr
+		}
+		if err != nil {
+			l.add(name, err)
+		}
+	}()
+}
+
+func (l *errorList) err() error {
+	if len(l.errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s", strings.Join(l.errs, "\n"))
+}
+
+// containerNames returns the containers to handle: name if it is not empty,
+// all the containers with saved links otherwise
+func containerNames(name string, m ovsdocker.OVSBulk) []string {
+	if name != "" {
+		return []string{name}
+	}
+	res := make([]string, 0, len(m))
+	for c := range m {
+		res = append(res, c)
+	}
+	return res
+}
+
+// Pause stops a container (all the containers of the topology if name is
+// empty) and removes the host side of its links. OVS bridges are kept.
+func Pause(name string) error {
+	m, err := ReadSaved()
+	if err != nil {
+		return err
+	}
+	rt := runtime.Current()
+	var errs errorList
+	var wg sync.WaitGroup
+	for _, c := range containerNames(name, m) {
+		c := c
+		errs.run(&wg, c, func() error {
+			if err := rt.Stop(c); err != nil {
+				return err
+			}
+			return detachPorts(c, m[c])
+		})
+	}
+	wg.Wait()
+	return errs.err()
+}
+
+// detachPorts removes the host side of the links of a stopped container.
+// Direct veth pairs are removed with the namespace of the container.
+func detachPorts(name string, links []ovsdocker.OVSInterface) error {
+	for _, v := range links {
+		if v.HostIface == "" {
+			continue
+		}
+		p := Port{
+			Container: name,
+			Iface:     v.ContainerIface,
+			HostIface: v.HostIface,
+			Bridge:    v.Bridge,
+			Backend:   v.Backend,
+		}
+		b, err := BackendOf(v)
+		if err != nil {
+			return err
+		}
+		if err := b.Detach(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Resume starts a paused container (all the containers of the topology if
+// name is empty), recreates its links and starts FRR. Errors on the links
+// do not stop the other links and containers, they are all returned.
+func Resume(name string) error {
+	m, err := ReadSaved()
+	if err != nil {
+		return err
+	}
+	rt := runtime.Current()
+	names := containerNames(name, m)
+	all := name == ""
+
+	// containers are started first as direct veth pairs need both
+	// namespaces
+	var errs errorList
+	started := make([]bool, len(names))
+	var wg sync.WaitGroup
+	for i, c := range names {
+		i, c := i, c
+		errs.run(&wg, c, func() error {
+			if err := rt.Start(c); err != nil {
+				return err
+			}
+			started[i] = true
+			return nil
+		})
+	}
+	wg.Wait()
+
+	for i, c := range names {
+		if !started[i] {
+			continue
+		}
+		c := c
+		errs.run(&wg, c, func() error {
+			reattachPorts(c, m, all, &errs)
+			if err := ReapplyStates(c); err != nil {
+				errs.add(c, err)
+			}
+			return runtime.StartFRR(c)
+		})
+	}
+	wg.Wait()
+	return errs.err()
+}
+
+// reattachPorts recreates the links of a container. If all is true, every
+// container is resumed so direct veth pairs are only created by one of their
+// ends. The errors are added to errs.
+func reattachPorts(name string, m ovsdocker.OVSBulk, all bool, errs *errorList) {
+	for _, v := range m[name] {
+		if all && v.HostIface == "" && v.Peer != "" && v.Peer < name+":"+v.ContainerIface {
+			continue
+		}
+		if err := Reattach(name, v, m); err != nil {
+			errs.add(name, err)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package link
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/rahveiz/topomate/internal/ovsdocker"
+	"github.com/rahveiz/topomate/internal/runtime"
+	"github.com/rahveiz/topomate/utils"
+)
+
+// SavedFile returns the path of the file where the links of the running
+// topology are saved
+func SavedFile() string {
+	return filepath.Join(utils.GetDirectoryFromKey("MainDir", ""), "links.json")
+}
+
+// ReadSaved returns the links saved when the topology was started
+func ReadSaved() (ovsdocker.OVSBulk, error) {
+	content, err := ioutil.ReadFile(SavedFile())
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the saved links (is the topology running ?): %v", err)
+	}
+	m := ovsdocker.OVSBulk{}
+	if err := json.Unmarshal(content, &m); err != nil {
+		return nil, fmt.Errorf("%s: %v", SavedFile(), err)
+	}
+	return m, nil
+}
+
+// WriteSaved replaces the saved links
+func WriteSaved(m ovsdocker.OVSBulk) error {
+	j, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(SavedFile(), j, 0644)
+}
+
+// RestartContainer restarts a container and recreates its ports using the
+// saved links
+func RestartContainer(name string) error {
+	m, err := ReadSaved()
+	if err != nil {
+		return err
+	}
+
+	if err := runtime.Current().Restart(name); err != nil {
+		return err
+	}
+
+	for _, v := range m[name] {
+		if err := Reattach(name, v, m); err != nil {
+			return err
+		}
+	}
+	if err := ReapplyStates(name); err != nil {
+		return err
+	}
+	if err := runtime.StartFRR(name); err != nil {
+		utils.PrintError(err)
+	}
+	return nil
+}
+
+// SetNetem changes the conditions emulated on ports of a running topology
+// and updates the saved links, so they are kept after a restart. Shared
+// ports are not modified.
+func SetNetem(n ovsdocker.Netem, ports ...Port) error {
+	m, err := ReadSaved()
+	if err != nil {
+		return err
+	}
+	for _, p := range ports {
+		if p.Shared {
+			continue
+		}
+		if err := applyNetem(p, n); err != nil {
+			return err
+		}
+		for i, v := range m[p.Container] {
+			if v.ContainerIface == p.Iface {
+				m[p.Container][i].Settings.Netem = n
+			}
+		}
+	}
+	return WriteSaved(m)
+}
+
+// applyNetem emulates the conditions n on a port, inside the container for
+// direct veth pairs
+func applyNetem(p Port, n ovsdocker.Netem) error {
+	if p.HostIface != "" {
+		return ovsdocker.ApplyNetem(p.HostIface, n)
+	}
+	c, err := ovsdocker.New(p.Container)
+	if err != nil {
+		return err
+	}
+	return c.WithNetNS(func() error {
+		return c.ApplyNetemNS(p.Iface, n)
+	})
+}
@@ -0,0 +1,281 @@
+package link
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/rahveiz/topomate/config"
+	"github.com/rahveiz/topomate/internal/ovsdocker"
+	"github.com/rahveiz/topomate/utils"
+)
+
+// Methods used to bring a link down
+const (
+	// MethodVeth sets the host side of the veth pairs down, the containers
+	// see their interface losing its carrier
+	MethodVeth = "veth"
+	// MethodFlow removes the forwarding between the ports on the OVS bridge,
+	// the interfaces stay up and the failure must be detected by the
+	// protocols
+	MethodFlow = "flow"
+)
+
+// dropPriority is the priority of the OpenFlow rules dropping the traffic of
+// a port on bridges without explicit forwarding rules
+const dropPriority = "65535"
+
+// Port is an end of a link on the host
+type Port struct {
+	Container string `json:"container"`
+	Iface     string `json:"iface"`
+	HostIface string `json:"host_iface"`
+	Bridge    string `json:"bridge"`
+	// Shared ports are used by other links and are never modified
+	Shared bool `json:"shared,omitempty"`
+	// Backend used to create the port, empty for OVS
+	Backend string `json:"backend,omitempty"`
+}
+
+func (p Port) String() string {
+	return p.Container + ":" + p.Iface
+}
+
+// State is a link that has been brought down
+type State struct {
+	A      Port      `json:"a"`
+	B      Port      `json:"b"`
+	Flows  bool      `json:"flows,omitempty"`
+	Method string    `json:"method"`
+	Since  time.Time `json:"since"`
+}
+
+// Key identifies the link independently of the order of its ports
+func (s State) Key() string {
+	k := []string{s.A.String(), s.B.String()}
+	sort.Strings(k)
+	return k[0] + "-" + k[1]
+}
+
+func (s State) String() string {
+	return s.A.String() + " <-> " + s.B.String()
+}
+
+// FindPort returns the host interface and bridge used by an interface of a
+// container in the saved links
+func FindPort(m ovsdocker.OVSBulk, container, ifName string) (Port, error) {
+	for _, v := range m[container] {
+		if v.ContainerIface == ifName {
+			return Port{
+				Container: container,
+				Iface:     ifName,
+				HostIface: v.HostIface,
+				Bridge:    v.Bridge,
+				Backend:   v.Backend,
+			}, nil
+		}
+	}
+	return Port{}, fmt.Errorf("interface %s of %s not found in the saved links", ifName, container)
+}
+
+// StatesFile returns the path of the file where the links states are saved
+func StatesFile() string {
+	return filepath.Join(utils.GetDirectoryFromKey("MainDir", ""), "link_states.json")
+}
+
+// LoadStates returns the links that are currently down, indexed by key
+func LoadStates() (map[string]State, error) {
+	res := make(map[string]State)
+	content, err := ioutil.ReadFile(StatesFile())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return res, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &res); err != nil {
+		return nil, fmt.Errorf("%s: %v", StatesFile(), err)
+	}
+	return res, nil
+}
+
+func saveStates(m map[string]State) error {
+	if len(m) == 0 {
+		if err := os.Remove(StatesFile()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	j, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(StatesFile(), j, 0644)
+}
+
+// ClearStates removes the saved links states
+func ClearStates() {
+	saveStates(nil)
+}
+
+func execOFCtl(args ...string) error {
+	var stderr bytes.Buffer
+	cmd := utils.ExecSudo(append([]string{"ovs-ofctl"}, args...)...)
+	cmd.Stderr = &stderr
+	if config.VFlag {
+		fmt.Println(cmd.String())
+	}
+	if err := utils.Run(cmd); err != nil {
+		return fmt.Errorf("ovs-ofctl: %s\n%s%s", cmd.String(), string(stderr.Bytes()), err)
+	}
+	return nil
+}
+
+func ofport(p Port) (string, error) {
+	port, ok, err := ovsdocker.GetOFPort(p.Container, p.Iface)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("no OVS port found for %s", p)
+	}
+	return port, nil
+}
+
+func setVeth(p Port, up bool) error {
+	state := "down"
+	if up {
+		state = "up"
+	}
+	if p.HostIface == "" {
+		// direct veth pair, the interface is only in the container
+		c, err := ovsdocker.New(p.Container)
+		if err != nil {
+			return err
+		}
+		return c.WithNetNS(func() error {
+			return c.ExecNS("ip", "link", "set", p.Iface, state)
+		})
+	}
+	return ovsdocker.ExecLink("set", p.HostIface, state)
+}
+
+// apply brings the link s down or up
+func (s State) apply(up bool) error {
+	ports := []Port{s.A, s.B}
+	switch s.Method {
+	case MethodVeth:
+		for _, p := range ports {
+			if p.Shared {
+				continue
+			}
+			if err := setVeth(p, up); err != nil {
+				return err
+			}
+		}
+	case MethodFlow:
+		if s.Flows {
+			if up {
+				return AddFlow(s.A.Bridge, s.A.Container, s.A.Iface, s.B.Container, s.B.Iface)
+			}
+			for _, p := range ports {
+				port, err := ofport(p)
+				if err != nil {
+					return err
+				}
+				if err := execOFCtl("del-flows", p.Bridge, "in_port="+port); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		for _, p := range ports {
+			if p.Shared {
+				continue
+			}
+			port, err := ofport(p)
+			if err != nil {
+				return err
+			}
+			if up {
+				err = execOFCtl("--strict", "del-flows", p.Bridge, "priority="+dropPriority+",in_port="+port)
+			} else {
+				err = execOFCtl("add-flow", p.Bridge, "priority="+dropPriority+",in_port="+port+",actions=drop")
+			}
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown method %s", s.Method)
+	}
+	return nil
+}
+
+// Down brings the link between the ports a and b down and records its state.
+// If flows is true, the traffic between the ports is forwarded by OpenFlow
+// rules (added by AddFlow).
+func Down(a, b Port, flows bool, method string) error {
+	states, err := LoadStates()
+	if err != nil {
+		return err
+	}
+	s := State{A: a, B: b, Flows: flows, Method: method, Since: time.Now()}
+	if method == MethodFlow && !(SupportsFlows(a.Backend) && SupportsFlows(b.Backend)) {
+		return fmt.Errorf("the %s method needs the %s link backend", MethodFlow, BackendOVS)
+	}
+	if _, ok := states[s.Key()]; ok {
+		return fmt.Errorf("link %s is already down", s)
+	}
+	if err := s.apply(false); err != nil {
+		return err
+	}
+	states[s.Key()] = s
+	return saveStates(states)
+}
+
+// Up brings the link between the ports a and b back up, using the method it
+// was brought down with
+func Up(a, b Port) error {
+	states, err := LoadStates()
+	if err != nil {
+		return err
+	}
+	key := State{A: a, B: b}.Key()
+	s, ok := states[key]
+	if !ok {
+		return fmt.Errorf("link %s <-> %s is not down", a, b)
+	}
+	if err := s.apply(true); err != nil {
+		return err
+	}
+	delete(states, key)
+	return saveStates(states)
+}
+
+// ReapplyStates brings the links of a container down again after its veth
+// pairs have been recreated (restart or resume)
+func ReapplyStates(container string) error {
+	states, err := LoadStates()
+	if err != nil {
+		return err
+	}
+	for _, s := range states {
+		if s.Method != MethodVeth {
+			continue
+		}
+		for _, p := range []Port{s.A, s.B} {
+			if p.Container == container && !p.Shared {
+				if err := setVeth(p, false); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
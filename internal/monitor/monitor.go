@@ -0,0 +1,195 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rahveiz/topomate/internal/link"
+	"github.com/rahveiz/topomate/internal/ovsdocker"
+	"github.com/rahveiz/topomate/utils"
+)
+
+// Counter sources
+const (
+	// SourceSysfs reads the counters of the host side of the veth pairs in
+	// /sys/class/net (inside the container for direct veth pairs)
+	SourceSysfs = "sysfs"
+	// SourceOVS reads the statistics of the OVS ports
+	SourceOVS = "ovs"
+)
+
+// DefaultInterval is the default sampling interval
+const DefaultInterval = 5 * time.Second
+
+const countersFile = "counters.jsonl"
+
+// Point contains the traffic of an interface during an interval, seen from
+// its node: Tx is the traffic sent on the link
+type Point struct {
+	Time      time.Time `json:"time"`
+	Port      string    `json:"port"`
+	Link      string    `json:"link"`
+	Interval  float64   `json:"interval"`
+	TxBytes   uint64    `json:"tx_bytes"`
+	RxBytes   uint64    `json:"rx_bytes"`
+	TxPackets uint64    `json:"tx_packets"`
+	RxPackets uint64    `json:"rx_packets"`
+	Drops     uint64    `json:"drops"`
+	Errors    uint64    `json:"errors"`
+}
+
+// TxBps returns the throughput sent in bits per second
+func (p Point) TxBps() float64 {
+	if p.Interval <= 0 {
+		return 0
+	}
+	return float64(p.TxBytes) * 8 / p.Interval
+}
+
+// Dir returns the directory where the counters of the current project are
+// stored
+func Dir() string {
+	return filepath.Join(utils.GetDirectoryFromKey("ConfigDir", ""), "monitor")
+}
+
+// Reset removes the stored counters
+func Reset() error {
+	if err := os.Remove(filepath.Join(Dir(), countersFile)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// read returns the counters of the interfaces of the saved links, seen from
+// the containers, indexed by <container>:<interface>
+func read(source string, m ovsdocker.OVSBulk) (map[string]ovsdocker.Counters, error) {
+	res := make(map[string]ovsdocker.Counters, 64)
+	switch source {
+	case SourceOVS:
+		stats, err := ovsdocker.ListPortStats()
+		if err != nil {
+			return nil, err
+		}
+		for k, c := range stats {
+			// OVS receives what the container sends
+			res[k] = c.Reverse()
+		}
+	case SourceSysfs:
+		for container, ifaces := range m {
+			for _, i := range ifaces {
+				k := ovsdocker.PortKey(container, i.ContainerIface)
+				if i.HostIface == "" {
+					c, err := ovsdocker.ReadContainerCounters(container, i.ContainerIface)
+					if err == nil {
+						res[k] = c
+					}
+					continue
+				}
+				if c, err := ovsdocker.ReadCounters(i.HostIface); err == nil {
+					res[k] = c.Reverse()
+				}
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown counter source %s (sysfs or ovs)", source)
+	}
+	return res, nil
+}
+
+// ReadCounters returns the current counters of the interfaces of the saved
+// links, seen from the containers, indexed by <container>:<interface>
+func ReadCounters(source string) (map[string]ovsdocker.Counters, error) {
+	m, err := link.ReadSaved()
+	if err != nil {
+		return nil, err
+	}
+	return read(source, m)
+}
+
+// delta returns the difference between two values of a counter, a counter
+// lower than its previous value has been reset (the container restarted)
+func delta(cur, prev uint64) uint64 {
+	if cur < prev {
+		return cur
+	}
+	return cur - prev
+}
+
+// Sampler reads the counters of the interfaces of a running topology every
+// interval and stores the traffic of each interval
+type Sampler struct {
+	Source   string
+	Interval time.Duration
+	// Links contains the name of the link of each interface
+	Links map[string]string
+	// OnSample is called with the points of each interval
+	OnSample func(points []Point)
+}
+
+// Run samples the counters until ctx is cancelled
+func (s *Sampler) Run(ctx context.Context) error {
+	if s.Interval <= 0 {
+		s.Interval = DefaultInterval
+	}
+	m, err := link.ReadSaved()
+	if err != nil {
+		return err
+	}
+	prev, err := read(s.Source, m)
+	if err != nil {
+		return err
+	}
+	last := time.Now()
+
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			cur, err := read(s.Source, m)
+			if err != nil {
+				return err
+			}
+			points := make([]Point, 0, len(cur))
+			for k, c := range cur {
+				p, ok := prev[k]
+				name, linked := s.Links[k]
+				if !ok || !linked {
+					continue
+				}
+				points = append(points, Point{
+					Time:      now,
+					Port:      k,
+					Link:      name,
+					Interval:  now.Sub(last).Seconds(),
+					TxBytes:   delta(c.TxBytes, p.TxBytes),
+					RxBytes:   delta(c.RxBytes, p.RxBytes),
+					TxPackets: delta(c.TxPackets, p.TxPackets),
+					RxPackets: delta(c.RxPackets, p.RxPackets),
+					Drops:     delta(c.TxDropped+c.RxDropped, p.TxDropped+p.RxDropped),
+					Errors:    delta(c.TxErrors+c.RxErrors, p.TxErrors+p.RxErrors),
+				})
+			}
+			prev, last = cur, now
+			if err := save(points); err != nil {
+				return err
+			}
+			if s.OnSample != nil {
+				s.OnSample(points)
+			}
+		}
+	}
+}
+
+func save(points []Point) error {
+	values := make([]interface{}, len(points))
+	for i := range points {
+		values[i] = points[i]
+	}
+	return utils.AppendJSONLines(filepath.Join(Dir(), countersFile), values)
+}
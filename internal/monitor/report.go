@@ -0,0 +1,123 @@
+package monitor
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rahveiz/topomate/internal/flows"
+)
+
+// Usage is the traffic sent on a link by one of its interfaces
+type Usage struct {
+	Link    string `json:"link"`
+	Port    string `json:"port"`
+	TxBytes uint64 `json:"tx_bytes"`
+	// Average and peak throughput in bits per second
+	Avg    float64 `json:"avg_bps"`
+	Peak   float64 `json:"peak_bps"`
+	Drops  uint64  `json:"drops"`
+	Errors uint64  `json:"errors"`
+	// Speed of the link in Mbps (0 if unknown)
+	Speed int `json:"speed,omitempty"`
+}
+
+// Utilisation returns the utilisation of the link in percent for the
+// throughput bps
+func (u Usage) Utilisation(bps float64) float64 {
+	if u.Speed == 0 {
+		return 0
+	}
+	return bps / float64(u.Speed*1000000) * 100
+}
+
+// Usages aggregates points by interface. speeds contains the speed of the
+// interfaces in Mbps. The result is sorted by link.
+func Usages(points []Point, speeds map[string]int) []Usage {
+	byPort := make(map[string]*Usage)
+	total := make(map[string]float64)
+	for _, p := range points {
+		u, ok := byPort[p.Port]
+		if !ok {
+			u = &Usage{Link: p.Link, Port: p.Port, Speed: speeds[p.Port]}
+			byPort[p.Port] = u
+		}
+		u.TxBytes += p.TxBytes
+		u.Drops += p.Drops
+		u.Errors += p.Errors
+		total[p.Port] += p.Interval
+		if bps := p.TxBps(); bps > u.Peak {
+			u.Peak = bps
+		}
+	}
+	res := make([]Usage, 0, len(byPort))
+	for k, u := range byPort {
+		if d := total[k]; d > 0 {
+			u.Avg = float64(u.TxBytes) * 8 / d
+		}
+		res = append(res, *u)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Link != res[j].Link {
+			return res[i].Link < res[j].Link
+		}
+		return res[i].Port < res[j].Port
+	})
+	return res
+}
+
+// Read returns the stored points more recent than since (all of them if
+// since is zero)
+func Read(since time.Time) ([]Point, error) {
+	f, err := os.Open(filepath.Join(Dir(), countersFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	res := make([]Point, 0, 256)
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for dec.More() {
+		var p Point
+		if err := dec.Decode(&p); err != nil {
+			return nil, fmt.Errorf("%s: %v", countersFile, err)
+		}
+		if p.Time.Before(since) {
+			continue
+		}
+		res = append(res, p)
+	}
+	return res, nil
+}
+
+// WriteUsages displays the utilisation, drops and errors of each link.
+// Links without any traffic are hidden unless all is true.
+func WriteUsages(dst io.Writer, usages []Usage, all bool) {
+	w := tabwriter.NewWriter(dst, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "LINK\tFROM\tAVERAGE\tPEAK\tUTILISATION\tDROPS\tERRORS")
+	n := 0
+	for _, u := range usages {
+		if !all && u.TxBytes == 0 && u.Drops == 0 && u.Errors == 0 {
+			continue
+		}
+		n++
+		util := "-"
+		if u.Speed > 0 {
+			util = fmt.Sprintf("%.1f%% (peak %.1f%%)", u.Utilisation(u.Avg), u.Utilisation(u.Peak))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%d\n", u.Link, u.Port,
+			flows.FormatBps(u.Avg), flows.FormatBps(u.Peak), util, u.Drops, u.Errors)
+	}
+	w.Flush()
+	if n == 0 {
+		fmt.Fprintln(dst, "No traffic.")
+	}
+}
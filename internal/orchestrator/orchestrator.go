@@ -0,0 +1,280 @@
+// Package orchestrator runs a graph of dependent tasks with a bounded
+// number of workers.
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Task is a unit of work, run once all its dependencies have succeeded
+type Task struct {
+	Name string
+	Deps []string
+	Run  func(ctx context.Context) error
+}
+
+// TaskError is the error returned by a task
+type TaskError struct {
+	Task string
+	Err  error
+}
+
+func (e *TaskError) Error() string {
+	return e.Task + ": " + e.Err.Error()
+}
+
+func (e *TaskError) Unwrap() error {
+	return e.Err
+}
+
+// Error aggregates the errors of a run. Failed contains the tasks that
+// returned an error, Skipped the tasks that did not run because a
+// dependency failed or the run was cancelled (Err is then the context
+// error).
+type Error struct {
+	Failed  []*TaskError
+	Skipped []string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	switch {
+	case len(e.Failed) == 1:
+		b.WriteString(e.Failed[0].Error())
+	case len(e.Failed) > 1:
+		fmt.Fprintf(&b, "%d tasks failed:", len(e.Failed))
+		for _, f := range e.Failed {
+			b.WriteString("\n  " + f.Error())
+		}
+	}
+	if e.Err != nil {
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(e.Err.Error())
+	}
+	if len(e.Skipped) > 0 {
+		fmt.Fprintf(&b, " (%d task(s) skipped)", len(e.Skipped))
+	}
+	return b.String()
+}
+
+// Unwrap returns the context error if the run was cancelled
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Progress describes a finished task of a run. Done is the number of tasks
+// finished (or skipped) out of Total.
+type Progress struct {
+	Task  string
+	Err   error
+	Done  int
+	Total int
+}
+
+type progressKey struct{}
+
+// WithProgress returns a context in which the graphs report each finished
+// task to fn. fn is called from the goroutine running the graph.
+func WithProgress(ctx context.Context, fn func(Progress)) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+// Graph contains tasks and their dependencies
+type Graph struct {
+	tasks []*Task
+	index map[string]int
+}
+
+// New returns an empty graph
+func New() *Graph {
+	return &Graph{index: make(map[string]int, 64)}
+}
+
+// Add adds the task name to the graph, run after the tasks deps
+func (g *Graph) Add(name string, run func(ctx context.Context) error, deps ...string) {
+	if _, ok := g.index[name]; ok {
+		panic("orchestrator: duplicate task " + name)
+	}
+	g.index[name] = len(g.tasks)
+	g.tasks = append(g.tasks, &Task{Name: name, Deps: deps, Run: run})
+}
+
+// Has returns true if the graph contains the task name
+func (g *Graph) Has(name string) bool {
+	_, ok := g.index[name]
+	return ok
+}
+
+// Len returns the number of tasks of the graph
+func (g *Graph) Len() int {
+	return len(g.tasks)
+}
+
+// ErrSkipped is the error reported for the tasks which did not run
+var ErrSkipped = errors.New("skipped")
+
+type result struct {
+	idx int
+	err error
+}
+
+// call runs a task, converting panics to errors
+func call(ctx context.Context, t *Task) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return t.Run(ctx)
+}
+
+// Run executes the tasks of the graph with at most limit tasks running at
+// the same time (no limit if limit <= 0). A task failure does not stop the
+// independent tasks, but its dependents are skipped. When ctx is
+// cancelled, the running tasks are waited for and the others are skipped.
+// The returned error is nil or an *Error.
+func (g *Graph) Run(ctx context.Context, limit int) error {
+	n := len(g.tasks)
+	if limit <= 0 || limit > n {
+		limit = n
+	}
+
+	pending := make([]int, n)
+	dependents := make([][]int, n)
+	for i, t := range g.tasks {
+		for _, d := range t.Deps {
+			j, ok := g.index[d]
+			if !ok {
+				return fmt.Errorf("orchestrator: task %s depends on unknown task %s", t.Name, d)
+			}
+			pending[i]++
+			dependents[j] = append(dependents[j], i)
+		}
+	}
+	if err := g.checkCycles(pending, dependents); err != nil {
+		return err
+	}
+
+	ready := make([]int, 0, n)
+	for i := range g.tasks {
+		if pending[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	res := &Error{}
+	done := make([]bool, n)
+	results := make(chan result)
+	running, finished := 0, 0
+
+	progress, _ := ctx.Value(progressKey{}).(func(Progress))
+	report := func(i int, err error) {
+		if progress != nil {
+			progress(Progress{Task: g.tasks[i].Name, Err: err, Done: finished, Total: n})
+		}
+	}
+
+	// skip marks a task and its dependents as not run
+	var skip func(i int)
+	skip = func(i int) {
+		if done[i] {
+			return
+		}
+		done[i] = true
+		finished++
+		res.Skipped = append(res.Skipped, g.tasks[i].Name)
+		report(i, ErrSkipped)
+		for _, d := range dependents[i] {
+			skip(d)
+		}
+	}
+
+	for finished < n {
+		cancelled := ctx.Err() != nil
+		for !cancelled && running < limit && len(ready) > 0 {
+			i := ready[0]
+			ready = ready[1:]
+			running++
+			go func(i int) {
+				results <- result{idx: i, err: call(ctx, g.tasks[i])}
+			}(i)
+		}
+		if cancelled && running == 0 {
+			res.Err = ctx.Err()
+			for i := range g.tasks {
+				skip(i)
+			}
+			break
+		}
+
+		var r result
+		select {
+		case r = <-results:
+		case <-ctx.Done():
+			if running > 0 {
+				r = <-results
+			} else {
+				continue
+			}
+		}
+		running--
+		done[r.idx] = true
+		finished++
+		report(r.idx, r.err)
+		if r.err != nil {
+			res.Failed = append(res.Failed, &TaskError{Task: g.tasks[r.idx].Name, Err: r.err})
+			for _, d := range dependents[r.idx] {
+				skip(d)
+			}
+			continue
+		}
+		for _, d := range dependents[r.idx] {
+			pending[d]--
+			if pending[d] == 0 && !done[d] {
+				ready = append(ready, d)
+			}
+		}
+	}
+
+	if ctx.Err() != nil {
+		res.Err = ctx.Err()
+	}
+	if len(res.Failed) == 0 && res.Err == nil {
+		return nil
+	}
+	return res
+}
+
+// checkCycles returns an error if the dependencies contain a cycle
+func (g *Graph) checkCycles(pending []int, dependents [][]int) error {
+	left := make([]int, len(pending))
+	copy(left, pending)
+	queue := make([]int, 0, len(pending))
+	for i, p := range left {
+		if p == 0 {
+			queue = append(queue, i)
+		}
+	}
+	seen := 0
+	for len(queue) > 0 {
+		i := queue[0]
+		queue = queue[1:]
+		seen++
+		for _, d := range dependents[i] {
+			left[d]--
+			if left[d] == 0 {
+				queue = append(queue, d)
+			}
+		}
+	}
+	if seen != len(pending) {
+		return fmt.Errorf("orchestrator: dependency cycle between tasks")
+	}
+	return nil
+}
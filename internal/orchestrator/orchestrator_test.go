@@ -0,0 +1,226 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testTask struct {
+	name string
+	deps []string
+	// fail is returned by the task, "panic" makes it panic
+	fail string
+	// cancel cancels the run once the task is done
+	cancel bool
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name    string
+		tasks   []testTask
+		run     []string
+		failed  []string
+		skipped []string
+		// err is the error of an invalid graph
+		err       string
+		cancelled bool
+	}{
+		{
+			name: "dependencies",
+			tasks: []testTask{
+				{name: "links", deps: []string{"R1", "R2"}},
+				{name: "R1"},
+				{name: "R2"},
+				{name: "config", deps: []string{"links"}},
+			},
+			run: []string{"R1", "R2", "config", "links"},
+		},
+		{
+			name: "dependents of a failed task skipped",
+			tasks: []testTask{
+				{name: "R1", fail: "no image"},
+				{name: "R2"},
+				{name: "links", deps: []string{"R1", "R2"}},
+				{name: "config", deps: []string{"links"}},
+				{name: "H1", deps: []string{"R2"}},
+			},
+			run:     []string{"H1", "R1", "R2"},
+			failed:  []string{"R1: no image"},
+			skipped: []string{"config", "links"},
+		},
+		{
+			name: "panic converted to an error",
+			tasks: []testTask{
+				{name: "R1", fail: "panic"},
+				{name: "R2"},
+				{name: "links", deps: []string{"R1"}},
+			},
+			run:     []string{"R1", "R2"},
+			failed:  []string{"R1: panic: injected panic"},
+			skipped: []string{"links"},
+		},
+		{
+			name: "cancellation",
+			tasks: []testTask{
+				{name: "R1", cancel: true},
+				{name: "links", deps: []string{"R1"}},
+				{name: "config", deps: []string{"links"}},
+			},
+			run:       []string{"R1"},
+			skipped:   []string{"config", "links"},
+			cancelled: true,
+		},
+		{
+			name: "cycle",
+			tasks: []testTask{
+				{name: "R1"},
+				{name: "a", deps: []string{"R1", "c"}},
+				{name: "b", deps: []string{"a"}},
+				{name: "c", deps: []string{"b"}},
+			},
+			err: "dependency cycle",
+		},
+		{
+			name: "self dependency",
+			tasks: []testTask{
+				{name: "a", deps: []string{"a"}},
+			},
+			err: "dependency cycle",
+		},
+		{
+			name: "unknown dependency",
+			tasks: []testTask{
+				{name: "links", deps: []string{"R3"}},
+			},
+			err: "depends on unknown task R3",
+		},
+		{
+			name: "empty graph",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var lock sync.Mutex
+			run := make([]string, 0, len(tt.tasks))
+			g := New()
+			for _, task := range tt.tasks {
+				task := task
+				g.Add(task.name, func(context.Context) error {
+					lock.Lock()
+					run = append(run, task.name)
+					lock.Unlock()
+					if task.cancel {
+						cancel()
+					}
+					switch task.fail {
+					case "":
+						return nil
+					case "panic":
+						panic("injected panic")
+					default:
+						return errors.New(task.fail)
+					}
+				}, task.deps...)
+			}
+
+			err := g.Run(ctx, 2)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %s", err, tt.err)
+				}
+				if len(run) > 0 {
+					t.Errorf("tasks %v run on an invalid graph", run)
+				}
+				return
+			}
+
+			sort.Strings(run)
+			if !reflect.DeepEqual(run, append([]string{}, tt.run...)) {
+				t.Errorf("run = %v, want %v", run, tt.run)
+			}
+			if tt.failed == nil && tt.skipped == nil && !tt.cancelled {
+				if err != nil {
+					t.Fatalf("error = %v, want nil", err)
+				}
+				return
+			}
+			var e *Error
+			if !errors.As(err, &e) {
+				t.Fatalf("error = %v, want an *Error", err)
+			}
+			failed := make([]string, 0, len(e.Failed))
+			for _, f := range e.Failed {
+				failed = append(failed, f.Error())
+			}
+			sort.Strings(failed)
+			sort.Strings(e.Skipped)
+			if !reflect.DeepEqual(failed, append([]string{}, tt.failed...)) {
+				t.Errorf("failed = %v, want %v", failed, tt.failed)
+			}
+			if !reflect.DeepEqual(e.Skipped, tt.skipped) {
+				t.Errorf("skipped = %v, want %v", e.Skipped, tt.skipped)
+			}
+			if cancelled := errors.Is(err, context.Canceled); cancelled != tt.cancelled {
+				t.Errorf("cancelled = %v, want %v", cancelled, tt.cancelled)
+			}
+		})
+	}
+}
+
+func TestRunLimit(t *testing.T) {
+	const limit = 3
+	var lock sync.Mutex
+	running, max := 0, 0
+	g := New()
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		g.Add(name, func(context.Context) error {
+			lock.Lock()
+			running++
+			if running > max {
+				max = running
+			}
+			lock.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			lock.Lock()
+			running--
+			lock.Unlock()
+			return nil
+		})
+	}
+	if err := g.Run(context.Background(), limit); err != nil {
+		t.Fatal(err)
+	}
+	if max > limit {
+		t.Errorf("%d tasks run at the same time, want at most %d", max, limit)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	g := New()
+	g.Add("R1", func(context.Context) error { return errors.New("failed") })
+	g.Add("links", func(context.Context) error { return nil }, "R1")
+	var reports []Progress
+	ctx := WithProgress(context.Background(), func(p Progress) {
+		reports = append(reports, p)
+	})
+	g.Run(ctx, 1)
+
+	if len(reports) != 2 {
+		t.Fatalf("%d progress reports, want 2", len(reports))
+	}
+	if r := reports[0]; r.Task != "R1" || r.Err == nil || r.Done != 1 || r.Total != 2 {
+		t.Errorf("first report = %+v, want R1 failed 1/2", r)
+	}
+	if r := reports[1]; r.Task != "links" || r.Err != ErrSkipped || r.Done != 2 || r.Total != 2 {
+		t.Errorf("second report = %+v, want links skipped 2/2", r)
+	}
+}
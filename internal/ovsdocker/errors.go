@@ -0,0 +1,78 @@
+package ovsdocker
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrIfaceExists is returned when adding an interface that already exists in
+// a container
+var ErrIfaceExists = errors.New("interface already exists")
+
+// LinkError is returned when an operation on an interface fails
+type LinkError struct {
+	// Op is the operation (add, mtu, move, rename, up, vrf, addr, route)
+	Op    string
+	Iface string
+	// PID identifies the namespace of the interface, 0 for the host
+	PID int
+	Err error
+}
+
+func (e *LinkError) Error() string {
+	if e.PID == 0 {
+		return fmt.Sprintf("link %s %s: %v", e.Op, e.Iface, e.Err)
+	}
+	return fmt.Sprintf("link %s %s (netns %d): %v", e.Op, e.Iface, e.PID, e.Err)
+}
+
+func (e *LinkError) Unwrap() error {
+	return e.Err
+}
+
+// NamespaceError is returned when the network namespace of a container can
+// not be entered
+type NamespaceError struct {
+	PID int
+	Err error
+}
+
+func (e *NamespaceError) Error() string {
+	return fmt.Sprintf("netns %d: %v", e.PID, e.Err)
+}
+
+func (e *NamespaceError) Unwrap() error {
+	return e.Err
+}
+
+// SysctlError is returned when a sysctl can not be set in a namespace
+type SysctlError struct {
+	Key string
+	PID int
+	Err error
+}
+
+func (e *SysctlError) Error() string {
+	return fmt.Sprintf("sysctl %s (netns %d): %v", e.Key, e.PID, e.Err)
+}
+
+func (e *SysctlError) Unwrap() error {
+	return e.Err
+}
+
+// CmdError is returned when a command (ip, tc...) fails
+type CmdError struct {
+	// Wrapper is the name of the function running the command
+	Wrapper string
+	Cmd     string
+	Stderr  string
+	Err     error
+}
+
+func (e *CmdError) Error() string {
+	return fmt.Sprintf("%s: %s\n%s%s", e.Wrapper, e.Cmd, e.Stderr, e.Err)
+}
+
+func (e *CmdError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,144 @@
+package ovsdocker
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/rahveiz/topomate/config"
+	"github.com/rahveiz/topomate/utils"
+)
+
+// Netem contains the network conditions emulated on a port. They are applied
+// on the host side of the veth pair, so they affect the traffic sent to the
+// container.
+type Netem struct {
+	Delay   time.Duration `json:",omitempty"`
+	Jitter  time.Duration `json:",omitempty"`
+	Loss    float64       `json:",omitempty"`
+	Corrupt float64       `json:",omitempty"`
+	Rate    string        `json:",omitempty"`
+}
+
+// IsZero returns true if no condition is emulated
+func (n Netem) IsZero() bool {
+	return n == Netem{}
+}
+
+func (n Netem) hasNetem() bool {
+	return n.Delay > 0 || n.Loss > 0 || n.Corrupt > 0
+}
+
+func usec(d time.Duration) string {
+	return strconv.FormatInt(d.Microseconds(), 10) + "us"
+}
+
+func percent(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64) + "%"
+}
+
+// netemArgs returns the parameters of the netem qdisc
+func (n Netem) netemArgs() []string {
+	args := make([]string, 0, 8)
+	if n.Delay > 0 {
+		args = append(args, "delay", usec(n.Delay))
+		if n.Jitter > 0 {
+			args = append(args, usec(n.Jitter))
+		}
+	}
+	if n.Loss > 0 {
+		args = append(args, "loss", percent(n.Loss))
+	}
+	if n.Corrupt > 0 {
+		args = append(args, "corrupt", percent(n.Corrupt))
+	}
+	return args
+}
+
+// tbfArgs returns the parameters of the tbf qdisc used to limit the rate
+func (n Netem) tbfArgs() []string {
+	return []string{"tbf", "rate", n.Rate, "burst", "32kbit", "latency", "400ms"}
+}
+
+// String returns a short description of the emulated conditions
+func (n Netem) String() string {
+	if n.IsZero() {
+		return "none"
+	}
+	var b bytes.Buffer
+	if n.Delay > 0 {
+		fmt.Fprintf(&b, "delay %v", n.Delay)
+		if n.Jitter > 0 {
+			fmt.Fprintf(&b, "±%v", n.Jitter)
+		}
+		b.WriteString(" ")
+	}
+	if n.Loss > 0 {
+		fmt.Fprintf(&b, "loss %s ", percent(n.Loss))
+	}
+	if n.Corrupt > 0 {
+		fmt.Fprintf(&b, "corrupt %s ", percent(n.Corrupt))
+	}
+	if n.Rate != "" {
+		fmt.Fprintf(&b, "rate %s ", n.Rate)
+	}
+	return b.String()[:b.Len()-1]
+}
+
+// ExecTC is a wrapper around the "tc" command
+func ExecTC(args ...string) error {
+	var stderr bytes.Buffer
+	cmdArgs := []string{"tc"}
+	cmdArgs = append(cmdArgs, args...)
+	cmd := utils.ExecSudo(cmdArgs...)
+	cmd.Stderr = &stderr
+	if config.VFlag {
+		fmt.Println(cmd.String())
+	}
+	err := utils.Run(cmd)
+	if err != nil {
+		return &CmdError{Wrapper: "ExecTC", Cmd: cmd.String(), Stderr: stderr.String(), Err: err}
+	}
+	return nil
+}
+
+// ApplyNetem configures the qdiscs of the interface dev (on the host) to
+// emulate the conditions n. Existing qdiscs are replaced, and removed if n
+// is empty.
+func ApplyNetem(dev string, n Netem) error {
+	return applyNetem(ExecTC, dev, n)
+}
+
+// ApplyNetemNS configures the qdiscs of the interface dev inside the
+// container namespace, used when the interface has no host side
+func (c *OVSDockerClient) ApplyNetemNS(dev string, n Netem) error {
+	return applyNetem(func(args ...string) error {
+		return c.ExecNS(append([]string{"tc"}, args...)...)
+	}, dev, n)
+}
+
+// applyNetem configures the qdiscs of dev using the tc function
+func applyNetem(tc func(args ...string) error, dev string, n Netem) error {
+	if n.IsZero() {
+		// an error is returned if there is no qdisc to delete
+		tc("qdisc", "del", "dev", dev, "root")
+		return nil
+	}
+
+	switch {
+	case n.hasNetem() && n.Rate != "":
+		args := append([]string{"qdisc", "replace", "dev", dev, "root", "handle", "1:", "netem"}, n.netemArgs()...)
+		if err := tc(args...); err != nil {
+			return err
+		}
+		args = append([]string{"qdisc", "replace", "dev", dev, "parent", "1:1", "handle", "10:"}, n.tbfArgs()...)
+		return tc(args...)
+	case n.hasNetem():
+		args := append([]string{"qdisc", "replace", "dev", dev, "root", "netem"}, n.netemArgs()...)
+		return tc(args...)
+	default:
+		args := append([]string{"qdisc", "replace", "dev", dev, "root"}, n.tbfArgs()...)
+		return tc(args...)
+	}
+}
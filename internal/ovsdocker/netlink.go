@@ -0,0 +1,251 @@
+package ovsdocker
+
+import (
+	"io/ioutil"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+
+	"github.com/rahveiz/topomate/utils"
+)
+
+// Capabilities needed to configure the namespaces of the containers through
+// netlink: open them (ptrace access), enter them and configure interfaces
+const (
+	capNetAdmin  = 12
+	capSysPtrace = 19
+	capSysAdmin  = 21
+)
+
+var (
+	netlinkCaps     bool
+	netlinkCapsOnce sync.Once
+)
+
+// useNetlink returns true if the interfaces are configured in-process through
+// netlink, which needs the CAP_NET_ADMIN, CAP_SYS_ADMIN and CAP_SYS_PTRACE
+// capabilities (root, or set on the binary with setcap). Otherwise the ip
+// command is used (with sudo), as well as in dry-run mode so that operations
+// are recorded.
+func useNetlink() bool {
+	netlinkCapsOnce.Do(func() {
+		netlinkCaps = hasCaps(capNetAdmin, capSysPtrace, capSysAdmin)
+	})
+	return !utils.DryRun() && netlinkCaps
+}
+
+// hasCaps returns true if the process has the effective capabilities caps
+func hasCaps(caps ...uint) bool {
+	data, err := ioutil.ReadFile("/proc/self/status")
+	if err != nil {
+		return false
+	}
+	for _, l := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(l, "CapEff:") {
+			continue
+		}
+		eff, err := strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(l, "CapEff:")), 16, 64)
+		if err != nil {
+			return false
+		}
+		for _, c := range caps {
+			if eff&(1<<c) == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// netNS is a handle on the network namespace of a container
+type netNS struct {
+	pid int
+	ns  netns.NsHandle
+	h   *netlink.Handle
+}
+
+func openNetNS(pid int) (*netNS, error) {
+	ns, err := netns.GetFromPid(pid)
+	if err != nil {
+		return nil, &NamespaceError{PID: pid, Err: err}
+	}
+	h, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		ns.Close()
+		return nil, &NamespaceError{PID: pid, Err: err}
+	}
+	return &netNS{pid: pid, ns: ns, h: h}, nil
+}
+
+func (n *netNS) Close() {
+	n.h.Delete()
+	n.ns.Close()
+}
+
+// setSysctls writes the sysctls from a thread switched to the namespace, as
+// /proc/sys/net depends on the namespace of the caller
+func (n *netNS) setSysctls(values []sysctl) error {
+	runtime.LockOSThread()
+	orig, err := netns.Get()
+	if err != nil {
+		runtime.UnlockOSThread()
+		return &NamespaceError{PID: n.pid, Err: err}
+	}
+	defer orig.Close()
+	if err := netns.Set(n.ns); err != nil {
+		runtime.UnlockOSThread()
+		return &NamespaceError{PID: n.pid, Err: err}
+	}
+
+	var werr error
+	for _, v := range values {
+		path := "/proc/sys/" + strings.Replace(v.key, ".", "/", -1)
+		if err := ioutil.WriteFile(path, []byte(v.val), 0644); err != nil {
+			werr = &SysctlError{Key: v.key, PID: n.pid, Err: err}
+			break
+		}
+	}
+
+	if err := netns.Set(orig); err != nil {
+		// the thread stays locked so that it is terminated instead of being
+		// reused in the wrong namespace
+		return &NamespaceError{PID: 0, Err: err}
+	}
+	runtime.UnlockOSThread()
+	return werr
+}
+
+// createVethNL creates a veth pair a <-> b on the host
+func createVethNL(a, b string, mtu int) error {
+	veth := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{Name: a, MTU: mtu},
+		PeerName:  b,
+	}
+	if err := netlink.LinkAdd(veth); err != nil {
+		return &LinkError{Op: "add", Iface: a, Err: err}
+	}
+	if mtu > 0 {
+		peer, err := netlink.LinkByName(b)
+		if err != nil {
+			return &LinkError{Op: "mtu", Iface: b, Err: err}
+		}
+		if err := netlink.LinkSetMTU(peer, mtu); err != nil {
+			return &LinkError{Op: "mtu", Iface: b, Err: err}
+		}
+	}
+	return nil
+}
+
+// setUpNL activates an interface of the host
+func setUpNL(name string) error {
+	l, err := netlink.LinkByName(name)
+	if err == nil {
+		err = netlink.LinkSetUp(l)
+	}
+	if err != nil {
+		return &LinkError{Op: "up", Iface: name, Err: err}
+	}
+	return nil
+}
+
+// configureIfaceNL is the netlink version of configureIface
+func (c *OVSDockerClient) configureIfaceNL(port, ifName string, settings PortSettings) error {
+	n, err := openNetNS(c.PID)
+	if err != nil {
+		return err
+	}
+	defer n.Close()
+
+	// Move container side into container
+	l, err := netlink.LinkByName(port)
+	if err == nil {
+		err = netlink.LinkSetNsFd(l, int(n.ns))
+	}
+	if err != nil {
+		return &LinkError{Op: "move", Iface: port, PID: c.PID, Err: err}
+	}
+
+	// Change its name
+	if l, err = n.h.LinkByName(port); err == nil {
+		err = n.h.LinkSetName(l, ifName)
+	}
+	if err != nil {
+		return &LinkError{Op: "rename", Iface: port, PID: c.PID, Err: err}
+	}
+
+	// Activate container side
+	if err := n.h.LinkSetUp(l); err != nil {
+		return &LinkError{Op: "up", Iface: ifName, PID: c.PID, Err: err}
+	}
+
+	if sc := ifaceSysctls(ifName, settings); len(sc) > 0 {
+		if err := n.setSysctls(sc); err != nil {
+			return err
+		}
+	}
+
+	// Add a VRF in needed
+	if settings.VRF != "" {
+		vrf, err := n.h.LinkByName(settings.VRF)
+		if err != nil {
+			vrf = &netlink.Vrf{
+				LinkAttrs: netlink.LinkAttrs{Name: settings.VRF},
+				Table:     uint32(nextTableID()),
+			}
+			if err := n.h.LinkAdd(vrf); err != nil {
+				return &LinkError{Op: "vrf", Iface: settings.VRF, PID: c.PID, Err: err}
+			}
+			if vrf, err = n.h.LinkByName(settings.VRF); err != nil {
+				return &LinkError{Op: "vrf", Iface: settings.VRF, PID: c.PID, Err: err}
+			}
+		}
+		if err := n.h.LinkSetUp(vrf); err != nil {
+			return &LinkError{Op: "vrf", Iface: settings.VRF, PID: c.PID, Err: err}
+		}
+		if err := n.h.LinkSetMasterByIndex(l, vrf.Attrs().Index); err != nil {
+			return &LinkError{Op: "vrf", Iface: ifName, PID: c.PID, Err: err}
+		}
+	}
+
+	// Add IP if specified
+	if settings.IP != "" {
+		addr, err := netlink.ParseAddr(settings.IP)
+		if err == nil {
+			err = n.h.AddrAdd(l, addr)
+		}
+		if err != nil {
+			return &LinkError{Op: "addr", Iface: ifName, PID: c.PID, Err: err}
+		}
+	}
+
+	for _, route := range settings.Routes {
+		if err := n.addRoute(route); err != nil {
+			return &LinkError{Op: "route", Iface: route.IfName, PID: c.PID, Err: err}
+		}
+	}
+
+	return nil
+}
+
+func (n *netNS) addRoute(route IPRoute) error {
+	dev, err := n.h.LinkByName(route.IfName)
+	if err != nil {
+		return err
+	}
+	r := &netlink.Route{
+		LinkIndex: dev.Attrs().Index,
+		Gw:        net.ParseIP(route.Via),
+	}
+	if route.IP != "default" {
+		if _, r.Dst, err = net.ParseCIDR(route.IP); err != nil {
+			return err
+		}
+	}
+	return n.h.RouteAdd(r)
+}
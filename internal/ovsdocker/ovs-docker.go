@@ -2,18 +2,19 @@ package ovsdocker
 
 import (
 	"bytes"
-	"context"
-	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"strconv"
 	"strings"
+	"sync/atomic"
 
 	"github.com/docker/distribution/uuid"
+	"github.com/vishvananda/netlink"
 
-	"github.com/docker/docker/client"
 	"github.com/rahveiz/topomate/config"
+	"github.com/rahveiz/topomate/internal/journal"
+	"github.com/rahveiz/topomate/internal/runtime"
 	"github.com/rahveiz/topomate/utils"
 )
 
@@ -23,9 +24,13 @@ type PortSettings struct {
 	MTU    int
 	Speed  int
 	OFPort int
+	// MPLS enables the MPLS input on the interface (core interfaces of MPLS
+	// AS)
+	MPLS   bool
 	VRF    string
 	IP     string
 	Routes []IPRoute
+	Netem  Netem
 }
 
 type IPRoute struct {
@@ -47,6 +52,11 @@ type OVSInterface struct {
 	Bridge         string `json:"br"`
 	ContainerIface string `json:"c_if"`
 	Settings       PortSettings
+	// Backend used to create the interface, empty for OVS
+	Backend string `json:"backend,omitempty"`
+	// Peer is the other end (<container>:<interface>) of a direct veth
+	// pair, which has no host side
+	Peer string `json:"peer,omitempty"`
 }
 
 type OVSBulk map[string][]OVSInterface
@@ -58,84 +68,82 @@ func DefaultParams() PortSettings {
 	}
 }
 
-var nextTableID = 100
+// lastTableID is the last routing table allocated to a VRF
+var lastTableID int32 = 99
+
+// nextTableID returns a new routing table for a VRF. Containers are
+// configured concurrently, so the counter is updated atomically.
+func nextTableID() int {
+	return int(atomic.AddInt32(&lastTableID, 1))
+}
 
 func (c *OVSDockerClient) pidToStr() string {
 	return strconv.Itoa(c.PID)
 }
 
-func (c *OVSDockerClient) createNetNSLink() {
+func (c *OVSDockerClient) createNetNSLink() error {
 	var stderr bytes.Buffer
 	cmd := utils.ExecSudo("mkdir", "-p", "/var/run/netns")
 	cmd.Stderr = &stderr
-	err := cmd.Run()
-	// stderr.Reset()
-	if err != nil {
-		fmt.Fprintln(os.Stderr, string(stderr.Bytes()))
-		utils.Fatalln("createNetNS:", err)
-	}
-
-	if _, err = os.Stat(c.varPath); err != nil {
-		if os.IsNotExist(err) {
-			// os.Symlink(procPath, varPath)
-			cmd = utils.ExecSudo("ln", "-s", c.procPath, c.varPath)
-			cmd.Stderr = &stderr
-			_err := cmd.Run()
-			if _err != nil {
-				fmt.Fprintln(os.Stderr, string(stderr.Bytes()))
-				utils.Fatalln("createNetNS:", err)
-			}
-		} else {
-			utils.Fatalln("createNetNS:", err)
+	if err := utils.Run(cmd); err != nil {
+		return &CmdError{Wrapper: "createNetNS", Cmd: cmd.String(), Stderr: stderr.String(), Err: err}
+	}
+
+	if _, err := os.Stat(c.varPath); err != nil {
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("createNetNS: %w", err)
 		}
+		cmd = utils.ExecSudo("ln", "-s", c.procPath, c.varPath)
+		cmd.Stderr = &stderr
+		if err := utils.Run(cmd); err != nil {
+			return &CmdError{Wrapper: "createNetNS", Cmd: cmd.String(), Stderr: stderr.String(), Err: err}
+		}
+		journal.Record(journal.NetNS, c.varPath)
 	}
+	return nil
 }
 
+// deleteNetNSLink removes the namespace link, errors are only displayed as
+// the link is removed after the operations using it
 func (c *OVSDockerClient) deleteNetNSLink() {
 	var stderr bytes.Buffer
 	cmd := utils.ExecSudo("rm", "-f", c.varPath)
 	cmd.Stderr = &stderr
-	err := cmd.Run()
-	// stderr.Reset()
-	if err != nil {
-		fmt.Fprintln(os.Stderr, string(stderr.Bytes()))
-		utils.Fatalln("deleteNetNS:", err)
+	if err := utils.Run(cmd); err != nil {
+		utils.PrintError(&CmdError{Wrapper: "deleteNetNS", Cmd: cmd.String(), Stderr: stderr.String(), Err: err})
 	}
 }
 
 // PortExists checks if an interface with name ifName already exists in the container (from OVS)
-func (c *OVSDockerClient) PortExists(ifName string) bool {
-	var stdout bytes.Buffer
-	cmd := findInterface(c.ContainerName, ifName)
-	cmd.Stdout = &stdout
-	err := cmd.Run()
-	if err != nil {
-		utils.Fatalln("PortExists: ", err)
-	}
-	return stdout.Len() > 0
+func (c *OVSDockerClient) PortExists(ifName string) (bool, error) {
+	_, ok, err := c.FindPort(ifName)
+	return ok, err
 }
 
 // FindPort checks if an interface ifName exists within the container,
 // and returns the corresponding interface on the host side
-func (c *OVSDockerClient) FindPort(ifName string) (string, bool) {
+func (c *OVSDockerClient) FindPort(ifName string) (string, bool, error) {
 	var stdout bytes.Buffer
 	cmd := findInterface(c.ContainerName, ifName)
 	cmd.Stdout = &stdout
-	err := cmd.Run()
-	if err != nil {
-		utils.Fatalln("FindPort: ", err)
+	if err := utils.Run(cmd); err != nil {
+		return "", false, fmt.Errorf("FindPort: %w", err)
 	}
 	if stdout.Len() > 0 {
-		return strings.TrimSuffix(string(stdout.Bytes()), "\n"), true
+		return strings.TrimSuffix(string(stdout.Bytes()), "\n"), true, nil
 	}
-	return "", false
+	return "", false, nil
 }
 
 // New returns an OVSDockerClient based on the container name.
 // It fetches the matching PID and generates an UUID for future use
-func New(containerName string) *OVSDockerClient {
+func New(containerName string) (*OVSDockerClient, error) {
+	pid, err := getPID(containerName)
+	if err != nil {
+		return nil, err
+	}
 	c := &OVSDockerClient{
-		PID:           getPID(containerName),
+		PID:           pid,
 		ContainerName: containerName,
 	}
 	id := uuid.Generate().String()
@@ -144,7 +152,7 @@ func New(containerName string) *OVSDockerClient {
 	c.procPath = fmt.Sprintf("/proc/%d/ns/net", c.PID)
 	c.varPath = fmt.Sprintf("/var/run/netns/%d", c.PID)
 
-	return c
+	return c, nil
 }
 
 // PortnameHost returns the portname suffixed by "_l"
@@ -178,16 +186,17 @@ func findInterface(containerName, ifName string) *exec.Cmd {
 	)
 }
 
-func GetOFPort(containerName, ifName string) (string, bool) {
+// GetOFPort returns the OpenFlow port of the interface ifName of a
+// container, false if it has no OVS port
+func GetOFPort(containerName, ifName string) (string, bool, error) {
 	var stdout, stderr bytes.Buffer
 	cmd := findInterface(containerName, ifName)
 	cmd.Stdout = &stdout
-	err := cmd.Run()
-	if err != nil {
-		utils.Fatalln("GetOFPort: ", err)
+	if err := utils.Run(cmd); err != nil {
+		return "", false, fmt.Errorf("GetOFPort: %w", err)
 	}
 	if stdout.Len() == 0 {
-		return "", false
+		return "", false, nil
 	}
 	ifID := strings.TrimSuffix(string(stdout.Bytes()), "\n")
 	stdout.Reset()
@@ -198,11 +207,10 @@ func GetOFPort(containerName, ifName string) (string, bool) {
 	)
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
-	err = cmd.Run()
-	if err != nil {
-		utils.Fatalln("GetOFPort:", string(stderr.Bytes()), err)
+	if err := utils.Run(cmd); err != nil {
+		return "", false, &CmdError{Wrapper: "GetOFPort", Cmd: cmd.String(), Stderr: stderr.String(), Err: err}
 	}
-	return strings.TrimSuffix(string(stdout.Bytes()), "\n"), true
+	return strings.TrimSuffix(string(stdout.Bytes()), "\n"), true, nil
 
 }
 
@@ -210,23 +218,42 @@ func GetOFPort(containerName, ifName string) (string, bool) {
 // If hostIf in not nil, it fills the struct fields. If bridge is set to false,
 // the host part is not added to the OVS bridge
 func (c *OVSDockerClient) AddPort(brName, ifName string, settings PortSettings, hostIf *OVSInterface, bridge bool) error {
-	if _, ok := c.FindPort(ifName); ok {
-		return fmt.Errorf("AddPort: interface %s already exists in container %s", ifName, c.ContainerName)
+	if _, ok, err := c.FindPort(ifName); err != nil {
+		return err
+	} else if ok {
+		return &LinkError{Op: "add", Iface: ifName, PID: c.PID, Err: ErrIfaceExists}
 	}
+	var attach func(string) error
+	if bridge {
+		attach = func(string) error {
+			// Add the host end of the veth to an OVS bridge
+			return c.addToBridge(brName, ifName, settings.Speed, settings.OFPort)
+		}
+	}
+	return c.AddPortWith(brName, ifName, settings, hostIf, attach)
+}
 
-	c.createNetNSLink()
-	defer c.deleteNetNSLink()
-
-	// Create VEth pair
-	if err := c.createVEth(); err != nil {
-		return err
+// AddPortWith adds a port to the container. The host side of the veth pair
+// is connected using attach (if not nil), brName is only saved in hostIf.
+func (c *OVSDockerClient) AddPortWith(brName, ifName string, settings PortSettings, hostIf *OVSInterface, attach func(hostIface string) error) error {
+	nl := useNetlink()
+	if !nl {
+		if err := c.createNetNSLink(); err != nil {
+			return err
+		}
+		defer c.deleteNetNSLink()
 	}
 
 	portHost, portCont := c.IfNames()
 
-	if bridge {
-		// Add the host end of the veth to an OVS bridge
-		if err := c.addToBridge(brName, ifName, settings.Speed, settings.OFPort); err != nil {
+	// Create VEth pair and set the MTU on both ends
+	if err := createVeth(nl, portHost, portCont, settings.MTU); err != nil {
+		return err
+	}
+	journal.Record(journal.Iface, portHost)
+
+	if attach != nil {
+		if err := attach(portHost); err != nil {
 			return err
 		}
 	}
@@ -240,57 +267,147 @@ func (c *OVSDockerClient) AddPort(brName, ifName string, settings PortSettings,
 	}
 
 	// Activate host side
-	if err := ExecLink("set", portHost, "up"); err != nil {
+	if nl {
+		if err := setUpNL(portHost); err != nil {
+			return err
+		}
+	} else if err := ExecLink("set", portHost, "up"); err != nil {
 		return err
 	}
 
-	// Move container side into container
-	if err := ExecLink("set", portCont, "netns", c.pidToStr()); err != nil {
-		return err
+	// Emulate link conditions if needed
+	if !settings.Netem.IsZero() {
+		if err := ApplyNetem(portHost, settings.Netem); err != nil {
+			return err
+		}
 	}
 
-	// Change its name
-	if err := c.ExecNS("ip", "link", "set", "dev", portCont, "name", ifName); err != nil {
+	return c.configureIface(nl, portCont, ifName, settings)
+}
+
+// ConnectVeth links two containers with a veth pair, without any interface
+// on the host. The link conditions are emulated on both container
+// interfaces, as there is no host side.
+func ConnectVeth(a, b *OVSDockerClient, ifA, ifB string, settingsA, settingsB PortSettings) error {
+	nl := useNetlink()
+	// the namespace links are needed by the commands, and by tc for netem
+	if !nl || !settingsA.Netem.IsZero() {
+		if err := a.createNetNSLink(); err != nil {
+			return err
+		}
+		defer a.deleteNetNSLink()
+	}
+	if b.PID != a.PID && (!nl || !settingsB.Netem.IsZero()) {
+		if err := b.createNetNSLink(); err != nil {
+			return err
+		}
+		defer b.deleteNetNSLink()
+	}
+
+	portA, portB := a.PortnameContainer(), b.PortnameContainer()
+	if err := createVeth(nl, portA, portB, 0); err != nil {
 		return err
 	}
+	journal.Record(journal.Iface, portA)
+	for _, v := range []struct {
+		c        *OVSDockerClient
+		port     string
+		ifName   string
+		settings PortSettings
+	}{{a, portA, ifA, settingsA}, {b, portB, ifB, settingsB}} {
+		if err := setMTU(nl, v.port, v.settings.MTU); err != nil {
+			return err
+		}
+		if err := v.c.configureIface(nl, v.port, v.ifName, v.settings); err != nil {
+			return err
+		}
+		if !v.settings.Netem.IsZero() {
+			if err := v.c.ApplyNetemNS(v.ifName, v.settings.Netem); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
 
-	// Activate container side
-	if err := c.ExecNS("ip", "link", "set", ifName, "up"); err != nil {
+// createVeth creates a veth pair a <-> b on the host, with the MTU mtu on
+// both ends if not 0
+func createVeth(nl bool, a, b string, mtu int) error {
+	if nl {
+		return createVethNL(a, b, mtu)
+	}
+	if err := ExecLink("add", a, "type", "veth", "peer", "name", b); err != nil {
 		return err
 	}
+	if err := setMTU(false, a, mtu); err != nil {
+		return err
+	}
+	return setMTU(false, b, mtu)
+}
 
-	// Enable IPV6
+// setMTU sets the MTU of an interface of the host if mtu is not 0
+func setMTU(nl bool, name string, mtu int) error {
+	if mtu <= 0 {
+		return nil
+	}
+	if !nl {
+		return ExecLink("set", name, "mtu", strconv.Itoa(mtu))
+	}
+	l, err := netlink.LinkByName(name)
+	if err == nil {
+		err = netlink.LinkSetMTU(l, mtu)
+	}
+	if err != nil {
+		return &LinkError{Op: "mtu", Iface: name, Err: err}
+	}
+	return nil
+}
 
-	if err := c.ExecNS("sysctl", "-w", "net.ipv6.conf.all.forwarding=1"); err != nil {
-		return err
+// configureIface moves the interface port into the container namespace,
+// renames it ifName and configures it. Without netlink, the namespace link
+// must exist.
+func (c *OVSDockerClient) configureIface(nl bool, port, ifName string, settings PortSettings) error {
+	if nl {
+		return c.configureIfaceNL(port, ifName, settings)
 	}
 
-	// Enable MPLS
-	if err := c.ExecNS("sysctl", "-w", "net.mpls.conf."+ifName+".input=1"); err != nil {
+	// Move container side into container
+	if err := ExecLink("set", port, "netns", c.pidToStr()); err != nil {
 		return err
 	}
 
-	if err := c.ExecNS("sysctl", "-w", "net.mpls.platform_labels="+strconv.Itoa(MPLSMAXLabels)); err != nil {
+	// Change its name
+	if err := c.ExecNS("ip", "link", "set", "dev", port, "name", ifName); err != nil {
 		return err
 	}
 
-	// Enable BGP VPN support
-	if err := c.ExecNS("sysctl", "-w", "net.ipv4.tcp_l3mdev_accept=1"); err != nil {
+	// Activate container side
+	if err := c.ExecNS("ip", "link", "set", ifName, "up"); err != nil {
 		return err
 	}
-	if err := c.ExecNS("sysctl", "-w", "net.ipv4.udp_l3mdev_accept=1"); err != nil {
-		return err
+
+	for _, v := range ifaceSysctls(ifName, settings) {
+		if err := c.SysctlSet(v.key, v.val); err != nil {
+			return err
+		}
 	}
 
 	// Add a VRF in needed
 	if settings.VRF != "" {
-		c.ExecNS("ip", "link", "add", settings.VRF, "type", "vrf", "table", strconv.Itoa(nextTableID))
-		c.ExecNS("ip", "link", "set", settings.VRF, "up")
+		// The VRF is shared by the interfaces of the container, its table is
+		// allocated when it is created
+		if c.ExecNS("ip", "link", "show", settings.VRF) != nil {
+			if err := c.ExecNS("ip", "link", "add", settings.VRF, "type", "vrf", "table", strconv.Itoa(nextTableID())); err != nil {
+				return err
+			}
+		}
+		if err := c.ExecNS("ip", "link", "set", settings.VRF, "up"); err != nil {
+			return err
+		}
 
 		if err := c.ExecNS("ip", "link", "set", ifName, "vrf", settings.VRF); err != nil {
 			return err
 		}
-		nextTableID++
 	}
 
 	// Add IP if specified
@@ -315,19 +432,15 @@ func (c *OVSDockerClient) AddPort(brName, ifName string, settings PortSettings,
 }
 
 // DeletePort deletes a port from a container
-func (c *OVSDockerClient) DeletePort(ifName string) {
-	port, ok := c.FindPort(ifName)
-	if !ok {
-		return
-	}
-	err := utils.ExecSudo("ovs-vsctl", "if-exists", "del-port", port).Run()
-	if err != nil {
-		utils.Fatalln("DeletePort:", err)
+func (c *OVSDockerClient) DeletePort(ifName string) error {
+	port, ok, err := c.FindPort(ifName)
+	if err != nil || !ok {
+		return err
 	}
-
-	if err := ExecLink("delete", port); err != nil {
-		utils.Fatalln(err)
+	if err := utils.Run(utils.ExecSudo("ovs-vsctl", "if-exists", "del-port", port)); err != nil {
+		return fmt.Errorf("DeletePort: %w", err)
 	}
+	return ExecLink("delete", port)
 }
 
 // ExecNS is a wrapper around the "ip netns exec <PID>" command (with PID auto-filled)
@@ -340,13 +453,20 @@ func (c *OVSDockerClient) ExecNS(args ...string) error {
 	if config.VFlag {
 		fmt.Println(cmd.String())
 	}
-	err := cmd.Run()
+	err := utils.Run(cmd)
 	if err != nil {
-		return fmt.Errorf("ExecNS: %s\n%s%s", cmd.String(), string(stderr.Bytes()), err)
+		return &CmdError{Wrapper: "ExecNS", Cmd: cmd.String(), Stderr: stderr.String(), Err: err}
 	}
 	return nil
 }
 
+// NSCommand returns a command executed inside the container network
+// namespace, its outputs are set by the caller. The namespace must have been
+// linked (see WithNetNS).
+func (c *OVSDockerClient) NSCommand(args ...string) *exec.Cmd {
+	return utils.ExecSudo(append([]string{"ip", "netns", "exec", c.pidToStr()}, args...)...)
+}
+
 // SysctlSet executes a syswtl write inside the container network namespace
 func (c *OVSDockerClient) SysctlSet(key, val string) error {
 	if err := c.ExecNS("sysctl", "-w", key+"="+val); err != nil {
@@ -365,18 +485,13 @@ func ExecLink(args ...string) error {
 	if config.VFlag {
 		fmt.Println(cmd.String())
 	}
-	err := cmd.Run()
+	err := utils.Run(cmd)
 	if err != nil {
-		return fmt.Errorf("ExecLink: %s\n%s%s", cmd.String(), string(stderr.Bytes()), err)
+		return &CmdError{Wrapper: "ExecLink", Cmd: cmd.String(), Stderr: stderr.String(), Err: err}
 	}
 	return nil
 }
 
-func (c *OVSDockerClient) createVEth() error {
-	host, cont := c.IfNames()
-	return ExecLink("add", host, "type", "veth", "peer", "name", cont)
-}
-
 func (c *OVSDockerClient) addToBridge(brName, ifName string, speed int, ofport int) error {
 	var stderr bytes.Buffer
 	host := c.PortnameHost()
@@ -392,58 +507,26 @@ func (c *OVSDockerClient) addToBridge(brName, ifName string, speed int, ofport i
 	}
 	cmd := utils.ExecSudo(cmdArgs...)
 	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		return errors.New(string(stderr.Bytes()))
+	if err := utils.Run(cmd); err != nil {
+		return &CmdError{Wrapper: "addToBridge", Cmd: cmd.String(), Stderr: stderr.String(), Err: err}
 	}
 	return nil
 }
 
-func AddToBridgeBulk(elements map[string][]OVSInterface) error {
-	var stderr bytes.Buffer
-	size := 0
-	for _, v := range elements {
-		size += len(v)
-	}
-	if size == 0 {
-		return nil
-	}
-
-	cmdArgs := make([]string, 1, 16*size)
-	cmdArgs[0] = "ovs-vsctl"
-	for k, v := range elements {
-		for _, e := range v {
-			cmdArgs = append(cmdArgs,
-				"--", "add-port", e.Bridge, e.HostIface,
-				"--", "set", "interface", e.HostIface,
-				"external_ids:container_id="+k,
-				"external_ids:container_iface="+e.ContainerIface,
-				"ingress_policing_rate="+strconv.Itoa(e.Settings.Speed*1000),
-				"ofport_request="+strconv.Itoa(e.Settings.OFPort),
-			)
-		}
-	}
-	cmd := utils.ExecSudo(cmdArgs...)
-	cmd.Stderr = &stderr
-	if config.VFlag {
-		fmt.Println(cmd.String())
-	}
-	if err := cmd.Run(); err != nil {
-		return errors.New(string(stderr.Bytes()))
-	}
-	return nil
-}
-
-func getPID(containerName string) int {
-	ctx := context.Background()
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+func getPID(containerName string) (int, error) {
+	pid, err := runtime.Current().PID(containerName)
 	if err != nil {
-		utils.Fatalln("ovsdocker (getPID):", err)
+		return 0, fmt.Errorf("ovsdocker (getPID): %w", err)
 	}
+	return pid, nil
+}
 
-	res, err := cli.ContainerInspect(ctx, containerName)
-	if err != nil {
-		utils.Fatalln("ovsdocker (getPID):", err)
+// WithNetNS runs fn while the namespace of the container is available for
+// ExecNS
+func (c *OVSDockerClient) WithNetNS(fn func() error) error {
+	if err := c.createNetNSLink(); err != nil {
+		return err
 	}
-
-	return res.State.Pid
+	defer c.deleteNetNSLink()
+	return fn()
 }
@@ -0,0 +1,167 @@
+package ovsdocker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rahveiz/topomate/internal/runtime"
+	"github.com/rahveiz/topomate/utils"
+)
+
+// Counters are the statistics of an interface, as seen by the system it
+// belongs to
+type Counters struct {
+	RxBytes   uint64 `json:"rx_bytes"`
+	TxBytes   uint64 `json:"tx_bytes"`
+	RxPackets uint64 `json:"rx_packets"`
+	TxPackets uint64 `json:"tx_packets"`
+	RxDropped uint64 `json:"rx_dropped"`
+	TxDropped uint64 `json:"tx_dropped"`
+	RxErrors  uint64 `json:"rx_errors"`
+	TxErrors  uint64 `json:"tx_errors"`
+}
+
+// counterNames are the names of the counters in sysfs and in the OVS
+// statistics column, in the order of fields
+var counterNames = []string{
+	"rx_bytes", "tx_bytes", "rx_packets", "tx_packets",
+	"rx_dropped", "tx_dropped", "rx_errors", "tx_errors",
+}
+
+func (c *Counters) fields() []*uint64 {
+	return []*uint64{
+		&c.RxBytes, &c.TxBytes, &c.RxPackets, &c.TxPackets,
+		&c.RxDropped, &c.TxDropped, &c.RxErrors, &c.TxErrors,
+	}
+}
+
+// Reverse returns the counters seen from the other end of a veth pair
+func (c Counters) Reverse() Counters {
+	return Counters{
+		RxBytes:   c.TxBytes,
+		TxBytes:   c.RxBytes,
+		RxPackets: c.TxPackets,
+		TxPackets: c.RxPackets,
+		RxDropped: c.TxDropped,
+		TxDropped: c.RxDropped,
+		RxErrors:  c.TxErrors,
+		TxErrors:  c.RxErrors,
+	}
+}
+
+func statisticsPaths(ifName string) []string {
+	res := make([]string, len(counterNames))
+	for i, n := range counterNames {
+		res[i] = filepath.Join("/sys/class/net", ifName, "statistics", n)
+	}
+	return res
+}
+
+// parseCounters parses the values of the counters, one per line
+func parseCounters(lines []string) (Counters, error) {
+	var c Counters
+	fields := c.fields()
+	if len(lines) < len(fields) {
+		return c, fmt.Errorf("expected %d counters, got %d", len(fields), len(lines))
+	}
+	for i, f := range fields {
+		v, err := strconv.ParseUint(strings.TrimSpace(lines[i]), 10, 64)
+		if err != nil {
+			return c, err
+		}
+		*f = v
+	}
+	return c, nil
+}
+
+// ReadCounters reads the statistics of a host interface from sysfs
+func ReadCounters(ifName string) (Counters, error) {
+	paths := statisticsPaths(ifName)
+	lines := make([]string, len(paths))
+	for i, p := range paths {
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return Counters{}, err
+		}
+		lines[i] = string(b)
+	}
+	return parseCounters(lines)
+}
+
+// ReadContainerCounters reads the statistics of an interface inside a
+// container with a single exec
+func ReadContainerCounters(container, ifName string) (Counters, error) {
+	out, code, err := runtime.Exec(container, append([]string{"cat"}, statisticsPaths(ifName)...)...)
+	if err != nil {
+		return Counters{}, err
+	}
+	if code != 0 {
+		return Counters{}, fmt.Errorf("%s: %s(exit status %d)", container, out, code)
+	}
+	return parseCounters(strings.Fields(out))
+}
+
+// ListPortStats returns the statistics of the OVS interfaces created for
+// containers, indexed by PortKey, with a single ovs-vsctl call
+func ListPortStats() (map[string]Counters, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := utils.ExecSudo("ovs-vsctl", "--format=json",
+		"--columns=statistics,external_ids", "list", "Interface")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := utils.Run(cmd); err != nil {
+		return nil, &CmdError{Wrapper: "ListPortStats", Cmd: cmd.String(), Stderr: stderr.String(), Err: err}
+	}
+
+	res := make(map[string]Counters, 64)
+	if stdout.Len() == 0 {
+		// dry-run
+		return res, nil
+	}
+	var out ovsdbResult
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return nil, fmt.Errorf("ListPortStats: %v", err)
+	}
+	for _, row := range out.Data {
+		if len(row) != 2 {
+			continue
+		}
+		ids := ovsdbMap(row[1])
+		if ids["container_id"] == "" {
+			continue
+		}
+		stats := ovsdbIntMap(row[0])
+		var c Counters
+		for i, f := range c.fields() {
+			*f = stats[counterNames[i]]
+		}
+		res[PortKey(ids["container_id"], ids["container_iface"])] = c
+	}
+	return res, nil
+}
+
+// ovsdbIntMap decodes an OVSDB map with integer values
+func ovsdbIntMap(raw json.RawMessage) map[string]uint64 {
+	var m []json.RawMessage
+	if err := json.Unmarshal(raw, &m); err != nil || len(m) != 2 {
+		return nil
+	}
+	var pairs [][2]json.RawMessage
+	if err := json.Unmarshal(m[1], &pairs); err != nil {
+		return nil
+	}
+	res := make(map[string]uint64, len(pairs))
+	for _, p := range pairs {
+		var k string
+		var v uint64
+		if json.Unmarshal(p[0], &k) == nil && json.Unmarshal(p[1], &v) == nil {
+			res[k] = v
+		}
+	}
+	return res
+}
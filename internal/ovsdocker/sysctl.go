@@ -0,0 +1,66 @@
+package ovsdocker
+
+import (
+	"sort"
+	"strconv"
+)
+
+type sysctl struct {
+	key string
+	val string
+}
+
+// NamespaceSysctls returns the sysctls of the namespace of a router, set
+// when its container is created and each time it is started. MPLS labels are
+// only enabled if mpls is true, as it needs the mpls_router module on the
+// host.
+func NamespaceSysctls(mpls bool) map[string]string {
+	res := map[string]string{
+		// Enable IPV6
+		"net.ipv6.conf.all.forwarding": "1",
+		// Enable BGP VPN support
+		"net.ipv4.tcp_l3mdev_accept": "1",
+		"net.ipv4.udp_l3mdev_accept": "1",
+	}
+	if mpls {
+		res["net.mpls.platform_labels"] = strconv.Itoa(MPLSMAXLabels)
+	}
+	return res
+}
+
+// SetSysctls sets sysctls in the namespace of the container
+func (c *OVSDockerClient) SetSysctls(values map[string]string) error {
+	list := make([]sysctl, 0, len(values))
+	for k, v := range values {
+		list = append(list, sysctl{k, v})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].key < list[j].key })
+
+	if useNetlink() {
+		n, err := openNetNS(c.PID)
+		if err != nil {
+			return err
+		}
+		defer n.Close()
+		return n.setSysctls(list)
+	}
+	return c.WithNetNS(func() error {
+		for _, v := range list {
+			if err := c.SysctlSet(v.key, v.val); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ifaceSysctls returns the sysctls set when an interface is added
+func ifaceSysctls(ifName string, settings PortSettings) []sysctl {
+	if !settings.MPLS {
+		return nil
+	}
+	return []sysctl{
+		// Enable MPLS
+		{"net.mpls.conf." + ifName + ".input", "1"},
+	}
+}
@@ -0,0 +1,263 @@
+package ovsdocker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rahveiz/topomate/config"
+	"github.com/rahveiz/topomate/internal/journal"
+	"github.com/rahveiz/topomate/utils"
+)
+
+// PortInfo describes an OVS interface created for a container
+type PortInfo struct {
+	Name   string
+	OFPort int
+}
+
+// PortKey returns the key of an interface of a container in the map
+// returned by ListPorts
+func PortKey(container, ifName string) string {
+	return container + ":" + ifName
+}
+
+// ovsdbResult is the JSON output of ovs-vsctl
+type ovsdbResult struct {
+	Data [][]json.RawMessage `json:"data"`
+}
+
+// ListPorts returns the OVS interfaces created for containers, indexed by
+// PortKey, with a single ovs-vsctl call
+func ListPorts() (map[string]PortInfo, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := utils.ExecSudo("ovs-vsctl", "--format=json",
+		"--columns=name,ofport,external_ids", "list", "Interface")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := utils.Run(cmd); err != nil {
+		return nil, &CmdError{Wrapper: "ListPorts", Cmd: cmd.String(), Stderr: stderr.String(), Err: err}
+	}
+
+	res := make(map[string]PortInfo, 64)
+	if stdout.Len() == 0 {
+		// dry-run
+		return res, nil
+	}
+	var out ovsdbResult
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return nil, fmt.Errorf("ListPorts: %v", err)
+	}
+	for _, row := range out.Data {
+		if len(row) != 3 {
+			continue
+		}
+		var info PortInfo
+		if err := json.Unmarshal(row[0], &info.Name); err != nil {
+			continue
+		}
+		// ofport is an empty set if it is not allocated yet
+		json.Unmarshal(row[1], &info.OFPort)
+		ids := ovsdbMap(row[2])
+		if ids["container_id"] != "" {
+			res[PortKey(ids["container_id"], ids["container_iface"])] = info
+		}
+	}
+	return res, nil
+}
+
+// ovsdbMap decodes an OVSDB map (["map",[[k,v],...]])
+func ovsdbMap(raw json.RawMessage) map[string]string {
+	var m []json.RawMessage
+	if err := json.Unmarshal(raw, &m); err != nil || len(m) != 2 {
+		return nil
+	}
+	var pairs [][2]string
+	if err := json.Unmarshal(m[1], &pairs); err != nil {
+		return nil
+	}
+	res := make(map[string]string, len(pairs))
+	for _, p := range pairs {
+		res[p[0]] = p[1]
+	}
+	return res
+}
+
+type txnPort struct {
+	container string
+	iface     OVSInterface
+}
+
+type txnFlow struct {
+	a, b string
+}
+
+// Txn batches OVS changes. The bridges and ports are created in a single
+// ovs-vsctl call (one OVSDB transaction), and the flows of each bridge are
+// added with a single ovs-ofctl call once the OpenFlow ports are known.
+type Txn struct {
+	mu      sync.Mutex
+	bridges []string
+	ports   []txnPort
+	flows   map[string][]txnFlow
+	order   []string // bridges with flows, in insertion order
+}
+
+// NewTxn returns an empty transaction
+func NewTxn() *Txn {
+	return &Txn{flows: make(map[string][]txnFlow, 8)}
+}
+
+// AddBridge adds the bridge name if it does not exist
+func (t *Txn) AddBridge(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, br := range t.bridges {
+		if br == name {
+			return
+		}
+	}
+	t.bridges = append(t.bridges, name)
+}
+
+// AddPort adds the host interface of i to its bridge
+func (t *Txn) AddPort(container string, i OVSInterface) {
+	t.mu.Lock()
+	t.ports = append(t.ports, txnPort{container: container, iface: i})
+	t.mu.Unlock()
+}
+
+// AddFlow forwards the traffic between two ports of the bridge br,
+// designated by their PortKey
+func (t *Txn) AddFlow(br, a, b string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.flows[br]; !ok {
+		t.order = append(t.order, br)
+	}
+	t.flows[br] = append(t.flows[br], txnFlow{a: a, b: b})
+}
+
+// vsctlArgs returns the ovs-vsctl commands of the transaction
+func (t *Txn) vsctlArgs() []string {
+	args := make([]string, 0, 4*len(t.bridges)+16*len(t.ports))
+	for _, br := range t.bridges {
+		args = append(args, "--", "--may-exist", "add-br", br)
+	}
+	for _, p := range t.ports {
+		name := p.iface.HostIface
+		args = append(args,
+			"--", "--may-exist", "add-port", p.iface.Bridge, name,
+			"--", "set", "interface", name,
+			"external_ids:container_id="+p.container,
+			"external_ids:container_iface="+p.iface.ContainerIface,
+			"ingress_policing_rate="+strconv.Itoa(p.iface.Settings.Speed*1000),
+		)
+		if p.iface.Settings.OFPort > 0 {
+			args = append(args, "ofport_request="+strconv.Itoa(p.iface.Settings.OFPort))
+		}
+	}
+	return args
+}
+
+// newBridges returns the bridges of the transaction which do not exist
+func (t *Txn) newBridges() []string {
+	out, err := utils.Output(utils.ExecSudo("ovs-vsctl", "list-br"))
+	if err != nil {
+		return t.bridges
+	}
+	existing := make(map[string]bool, 16)
+	for _, br := range strings.Fields(string(out)) {
+		existing[br] = true
+	}
+	res := make([]string, 0, len(t.bridges))
+	for _, br := range t.bridges {
+		if !existing[br] {
+			res = append(res, br)
+		}
+	}
+	return res
+}
+
+// Commit applies the changes of the transaction, which is then empty
+func (t *Txn) Commit() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.bridges)+len(t.ports) > 0 {
+		var created []string
+		if journal.Enabled() {
+			created = t.newBridges()
+		}
+		var stderr bytes.Buffer
+		cmd := utils.ExecSudo(append([]string{"ovs-vsctl"}, t.vsctlArgs()...)...)
+		cmd.Stderr = &stderr
+		if config.VFlag {
+			fmt.Println(cmd.String())
+		}
+		if err := utils.Run(cmd); err != nil {
+			return &CmdError{Wrapper: "Commit", Cmd: "ovs-vsctl", Stderr: stderr.String(), Err: err}
+		}
+		for _, br := range created {
+			journal.Record(journal.OVSBridge, br)
+		}
+	}
+
+	if len(t.order) > 0 {
+		if err := t.commitFlows(); err != nil {
+			return err
+		}
+	}
+
+	t.bridges, t.ports = nil, nil
+	t.flows, t.order = make(map[string][]txnFlow, 8), nil
+	return nil
+}
+
+// commitFlows adds the flows of each bridge, the OpenFlow ports being read
+// in bulk
+func (t *Txn) commitFlows() error {
+	ports, err := ListPorts()
+	if err != nil {
+		return err
+	}
+	ofport := func(key string) (string, error) {
+		if p, ok := ports[key]; ok && p.OFPort > 0 {
+			return strconv.Itoa(p.OFPort), nil
+		}
+		if utils.DryRun() {
+			return "<" + key + ">", nil
+		}
+		return "", fmt.Errorf("no OpenFlow port for %s", key)
+	}
+
+	for _, br := range t.order {
+		var flows bytes.Buffer
+		for _, f := range t.flows[br] {
+			a, err := ofport(f.a)
+			if err != nil {
+				return err
+			}
+			b, err := ofport(f.b)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(&flows, "in_port=%s,actions=output:%s\n", a, b)
+			fmt.Fprintf(&flows, "in_port=%s,actions=output:%s\n", b, a)
+		}
+		var stderr bytes.Buffer
+		cmd := utils.ExecSudo("ovs-ofctl", "add-flows", br, "-")
+		cmd.Stdin = &flows
+		cmd.Stderr = &stderr
+		if config.VFlag {
+			fmt.Println(cmd.String())
+		}
+		if err := utils.Run(cmd); err != nil {
+			return &CmdError{Wrapper: "Commit", Cmd: cmd.String(), Stderr: stderr.String(), Err: err}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package runtime
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/rahveiz/topomate/utils"
+)
+
+// apiRuntime uses the Docker API (dockerd or the podman compatible socket).
+// Commands and copies use the CLI of the engine.
+type apiRuntime struct {
+	engine  string
+	cli     string
+	cliArgs []string
+	host    string
+}
+
+func (r *apiRuntime) Name() string {
+	return r.engine
+}
+
+func (r *apiRuntime) client() (utils.DockerAPI, error) {
+	return utils.DockerClient(r.host)
+}
+
+func (r *apiRuntime) Pull(image string) error {
+	cli, err := r.client()
+	if err != nil {
+		return err
+	}
+	out, err := cli.ImagePull(context.Background(), image, types.ImagePullOptions{})
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	// the image is pulled while the progress is read
+	_, err = ioutil.ReadAll(out)
+	return err
+}
+
+func (r *apiRuntime) Exists(name string) (bool, error) {
+	cli, err := r.client()
+	if err != nil {
+		return false, err
+	}
+	li, err := cli.ContainerList(context.Background(), types.ContainerListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("name", name)),
+	})
+	if err != nil {
+		return false, err
+	}
+	// the name filter matches substrings
+	for _, c := range li {
+		for _, n := range c.Names {
+			if strings.TrimPrefix(n, "/") == name {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func (r *apiRuntime) Create(s Spec) error {
+	cli, err := r.client()
+	if err != nil {
+		return err
+	}
+	_, err = cli.ContainerCreate(context.Background(), &container.Config{
+		Image:           s.Image,
+		Hostname:        s.Hostname,
+		Cmd:             s.Cmd,
+		Labels:          managedLabels(s),
+		NetworkDisabled: true, // networking is managed by topomate
+	}, &container.HostConfig{
+		CapAdd:  s.CapAdd,
+		Sysctls: s.Sysctls,
+	}, nil, nil, s.Name)
+	return err
+}
+
+func (r *apiRuntime) Start(name string) error {
+	cli, err := r.client()
+	if err != nil {
+		return err
+	}
+	return cli.ContainerStart(context.Background(), name, types.ContainerStartOptions{})
+}
+
+func (r *apiRuntime) Stop(name string) error {
+	cli, err := r.client()
+	if err != nil {
+		return err
+	}
+	return cli.ContainerStop(context.Background(), name, nil)
+}
+
+func (r *apiRuntime) Restart(name string) error {
+	cli, err := r.client()
+	if err != nil {
+		return err
+	}
+	return cli.ContainerRestart(context.Background(), name, nil)
+}
+
+func (r *apiRuntime) Remove(name string) error {
+	cli, err := r.client()
+	if err != nil {
+		return err
+	}
+	return cli.ContainerRemove(context.Background(), name, types.ContainerRemoveOptions{Force: true})
+}
+
+func (r *apiRuntime) Exec(name string, cmd ...string) (string, int, error) {
+	return cliExec(r.cli, r.cliArgs, name, cmd...)
+}
+
+func (r *apiRuntime) CopyTo(name, src, dst string) error {
+	return cliCopyTo(r.cli, r.cliArgs, name, src, dst)
+}
+
+func (r *apiRuntime) CopyFrom(name, src, dst string) error {
+	return cliCopyFrom(r.cli, r.cliArgs, name, src, dst)
+}
+
+func (r *apiRuntime) PID(name string) (int, error) {
+	cli, err := r.client()
+	if err != nil {
+		return 0, err
+	}
+	res, err := cli.ContainerInspect(context.Background(), name)
+	if err != nil {
+		return 0, err
+	}
+	return res.State.Pid, nil
+}
+
+func (r *apiRuntime) List(labels map[string]string) ([]Info, error) {
+	cli, err := r.client()
+	if err != nil {
+		return nil, err
+	}
+	flt := filters.NewArgs()
+	for k, v := range labels {
+		flt.Add("label", k+"="+v)
+	}
+	li, err := cli.ContainerList(context.Background(), types.ContainerListOptions{
+		All:     true,
+		Filters: flt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := make([]Info, 0, len(li))
+	for _, c := range li {
+		info := Info{
+			ID:      c.ID,
+			Image:   c.Image,
+			State:   c.State,
+			Running: c.State == "running",
+			Labels:  c.Labels,
+		}
+		if len(c.Names) > 0 {
+			info.Name = strings.TrimPrefix(c.Names[0], "/")
+		}
+		res = append(res, info)
+	}
+	return res, nil
+}
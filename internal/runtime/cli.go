@@ -0,0 +1,194 @@
+package runtime
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rahveiz/topomate/utils"
+)
+
+// cliRuntime uses a Docker-compatible CLI: podman (libpod) or nerdctl
+// (containerd)
+type cliRuntime struct {
+	engine  string
+	cli     string
+	cliArgs []string
+}
+
+func (r *cliRuntime) Name() string {
+	return r.engine
+}
+
+// output runs a command of the CLI and returns its standard output
+func (r *cliRuntime) output(args ...string) (string, error) {
+	out, code, err := runOutput(command(r.cli, r.cliArgs, args...))
+	if err == nil && code != 0 {
+		err = &cliError{r.cli, args, out, code}
+	}
+	return strings.TrimSpace(out), err
+}
+
+func (r *cliRuntime) Pull(image string) error {
+	_, err := r.output("pull", "-q", image)
+	return err
+}
+
+func (r *cliRuntime) Exists(name string) (bool, error) {
+	out, err := r.output("ps", "-a", "--filter", "name="+name, "--format", "{{.Names}}")
+	if err != nil {
+		return false, err
+	}
+	// the name filter matches substrings
+	for _, n := range strings.Fields(out) {
+		if n == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *cliRuntime) Create(s Spec) error {
+	args := []string{"create", "--name", s.Name, "--hostname", s.Hostname, "--network", "none"}
+	for _, c := range s.CapAdd {
+		args = append(args, "--cap-add", c)
+	}
+	labels := managedLabels(s)
+	for _, k := range sortedKeys(labels) {
+		args = append(args, "--label", k+"="+labels[k])
+	}
+	for _, k := range sortedKeys(s.Sysctls) {
+		args = append(args, "--sysctl", k+"="+s.Sysctls[k])
+	}
+	args = append(args, s.Image)
+	args = append(args, s.Cmd...)
+	_, err := r.output(args...)
+	return err
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (r *cliRuntime) Start(name string) error {
+	_, err := r.output("start", name)
+	return err
+}
+
+func (r *cliRuntime) Stop(name string) error {
+	_, err := r.output("stop", name)
+	return err
+}
+
+func (r *cliRuntime) Restart(name string) error {
+	_, err := r.output("restart", name)
+	return err
+}
+
+func (r *cliRuntime) Remove(name string) error {
+	_, err := r.output("rm", "-f", name)
+	return err
+}
+
+func (r *cliRuntime) Exec(name string, cmd ...string) (string, int, error) {
+	return cliExec(r.cli, r.cliArgs, name, cmd...)
+}
+
+func (r *cliRuntime) CopyTo(name, src, dst string) error {
+	return cliCopyTo(r.cli, r.cliArgs, name, src, dst)
+}
+
+func (r *cliRuntime) CopyFrom(name, src, dst string) error {
+	return cliCopyFrom(r.cli, r.cliArgs, name, src, dst)
+}
+
+func (r *cliRuntime) PID(name string) (int, error) {
+	out, err := r.output("inspect", "--format", "{{.State.Pid}}", name)
+	if err != nil {
+		return 0, err
+	}
+	if out == "" && utils.DryRun() {
+		return 0, nil
+	}
+	return strconv.Atoi(out)
+}
+
+func (r *cliRuntime) List(labels map[string]string) ([]Info, error) {
+	args := []string{"ps", "-a", "--format", "{{.ID}}\t{{.Names}}\t{{.Image}}\t{{.Status}}\t{{.Labels}}"}
+	for k, v := range labels {
+		args = append(args, "--filter", "label="+k+"="+v)
+	}
+	out, err := r.output(args...)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]Info, 0, 16)
+	for _, l := range strings.Split(out, "\n") {
+		f := strings.SplitN(l, "\t", 5)
+		if len(f) < 4 {
+			continue
+		}
+		info := Info{
+			ID:    f[0],
+			Name:  f[1],
+			Image: f[2],
+			State: stateOf(f[3]),
+		}
+		info.Running = info.State == "running"
+		if len(f) == 5 {
+			info.Labels = parseLabels(f[4])
+		}
+		res = append(res, info)
+	}
+	return res, nil
+}
+
+// stateOf returns the state of a container from the status displayed by ps
+// ("Up 2 minutes", "Exited (0) 5 seconds ago"...)
+func stateOf(status string) string {
+	s := strings.ToLower(strings.TrimSpace(status))
+	switch {
+	case strings.HasPrefix(s, "up"):
+		if strings.Contains(s, "(paused)") {
+			return "paused"
+		}
+		return "running"
+	case strings.HasPrefix(s, "exited"), strings.HasPrefix(s, "stopped"):
+		return "exited"
+	case strings.HasPrefix(s, "removal"):
+		return "removing"
+	}
+	if f := strings.Fields(s); len(f) > 0 {
+		return f[0]
+	}
+	return "unknown"
+}
+
+// parseLabels parses the labels displayed by ps: k1=v1,k2=v2 with nerdctl,
+// map[k1:v1 k2:v2] with podman
+func parseLabels(s string) map[string]string {
+	s = strings.TrimSpace(s)
+	sep, kv := ",", "="
+	if strings.HasPrefix(s, "map[") && strings.HasSuffix(s, "]") {
+		s = s[len("map[") : len(s)-1]
+		sep, kv = " ", ":"
+	}
+	res := make(map[string]string, 8)
+	for _, l := range strings.Split(s, sep) {
+		if l == "" {
+			continue
+		}
+		i := strings.Index(l, kv)
+		if i < 0 {
+			res[l] = ""
+			continue
+		}
+		res[l[:i]] = l[i+1:]
+	}
+	return res
+}
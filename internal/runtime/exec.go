@@ -0,0 +1,71 @@
+package runtime
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/rahveiz/topomate/config"
+	"github.com/rahveiz/topomate/utils"
+)
+
+// command returns a command of a container engine CLI
+func command(cli string, cliArgs []string, args ...string) *exec.Cmd {
+	return exec.Command(cli, append(append([]string{}, cliArgs...), args...)...)
+}
+
+// runOutput runs a command and returns its combined output and exit code.
+// A non-zero exit code is not considered as an error.
+func runOutput(cmd *exec.Cmd) (string, int, error) {
+	if config.VFlag {
+		fmt.Println(cmd)
+	}
+	out, err := utils.CombinedOutput(cmd)
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return string(out), exitErr.ExitCode(), nil
+		}
+		return string(out), -1, err
+	}
+	return string(out), 0, nil
+}
+
+// run runs a command and returns an error containing its output if it
+// fails
+func run(cmd *exec.Cmd) error {
+	out, code, err := runOutput(cmd)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("%s: %s(exit status %d)", cmd, out, code)
+	}
+	return nil
+}
+
+// cliExec and cliCopy are shared by the runtimes, as the copy and exec
+// operations always use the CLI of the engine
+
+func cliExec(cli string, cliArgs []string, name string, cmd ...string) (string, int, error) {
+	return runOutput(command(cli, cliArgs, append([]string{"exec", name}, cmd...)...))
+}
+
+func cliCopyTo(cli string, cliArgs []string, name, src, dst string) error {
+	return run(command(cli, cliArgs, "cp", src, name+":"+dst))
+}
+
+func cliCopyFrom(cli string, cliArgs []string, name, src, dst string) error {
+	return run(command(cli, cliArgs, "cp", name+":"+src, dst))
+}
+
+// cliError is returned when a command of an engine CLI fails
+type cliError struct {
+	cli    string
+	args   []string
+	output string
+	code   int
+}
+
+func (e *cliError) Error() string {
+	return fmt.Sprintf("%s %s: %s(exit status %d)", e.cli, strings.Join(e.args, " "), e.output, e.code)
+}
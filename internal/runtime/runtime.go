@@ -0,0 +1,214 @@
+// Package runtime manages the containers of a topology with the container
+// engine configured in the topomate configuration file (Docker, Podman or
+// containerd).
+package runtime
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/rahveiz/topomate/config"
+	"github.com/rahveiz/topomate/utils"
+	"github.com/spf13/viper"
+)
+
+// Container engines
+const (
+	EngineDocker     = "docker"
+	EnginePodman     = "podman"
+	EngineContainerd = "containerd"
+)
+
+// LabelManaged is set on all the containers created by topomate
+const LabelManaged = "topomate.managed"
+
+// Spec describes a container to create
+type Spec struct {
+	Name     string
+	Image    string
+	Hostname string
+	Cmd      []string
+	CapAdd   []string
+	Labels   map[string]string
+	// Sysctls are set in the namespaces of the container when it is created
+	Sysctls map[string]string
+}
+
+// Info describes an existing container
+type Info struct {
+	ID    string
+	Name  string
+	Image string
+	// State is the state reported by the engine (created, running, paused,
+	// restarting, exited, removing or dead)
+	State   string
+	Running bool
+	Labels  map[string]string
+}
+
+// Runtime creates and manages containers. Containers are designated by
+// their name.
+type Runtime interface {
+	// Name returns the name of the engine
+	Name() string
+	// Pull pulls the latest version of an image
+	Pull(image string) error
+	// Exists returns true if the container exists (running or not)
+	Exists(name string) (bool, error)
+	// Create creates a container without network
+	Create(s Spec) error
+	Start(name string) error
+	Stop(name string) error
+	Restart(name string) error
+	// Remove stops and removes a container
+	Remove(name string) error
+	// Exec runs a command in the container and returns its combined
+	// output and exit code. The error is only set if the command could not
+	// be run.
+	Exec(name string, cmd ...string) (string, int, error)
+	// CopyTo copies a file of the host to the container
+	CopyTo(name, src, dst string) error
+	// CopyFrom copies a file of the container to the host
+	CopyFrom(name, src, dst string) error
+	// PID returns the PID of the main process of a running container
+	PID(name string) (int, error)
+	// List returns the containers having all the labels
+	List(labels map[string]string) ([]Info, error)
+}
+
+var (
+	current Runtime
+	initErr error
+	once    sync.Once
+)
+
+// New returns the runtime of an engine. socket is the API socket for
+// docker and podman, or the containerd address, and namespace the
+// containerd namespace. Podman is used through its Docker-compatible API if
+// socket is set, and through the podman command (libpod) otherwise.
+// Containerd is used through nerdctl.
+func New(engine, socket, namespace string) (Runtime, error) {
+	switch strings.ToLower(engine) {
+	case "", EngineDocker:
+		socket = socketHost(socket)
+		r := &apiRuntime{engine: EngineDocker, cli: "docker", host: socket}
+		if socket != "" {
+			r.cliArgs = []string{"-H", socket}
+		}
+		return r, nil
+	case EnginePodman:
+		if socket != "" {
+			return &apiRuntime{engine: EnginePodman, cli: "podman", host: socketHost(socket)}, nil
+		}
+		return &cliRuntime{engine: EnginePodman, cli: "podman"}, nil
+	case EngineContainerd:
+		r := &cliRuntime{engine: EngineContainerd, cli: "nerdctl"}
+		if socket != "" {
+			r.cliArgs = append(r.cliArgs, "--address", socket)
+		}
+		if namespace != "" {
+			r.cliArgs = append(r.cliArgs, "--namespace", namespace)
+		}
+		return r, nil
+	}
+	return nil, fmt.Errorf("unknown container engine %s (docker, podman or containerd)", engine)
+}
+
+// socketHost returns the Docker API host of a socket, a path without
+// scheme is a unix socket
+func socketHost(socket string) string {
+	if socket == "" || strings.Contains(socket, "://") {
+		return socket
+	}
+	return "unix://" + socket
+}
+
+// Init initializes the runtime returned by Current from the "runtime"
+// section of the configuration file (engine, socket and namespace keys)
+func Init() error {
+	once.Do(func() {
+		current, initErr = New(
+			viper.GetString("runtime.engine"),
+			viper.GetString("runtime.socket"),
+			viper.GetString("runtime.namespace"),
+		)
+	})
+	return initErr
+}
+
+// Current returns the runtime configured in the "runtime" section of the
+// configuration file. It exits if the configuration is invalid, Init
+// returns the error instead.
+func Current() Runtime {
+	if err := Init(); err != nil {
+		utils.Fatalln(err)
+	}
+	return current
+}
+
+// SetCurrent replaces the runtime returned by Current
+func SetCurrent(r Runtime) {
+	once.Do(func() {})
+	current, initErr = r, nil
+}
+
+// Exec runs a command in a container with the current runtime
+func Exec(name string, cmd ...string) (string, int, error) {
+	return Current().Exec(name, cmd...)
+}
+
+// StartFRR launches the FRR init script inside the container
+func StartFRR(name string) error {
+	out, code, err := Exec(name, "/usr/lib/frr/frrinit.sh", "start")
+	if err != nil {
+		return fmt.Errorf("%s: %s %v", name, out, err)
+	} else if code != 0 {
+		return fmt.Errorf("%s: %s exit status %d", name, out, code)
+	}
+	return nil
+}
+
+// PullImages pulls the latest version of the images used by topomate
+func PullImages() error {
+	for _, img := range []string{config.DockerRouterImage, config.DockerRSImage} {
+		if config.VFlag {
+			fmt.Printf("Pulling latest %s image... ", img)
+		}
+		if err := Current().Pull(img); err != nil {
+			return err
+		}
+		if config.VFlag {
+			fmt.Println("Done.")
+		}
+	}
+	return nil
+}
+
+// managedLabels returns the labels of a spec with the topomate label added
+func managedLabels(s Spec) map[string]string {
+	labels := make(map[string]string, len(s.Labels)+1)
+	for k, v := range s.Labels {
+		labels[k] = v
+	}
+	labels[LabelManaged] = "true"
+	return labels
+}
+
+// ImageName returns the name of an image without its registry, tag and
+// digest, so that topomate/router matches localhost/topomate/router:latest
+func ImageName(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	if i := strings.Index(image, "/"); i >= 0 {
+		host := image[:i]
+		if host == "localhost" || strings.ContainsAny(host, ".:") {
+			image = image[i+1:]
+		}
+	}
+	return strings.TrimPrefix(image, "library/")
+}
@@ -0,0 +1,380 @@
+package scenario
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rahveiz/topomate/config"
+	"github.com/rahveiz/topomate/frr"
+	"github.com/rahveiz/topomate/internal/link"
+	"github.com/rahveiz/topomate/internal/snapshot"
+	"github.com/rahveiz/topomate/project"
+)
+
+// LinkAction brings the link between A and B down, using Method (veth by
+// default)
+type LinkAction struct {
+	A      string `yaml:"a"`
+	B      string `yaml:"b"`
+	Method string `yaml:"method"`
+}
+
+// LinkUpAction brings the link between A and B back up
+type LinkUpAction struct {
+	A string `yaml:"a"`
+	B string `yaml:"b"`
+}
+
+// RestartAction restarts the container of a node
+type RestartAction string
+
+// VtyshAction runs vtysh commands on a router, e.g. to change its
+// configuration
+type VtyshAction struct {
+	Node     string   `yaml:"node"`
+	Commands []string `yaml:"commands"`
+}
+
+// PrefixAction announces a prefix in BGP from a router. A blackhole static
+// route is added so the prefix is present in the RIB.
+type PrefixAction struct {
+	Node   string `yaml:"node"`
+	Prefix string `yaml:"prefix"`
+}
+
+// WithdrawAction withdraws a prefix announced by a PrefixAction
+type WithdrawAction PrefixAction
+
+// NetemAction changes the conditions emulated on the link between A and B
+type NetemAction struct {
+	A                  string `yaml:"a"`
+	B                  string `yaml:"b"`
+	config.NetemConfig `yaml:",inline"`
+}
+
+// ExecAction runs a shell command inside the container of a node
+type ExecAction struct {
+	Node    string `yaml:"node"`
+	Command string `yaml:"command"`
+}
+
+// SnapshotAction saves the routing tables of all routers in a snapshot named
+// after the scenario run and the mark
+type SnapshotAction string
+
+// WaitAction waits until the lab is ready (see the wait command)
+type WaitAction struct {
+	Timeout string `yaml:"timeout"`
+	Stable  string `yaml:"stable"`
+}
+
+func findRouter(p *project.Project, name string) (project.Node, error) {
+	n, ok := p.FindNode(name)
+	if !ok {
+		return n, fmt.Errorf("node %s not found", name)
+	}
+	if !n.IsRouter() {
+		return n, fmt.Errorf("%s is not a router", name)
+	}
+	return n, nil
+}
+
+func vtysh(n project.Node, commands ...string) error {
+	res := n.Vtysh(commands...)
+	if res.Err != nil {
+		return res.Err
+	}
+	// vtysh returns 0 even if a command is rejected in configuration mode
+	if out := strings.TrimSpace(res.Output); res.ExitCode != 0 || strings.Contains(out, "% ") {
+		return fmt.Errorf("%s: %s", n.ContainerName, out)
+	}
+	return nil
+}
+
+/* link_down */
+
+func (a *LinkAction) kind() string { return "link_down" }
+
+func (a *LinkAction) describe() string {
+	return fmt.Sprintf("link down %s <-> %s", a.A, a.B)
+}
+
+func (a *LinkAction) setDefaults() {
+	if a.Method == "" {
+		a.Method = link.MethodVeth
+	}
+}
+
+func (a *LinkAction) validate(p *project.Project) error {
+	switch a.Method {
+	case link.MethodVeth, link.MethodFlow:
+	default:
+		return fmt.Errorf("unknown method %s", a.Method)
+	}
+	_, err := p.FindLink(a.A, a.B)
+	return err
+}
+
+func (a *LinkAction) run(r *runner, ev *Event) error {
+	pA, pB, flows, err := r.p.LinkPorts(a.A, a.B)
+	if err != nil {
+		return err
+	}
+	return link.Down(pA, pB, flows, a.Method)
+}
+
+/* link_up */
+
+func (a *LinkUpAction) kind() string { return "link_up" }
+
+func (a *LinkUpAction) describe() string {
+	return fmt.Sprintf("link up %s <-> %s", a.A, a.B)
+}
+
+func (a *LinkUpAction) validate(p *project.Project) error {
+	_, err := p.FindLink(a.A, a.B)
+	return err
+}
+
+func (a *LinkUpAction) run(r *runner, ev *Event) error {
+	pA, pB, _, err := r.p.LinkPorts(a.A, a.B)
+	if err != nil {
+		return err
+	}
+	return link.Up(pA, pB)
+}
+
+/* restart */
+
+func (a *RestartAction) kind() string { return "restart" }
+
+func (a *RestartAction) describe() string {
+	return "restart " + string(*a)
+}
+
+func (a *RestartAction) validate(p *project.Project) error {
+	if _, ok := p.FindNode(string(*a)); !ok {
+		return fmt.Errorf("node %s not found", string(*a))
+	}
+	return nil
+}
+
+func (a *RestartAction) run(r *runner, ev *Event) error {
+	n, _ := r.p.FindNode(string(*a))
+	return link.RestartContainer(n.ContainerName)
+}
+
+/* vtysh */
+
+func (a *VtyshAction) kind() string { return "vtysh" }
+
+func (a *VtyshAction) describe() string {
+	return fmt.Sprintf("vtysh on %s: %s", a.Node, strings.Join(a.Commands, "; "))
+}
+
+func (a *VtyshAction) validate(p *project.Project) error {
+	if len(a.Commands) == 0 {
+		return errors.New("no commands")
+	}
+	_, err := findRouter(p, a.Node)
+	return err
+}
+
+func (a *VtyshAction) run(r *runner, ev *Event) error {
+	n, _ := r.p.FindNode(a.Node)
+	return vtysh(n, a.Commands...)
+}
+
+/* announce / withdraw */
+
+// prefixCommands returns the vtysh commands used to announce (or withdraw)
+// the prefix
+func prefixCommands(n project.Node, prefix string, withdraw bool) []string {
+	_, ipnet, _ := net.ParseCIDR(prefix)
+	route, af := "ip route", "ipv4"
+	if ipnet.IP.To4() == nil {
+		route, af = "ipv6 route", "ipv6"
+	}
+	no := ""
+	if withdraw {
+		no = "no "
+	}
+	cmds := []string{
+		"configure terminal",
+		"router bgp " + strconv.Itoa(n.ASN),
+		"address-family " + af + " unicast",
+		no + "network " + ipnet.String(),
+		"exit-address-family",
+		"exit",
+	}
+	static := no + route + " " + ipnet.String() + " Null0"
+	if withdraw {
+		return append(cmds, static)
+	}
+	// the static route must exist before the network is announced
+	return append([]string{"configure terminal", static, "exit"}, cmds...)
+}
+
+func validatePrefix(p *project.Project, node, prefix string) error {
+	if _, _, err := net.ParseCIDR(prefix); err != nil {
+		return err
+	}
+	_, err := findRouter(p, node)
+	return err
+}
+
+func (a *PrefixAction) kind() string { return "announce" }
+
+func (a *PrefixAction) describe() string {
+	return fmt.Sprintf("announce %s from %s", a.Prefix, a.Node)
+}
+
+func (a *PrefixAction) validate(p *project.Project) error {
+	return validatePrefix(p, a.Node, a.Prefix)
+}
+
+func (a *PrefixAction) run(r *runner, ev *Event) error {
+	n, _ := r.p.FindNode(a.Node)
+	return vtysh(n, prefixCommands(n, a.Prefix, false)...)
+}
+
+func (a *WithdrawAction) kind() string { return "withdraw" }
+
+func (a *WithdrawAction) describe() string {
+	return fmt.Sprintf("withdraw %s from %s", a.Prefix, a.Node)
+}
+
+func (a *WithdrawAction) validate(p *project.Project) error {
+	return validatePrefix(p, a.Node, a.Prefix)
+}
+
+func (a *WithdrawAction) run(r *runner, ev *Event) error {
+	n, _ := r.p.FindNode(a.Node)
+	return vtysh(n, prefixCommands(n, a.Prefix, true)...)
+}
+
+/* netem */
+
+func (a *NetemAction) kind() string { return "netem" }
+
+func (a *NetemAction) describe() string {
+	n, _ := project.ParseNetem(a.NetemConfig)
+	return fmt.Sprintf("netem %s <-> %s: %s", a.A, a.B, n)
+}
+
+func (a *NetemAction) validate(p *project.Project) error {
+	if _, err := project.ParseNetem(a.NetemConfig); err != nil {
+		return err
+	}
+	_, err := p.FindLink(a.A, a.B)
+	return err
+}
+
+func (a *NetemAction) run(r *runner, ev *Event) error {
+	n, _ := project.ParseNetem(a.NetemConfig)
+	pA, pB, _, err := r.p.LinkPorts(a.A, a.B)
+	if err != nil {
+		return err
+	}
+	return link.SetNetem(n, pA, pB)
+}
+
+/* exec */
+
+func (a *ExecAction) kind() string { return "exec" }
+
+func (a *ExecAction) describe() string {
+	return fmt.Sprintf("exec on %s: %s", a.Node, a.Command)
+}
+
+func (a *ExecAction) validate(p *project.Project) error {
+	if a.Command == "" {
+		return errors.New("no command")
+	}
+	if _, ok := p.FindNode(a.Node); !ok {
+		return fmt.Errorf("node %s not found", a.Node)
+	}
+	return nil
+}
+
+func (a *ExecAction) run(r *runner, ev *Event) error {
+	n, _ := r.p.FindNode(a.Node)
+	res := n.Exec("sh", "-c", a.Command)
+	if res.Err != nil {
+		return res.Err
+	}
+	if res.ExitCode != 0 {
+		return fmt.Errorf("exit code %d: %s", res.ExitCode, strings.TrimSpace(res.Output))
+	}
+	return nil
+}
+
+/* snapshot */
+
+func (a *SnapshotAction) kind() string { return "snapshot" }
+
+func (a *SnapshotAction) describe() string {
+	return "snapshot " + string(*a)
+}
+
+func (a *SnapshotAction) validate(p *project.Project) error {
+	if *a == "" || strings.ContainsAny(string(*a), `/\`) {
+		return fmt.Errorf("invalid snapshot mark \"%s\"", string(*a))
+	}
+	return nil
+}
+
+func (a *SnapshotAction) run(r *runner, ev *Event) error {
+	ev.Snapshot = r.id + "-" + string(*a)
+	meta, err := snapshot.Save(r.p, ev.Snapshot, r.p.Nodes(), true)
+	if err != nil {
+		return err
+	}
+	if len(meta.Errors) > 0 {
+		return fmt.Errorf("%d table(s) could not be collected", len(meta.Errors))
+	}
+	return nil
+}
+
+/* wait */
+
+func (a *WaitAction) kind() string { return "wait" }
+
+func (a *WaitAction) describe() string {
+	return "wait for the lab to be ready"
+}
+
+func (a *WaitAction) options() (frr.ReadyOptions, error) {
+	opts := frr.DefaultReadyOptions()
+	var err error
+	if a.Timeout != "" {
+		if opts.Timeout, err = time.ParseDuration(a.Timeout); err != nil {
+			return opts, err
+		}
+	}
+	if a.Stable != "" {
+		if opts.Stable, err = time.ParseDuration(a.Stable); err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+func (a *WaitAction) validate(p *project.Project) error {
+	_, err := a.options()
+	return err
+}
+
+func (a *WaitAction) run(r *runner, ev *Event) error {
+	opts, _ := a.options()
+	report := frr.WaitReady(r.p, opts)
+	if !report.Ready {
+		return fmt.Errorf("not ready after %v (%d session(s) pending, %d unstable RIB(s))",
+			report.Elapsed.Round(time.Second), len(report.Pending), len(report.Unstable))
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+package scenario
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/rahveiz/topomate/internal/convergence"
+	"github.com/rahveiz/topomate/project"
+	"github.com/rahveiz/topomate/utils"
+	"gopkg.in/yaml.v2"
+)
+
+// File is the content of a scenario file
+type File struct {
+	Name        string `yaml:"name"`
+	StopOnError bool   `yaml:"stop_on_error"`
+	Steps       []Step `yaml:"steps"`
+}
+
+// Step is an action executed At a given time after the start of the
+// scenario. Without At, the step is executed right after the previous one.
+// Exactly one of the action fields must be set. If Measure is true, the
+// convergence time after the action is measured, which delays the next steps
+// until the network is stable.
+type Step struct {
+	At       string          `yaml:"at"`
+	Name     string          `yaml:"name"`
+	LinkDown *LinkAction     `yaml:"link_down"`
+	LinkUp   *LinkUpAction   `yaml:"link_up"`
+	Restart  *RestartAction  `yaml:"restart"`
+	Vtysh    *VtyshAction    `yaml:"vtysh"`
+	Announce *PrefixAction   `yaml:"announce"`
+	Withdraw *WithdrawAction `yaml:"withdraw"`
+	Netem    *NetemAction    `yaml:"netem"`
+	Exec     *ExecAction     `yaml:"exec"`
+	Snapshot *SnapshotAction `yaml:"snapshot"`
+	Wait     *WaitAction     `yaml:"wait"`
+	Measure  bool            `yaml:"measure"`
+
+	at time.Duration
+}
+
+// Event is the execution record of a Step
+type Event struct {
+	Step     int           `json:"step"`
+	Name     string        `json:"name"`
+	Action   string        `json:"action"`
+	At       time.Duration `json:"at"`
+	Start    time.Time     `json:"start"`
+	End      time.Time     `json:"end"`
+	Error    string        `json:"error,omitempty"`
+	Snapshot string        `json:"snapshot,omitempty"`
+
+	Convergence *convergence.Report `json:"convergence,omitempty"`
+}
+
+// Record is the execution record of a scenario
+type Record struct {
+	ID       string    `json:"id"`
+	Scenario string    `json:"scenario"`
+	Project  string    `json:"project"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Events   []Event   `json:"events"`
+}
+
+// Failed returns the number of steps that returned an error
+func (r *Record) Failed() int {
+	n := 0
+	for _, e := range r.Events {
+		if e.Error != "" {
+			n++
+		}
+	}
+	return n
+}
+
+type action interface {
+	kind() string
+	describe() string
+	validate(p *project.Project) error
+	run(r *runner, ev *Event) error
+}
+
+// defaulter is implemented by the actions with default settings, applied
+// when the scenario is read
+type defaulter interface {
+	setDefaults()
+}
+
+// ReadFile reads and validates a scenario file. Steps are sorted by time,
+// steps with the same time keep their order.
+func ReadFile(path string) (*File, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &File{}
+	if err := yaml.UnmarshalStrict(data, f); err != nil {
+		return nil, err
+	}
+	if len(f.Steps) == 0 {
+		return nil, fmt.Errorf("%s: no steps", path)
+	}
+	var prev time.Duration
+	for i := range f.Steps {
+		s := &f.Steps[i]
+		s.at = prev
+		a, err := s.action()
+		if err != nil {
+			return nil, fmt.Errorf("step %d: %v", i+1, err)
+		}
+		if d, ok := a.(defaulter); ok {
+			d.setDefaults()
+		}
+		if s.At != "" {
+			if s.at, err = time.ParseDuration(s.At); err != nil {
+				return nil, fmt.Errorf("step %d: %v", i+1, err)
+			}
+			if s.at < 0 {
+				return nil, fmt.Errorf("step %d: negative time %s", i+1, s.At)
+			}
+		}
+		prev = s.at
+	}
+	sort.SliceStable(f.Steps, func(i, j int) bool {
+		return f.Steps[i].at < f.Steps[j].at
+	})
+	if f.Name == "" {
+		f.Name = trimExt(filepath.Base(path))
+	}
+	return f, nil
+}
+
+func trimExt(name string) string {
+	return name[:len(name)-len(filepath.Ext(name))]
+}
+
+func (s Step) action() (action, error) {
+	res := make([]action, 0, 1)
+	if s.LinkDown != nil {
+		res = append(res, s.LinkDown)
+	}
+	if s.LinkUp != nil {
+		res = append(res, s.LinkUp)
+	}
+	if s.Restart != nil {
+		res = append(res, s.Restart)
+	}
+	if s.Vtysh != nil {
+		res = append(res, s.Vtysh)
+	}
+	if s.Announce != nil {
+		res = append(res, s.Announce)
+	}
+	if s.Withdraw != nil {
+		res = append(res, s.Withdraw)
+	}
+	if s.Netem != nil {
+		res = append(res, s.Netem)
+	}
+	if s.Exec != nil {
+		res = append(res, s.Exec)
+	}
+	if s.Snapshot != nil {
+		res = append(res, s.Snapshot)
+	}
+	if s.Wait != nil {
+		res = append(res, s.Wait)
+	}
+	if len(res) != 1 {
+		return nil, fmt.Errorf("exactly one action must be set (found %d)", len(res))
+	}
+	return res[0], nil
+}
+
+// Validate checks that the nodes and links used by the scenario exist in
+// the project
+func (f *File) Validate(p *project.Project) error {
+	for i, s := range f.Steps {
+		a, _ := s.action()
+		if err := a.validate(p); err != nil {
+			return fmt.Errorf("step %d (%s): %v", i+1, a.kind(), err)
+		}
+	}
+	return nil
+}
+
+// Dir returns the directory where the scenario records of the current
+// project are stored
+func Dir() string {
+	return filepath.Join(utils.GetDirectoryFromKey("ConfigDir", ""), "scenarios")
+}
+
+type runner struct {
+	p     *project.Project
+	id    string
+	start time.Time
+}
+
+// Run validates and executes the scenario f against the running project p.
+// Progress is written to out. The returned record is also saved in the
+// scenarios directory of the project.
+func Run(p *project.Project, f *File, out io.Writer) (*Record, error) {
+	if err := f.Validate(p); err != nil {
+		return nil, err
+	}
+	r := &runner{p: p, start: time.Now()}
+	r.id = f.Name + "_" + r.start.Format("20060102-150405")
+	rec := &Record{
+		ID:       r.id,
+		Scenario: f.Name,
+		Project:  p.Name,
+		Start:    r.start,
+		Events:   make([]Event, 0, len(f.Steps)),
+	}
+
+	for i, s := range f.Steps {
+		a, _ := s.action()
+		if d := time.Until(r.start.Add(s.at)); d > 0 {
+			time.Sleep(d)
+		}
+		name := s.Name
+		if name == "" {
+			name = a.describe()
+		}
+		ev := Event{Step: i + 1, Name: name, Action: a.kind(), At: s.at, Start: time.Now()}
+		var err error
+		if s.Measure {
+			ev.Convergence, err = convergence.Measure(p.Nodes(), name, func() error {
+				return a.run(r, &ev)
+			}, convergence.DefaultOptions())
+		} else {
+			err = a.run(r, &ev)
+		}
+		ev.End = time.Now()
+		status := "ok"
+		if err != nil {
+			ev.Error = err.Error()
+			status = "FAIL: " + ev.Error
+		}
+		rec.Events = append(rec.Events, ev)
+		fmt.Fprintf(out, "[T+%6.1fs] %s (%s)\n", ev.Start.Sub(r.start).Seconds(), name, status)
+		if c := ev.Convergence; c != nil {
+			status := "converged"
+			if !c.Converged {
+				status = "not converged (timeout)"
+			}
+			fmt.Fprintf(out, "             %s after %v (%d FIB updates, %d BGP updates)\n",
+				status, c.Convergence.Round(time.Millisecond), c.FIBUpdates, c.BGPUpdates)
+		}
+		if err != nil && f.StopOnError {
+			break
+		}
+	}
+	rec.End = time.Now()
+
+	if err := os.MkdirAll(Dir(), os.ModeDir|os.ModePerm); err != nil {
+		return rec, err
+	}
+	j, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return rec, err
+	}
+	return rec, ioutil.WriteFile(filepath.Join(Dir(), r.id+".json"), j, 0644)
+}
@@ -0,0 +1,513 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rahveiz/topomate/frr"
+	"github.com/rahveiz/topomate/internal/link"
+	"github.com/rahveiz/topomate/internal/runtime"
+	"github.com/rahveiz/topomate/internal/snapshot"
+	"github.com/rahveiz/topomate/project"
+	"github.com/rahveiz/topomate/utils"
+)
+
+// projectRequest designates a configuration file or a project of the
+// project directory
+type projectRequest struct {
+	Path string `json:"path"`
+	Name string `json:"name"`
+}
+
+// projectInfo summarizes a project
+type projectInfo struct {
+	Name        string `json:"name"`
+	Path        string `json:"path,omitempty"`
+	LinkBackend string `json:"link_backend,omitempty"`
+	AS          []int  `json:"as"`
+	Nodes       int    `json:"nodes"`
+	Links       int    `json:"links"`
+}
+
+func newProjectInfo(p *project.Project, path string) projectInfo {
+	info := projectInfo{
+		Name:        p.Name,
+		Path:        path,
+		LinkBackend: p.LinkBackend,
+		AS:          make([]int, 0, len(p.AS)),
+		Nodes:       len(p.Nodes()),
+		Links:       len(p.ListLinks()),
+	}
+	for asn := range p.AS {
+		info.AS = append(info.AS, asn)
+	}
+	sort.Ints(info.AS)
+	return info
+}
+
+func (s *Server) getProject(r *http.Request) (interface{}, error) {
+	p, err := s.current()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return newProjectInfo(p, s.path), nil
+}
+
+func (s *Server) loadProject(r *http.Request) (interface{}, error) {
+	var req projectRequest
+	if err := decode(r, &req); err != nil {
+		return nil, err
+	}
+	j := s.jobs.Submit("load", func(ctx context.Context, j *Job) (interface{}, error) {
+		p, err := s.Load(req.Path, req.Name)
+		if err != nil {
+			return nil, err
+		}
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		return newProjectInfo(p, s.path), nil
+	})
+	return wait(r, j, http.StatusUnprocessableEntity)
+}
+
+func (s *Server) validate(r *http.Request) (interface{}, error) {
+	var req projectRequest
+	if err := decode(r, &req); err != nil {
+		return nil, err
+	}
+	j := s.jobs.Submit("validate", func(ctx context.Context, j *Job) (interface{}, error) {
+		p, err := s.Validate(req.Path, req.Name)
+		if err != nil {
+			return nil, err
+		}
+		return newProjectInfo(p, ""), nil
+	})
+	return wait(r, j, http.StatusUnprocessableEntity)
+}
+
+// submit runs fn as a job on the loaded project
+func (s *Server) submit(kind string, fn func(ctx context.Context, j *Job, p *project.Project) (interface{}, error)) (interface{}, error) {
+	if _, err := s.current(); err != nil {
+		return nil, err
+	}
+	return s.jobs.Submit(kind, func(ctx context.Context, j *Job) (interface{}, error) {
+		// the project may have been replaced by a previous job
+		p, err := s.current()
+		if err != nil {
+			return nil, err
+		}
+		return fn(ctx, j, p)
+	}), nil
+}
+
+func (s *Server) generate(r *http.Request) (interface{}, error) {
+	return s.submit("generate", func(ctx context.Context, j *Job, p *project.Project) (interface{}, error) {
+		frr.WriteAll(frr.GenerateConfig(p))
+		return map[string]string{"directory": utils.GetDirectoryFromKey("ConfigDir", "")}, nil
+	})
+}
+
+type startRequest struct {
+	// Links applied (all, internal, external, none)
+	Links      string `json:"links"`
+	AS         []int  `json:"as"`
+	NoGenerate bool   `json:"no_generate"`
+	Pull       bool   `json:"pull"`
+	// Wait until the BGP sessions are established and the RIBs are stable
+	Wait bool `json:"wait"`
+	// Maximum wait in seconds
+	WaitTimeout int `json:"wait_timeout"`
+}
+
+// readyResult is the JSON version of frr.ReadyReport
+type readyResult struct {
+	Ready    bool                `json:"ready"`
+	Elapsed  float64             `json:"elapsed"`
+	Sessions int                 `json:"sessions"`
+	Pending  []frr.SessionStatus `json:"pending,omitempty"`
+	Unstable []string            `json:"unstable,omitempty"`
+	Errors   map[string]string   `json:"errors,omitempty"`
+}
+
+func (s *Server) start(r *http.Request) (interface{}, error) {
+	req := startRequest{Links: "all"}
+	if err := decode(r, &req); err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(req.Links) {
+	case "all", "internal", "external", "none":
+	default:
+		return nil, errorf(http.StatusBadRequest, "invalid links %s (all, internal, external or none)", req.Links)
+	}
+	return s.submit("start", func(ctx context.Context, j *Job, p *project.Project) (interface{}, error) {
+		if !req.NoGenerate {
+			j.Logf("Generating the configuration files")
+			frr.WriteAll(frr.GenerateConfig(p))
+		}
+		if req.Pull {
+			j.Logf("Pulling the images")
+			if err := runtime.PullImages(); err != nil {
+				return nil, err
+			}
+		}
+		j.Logf("Starting the topology")
+		p = p.OnlyAS(req.AS)
+		if err := p.StartAll(ctx, req.Links); err != nil {
+			return nil, err
+		}
+		if !req.Wait {
+			return nil, nil
+		}
+		j.Logf("Waiting for the BGP sessions")
+		opts := frr.DefaultReadyOptions()
+		if req.WaitTimeout > 0 {
+			opts.Timeout = time.Duration(req.WaitTimeout) * time.Second
+		}
+		report := frr.WaitReady(p, opts)
+		res := readyResult{
+			Ready:    report.Ready,
+			Elapsed:  report.Elapsed.Seconds(),
+			Sessions: report.Sessions,
+			Pending:  report.Pending,
+			Unstable: report.Unstable,
+			Errors:   make(map[string]string, len(report.Errors)),
+		}
+		for n, err := range report.Errors {
+			res.Errors[n] = err.Error()
+		}
+		return res, nil
+	})
+}
+
+func (s *Server) stop(r *http.Request) (interface{}, error) {
+	return s.submit("stop", func(ctx context.Context, j *Job, p *project.Project) (interface{}, error) {
+		return nil, p.StopAll(ctx)
+	})
+}
+
+// nodeRequest designates a node, or all the nodes if empty
+type nodeRequest struct {
+	Node string `json:"node"`
+}
+
+// containerName returns the container of a node designated by its container
+// name or its hostname
+func containerName(p *project.Project, name string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+	n, ok := p.FindNode(name)
+	if !ok {
+		return "", errorf(http.StatusNotFound, "node %s not found", name)
+	}
+	return n.ContainerName, nil
+}
+
+func (s *Server) pause(r *http.Request) (interface{}, error) {
+	return s.pauseOrResume(r, "pause", link.Pause)
+}
+
+func (s *Server) resume(r *http.Request) (interface{}, error) {
+	return s.pauseOrResume(r, "resume", link.Resume)
+}
+
+func (s *Server) pauseOrResume(r *http.Request, kind string, fn func(string) error) (interface{}, error) {
+	var req nodeRequest
+	if err := decode(r, &req); err != nil {
+		return nil, err
+	}
+	p, err := s.current()
+	if err != nil {
+		return nil, err
+	}
+	name, err := containerName(p, req.Node)
+	if err != nil {
+		return nil, err
+	}
+	return s.submit(kind, func(ctx context.Context, j *Job, p *project.Project) (interface{}, error) {
+		return nil, fn(name)
+	})
+}
+
+type nodeStatus struct {
+	Name     string `json:"name"`
+	Hostname string `json:"hostname"`
+	ASN      int    `json:"asn"`
+	Role     string `json:"role"`
+	State    string `json:"state"`
+}
+
+type statusResult struct {
+	Project   string       `json:"project"`
+	Nodes     []nodeStatus `json:"nodes"`
+	LinksDown []link.State `json:"links_down"`
+}
+
+func (s *Server) status(r *http.Request) (interface{}, error) {
+	p, err := s.current()
+	if err != nil {
+		return nil, err
+	}
+	s.settings.RLock()
+	defer s.settings.RUnlock()
+	li, err := runtime.Current().List(nil)
+	if err != nil {
+		return nil, err
+	}
+	states := make(map[string]string, len(li))
+	for _, c := range li {
+		states[c.Name] = c.State
+	}
+
+	res := statusResult{Project: p.Name, LinksDown: make([]link.State, 0, 4)}
+	for _, n := range p.Nodes() {
+		state, ok := states[n.ContainerName]
+		if !ok {
+			state = "absent"
+		}
+		res.Nodes = append(res.Nodes, nodeStatus{
+			Name:     n.ContainerName,
+			Hostname: n.Hostname,
+			ASN:      n.ASN,
+			Role:     n.Role,
+			State:    state,
+		})
+	}
+
+	down, err := link.LoadStates()
+	if err != nil {
+		return nil, err
+	}
+	for _, st := range down {
+		res.LinksDown = append(res.LinksDown, st)
+	}
+	sort.Slice(res.LinksDown, func(i, j int) bool {
+		return res.LinksDown[i].Key() < res.LinksDown[j].Key()
+	})
+	return res, nil
+}
+
+type execRequest struct {
+	Command []string `json:"command"`
+	Vtysh   bool     `json:"vtysh"`
+	// Parse the output as JSON
+	JSON bool `json:"json"`
+}
+
+type execResult struct {
+	project.ExecResult
+	Error string `json:"error,omitempty"`
+}
+
+// exec runs a command on a node: POST /api/v1/nodes/<node>/exec
+func (s *Server) exec(r *http.Request) (interface{}, error) {
+	params := pathParams(r, "/api/v1/nodes/")
+	if len(params) != 2 || params[1] != "exec" {
+		return nil, errorf(http.StatusNotFound, "%s not found", r.URL.Path)
+	}
+	var req execRequest
+	if err := decode(r, &req); err != nil {
+		return nil, err
+	}
+	if len(req.Command) == 0 {
+		return nil, errorf(http.StatusBadRequest, "no command specified")
+	}
+	p, err := s.current()
+	if err != nil {
+		return nil, err
+	}
+	n, ok := p.FindNode(params[0])
+	if !ok {
+		return nil, errorf(http.StatusNotFound, "node %s not found", params[0])
+	}
+	s.settings.RLock()
+	defer s.settings.RUnlock()
+
+	var res execResult
+	if req.Vtysh {
+		res.ExecResult = n.Vtysh(strings.Join(req.Command, " "))
+	} else {
+		res.ExecResult = n.Exec(req.Command...)
+	}
+	if res.Err != nil {
+		res.Error = res.Err.Error()
+	} else if req.JSON && !res.Failed() {
+		if err := res.ParseJSON(); err != nil {
+			res.Error = err.Error()
+		} else {
+			res.Output = ""
+		}
+	}
+	return res, nil
+}
+
+type linkRequest struct {
+	A string `json:"a"`
+	B string `json:"b"`
+	// Method used to bring the link down (veth or flow)
+	Method string `json:"method"`
+}
+
+type linkResult struct {
+	A      string `json:"a"`
+	B      string `json:"b"`
+	State  string `json:"state"`
+	Method string `json:"method,omitempty"`
+}
+
+// setLink brings a link down or up: POST /api/v1/links/{down,up}
+func (s *Server) setLink(r *http.Request) (interface{}, error) {
+	params := pathParams(r, "/api/v1/links/")
+	if len(params) != 1 || (params[0] != "down" && params[0] != "up") {
+		return nil, errorf(http.StatusNotFound, "%s not found", r.URL.Path)
+	}
+	action := params[0]
+	req := linkRequest{Method: link.MethodVeth}
+	if err := decode(r, &req); err != nil {
+		return nil, err
+	}
+	if req.Method != link.MethodVeth && req.Method != link.MethodFlow {
+		return nil, errorf(http.StatusBadRequest, "unknown method %s (must be %s or %s)", req.Method, link.MethodVeth, link.MethodFlow)
+	}
+	p, err := s.current()
+	if err != nil {
+		return nil, err
+	}
+	a, b, flows, err := p.LinkPorts(req.A, req.B)
+	if err != nil {
+		return nil, withStatus(http.StatusBadRequest, err)
+	}
+
+	j := s.jobs.Submit("link "+action, func(ctx context.Context, j *Job) (interface{}, error) {
+		res := linkResult{A: a.String(), B: b.String(), State: action}
+		if action == "up" {
+			return res, link.Up(a, b)
+		}
+		res.Method = req.Method
+		return res, link.Down(a, b, flows, req.Method)
+	})
+	return wait(r, j, http.StatusInternalServerError)
+}
+
+func (s *Server) listSnapshots(r *http.Request) (interface{}, error) {
+	if _, err := s.current(); err != nil {
+		return nil, err
+	}
+	s.settings.RLock()
+	defer s.settings.RUnlock()
+	list, err := snapshot.List()
+	if err != nil {
+		return nil, err
+	}
+	if list == nil {
+		list = []*snapshot.Metadata{}
+	}
+	return list, nil
+}
+
+type snapshotRequest struct {
+	Name  string `json:"name"`
+	Force bool   `json:"force"`
+	// Selection of the routers, all of them if empty
+	AS    []int    `json:"as"`
+	Roles []string `json:"roles"`
+	Nodes []string `json:"nodes"`
+}
+
+func (s *Server) saveSnapshot(r *http.Request) (interface{}, error) {
+	var req snapshotRequest
+	if err := decode(r, &req); err != nil {
+		return nil, err
+	}
+	if req.Name == "" {
+		return nil, errorf(http.StatusBadRequest, "snapshot name required")
+	}
+	filter := project.NodeFilter{ASN: req.AS, Roles: req.Roles, Patterns: req.Nodes}
+	return s.submit("snapshot", func(ctx context.Context, j *Job, p *project.Project) (interface{}, error) {
+		return snapshot.Save(p, req.Name, p.SelectNodes(filter), req.Force)
+	})
+}
+
+// getSnapshot returns the metadata of a snapshot (GET /api/v1/snapshots/<a>)
+// or its changes with another one (GET /api/v1/snapshots/<a>/diff/<b>)
+func (s *Server) getSnapshot(r *http.Request) (interface{}, error) {
+	if _, err := s.current(); err != nil {
+		return nil, err
+	}
+	s.settings.RLock()
+	defer s.settings.RUnlock()
+	params := pathParams(r, "/api/v1/snapshots/")
+	switch {
+	case len(params) == 1:
+		meta, err := snapshot.Load(params[0])
+		if err != nil {
+			return nil, withStatus(http.StatusNotFound, err)
+		}
+		return meta, nil
+	case len(params) == 3 && params[1] == "diff":
+		changes, err := snapshot.Diff(params[0], params[2])
+		if err != nil {
+			return nil, withStatus(http.StatusNotFound, err)
+		}
+		if changes == nil {
+			changes = []snapshot.Change{}
+		}
+		return changes, nil
+	}
+	return nil, errorf(http.StatusNotFound, "%s not found", r.URL.Path)
+}
+
+func (s *Server) deleteSnapshot(r *http.Request) (interface{}, error) {
+	if _, err := s.current(); err != nil {
+		return nil, err
+	}
+	params := pathParams(r, "/api/v1/snapshots/")
+	if len(params) != 1 {
+		return nil, errorf(http.StatusNotFound, "%s not found", r.URL.Path)
+	}
+	s.settings.RLock()
+	defer s.settings.RUnlock()
+	if err := snapshot.Delete(params[0]); err != nil {
+		return nil, withStatus(http.StatusNotFound, err)
+	}
+	return map[string]string{"deleted": params[0]}, nil
+}
+
+func (s *Server) listJobs(r *http.Request) (interface{}, error) {
+	return s.jobs.List(), nil
+}
+
+// job returns the job of a /api/v1/jobs/<id> request
+func (s *Server) job(r *http.Request) (*Job, error) {
+	params := pathParams(r, "/api/v1/jobs/")
+	if len(params) != 1 {
+		return nil, errorf(http.StatusNotFound, "%s not found", r.URL.Path)
+	}
+	j, ok := s.jobs.Get(params[0])
+	if !ok {
+		return nil, errorf(http.StatusNotFound, "job %s not found", params[0])
+	}
+	return j, nil
+}
+
+func (s *Server) getJob(r *http.Request) (interface{}, error) {
+	j, err := s.job(r)
+	if err != nil {
+		return nil, err
+	}
+	return j.Info(), nil
+}
+
+func (s *Server) cancelJob(r *http.Request) (interface{}, error) {
+	j, err := s.job(r)
+	if err != nil {
+		return nil, err
+	}
+	j.Cancel()
+	return j.Info(), nil
+}
@@ -0,0 +1,250 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rahveiz/topomate/internal/orchestrator"
+	"github.com/rahveiz/topomate/utils"
+)
+
+// Job states
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// maxJobLog is the number of messages kept in the log of a job
+const maxJobLog = 200
+
+// Progress is the progress of a job running a graph of tasks
+type Progress struct {
+	Done  int    `json:"done"`
+	Total int    `json:"total"`
+	Task  string `json:"task,omitempty"`
+}
+
+// JobInfo is the state of a job returned by the API
+type JobInfo struct {
+	ID       string      `json:"id"`
+	Kind     string      `json:"kind"`
+	State    string      `json:"state"`
+	Created  time.Time   `json:"created"`
+	Started  *time.Time  `json:"started,omitempty"`
+	Finished *time.Time  `json:"finished,omitempty"`
+	Progress Progress    `json:"progress"`
+	Log      []string    `json:"log,omitempty"`
+	Error    string      `json:"error,omitempty"`
+	Result   interface{} `json:"result,omitempty"`
+}
+
+// JobFunc is the function run by a job. Its result is stored in the job.
+type JobFunc func(ctx context.Context, j *Job) (interface{}, error)
+
+// Job is an operation run in the background. Jobs are run one at a time,
+// in the order they have been submitted.
+type Job struct {
+	mu     sync.Mutex
+	info   JobInfo
+	fn     JobFunc
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Info returns a copy of the state of the job
+func (j *Job) Info() JobInfo {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	info := j.info
+	info.Log = append([]string(nil), j.info.Log...)
+	return info
+}
+
+// Done returns a channel closed when the job is finished
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// Cancel cancels the job. A running job stops at its next cancellation
+// point, a pending job is not run.
+func (j *Job) Cancel() {
+	j.cancel()
+}
+
+// Logf adds a message to the log of the job
+func (j *Job) Logf(format string, args ...interface{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	msg := time.Now().Format("15:04:05") + " " + fmt.Sprintf(format, args...)
+	if len(j.info.Log) >= maxJobLog {
+		j.info.Log = j.info.Log[1:]
+	}
+	j.info.Log = append(j.info.Log, msg)
+}
+
+// Progress updates the progress of the job with a finished task of a graph
+func (j *Job) Progress(p orchestrator.Progress) {
+	j.mu.Lock()
+	j.info.Progress = Progress{Done: p.Done, Total: p.Total, Task: p.Task}
+	j.mu.Unlock()
+	if p.Err != nil && p.Err != orchestrator.ErrSkipped {
+		j.Logf("%s: %v", p.Task, p.Err)
+	}
+}
+
+// Context returns the context of the job, reporting the progress of the
+// graphs run with it
+func (j *Job) Context() context.Context {
+	return orchestrator.WithProgress(j.ctx, j.Progress)
+}
+
+func (j *Job) setState(state string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	j.info.State = state
+	switch state {
+	case JobRunning:
+		j.info.Started = &now
+	default:
+		j.info.Finished = &now
+	}
+}
+
+func (j *Job) finish(res interface{}, err error) {
+	state := JobSucceeded
+	switch {
+	case j.ctx.Err() != nil:
+		state = JobCancelled
+	case err != nil:
+		state = JobFailed
+	}
+	j.mu.Lock()
+	j.info.Result = res
+	if err != nil {
+		j.info.Error = err.Error()
+	}
+	j.mu.Unlock()
+	j.setState(state)
+	j.cancel()
+	close(j.done)
+}
+
+// Jobs runs the submitted jobs and keeps their state
+type Jobs struct {
+	mu    sync.Mutex
+	jobs  map[string]*Job
+	order []string
+	next  int
+	queue chan *Job
+	// Maximum number of finished jobs kept
+	keep int
+}
+
+// NewJobs returns a job queue keeping at most keep finished jobs. Jobs are
+// run until ctx is cancelled.
+func NewJobs(ctx context.Context, keep int) *Jobs {
+	q := &Jobs{
+		jobs:  make(map[string]*Job, 64),
+		queue: make(chan *Job, 256),
+		keep:  keep,
+	}
+	go q.run(ctx)
+	return q
+}
+
+func (q *Jobs) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-q.queue:
+			if j.ctx.Err() != nil {
+				j.finish(nil, j.ctx.Err())
+				continue
+			}
+			j.setState(JobRunning)
+			var res interface{}
+			var err error
+			// fatal errors of the project and frr packages fail the job
+			if perr := utils.Catch(func() { res, err = j.fn(j.Context(), j) }); perr != nil {
+				err = perr
+			}
+			j.finish(res, err)
+		}
+	}
+}
+
+// Submit adds a job to the queue
+func (q *Jobs) Submit(kind string, fn JobFunc) *Job {
+	q.mu.Lock()
+	q.next++
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &Job{
+		info: JobInfo{
+			ID:      strconv.Itoa(q.next),
+			Kind:    kind,
+			State:   JobPending,
+			Created: time.Now(),
+		},
+		fn:     fn,
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	q.jobs[j.info.ID] = j
+	q.order = append(q.order, j.info.ID)
+	q.prune()
+	q.mu.Unlock()
+	q.queue <- j
+	return j
+}
+
+// prune removes the oldest finished jobs
+func (q *Jobs) prune() {
+	finished := 0
+	for _, id := range q.order {
+		if q.jobs[id].Info().Finished != nil {
+			finished++
+		}
+	}
+	order := q.order[:0]
+	for _, id := range q.order {
+		if finished > q.keep && q.jobs[id].Info().Finished != nil {
+			delete(q.jobs, id)
+			finished--
+			continue
+		}
+		order = append(order, id)
+	}
+	q.order = order
+}
+
+// Get returns a job by its ID
+func (q *Jobs) Get(id string) (*Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	return j, ok
+}
+
+// List returns the state of the jobs, oldest first
+func (q *Jobs) List() []JobInfo {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	res := make([]JobInfo, 0, len(q.order))
+	for _, id := range q.order {
+		info := q.jobs[id].Info()
+		info.Log = nil
+		info.Result = nil
+		res = append(res, info)
+	}
+	return res
+}
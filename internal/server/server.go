@@ -0,0 +1,290 @@
+// Package server exposes the lifecycle and the state of a topology through
+// an HTTP/JSON API.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/rahveiz/topomate/config"
+	"github.com/rahveiz/topomate/project"
+	"github.com/rahveiz/topomate/utils"
+	"github.com/spf13/viper"
+)
+
+// DefaultAddress is the default listening address of the API
+const DefaultAddress = "127.0.0.1:8273"
+
+// DefaultKeepJobs is the default number of finished jobs kept
+const DefaultKeepJobs = 100
+
+// Server serves the API for one project at a time. The operations changing
+// the topology are run as jobs, one at a time.
+type Server struct {
+	jobs *Jobs
+	// ConfigDir set before the first project is loaded
+	configDir string
+	// settings guards the global settings changed by reading a project
+	// (directories and BGP defaults): reading a project takes the write
+	// lock, the handlers using them outside of a job the read lock
+	settings sync.RWMutex
+
+	mu      sync.RWMutex
+	path    string
+	project *project.Project
+}
+
+// New returns a server running its jobs until ctx is cancelled
+func New(ctx context.Context, keepJobs int) *Server {
+	return &Server{
+		jobs:      NewJobs(ctx, keepJobs),
+		configDir: viper.GetString("ConfigDir"),
+	}
+}
+
+// apiError is an error returned with an HTTP status
+type apiError struct {
+	status int
+	err    error
+}
+
+func (e *apiError) Error() string {
+	return e.err.Error()
+}
+
+func errorf(status int, format string, args ...interface{}) error {
+	return &apiError{status: status, err: fmt.Errorf(format, args...)}
+}
+
+// withStatus returns err with an HTTP status
+func withStatus(status int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &apiError{status: status, err: err}
+}
+
+var errNoProject = errorf(http.StatusConflict, "no project loaded")
+
+// current returns the loaded project
+func (s *Server) current() (*project.Project, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.project == nil {
+		return nil, errNoProject
+	}
+	return s.project, nil
+}
+
+// configPath returns the path of the configuration file of a project, from
+// a path or from the name of a project of the project directory, and the
+// ConfigDir used by the project
+func (s *Server) configPath(path, name string) (string, string, error) {
+	switch {
+	case name != "" && path != "":
+		return "", "", errorf(http.StatusBadRequest, "path and name are mutually exclusive")
+	case name != "":
+		if strings.ContainsAny(name, `/\`) {
+			return "", "", errorf(http.StatusBadRequest, "invalid project name %s", name)
+		}
+		return filepath.Join(utils.GetDirectoryFromKey("ProjectDir", ""), name+".yml"),
+			s.configDir + "/" + name, nil
+	case path != "":
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return "", "", withStatus(http.StatusBadRequest, err)
+		}
+		return abs, s.configDir, nil
+	}
+	return "", "", errorf(http.StatusBadRequest, "path or name required")
+}
+
+// globalSettings are the global settings changed by reading a project
+type globalSettings struct {
+	dir     string
+	confDir string
+	bgp     config.GlobalBGPConfig
+}
+
+func saveSettings() globalSettings {
+	return globalSettings{
+		dir:     viper.GetString("ConfigDir"),
+		confDir: config.ConfigDir,
+		bgp:     config.DefaultBGPSettings,
+	}
+}
+
+func (g globalSettings) restore() {
+	viper.Set("ConfigDir", g.dir)
+	config.ConfigDir = g.confDir
+	config.DefaultBGPSettings = g.bgp
+}
+
+// read reads a configuration file with the settings lock held. Fatal errors
+// of the project package are returned. The global settings are restored if
+// keep is false or if the project cannot be read.
+func (s *Server) read(path, configDir string, keep bool) (p *project.Project, err error) {
+	s.settings.Lock()
+	defer s.settings.Unlock()
+	old := saveSettings()
+	viper.Set("ConfigDir", configDir)
+	err = utils.Catch(func() {
+		p = project.ReadConfig(path)
+	})
+	if err != nil || !keep {
+		old.restore()
+	}
+	if err != nil {
+		return nil, withStatus(http.StatusUnprocessableEntity, err)
+	}
+	return p, nil
+}
+
+// Load reads a project, which replaces the loaded one. It must not be
+// called while a job is running.
+func (s *Server) Load(path, name string) (*project.Project, error) {
+	path, dir, err := s.configPath(path, name)
+	if err != nil {
+		return nil, err
+	}
+	p, err := s.read(path, dir, true)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.path, s.project = path, p
+	s.mu.Unlock()
+	return p, nil
+}
+
+// Validate reads a project without loading it, the global settings of the
+// loaded project are kept
+func (s *Server) Validate(path, name string) (*project.Project, error) {
+	path, dir, err := s.configPath(path, name)
+	if err != nil {
+		return nil, err
+	}
+	return s.read(path, dir, false)
+}
+
+// handlerFunc handles an API request. The result is encoded in JSON, a
+// *Job is returned with the 202 status.
+type handlerFunc func(r *http.Request) (interface{}, error)
+
+// methods dispatches the requests to the handlers by method
+func methods(handlers map[string]handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h, ok := handlers[r.Method]
+		if !ok {
+			writeError(w, errorf(http.StatusMethodNotAllowed, "method %s not allowed", r.Method))
+			return
+		}
+		var res interface{}
+		var err error
+		if perr := utils.Catch(func() { res, err = h(r) }); perr != nil {
+			err = perr
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if j, ok := res.(*Job); ok {
+			info := j.Info()
+			w.Header().Set("Location", "/api/v1/jobs/"+info.ID)
+			writeJSON(w, http.StatusAccepted, info)
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var e *apiError
+	if errors.As(err, &e) {
+		status = e.status
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// decode reads the JSON body of a request in v. An empty body is allowed.
+func decode(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil && err != io.EOF {
+		return errorf(http.StatusBadRequest, "invalid request: %v", err)
+	}
+	return nil
+}
+
+// pathParams returns the elements of the path of the request after prefix
+func pathParams(r *http.Request, prefix string) []string {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	if rest == "" {
+		return nil
+	}
+	return strings.Split(rest, "/")
+}
+
+// wait waits for a job submitted by a synchronous request and returns its
+// result
+func wait(r *http.Request, j *Job, status int) (interface{}, error) {
+	select {
+	case <-j.Done():
+	case <-r.Context().Done():
+		return nil, r.Context().Err()
+	}
+	info := j.Info()
+	if info.Error != "" {
+		return nil, errorf(status, "%s", info.Error)
+	}
+	return info.Result, nil
+}
+
+// Handler returns the HTTP handler of the API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/api/v1/project", methods(map[string]handlerFunc{
+		http.MethodGet:  s.getProject,
+		http.MethodPost: s.loadProject,
+	}))
+	mux.Handle("/api/v1/validate", methods(map[string]handlerFunc{http.MethodPost: s.validate}))
+	mux.Handle("/api/v1/generate", methods(map[string]handlerFunc{http.MethodPost: s.generate}))
+	mux.Handle("/api/v1/start", methods(map[string]handlerFunc{http.MethodPost: s.start}))
+	mux.Handle("/api/v1/stop", methods(map[string]handlerFunc{http.MethodPost: s.stop}))
+	mux.Handle("/api/v1/pause", methods(map[string]handlerFunc{http.MethodPost: s.pause}))
+	mux.Handle("/api/v1/resume", methods(map[string]handlerFunc{http.MethodPost: s.resume}))
+	mux.Handle("/api/v1/status", methods(map[string]handlerFunc{http.MethodGet: s.status}))
+	mux.Handle("/api/v1/nodes/", methods(map[string]handlerFunc{http.MethodPost: s.exec}))
+	mux.Handle("/api/v1/links/", methods(map[string]handlerFunc{http.MethodPost: s.setLink}))
+	mux.Handle("/api/v1/snapshots", methods(map[string]handlerFunc{
+		http.MethodGet:  s.listSnapshots,
+		http.MethodPost: s.saveSnapshot,
+	}))
+	mux.Handle("/api/v1/snapshots/", methods(map[string]handlerFunc{
+		http.MethodGet:    s.getSnapshot,
+		http.MethodDelete: s.deleteSnapshot,
+	}))
+	mux.Handle("/api/v1/jobs", methods(map[string]handlerFunc{http.MethodGet: s.listJobs}))
+	mux.Handle("/api/v1/jobs/", methods(map[string]handlerFunc{
+		http.MethodGet:    s.getJob,
+		http.MethodDelete: s.cancelJob,
+	}))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, errorf(http.StatusNotFound, "%s not found", r.URL.Path))
+	})
+	return mux
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rahveiz/topomate/internal/runtime"
+	"github.com/rahveiz/topomate/project"
+	"github.com/rahveiz/topomate/utils"
+	"github.com/spf13/viper"
+)
+
+// fakeRuntime lists containers, the other operations are not used
+type fakeRuntime struct {
+	runtime.Runtime
+	containers []runtime.Info
+}
+
+func (*fakeRuntime) Name() string { return "fake" }
+
+func (r *fakeRuntime) List(map[string]string) ([]runtime.Info, error) {
+	return r.containers, nil
+}
+
+const testConfig = `autonomous_systems:
+  - asn: 1
+    routers: 2
+    igp: OSPF
+    prefix: '10.0.0.0/24'
+    links:
+      kind: 'full-mesh'
+`
+
+const invalidConfig = `autonomous_systems:
+  - asn: 1
+    routers: 0
+`
+
+// setup returns a server and the directory of its files
+func setup(t *testing.T) (*Server, string, func()) {
+	dir, err := ioutil.TempDir("", "topomate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	viper.Set("MainDir", dir)
+	viper.Set("ConfigDir", dir)
+	utils.SetFatalRecoverable(true)
+	ctx, cancel := context.WithCancel(context.Background())
+	return New(ctx, DefaultKeepJobs), dir, func() {
+		cancel()
+		utils.SetFatalRecoverable(false)
+		viper.Set("MainDir", nil)
+		viper.Set("ConfigDir", nil)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestJobs(t *testing.T) {
+	s, _, cleanup := setup(t)
+	defer cleanup()
+
+	nodes := []project.Node{{ContainerName: "AS1-R1"}, {ContainerName: "AS1-R2"}}
+	tests := []struct {
+		name   string
+		fn     JobFunc
+		state  string
+		err    string
+		result interface{}
+	}{
+		{
+			name:   "succeeded",
+			fn:     func(context.Context, *Job) (interface{}, error) { return "done", nil },
+			state:  JobSucceeded,
+			result: "done",
+		},
+		{
+			name:  "failed",
+			fn:    func(context.Context, *Job) (interface{}, error) { return nil, errors.New("injected failure") },
+			state: JobFailed,
+			err:   "injected failure",
+		},
+		{
+			name: "fatal",
+			fn: func(context.Context, *Job) (interface{}, error) {
+				utils.Fatalln("injected fatal error")
+				return nil, nil
+			},
+			state: JobFailed,
+			err:   "injected fatal error",
+		},
+		{
+			name: "fatal in a worker",
+			fn: func(context.Context, *Job) (interface{}, error) {
+				res := project.ExecAll(nodes, 2, func(n project.Node) project.ExecResult {
+					utils.Fatalln("injected fatal error on", n.ContainerName)
+					return project.ExecResult{}
+				})
+				errs := make([]string, 0, len(res))
+				for _, r := range res {
+					errs = append(errs, r.Err.Error())
+				}
+				return strings.Join(errs, ","), nil
+			},
+			state:  JobSucceeded,
+			result: "injected fatal error on AS1-R1,injected fatal error on AS1-R2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := s.jobs.Submit(tt.name, tt.fn)
+			<-j.Done()
+			info := j.Info()
+			if info.State != tt.state {
+				t.Errorf("state = %s, want %s", info.State, tt.state)
+			}
+			if info.Error != tt.err {
+				t.Errorf("error = %q, want %q", info.Error, tt.err)
+			}
+			if info.Result != tt.result {
+				t.Errorf("result = %v, want %v", info.Result, tt.result)
+			}
+		})
+	}
+}
+
+func TestJobCancel(t *testing.T) {
+	s, _, cleanup := setup(t)
+	defer cleanup()
+
+	started := make(chan struct{})
+	j := s.jobs.Submit("wait", func(ctx context.Context, j *Job) (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	<-started
+	j.Cancel()
+	<-j.Done()
+	if state := j.Info().State; state != JobCancelled {
+		t.Errorf("state = %s, want %s", state, JobCancelled)
+	}
+}
+
+// request sends a request to the API and decodes the JSON response in v
+func request(t *testing.T, srv *httptest.Server, method, path, body string, v interface{}) int {
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestHandler(t *testing.T) {
+	s, dir, cleanup := setup(t)
+	defer cleanup()
+	runtime.SetCurrent(&fakeRuntime{containers: []runtime.Info{
+		{Name: "AS1-R1", State: "running"},
+	}})
+
+	valid := filepath.Join(dir, "valid.yml")
+	invalid := filepath.Join(dir, "invalid.yml")
+	if err := ioutil.WriteFile(valid, []byte(testConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(invalid, []byte(invalidConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	var apiErr map[string]string
+	if code := request(t, srv, http.MethodGet, "/api/v1/status", "", &apiErr); code != http.StatusConflict {
+		t.Errorf("status without project: code = %d, want %d", code, http.StatusConflict)
+	}
+
+	// the load request waits for its job
+	var info projectInfo
+	if code := request(t, srv, http.MethodPost, "/api/v1/project", `{"path": "`+valid+`"}`, &info); code != http.StatusOK {
+		t.Fatalf("load: code = %d, want %d", code, http.StatusOK)
+	}
+	if info.Nodes != 2 || len(info.AS) != 1 || info.AS[0] != 1 {
+		t.Errorf("load: project = %+v, want 2 nodes in AS 1", info)
+	}
+
+	var st statusResult
+	if code := request(t, srv, http.MethodGet, "/api/v1/status", "", &st); code != http.StatusOK {
+		t.Fatalf("status: code = %d, want %d", code, http.StatusOK)
+	}
+	states := make(map[string]string, len(st.Nodes))
+	for _, n := range st.Nodes {
+		states[n.Name] = n.State
+	}
+	if states["AS1-R1"] != "running" || states["AS1-R2"] != "absent" {
+		t.Errorf("status: node states = %v, want AS1-R1 running and AS1-R2 absent", states)
+	}
+
+	// the fatal error of the invalid configuration fails the request only
+	// and the settings of the loaded project are kept
+	apiErr = nil
+	code := request(t, srv, http.MethodPost, "/api/v1/validate", `{"path": "`+invalid+`"}`, &apiErr)
+	if code != http.StatusUnprocessableEntity || !strings.Contains(apiErr["error"], "cannot generate AS without routers") {
+		t.Errorf("validate: code = %d, error = %q, want %d", code, apiErr["error"], http.StatusUnprocessableEntity)
+	}
+	if d := viper.GetString("ConfigDir"); d != dir {
+		t.Errorf("ConfigDir after validate = %s, want %s", d, dir)
+	}
+	if code := request(t, srv, http.MethodGet, "/api/v1/project", "", &info); code != http.StatusOK || info.Path != valid {
+		t.Errorf("project after validate: code = %d, path = %s, want %s", code, info.Path, valid)
+	}
+
+	// a failed job is reported by the jobs endpoint
+	j := s.jobs.Submit("failing", func(context.Context, *Job) (interface{}, error) {
+		return nil, errors.New("injected failure")
+	})
+	<-j.Done()
+	var ji JobInfo
+	if code := request(t, srv, http.MethodGet, "/api/v1/jobs/"+j.Info().ID, "", &ji); code != http.StatusOK {
+		t.Fatalf("job: code = %d, want %d", code, http.StatusOK)
+	}
+	if ji.State != JobFailed || ji.Error != "injected failure" {
+		t.Errorf("job: state = %s, error = %q, want failed with the injected failure", ji.State, ji.Error)
+	}
+	if code := request(t, srv, http.MethodGet, "/api/v1/jobs/0", "", nil); code != http.StatusNotFound {
+		t.Errorf("unknown job: code = %d, want %d", code, http.StatusNotFound)
+	}
+}
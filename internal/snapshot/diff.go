@@ -0,0 +1,245 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// Route is the selected route for a prefix in a routing table
+type Route struct {
+	Prefix   string
+	Protocol string
+	NextHops []string
+	ASPath   string
+}
+
+// Change kinds
+const (
+	Added           = "added"
+	Removed         = "removed"
+	NextHopChanged  = "nexthop"
+	ASPathChanged   = "as-path"
+	ProtocolChanged = "protocol"
+)
+
+// Change is a route-level difference between two snapshots
+type Change struct {
+	Node   string `json:"node"`
+	Table  string `json:"table"`
+	Prefix string `json:"prefix"`
+	Kind   string `json:"kind"`
+	Old    string `json:"old,omitempty"`
+	New    string `json:"new,omitempty"`
+}
+
+type bgpTableJSON struct {
+	Routes map[string][]struct {
+		Valid    bool   `json:"valid"`
+		BestPath bool   `json:"bestpath"`
+		Path     string `json:"path"`
+		NextHops []struct {
+			IP string `json:"ip"`
+		} `json:"nexthops"`
+	} `json:"routes"`
+}
+
+type ribEntryJSON struct {
+	Protocol string `json:"protocol"`
+	Selected bool   `json:"selected"`
+	NextHops []struct {
+		IP            string `json:"ip"`
+		InterfaceName string `json:"interfaceName"`
+		Active        bool   `json:"active"`
+	} `json:"nexthops"`
+}
+
+// readTable parses a BGP or RIB table stored in a snapshot and returns the
+// selected route for each prefix
+func readTable(name, file string) (map[string]Route, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]Route, 64)
+	if strings.HasPrefix(name, "bgp-") {
+		var t bgpTableJSON
+		if err := json.Unmarshal(data, &t); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		for prefix, paths := range t.Routes {
+			for _, p := range paths {
+				if !p.BestPath {
+					continue
+				}
+				r := Route{Prefix: prefix, Protocol: "bgp", ASPath: p.Path}
+				for _, nh := range p.NextHops {
+					r.NextHops = append(r.NextHops, nh.IP)
+				}
+				sort.Strings(r.NextHops)
+				res[prefix] = r
+			}
+		}
+		return res, nil
+	}
+
+	var t map[string][]ribEntryJSON
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	for prefix, entries := range t {
+		for _, e := range entries {
+			if !e.Selected {
+				continue
+			}
+			r := Route{Prefix: prefix, Protocol: e.Protocol}
+			for _, nh := range e.NextHops {
+				if !nh.Active {
+					continue
+				}
+				if nh.IP != "" {
+					r.NextHops = append(r.NextHops, nh.IP)
+				} else {
+					r.NextHops = append(r.NextHops, nh.InterfaceName)
+				}
+			}
+			sort.Strings(r.NextHops)
+			res[prefix] = r
+		}
+	}
+	return res, nil
+}
+
+func (r Route) String() string {
+	s := r.Protocol + " via " + strings.Join(r.NextHops, ",")
+	if r.ASPath != "" {
+		s += " path [" + r.ASPath + "]"
+	}
+	return s
+}
+
+// compareTables returns the changes between two versions of a table
+func compareTables(node, table string, a, b map[string]Route) []Change {
+	res := make([]Change, 0, 8)
+	for prefix, old := range a {
+		cur, ok := b[prefix]
+		if !ok {
+			res = append(res, Change{node, table, prefix, Removed, old.String(), ""})
+			continue
+		}
+		if old.Protocol != cur.Protocol {
+			res = append(res, Change{node, table, prefix, ProtocolChanged, old.Protocol, cur.Protocol})
+		}
+		if o, c := strings.Join(old.NextHops, ","), strings.Join(cur.NextHops, ","); o != c {
+			res = append(res, Change{node, table, prefix, NextHopChanged, o, c})
+		}
+		if old.ASPath != cur.ASPath {
+			res = append(res, Change{node, table, prefix, ASPathChanged, old.ASPath, cur.ASPath})
+		}
+	}
+	for prefix, cur := range b {
+		if _, ok := a[prefix]; !ok {
+			res = append(res, Change{node, table, prefix, Added, "", cur.String()})
+		}
+	}
+	return res
+}
+
+// Diff returns the route-level changes between the snapshots a and b
+func Diff(a, b string) ([]Change, error) {
+	metaA, err := Load(a)
+	if err != nil {
+		return nil, err
+	}
+	metaB, err := Load(b)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[string]bool, len(metaA.Nodes))
+	for _, n := range metaA.Nodes {
+		nodes[n] = true
+	}
+	for _, n := range metaB.Nodes {
+		nodes[n] = true
+	}
+
+	res := make([]Change, 0, 64)
+	for node := range nodes {
+		filesA, err := tableFiles(a, node)
+		if err != nil {
+			return nil, err
+		}
+		filesB, err := tableFiles(b, node)
+		if err != nil {
+			return nil, err
+		}
+		tables := make(map[string]bool, len(filesA))
+		for t := range filesA {
+			tables[t] = true
+		}
+		for t := range filesB {
+			tables[t] = true
+		}
+		for t := range tables {
+			var routesA, routesB map[string]Route
+			if f, ok := filesA[t]; ok {
+				if routesA, err = readTable(t, f); err != nil {
+					return nil, err
+				}
+			}
+			if f, ok := filesB[t]; ok {
+				if routesB, err = readTable(t, f); err != nil {
+					return nil, err
+				}
+			}
+			res = append(res, compareTables(node, t, routesA, routesB)...)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Node != res[j].Node {
+			return res[i].Node < res[j].Node
+		}
+		if res[i].Table != res[j].Table {
+			return res[i].Table < res[j].Table
+		}
+		if res[i].Prefix != res[j].Prefix {
+			return res[i].Prefix < res[j].Prefix
+		}
+		return res[i].Kind < res[j].Kind
+	})
+	return res, nil
+}
+
+// WriteChanges displays the changes grouped by node and table
+func WriteChanges(dst io.Writer, changes []Change) {
+	if len(changes) == 0 {
+		fmt.Fprintln(dst, "No changes.")
+		return
+	}
+	var node, table string
+	for _, c := range changes {
+		if c.Node != node {
+			fmt.Fprintf(dst, "===== %s =====\n", c.Node)
+			node = c.Node
+			table = ""
+		}
+		if c.Table != table {
+			fmt.Fprintf(dst, "[%s]\n", c.Table)
+			table = c.Table
+		}
+		switch c.Kind {
+		case Added:
+			fmt.Fprintf(dst, "  + %s %s\n", c.Prefix, c.New)
+		case Removed:
+			fmt.Fprintf(dst, "  - %s %s\n", c.Prefix, c.Old)
+		default:
+			fmt.Fprintf(dst, "  ~ %s %s: %s -> %s\n", c.Prefix, c.Kind, c.Old, c.New)
+		}
+	}
+	fmt.Fprintf(dst, "\n%d change(s)\n", len(changes))
+}
@@ -0,0 +1,192 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestCompareTables(t *testing.T) {
+	route := func(prefix, proto, path string, nh ...string) Route {
+		return Route{Prefix: prefix, Protocol: proto, ASPath: path, NextHops: nh}
+	}
+	base := map[string]Route{
+		"10.0.0.0/24": route("10.0.0.0/24", "bgp", "2 3", "192.0.2.1"),
+		"10.0.1.0/24": route("10.0.1.0/24", "ospf", "", "192.0.2.2", "192.0.2.3"),
+	}
+
+	tests := []struct {
+		name string
+		a, b map[string]Route
+		want []Change
+	}{
+		{
+			name: "same tables",
+			a:    base,
+			b:    base,
+			want: []Change{},
+		},
+		{
+			name: "empty tables",
+			want: []Change{},
+		},
+		{
+			name: "added and removed",
+			a:    map[string]Route{"10.0.0.0/24": base["10.0.0.0/24"]},
+			b:    map[string]Route{"10.0.1.0/24": base["10.0.1.0/24"]},
+			want: []Change{
+				{"R1", "rib-ipv4", "10.0.0.0/24", Removed, "bgp via 192.0.2.1 path [2 3]", ""},
+				{"R1", "rib-ipv4", "10.0.1.0/24", Added, "", "ospf via 192.0.2.2,192.0.2.3"},
+			},
+		},
+		{
+			name: "all attributes changed",
+			a:    base,
+			b: map[string]Route{
+				"10.0.0.0/24": route("10.0.0.0/24", "static", "2 4 3", "192.0.2.4"),
+				"10.0.1.0/24": base["10.0.1.0/24"],
+			},
+			want: []Change{
+				{"R1", "rib-ipv4", "10.0.0.0/24", ProtocolChanged, "bgp", "static"},
+				{"R1", "rib-ipv4", "10.0.0.0/24", NextHopChanged, "192.0.2.1", "192.0.2.4"},
+				{"R1", "rib-ipv4", "10.0.0.0/24", ASPathChanged, "2 3", "2 4 3"},
+			},
+		},
+		{
+			name: "one next hop lost",
+			a:    base,
+			b: map[string]Route{
+				"10.0.0.0/24": base["10.0.0.0/24"],
+				"10.0.1.0/24": route("10.0.1.0/24", "ospf", "", "192.0.2.2"),
+			},
+			want: []Change{
+				{"R1", "rib-ipv4", "10.0.1.0/24", NextHopChanged, "192.0.2.2,192.0.2.3", "192.0.2.2"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := compareTables("R1", "rib-ipv4", tt.a, tt.b)
+			sort.SliceStable(got, func(i, j int) bool { return got[i].Prefix < got[j].Prefix })
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("changes = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+const (
+	ribBefore = `{
+  "10.0.0.0/24": [
+    {"protocol": "ospf", "selected": true, "nexthops": [{"ip": "192.0.2.1", "active": true}, {"ip": "192.0.2.2", "active": false}]},
+    {"protocol": "bgp", "selected": false, "nexthops": [{"ip": "192.0.2.9", "active": true}]}
+  ],
+  "10.0.1.0/24": [
+    {"protocol": "connected", "selected": true, "nexthops": [{"interfaceName": "eth0", "active": true}]}
+  ]
+}`
+	ribAfter = `{
+  "10.0.0.0/24": [
+    {"protocol": "bgp", "selected": true, "nexthops": [{"ip": "192.0.2.9", "active": true}]}
+  ],
+  "10.0.1.0/24": [
+    {"protocol": "connected", "selected": true, "nexthops": [{"interfaceName": "eth0", "active": true}]}
+  ]
+}`
+	bgpBefore = `{
+  "routes": {
+    "10.1.0.0/16": [
+      {"valid": true, "bestpath": false, "path": "2 4 3", "nexthops": [{"ip": "192.0.2.5"}]},
+      {"valid": true, "bestpath": true, "path": "2 3", "nexthops": [{"ip": "192.0.2.6"}]}
+    ]
+  }
+}`
+	bgpAfter = `{
+  "routes": {
+    "10.1.0.0/16": [
+      {"valid": true, "bestpath": true, "path": "2 4 3", "nexthops": [{"ip": "192.0.2.5"}]}
+    ],
+    "10.2.0.0/16": [
+      {"valid": true, "bestpath": true, "path": "5", "nexthops": [{"ip": "192.0.2.7"}]}
+    ]
+  }
+}`
+)
+
+// writeSnapshot writes a snapshot with the files of each node
+func writeSnapshot(t *testing.T, name string, nodes map[string]map[string]string) {
+	dir := filepath.Join(Dir(), name)
+	meta := Metadata{Version: FormatVersion, Name: name}
+	for node, files := range nodes {
+		meta.Nodes = append(meta.Nodes, node)
+		if err := os.MkdirAll(filepath.Join(dir, node), 0755); err != nil {
+			t.Fatal(err)
+		}
+		for f, data := range files {
+			if err := ioutil.WriteFile(filepath.Join(dir, node, f), []byte(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	j, err := json.Marshal(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, metadataFile), j, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "topomate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	viper.Set("ConfigDir", dir)
+	defer viper.Set("ConfigDir", nil)
+
+	writeSnapshot(t, "before", map[string]map[string]string{
+		"AS1-R1": {"rib-ipv4.json": ribBefore, "bgp-ipv4.json": bgpBefore, "isis.txt": "ignored"},
+		"AS1-R2": {"rib-ipv4.json": ribBefore},
+	})
+	writeSnapshot(t, "after", map[string]map[string]string{
+		"AS1-R1": {"rib-ipv4.json": ribAfter, "bgp-ipv4.json": bgpAfter, "isis.txt": "changed"},
+		"AS1-R2": {"rib-ipv4.json": ribBefore},
+		"AS1-R3": {"rib-ipv4.json": `{}`},
+	})
+	writeSnapshot(t, "invalid", map[string]map[string]string{
+		"AS1-R1": {"rib-ipv4.json": `[`},
+	})
+
+	want := []Change{
+		{"AS1-R1", "bgp-ipv4", "10.1.0.0/16", ASPathChanged, "2 3", "2 4 3"},
+		{"AS1-R1", "bgp-ipv4", "10.1.0.0/16", NextHopChanged, "192.0.2.6", "192.0.2.5"},
+		{"AS1-R1", "bgp-ipv4", "10.2.0.0/16", Added, "", "bgp via 192.0.2.7 path [5]"},
+		{"AS1-R1", "rib-ipv4", "10.0.0.0/24", NextHopChanged, "192.0.2.1", "192.0.2.9"},
+		{"AS1-R1", "rib-ipv4", "10.0.0.0/24", ProtocolChanged, "ospf", "bgp"},
+	}
+	got, err := Diff("before", "after")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff = %+v, want %+v", got, want)
+	}
+
+	if got, err := Diff("after", "after"); err != nil || len(got) != 0 {
+		t.Errorf("Diff of a snapshot with itself = %+v, %v, want no change", got, err)
+	}
+	if _, err := Diff("before", "missing"); err == nil {
+		t.Error("Diff with a missing snapshot: no error")
+	}
+	if _, err := Diff("before", "invalid"); err == nil {
+		t.Error("Diff with an invalid table: no error")
+	}
+}
@@ -0,0 +1,243 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rahveiz/topomate/project"
+	"github.com/rahveiz/topomate/utils"
+)
+
+// FormatVersion is the version of the snapshot directory layout
+const FormatVersion = 1
+
+const metadataFile = "snapshot.json"
+
+// Metadata describes a snapshot. It is stored at the root of the snapshot
+// directory.
+type Metadata struct {
+	Version int               `json:"version"`
+	Name    string            `json:"name"`
+	Project string            `json:"project"`
+	Date    time.Time         `json:"date"`
+	Nodes   []string          `json:"nodes"`
+	Errors  map[string]string `json:"errors,omitempty"`
+}
+
+// table is a vtysh command whose output is stored in a snapshot
+type table struct {
+	name    string
+	command string
+	json    bool
+}
+
+// defaultTables returns the tables collected on a router. VRF tables are
+// added for every VRF configured on the router.
+func defaultTables(r *project.Router) []table {
+	res := []table{
+		{"bgp-ipv4", "show bgp ipv4 unicast json", true},
+		{"bgp-ipv6", "show bgp ipv6 unicast json", true},
+		{"rib-ipv4", "show ip route json", true},
+		{"rib-ipv6", "show ipv6 route json", true},
+		{"mpls", "show mpls table json", true},
+		{"ospf", "show ip ospf database json", true},
+		{"ospf6", "show ipv6 ospf6 database", false},
+		{"isis", "show isis database detail", false},
+	}
+	vrfs := make(map[string]bool, 2)
+	for _, l := range r.Links {
+		if l.VRF != "" && !vrfs[l.VRF] {
+			vrfs[l.VRF] = true
+			res = append(res,
+				table{"bgp-vrf-" + l.VRF + "-ipv4", "show bgp vrf " + l.VRF + " ipv4 unicast json", true},
+				table{"bgp-vrf-" + l.VRF + "-ipv6", "show bgp vrf " + l.VRF + " ipv6 unicast json", true},
+				table{"rib-vrf-" + l.VRF + "-ipv4", "show ip route vrf " + l.VRF + " json", true},
+				table{"rib-vrf-" + l.VRF + "-ipv6", "show ipv6 route vrf " + l.VRF + " json", true},
+			)
+		}
+	}
+	return res
+}
+
+func (t table) filename() string {
+	if t.json {
+		return t.name + ".json"
+	}
+	return t.name + ".txt"
+}
+
+// Dir returns the directory where the snapshots of the current project are
+// stored
+func Dir() string {
+	return filepath.Join(utils.GetDirectoryFromKey("ConfigDir", ""), "snapshots")
+}
+
+func path(name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return "", fmt.Errorf("invalid snapshot name \"%s\"", name)
+	}
+	return filepath.Join(Dir(), name), nil
+}
+
+// Save collects the routing tables of the nodes and stores them in a new
+// snapshot directory. If overwrite is false, an existing snapshot with the
+// same name is not replaced.
+func Save(p *project.Project, name string, nodes []project.Node, overwrite bool) (*Metadata, error) {
+	dir, err := path(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(dir); err == nil {
+		if !overwrite {
+			return nil, fmt.Errorf("snapshot %s already exists", name)
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(dir, os.ModeDir|os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	meta := &Metadata{
+		Version: FormatVersion,
+		Name:    name,
+		Project: p.Name,
+		Date:    time.Now(),
+		Nodes:   make([]string, 0, len(nodes)),
+		Errors:  make(map[string]string),
+	}
+	var lock sync.Mutex
+	routers := make([]project.Node, 0, len(nodes))
+	for _, n := range nodes {
+		if n.IsRouter() {
+			routers = append(routers, n)
+			meta.Nodes = append(meta.Nodes, n.ContainerName)
+		}
+	}
+
+	project.ExecAll(routers, project.DefaultParallelism, func(n project.Node) project.ExecResult {
+		nodeDir := filepath.Join(dir, n.ContainerName)
+		if err := os.MkdirAll(nodeDir, os.ModeDir|os.ModePerm); err != nil {
+			lock.Lock()
+			meta.Errors[n.ContainerName] = err.Error()
+			lock.Unlock()
+			return project.ExecResult{Node: n.ContainerName, Err: err}
+		}
+		for _, t := range defaultTables(n.Router) {
+			res := n.Vtysh(t.command)
+			if res.Failed() || (t.json && !json.Valid([]byte(res.Output))) {
+				lock.Lock()
+				meta.Errors[n.ContainerName+"/"+t.name] = strings.TrimSpace(res.Output)
+				lock.Unlock()
+				continue
+			}
+			if err := ioutil.WriteFile(filepath.Join(nodeDir, t.filename()), []byte(res.Output), 0644); err != nil {
+				lock.Lock()
+				meta.Errors[n.ContainerName+"/"+t.name] = err.Error()
+				lock.Unlock()
+			}
+		}
+		return project.ExecResult{Node: n.ContainerName}
+	})
+
+	j, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, metadataFile), j, 0644); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// Load reads the metadata of a snapshot
+func Load(name string) (*Metadata, error) {
+	dir, err := path(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, metadataFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("snapshot %s not found", name)
+		}
+		return nil, err
+	}
+	meta := &Metadata{}
+	if err := json.Unmarshal(data, meta); err != nil {
+		return nil, err
+	}
+	if meta.Version > FormatVersion {
+		return nil, fmt.Errorf("snapshot %s: unsupported format version %d", name, meta.Version)
+	}
+	return meta, nil
+}
+
+// List returns the metadata of all the snapshots of the current project,
+// sorted by date
+func List() ([]*Metadata, error) {
+	entries, err := ioutil.ReadDir(Dir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	res := make([]*Metadata, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if meta, err := Load(e.Name()); err == nil {
+			res = append(res, meta)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Date.Before(res[j].Date)
+	})
+	return res, nil
+}
+
+// Delete removes a snapshot
+func Delete(name string) error {
+	if _, err := Load(name); err != nil {
+		return err
+	}
+	dir, _ := path(name)
+	return os.RemoveAll(dir)
+}
+
+// tableFiles returns the JSON routing tables stored for a node, indexed by
+// table name
+func tableFiles(name, node string) (map[string]string, error) {
+	dir, err := path(name)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := ioutil.ReadDir(filepath.Join(dir, node))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	res := make(map[string]string, len(entries))
+	for _, e := range entries {
+		n := e.Name()
+		if !strings.HasSuffix(n, ".json") {
+			continue
+		}
+		if strings.HasPrefix(n, "bgp-") || strings.HasPrefix(n, "rib-") {
+			res[strings.TrimSuffix(n, ".json")] = filepath.Join(dir, node, n)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,264 @@
+package traffic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rahveiz/topomate/internal/flows"
+	"github.com/rahveiz/topomate/project"
+)
+
+// BasePort is the iperf3 port of the first flow, each flow uses its own port
+const BasePort = 5201
+
+// Result is the outcome of a flow. Throughputs are in bits per second.
+type Result struct {
+	Name        string        `json:"name"`
+	Source      string        `json:"source"`
+	Destination string        `json:"destination"`
+	Address     string        `json:"address"`
+	Protocol    string        `json:"protocol"`
+	Rate        string        `json:"rate,omitempty"`
+	Start       time.Duration `json:"start"`
+	Duration    time.Duration `json:"duration"`
+
+	Sent        float64 `json:"sent_bps"`
+	Received    float64 `json:"received_bps"`
+	Retransmits int     `json:"retransmits,omitempty"`
+	LostPercent float64 `json:"lost_percent,omitempty"`
+	JitterMs    float64 `json:"jitter_ms,omitempty"`
+	Error       string  `json:"error,omitempty"`
+}
+
+// Run is the record of a traffic run
+type Run struct {
+	ID      string    `json:"id"`
+	Project string    `json:"project"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Flows   []Result  `json:"flows"`
+}
+
+// Dir returns the directory where the traffic runs of the current project
+// are stored
+func Dir() string {
+	return filepath.Join(flows.Dir(), "runs")
+}
+
+// iperfOutput is the part of the iperf3 JSON output used
+type iperfOutput struct {
+	End struct {
+		SumSent     iperfSum `json:"sum_sent"`
+		SumReceived iperfSum `json:"sum_received"`
+		// UDP summary
+		Sum iperfSum `json:"sum"`
+	} `json:"end"`
+	Error string `json:"error"`
+}
+
+type iperfSum struct {
+	BitsPerSecond float64 `json:"bits_per_second"`
+	Retransmits   int     `json:"retransmits"`
+	LostPercent   float64 `json:"lost_percent"`
+	JitterMs      float64 `json:"jitter_ms"`
+}
+
+// execError describes a failed command
+func execError(r project.ExecResult) string {
+	if r.Err != nil {
+		return r.Err.Error()
+	}
+	return fmt.Sprintf("%s (exit status %d)", strings.TrimSpace(r.Output), r.ExitCode)
+}
+
+func pidFile(port int) string {
+	return fmt.Sprintf("/tmp/topomate-iperf3-%d.pid", port)
+}
+
+// startServer starts a one-off iperf3 server in the background
+func startServer(n project.Node, port int) error {
+	res := n.Exec("iperf3", "-s", "-D", "-1", "-p", strconv.Itoa(port), "--pidfile", pidFile(port))
+	if res.Failed() {
+		return fmt.Errorf("iperf3 server on %s: %s", n.ContainerName, execError(res))
+	}
+	return nil
+}
+
+// stopServer stops the server if it is still running (the client failed or
+// has been interrupted)
+func stopServer(n project.Node, port int) {
+	f := pidFile(port)
+	n.Exec("sh", "-c", fmt.Sprintf("[ -f %[1]s ] && kill $(cat %[1]s); rm -f %[1]s", f))
+}
+
+func clientArgs(f project.TrafficFlow, port int) []string {
+	secs := int(f.Duration.Round(time.Second).Seconds())
+	args := []string{"iperf3", "-c", f.Address, "-p", strconv.Itoa(port),
+		"-t", strconv.Itoa(secs), "-J"}
+	if f.Protocol == project.ProtoUDP {
+		args = append(args, "-u")
+	}
+	if f.Rate != "" {
+		args = append(args, "-b", f.Rate)
+	}
+	return args
+}
+
+// runClient runs the client of a flow and returns its raw JSON output
+func runClient(f project.TrafficFlow, port int, res *Result) []byte {
+	out := f.Source.Exec(clientArgs(f, port)...)
+	// warnings may be printed before the JSON document
+	raw := out.Output
+	if i := strings.Index(raw, "{"); i >= 0 {
+		raw = raw[i:]
+	}
+	var o iperfOutput
+	if err := json.Unmarshal([]byte(raw), &o); err != nil {
+		if out.Failed() {
+			res.Error = execError(out)
+		} else {
+			res.Error = "cannot parse the iperf3 output: " + err.Error()
+		}
+		return nil
+	}
+	if o.Error != "" {
+		res.Error = o.Error
+	}
+	if f.Protocol == project.ProtoUDP {
+		res.Sent = o.End.Sum.BitsPerSecond
+		res.Received = o.End.Sum.BitsPerSecond * (100 - o.End.Sum.LostPercent) / 100
+		res.LostPercent = o.End.Sum.LostPercent
+		res.JitterMs = o.End.Sum.JitterMs
+	} else {
+		res.Sent = o.End.SumSent.BitsPerSecond
+		res.Received = o.End.SumReceived.BitsPerSecond
+		res.Retransmits = o.End.SumSent.Retransmits
+	}
+	return []byte(raw)
+}
+
+// Start runs the flows: the iperf3 servers are started on the destinations,
+// then each client is started at its offset. The run stops early if ctx is
+// cancelled, the servers still running are stopped. The run and the iperf3
+// output of each flow are saved in the runs directory of the project.
+func Start(ctx context.Context, p *project.Project, fl []project.TrafficFlow, out io.Writer) (*Run, error) {
+	run := &Run{
+		Project: p.Name,
+		Start:   time.Now(),
+		Flows:   make([]Result, len(fl)),
+	}
+	run.ID = run.Start.Format("20060102-150405")
+	dir := filepath.Join(Dir(), run.ID)
+	if err := os.MkdirAll(dir, os.ModeDir|os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	for i, f := range fl {
+		res := &run.Flows[i]
+		*res = Result{
+			Name:        f.Name,
+			Source:      f.Source.ContainerName,
+			Destination: f.Destination.ContainerName,
+			Address:     f.Address,
+			Protocol:    f.Protocol,
+			Rate:        f.Rate,
+			Start:       f.Start,
+			Duration:    f.Duration,
+		}
+		port := BasePort + i
+		wg.Add(1)
+		go func(f project.TrafficFlow) {
+			defer wg.Done()
+			defer stopServer(f.Destination, port)
+			select {
+			case <-time.After(f.Start):
+			case <-ctx.Done():
+				res.Error = "not started (interrupted)"
+				return
+			}
+			if err := startServer(f.Destination, port); err != nil {
+				res.Error = err.Error()
+				return
+			}
+			lock.Lock()
+			fmt.Fprintf(out, "[T+%6.1fs] %s started: %s\n", time.Since(run.Start).Seconds(), f.Name, f)
+			lock.Unlock()
+
+			done := make(chan []byte, 1)
+			go func() {
+				done <- runClient(f, port, res)
+			}()
+			var raw []byte
+			select {
+			case raw = <-done:
+			case <-ctx.Done():
+				// stopping the server makes the client fail
+				stopServer(f.Destination, port)
+				raw = <-done
+			}
+			if raw != nil {
+				ioutil.WriteFile(filepath.Join(dir, f.Name+".json"), raw, 0644)
+			}
+			status := "ok"
+			if res.Error != "" {
+				status = "FAIL: " + res.Error
+			}
+			lock.Lock()
+			fmt.Fprintf(out, "[T+%6.1fs] %s finished (%s)\n", time.Since(run.Start).Seconds(), f.Name, status)
+			lock.Unlock()
+		}(f)
+	}
+	wg.Wait()
+	run.End = time.Now()
+
+	j, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return run, err
+	}
+	return run, ioutil.WriteFile(filepath.Join(dir, "run.json"), j, 0644)
+}
+
+// Failed returns the number of flows which failed
+func (r *Run) Failed() int {
+	n := 0
+	for _, f := range r.Flows {
+		if f.Error != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// Write displays the throughput and the loss of each flow
+func (r *Run) Write(dst io.Writer) {
+	w := tabwriter.NewWriter(dst, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "FLOW\tSOURCE\tDESTINATION\tPROTO\tTARGET\tSENT\tRECEIVED\tLOSS")
+	for _, f := range r.Flows {
+		target := f.Rate
+		if target == "" {
+			target = "-"
+		}
+		loss := fmt.Sprintf("%d retr", f.Retransmits)
+		if f.Protocol == project.ProtoUDP {
+			loss = fmt.Sprintf("%.2f%%", f.LostPercent)
+		}
+		if f.Error != "" {
+			loss = "error"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", f.Name, f.Source, f.Address,
+			f.Protocol, target, flows.FormatBps(f.Sent), flows.FormatBps(f.Received), loss)
+	}
+	w.Flush()
+}
@@ -0,0 +1,290 @@
+package project
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+
+	"github.com/rahveiz/topomate/internal/link"
+	"github.com/rahveiz/topomate/internal/ovsdocker"
+	"github.com/rahveiz/topomate/utils"
+)
+
+// Plan contains the changes needed to go from a running state to another
+type Plan struct {
+	AddNodes    []string
+	RemoveNodes []string
+	AddLinks    []ProjectLink
+	RemoveLinks []ProjectLink
+	// ChangedLinks contains the new version of links whose settings changed,
+	// they are recreated
+	ChangedLinks []ProjectLink
+	// Reload contains the routers whose configuration changed
+	Reload []string
+}
+
+// linkKey identifies a link by its bridge and its ends
+func linkKey(l ProjectLink) string {
+	k := []string{l.A.String(), l.B.String()}
+	sort.Strings(k)
+	return l.Bridge + "/" + k[0] + "-" + k[1]
+}
+
+// sameSettings compares the settings of two versions of a link. The OpenFlow
+// port numbers are not compared as they are allocated when links are added.
+func sameSettings(a, b ProjectLink) bool {
+	a.A.Settings.OFPort, a.B.Settings.OFPort = 0, 0
+	b.A.Settings.OFPort, b.B.Settings.OFPort = 0, 0
+	return reflect.DeepEqual(a, b)
+}
+
+// ComputePlan returns the changes needed to go from the state old to cur
+func ComputePlan(old, cur *RunningState) *Plan {
+	pl := &Plan{}
+	for name, n := range cur.Nodes {
+		o, ok := old.Nodes[name]
+		switch {
+		case !ok:
+			pl.AddNodes = append(pl.AddNodes, name)
+		case o.ConfigHash != n.ConfigHash && n.ConfigHash != "":
+			pl.Reload = append(pl.Reload, name)
+		}
+	}
+	for name := range old.Nodes {
+		if _, ok := cur.Nodes[name]; !ok {
+			pl.RemoveNodes = append(pl.RemoveNodes, name)
+		}
+	}
+
+	oldLinks := make(map[string]ProjectLink, len(old.Links))
+	for _, l := range old.Links {
+		oldLinks[linkKey(l)] = l
+	}
+	curLinks := make(map[string]bool, len(cur.Links))
+	for _, l := range cur.Links {
+		k := linkKey(l)
+		curLinks[k] = true
+		o, ok := oldLinks[k]
+		switch {
+		case !ok:
+			pl.AddLinks = append(pl.AddLinks, l)
+		case !sameSettings(o, l):
+			pl.ChangedLinks = append(pl.ChangedLinks, l)
+		}
+	}
+	for _, l := range old.Links {
+		if !curLinks[linkKey(l)] {
+			pl.RemoveLinks = append(pl.RemoveLinks, l)
+		}
+	}
+
+	sort.Strings(pl.AddNodes)
+	sort.Strings(pl.RemoveNodes)
+	sort.Strings(pl.Reload)
+	for _, links := range [][]ProjectLink{pl.AddLinks, pl.RemoveLinks, pl.ChangedLinks} {
+		sort.Slice(links, func(i, j int) bool {
+			return linkKey(links[i]) < linkKey(links[j])
+		})
+	}
+	return pl
+}
+
+// Empty returns true if there is nothing to apply
+func (pl *Plan) Empty() bool {
+	return len(pl.AddNodes)+len(pl.RemoveNodes)+len(pl.AddLinks)+
+		len(pl.RemoveLinks)+len(pl.ChangedLinks)+len(pl.Reload) == 0
+}
+
+// Write displays the plan
+func (pl *Plan) Write(dst io.Writer) {
+	if pl.Empty() {
+		fmt.Fprintln(dst, "No changes.")
+		return
+	}
+	for _, n := range pl.RemoveNodes {
+		fmt.Fprintf(dst, "- node %s\n", n)
+	}
+	for _, n := range pl.AddNodes {
+		fmt.Fprintf(dst, "+ node %s\n", n)
+	}
+	for _, l := range pl.RemoveLinks {
+		fmt.Fprintf(dst, "- link %s (%s)\n", l, l.Bridge)
+	}
+	for _, l := range pl.AddLinks {
+		fmt.Fprintf(dst, "+ link %s (%s)\n", l, l.Bridge)
+	}
+	for _, l := range pl.ChangedLinks {
+		fmt.Fprintf(dst, "~ link %s (%s)\n", l, l.Bridge)
+	}
+	for _, n := range pl.Reload {
+		fmt.Fprintf(dst, "~ config %s\n", n)
+	}
+	fmt.Fprintf(dst, "\n%d node(s) to add, %d to remove, %d link(s) to add, %d to remove, %d to change, %d config(s) to reload\n",
+		len(pl.AddNodes), len(pl.RemoveNodes), len(pl.AddLinks), len(pl.RemoveLinks),
+		len(pl.ChangedLinks), len(pl.Reload))
+}
+
+// linkPort returns the port of an end of a link in the saved links
+func linkPort(m ovsdocker.OVSBulk, bridge string, e LinkEnd) (link.Port, bool) {
+	for _, i := range m[e.ContainerName] {
+		if i.ContainerIface == e.IfName && i.Bridge == bridge {
+			return link.Port{
+				Container: e.ContainerName,
+				Iface:     e.IfName,
+				HostIface: i.HostIface,
+				Bridge:    bridge,
+				Shared:    e.Shared,
+				Backend:   i.Backend,
+			}, true
+		}
+	}
+	return link.Port{}, false
+}
+
+// removeSaved removes an interface from the saved links
+func removeSaved(m ovsdocker.OVSBulk, p link.Port) {
+	ifaces := m[p.Container]
+	for i, v := range ifaces {
+		if v.ContainerIface == p.Iface && v.Bridge == p.Bridge {
+			m[p.Container] = append(ifaces[:i], ifaces[i+1:]...)
+			break
+		}
+	}
+	if len(m[p.Container]) == 0 {
+		delete(m, p.Container)
+	}
+}
+
+// removeLink removes the ports of a link. Shared ports are kept unless their
+// container is removed.
+func removeLink(m ovsdocker.OVSBulk, l ProjectLink, removed map[string]bool) error {
+	for _, e := range []LinkEnd{l.A, l.B} {
+		if e.Shared && !removed[e.ContainerName] {
+			continue
+		}
+		p, ok := linkPort(m, l.Bridge, e)
+		if !ok {
+			continue
+		}
+		if err := link.RemovePort(p, l.Flows); err != nil {
+			return fmt.Errorf("%s: %v", l, err)
+		}
+		removeSaved(m, p)
+	}
+	return nil
+}
+
+// addLink creates the ports of a link. Shared ports are created only once.
+// OpenFlow port numbers are allocated by OVS as the bridge is already used.
+func addLink(b link.Backend, m ovsdocker.OVSBulk, l ProjectLink) error {
+	l.A.Settings.OFPort, l.B.Settings.OFPort = 0, 0
+	if err := connectLink(b, l, m); err != nil {
+		return fmt.Errorf("%s: %v", l, err)
+	}
+	return nil
+}
+
+// ApplyPlan applies the changes of the plan on the running topology: removed
+// links and nodes are deleted, new nodes and links are created and the
+// configuration of changed routers is reloaded without restarting them.
+// The configuration files must have been generated before.
+func (p *Project) ApplyPlan(pl *Plan, st *RunningState) error {
+	m, err := link.ReadSaved()
+	if err != nil {
+		return err
+	}
+	removed := make(map[string]bool, len(pl.RemoveNodes))
+	for _, n := range pl.RemoveNodes {
+		removed[n] = true
+	}
+
+	// bridges used before the changes, deleted at the end if they are empty
+	bridges := make(map[string]bool, 16)
+	for _, l := range append(pl.RemoveLinks, pl.ChangedLinks...) {
+		bridges[l.Bridge] = true
+		if err := removeLink(m, l, removed); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range pl.RemoveNodes {
+		fmt.Println("Removing", name)
+		if err := removeContainer(name); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		delete(m, name)
+	}
+
+	added := make([]*Router, 0, len(pl.AddNodes))
+	for _, name := range pl.AddNodes {
+		n, ok := p.FindNode(name)
+		if !ok {
+			return fmt.Errorf("node %s not found", name)
+		}
+		fmt.Println("Creating", name)
+		if n.IsRouter() {
+			err = n.Router.StartContainer(n.ConfigPath())
+			added = append(added, n.Router)
+		} else {
+			err = n.Host.StartContainer()
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+
+	b, err := p.linkBackend()
+	if err != nil {
+		return err
+	}
+	for _, l := range append(pl.AddLinks, pl.ChangedLinks...) {
+		if err := addLink(b, m, l); err != nil {
+			return err
+		}
+	}
+	if err := b.Flush(); err != nil {
+		return err
+	}
+
+	for _, r := range added {
+		if err := r.StartFRR(); err != nil {
+			utils.PrintError(err)
+		}
+	}
+
+	for _, name := range pl.Reload {
+		n, ok := p.FindNode(name)
+		if !ok || !n.IsRouter() {
+			continue
+		}
+		fmt.Println("Reloading", name)
+		if err := n.Router.CopyConfig(n.ConfigPath()); err != nil {
+			utils.PrintError(err)
+			continue
+		}
+		if err := n.Router.HotReload(); err != nil {
+			utils.PrintError(err)
+		}
+	}
+
+	for _, l := range st.Links {
+		delete(bridges, l.Bridge)
+	}
+	for _, ifaces := range m {
+		for _, i := range ifaces {
+			delete(bridges, i.Bridge)
+		}
+	}
+	for br := range bridges {
+		if err := b.RemoveSegment(br); err != nil {
+			utils.PrintError(br+":", err)
+		}
+	}
+
+	p.AllLinks = m
+	if err := p.saveLinks(); err != nil {
+		return err
+	}
+	return st.Save()
+}
@@ -0,0 +1,112 @@
+package project
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rahveiz/topomate/internal/link"
+	"github.com/rahveiz/topomate/internal/ovsdocker"
+	"github.com/rahveiz/topomate/utils"
+)
+
+// newLinkBackend returns a link backend by its name
+var newLinkBackend = link.NewBackend
+
+// linkBackend returns the backend used to create the links of the project
+func (p *Project) linkBackend() (link.Backend, error) {
+	return newLinkBackend(p.LinkBackend)
+}
+
+// sharedInternalBridge returns true if the internal links of an AS share
+// an OVS bridge, the traffic being forwarded with OpenFlow rules
+func (p *Project) sharedInternalBridge() bool {
+	return link.SupportsFlows(p.LinkBackend)
+}
+
+func (e LinkEnd) endpoint() link.Endpoint {
+	return link.Endpoint{
+		Container: e.ContainerName,
+		IfName:    e.IfName,
+		Settings:  e.Settings,
+	}
+}
+
+// hasInterface returns true if the interface of e is saved in m on the
+// segment
+func hasInterface(m ovsdocker.OVSBulk, segment string, e LinkEnd) bool {
+	for _, i := range m[e.ContainerName] {
+		if i.ContainerIface == e.IfName && i.Bridge == segment {
+			return true
+		}
+	}
+	return false
+}
+
+// connectLink creates a link with the backend b and saves the interfaces in
+// m. Shared ends are attached to the segment only once. b must be flushed
+// once all the links are created.
+func connectLink(b link.Backend, l ProjectLink, m ovsdocker.OVSBulk) error {
+	if l.A.Shared || l.B.Shared {
+		for _, e := range []LinkEnd{l.A, l.B} {
+			if e.Shared && hasInterface(m, l.Bridge, e) {
+				continue
+			}
+			i, err := b.Attach(l.Bridge, e.endpoint())
+			if err != nil {
+				return err
+			}
+			m[e.ContainerName] = append(m[e.ContainerName], i)
+		}
+	} else {
+		ifaces, err := b.Connect(l.Bridge, l.A.endpoint(), l.B.endpoint())
+		if err != nil {
+			return err
+		}
+		m[l.A.ContainerName] = append(m[l.A.ContainerName], ifaces[0])
+		m[l.B.ContainerName] = append(m[l.B.ContainerName], ifaces[1])
+	}
+	if l.Flows {
+		return link.QueueFlow(b, l.Bridge, l.A.ContainerName, l.A.IfName, l.B.ContainerName, l.B.IfName)
+	}
+	return nil
+}
+
+// applyLinks creates the links of the given kind with the backend of the
+// project. It stops at the first error or when ctx is cancelled.
+func (p *Project) applyLinks(ctx context.Context, kind string) error {
+	b, err := p.linkBackend()
+	if err != nil {
+		return err
+	}
+	for _, l := range p.ListLinks() {
+		if l.Kind != kind {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := connectLink(b, l, p.AllLinks); err != nil {
+			return fmt.Errorf("%s: %w", l.String(), err)
+		}
+	}
+	return b.Flush()
+}
+
+// removeLinks removes the segments of the links of the given kind
+func (p *Project) removeLinks(kind string) {
+	b, err := p.linkBackend()
+	if err != nil {
+		utils.PrintError(err)
+		return
+	}
+	done := make(map[string]bool, 16)
+	for _, l := range p.ListLinks() {
+		if l.Kind != kind || done[l.Bridge] {
+			continue
+		}
+		done[l.Bridge] = true
+		if err := b.RemoveSegment(l.Bridge); err != nil {
+			utils.PrintError(l.Bridge+":", err)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package project
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -9,11 +10,12 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
-	"sync"
 
 	"github.com/apparentlymart/go-cidr/cidr"
 	"github.com/rahveiz/topomate/config"
+	"github.com/rahveiz/topomate/internal/journal"
 	"github.com/rahveiz/topomate/internal/link"
+	"github.com/rahveiz/topomate/internal/orchestrator"
 	"github.com/rahveiz/topomate/internal/ovsdocker"
 	"github.com/rahveiz/topomate/utils"
 	"github.com/spf13/viper"
@@ -29,6 +31,10 @@ type Project struct {
 	IXPs     []IXP
 	RPKI     map[string]RPKIServer
 	AllLinks ovsdocker.OVSBulk
+	// LinkBackend is the name of the backend used to create links
+	LinkBackend string
+	// Traffic contains the flows generated by the traffic command
+	Traffic []TrafficFlow
 }
 
 type RPKIServer struct {
@@ -67,10 +73,15 @@ func ReadConfig(path string) *Project {
 	nbAS := len(conf.AS)
 
 	// Create a project
+	if _, err := link.NewBackend(conf.LinkBackend); err != nil {
+		utils.Fatalln(err)
+	}
+
 	proj := &Project{
-		Name: conf.Name,
-		AS:   make(map[int]*AutonomousSystem, nbAS),
-		Ext:  make([]*ExternalLink, 0, 128),
+		Name:        conf.Name,
+		LinkBackend: conf.LinkBackend,
+		AS:          make(map[int]*AutonomousSystem, nbAS),
+		Ext:         make([]*ExternalLink, 0, 128),
 	}
 
 	// Iterate on AS elements from the config to fill the project
@@ -127,6 +138,7 @@ func ReadConfig(path string) *Project {
 				ID:            id,
 				Hostname:      host,
 				ContainerName: "AS" + strconv.Itoa(k.ASN) + "-" + host,
+				MPLS:          k.MPLS,
 				NextInterface: 0,
 				Neighbors:     make(map[string]*BGPNbr, k.NumRouters+nbAS),
 			}
@@ -300,6 +312,13 @@ func ReadConfig(path string) *Project {
 
 	/******************************* RPKI setup *******************************/
 	proj.parseRPKIConfig(conf.RPKI)
+
+	/****************************** Traffic flows *****************************/
+	proj.parseTraffic(conf.Traffic)
+
+	for _, m := range proj.MTUMismatches() {
+		utils.PrintError("Warning: MTU mismatch:", m)
+	}
 	return proj
 }
 
@@ -338,326 +357,203 @@ func (p *Project) Print() {
 }
 
 // StartAll starts all containers (creates them before if needed) with the configurations
-// present the configuration directory, and apply links
-func (p *Project) StartAll(linksFlag string) {
-	var wg sync.WaitGroup
-
-	reloadReady := make(chan struct{}) // will be used to trigger a config reload
-	wgTotal := len(p.IXPs)
-	wg.Add(len(p.IXPs))
-	for asn, v := range p.AS {
-		totalContainers := v.TotalContainers()
-		wg.Add(totalContainers + len(v.Hosts))
-		wgTotal += totalContainers
-		// Create containers for provider routers
-		for i := 0; i < len(v.Routers); i++ {
-			configPath := fmt.Sprintf(
-				"%s/conf_%d_%s",
-				utils.GetDirectoryFromKey("ConfigDir", ""),
-				asn,
-				v.Routers[i].Hostname,
-			)
-			go func(r Router, wg *sync.WaitGroup, path string) {
-				r.StartContainer(nil, path)
-				wg.Done()
-				<-reloadReady // wait until links are applied
-				r.StartFRR()
-				wg.Done()
-			}(*v.Routers[i], &wg, configPath)
-		}
+// present the configuration directory, and apply links. At most config.Jobs
+// containers are handled at the same time, and nothing is started once ctx
+// is cancelled.
+// The created resources are recorded in a journal, and removed if the start
+// fails (unless config.KeepPartial is set, CleanupJournal removes them).
+func (p *Project) StartAll(ctx context.Context, linksFlag string) error {
+	var j *journal.Journal
+	if !utils.DryRun() {
+		j = p.openJournal()
+		defer journal.SetActive(nil)
+	}
+
+	saved := false
+	err := p.startGraph(linksFlag, &saved).Run(ctx, config.Jobs)
+	if j == nil {
+		return err
+	}
+	if err == nil {
+		j.Remove()
+		return nil
+	}
+	j.Close()
+
+	if config.KeepPartial {
+		return fmt.Errorf("%v\nThe created resources are recorded in %s, remove them with: topomate cleanup -p %s",
+			err, j.Path(), p.Name)
+	}
+	fmt.Fprintln(os.Stderr, "Start failed, removing the created resources...")
+	if saved {
+		removeSavedFiles()
+	}
+	if rerr := CleanupJournal(p.Name); rerr != nil {
+		return fmt.Errorf("%v\n%v\nThe remaining resources are recorded in %s", err, rerr, j.Path())
+	}
+	return err
+}
 
-		// Create containers for customers
-		for i := 0; i < len(v.VPN); i++ {
-			for j := 0; j < len(v.VPN[i].Customers); j++ {
-				configPath := fmt.Sprintf(
-					"%s/conf_cust_%s",
-					utils.GetDirectoryFromKey("ConfigDir", ""),
-					v.VPN[i].Customers[j].Router.Hostname,
-				)
-				go func(r Router, wg *sync.WaitGroup, path string) {
-					r.StartContainer(nil, path)
-					wg.Done()
-					<-reloadReady // wait until links are applied
-					r.StartFRR()
-					wg.Done()
-				}(*v.VPN[i].Customers[j].Router, &wg, configPath)
+// startGraph returns the tasks starting the project. saved is set once the
+// links and the running state are saved.
+func (p *Project) startGraph(linksFlag string, saved *bool) *orchestrator.Graph {
+	g := orchestrator.New()
+	nodes := p.Nodes()
+
+	// Containers are started first, then the links are applied once all
+	// containers are running, and FRR is started on each router.
+	containers := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		n := n
+		task := "container " + n.ContainerName
+		containers = append(containers, task)
+		g.Add(task, func(context.Context) error {
+			if n.IsRouter() {
+				return n.Router.StartContainer(n.ConfigPath())
+			}
+			return n.Host.StartContainer()
+		})
+	}
+
+	g.Add("links", func(ctx context.Context) error {
+		if config.VFlag {
+			fmt.Println("Applying links...")
+		}
+		if err := p.applyLinksFlag(ctx, linksFlag); err != nil {
+			return err
+		}
+		if !utils.DryRun() {
+			if err := p.saveLinks(); err != nil {
+				return err
 			}
+			p.saveState(linksFlag)
+			*saved = true
 		}
+		return nil
+	}, containers...)
 
-		// Create containers for other hosts
-		for i := 0; i < len(v.Hosts); i++ {
-			go func(h Host, wg *sync.WaitGroup) {
-				h.StartContainer(nil)
-				wg.Done()
-			}(*v.Hosts[i], &wg)
+	for _, n := range nodes {
+		if !n.IsRouter() {
+			continue
 		}
+		r := n.Router
+		g.Add("frr "+n.ContainerName, func(context.Context) error {
+			return r.StartFRR()
+		}, "links")
 	}
-	// Create containers for IXPs
-	for i := 0; i < len(p.IXPs); i++ {
-		configPath := fmt.Sprintf(
-			"%s/conf_%d_%s",
-			utils.GetDirectoryFromKey("ConfigDir", ""),
-			p.IXPs[i].ASN,
-			p.IXPs[i].RouteServer.Hostname,
-		)
-		go func(r Router, wg *sync.WaitGroup, path string) {
-			r.StartContainer(nil, path)
-			wg.Done()
-			<-reloadReady // wait until links are applied
-			r.StartFRR()
-			wg.Done()
-		}(*p.IXPs[i].RouteServer, &wg, configPath)
-	}
-	wg.Wait()
 
-	if config.VFlag {
-		fmt.Println("Applying links with OVS...")
-	}
+	return g
+}
 
+// applyLinksFlag applies the links selected by linksFlag (all, internal,
+// external or none)
+func (p *Project) applyLinksFlag(ctx context.Context, linksFlag string) error {
 	p.AllLinks = make(ovsdocker.OVSBulk, 1024)
 	// currently, internal links must be applied in priority
+	var apply []func(context.Context) error
 	switch strings.ToLower(linksFlag) {
 	case "internal":
-		p.ApplyInternalLinks()
-		p.ApplyHostLinks()
-		break
+		apply = append(apply, p.ApplyInternalLinks, p.ApplyHostLinks)
 	case "external":
-		p.ApplyExternalLinks()
-		p.ApplyIXPLinks()
-		break
+		apply = append(apply, p.ApplyExternalLinks, p.ApplyIXPLinks)
 	case "none":
-		break
 	default:
-		p.ApplyInternalLinks()
-		p.ApplyHostLinks()
-		p.ApplyExternalLinks()
-		p.ApplyIXPLinks()
-		break
+		apply = append(apply, p.ApplyInternalLinks, p.ApplyHostLinks,
+			p.ApplyExternalLinks, p.ApplyIXPLinks)
 	}
-	wg.Add(wgTotal)
-	close(reloadReady) // trigger configuration reload
-	p.saveLinks()
-	wg.Wait()
-}
-
-// StopAll stops all containers and removes all links
-func (p *Project) StopAll() {
-	var wg sync.WaitGroup
-	wg.Add(len(p.IXPs))
-	for asn, v := range p.AS {
-		wg.Add(v.TotalContainers() + len(v.Hosts))
-		// Provider
-		for i := 0; i < len(v.Routers); i++ {
-			configPath := fmt.Sprintf(
-				"%s/conf_%d_%s",
-				utils.GetDirectoryFromKey("ConfigDir", ""),
-				asn,
-				v.Routers[i].Hostname,
-			)
-			go func(r Router, wg *sync.WaitGroup, path string) {
-				r.StopContainer(nil, path)
-				wg.Done()
-			}(*v.Routers[i], &wg, configPath)
+	for _, fn := range apply {
+		if err := fn(ctx); err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		// Customers
-		for i := 0; i < len(v.VPN); i++ {
-			for j := 0; j < len(v.VPN[i].Customers); j++ {
-				configPath := fmt.Sprintf(
-					"%s/conf_cust_%s",
-					utils.GetDirectoryFromKey("ConfigDir", ""),
-					v.VPN[i].Customers[j].Router.Hostname,
-				)
-				go func(r Router, wg *sync.WaitGroup, path string) {
-					r.StopContainer(nil, path)
-					wg.Done()
-				}(*v.VPN[i].Customers[j].Router, &wg, configPath)
+// saveState saves the running state of the project, keeping only the links
+// that have been applied
+func (p *Project) saveState(linksFlag string) {
+	st := p.State(nil)
+	links := st.Links[:0]
+	for _, l := range st.Links {
+		switch strings.ToLower(linksFlag) {
+		case "internal":
+			if l.Kind != LinkInternal && l.Kind != LinkHost {
+				continue
 			}
+		case "external":
+			if l.Kind != LinkExternal && l.Kind != LinkIXP {
+				continue
+			}
+		case "none":
+			continue
 		}
+		links = append(links, l)
+	}
+	st.Links = links
+	if err := st.Save(); err != nil {
+		utils.PrintError("cannot save the running state:", err)
+	}
+}
 
-		for i := 0; i < len(v.Hosts); i++ {
-			go func(h Host, wg *sync.WaitGroup) {
-				h.StopContainer(nil)
-				wg.Done()
-			}(*v.Hosts[i], &wg)
-		}
+// StopAll stops all containers and removes all links
+func (p *Project) StopAll(ctx context.Context) error {
+	g := orchestrator.New()
+	for _, n := range p.Nodes() {
+		n := n
+		g.Add("container "+n.ContainerName, func(context.Context) error {
+			switch {
+			case n.Role == RoleRS:
+				return n.Router.StopContainer("")
+			case n.IsRouter():
+				return n.Router.StopContainer(n.ConfigPath())
+			default:
+				return n.Host.StopContainer()
+			}
+		})
 	}
-	for i := 0; i < len(p.IXPs); i++ {
-		go func(r Router, wg *sync.WaitGroup, path string) {
-			r.StopContainer(nil, path)
-			wg.Done()
-		}(*p.IXPs[i].RouteServer, &wg, "")
+	err := g.Run(ctx, config.Jobs)
+	if ctx.Err() != nil {
+		return err
 	}
-	wg.Wait()
+
+	// links are removed even if some containers could not be stopped
 	p.RemoveInternalLinks()
 	p.RemoveExternalLinks()
 	p.RemoveIXPLinks()
 	p.RemoveHostLinks()
-	os.Remove(utils.GetDirectoryFromKey("MainDir", "") + "/links.json")
-}
-
-func setupContainerLinks(brName string, links []Link, m ovsdocker.OVSBulk) {
-
-	// Create an OVS bridge
-	link.CreateBridge(brName)
-
-	// Prepare a slice for bulk add to the OVS bridge (better performances)
-	// res := make([]ovsdocker.OVSInterface, 0, len(links))
-
-	hostIf := &ovsdocker.OVSInterface{}
-
-	settings := ovsdocker.DefaultParams()
-	settings.OFPort = 1
-	for _, v := range links {
-		idA := v.First.Router.ContainerName
-		idB := v.Second.Router.ContainerName
-		ifA := v.First.Interface.IfName
-		ifB := v.Second.Interface.IfName
-
-		settings.Speed = v.First.Interface.Speed
-		settings.VRF = v.First.Interface.VRF
-
-		link.AddPortToContainer(brName, ifA, idA, settings, hostIf, false)
-		// res = append(res, *hostIf)
-		if _, ok := m[idA]; !ok {
-			m[idA] = make([]ovsdocker.OVSInterface, 0, len(links))
-		}
-		m[idA] = append(m[idA], *hostIf)
-		settings.OFPort++
-
-		settings.Speed = v.Second.Interface.Speed
-		settings.VRF = v.Second.Interface.VRF
-		link.AddPortToContainer(brName, ifB, idB, settings, hostIf, false)
-		// res = append(res, *hostIf)
-		if _, ok := m[idB]; !ok {
-			m[idB] = make([]ovsdocker.OVSInterface, 0, len(links))
-		}
-		m[idB] = append(m[idB], *hostIf)
-		settings.OFPort++
-	}
-	// return res
-}
-
-func applyFlow(brName string, links []Link) {
-	for _, v := range links {
-		idA := v.First.Router.ContainerName
-		idB := v.Second.Router.ContainerName
-		ifA := v.First.Interface.IfName
-		ifB := v.Second.Interface.IfName
-		link.AddFlow(brName, idA, ifA, idB, ifB)
+	if utils.DryRun() {
+		return err
 	}
+	removeSavedFiles()
+	return err
 }
 
 // ApplyInternalLinks creates all internal links for each AS of the project
-func (p *Project) ApplyInternalLinks() {
-	for n, as := range p.AS {
-		// Create bridge with name "int-<ASN>"
-		brName := fmt.Sprintf("int-%d", n)
-		// Setup container links
-		setupContainerLinks(brName, as.Links, p.AllLinks)
-	}
-	// Link host interfaces to OVS bridges
-	ovsdocker.AddToBridgeBulk(p.AllLinks)
-
-	// Apply OpenFlow rules to the bridges
-	for n, as := range p.AS {
-		brName := fmt.Sprintf("int-%d", n)
-		applyFlow(brName, as.Links)
-	}
+func (p *Project) ApplyInternalLinks(ctx context.Context) error {
+	return p.applyLinks(ctx, LinkInternal)
 }
 
 // RemoveInternalLinks removes all internal links of the project
 func (p *Project) RemoveInternalLinks() {
-	for n := range p.AS {
-		link.DeleteBridge(fmt.Sprintf("int-%d", n))
-	}
+	p.removeLinks(LinkInternal)
 }
 
 // ApplyExternalLinks creates all external links between the different AS
-func (p *Project) ApplyExternalLinks() {
-	for _, v := range p.Ext {
-
-		brName := fmt.Sprintf("ext-%d%s-%d%s",
-			v.From.ASN,
-			v.From.Router.Hostname,
-			v.To.ASN,
-			v.To.Router.Hostname,
-		)
-
-		link.CreateBridge(brName)
-		settings := ovsdocker.DefaultParams()
-		hostIf := ovsdocker.OVSInterface{}
-
-		settings.Speed = v.From.Interface.Speed
-		link.AddPortToContainer(brName, v.From.Interface.IfName, v.From.Router.ContainerName, settings, &hostIf, true)
-		if _, ok := p.AllLinks[v.From.Router.ContainerName]; !ok {
-			p.AllLinks[v.From.Router.ContainerName] = make([]ovsdocker.OVSInterface, 0, len(p.Ext))
-		}
-		p.AllLinks[v.From.Router.ContainerName] = append(p.AllLinks[v.From.Router.ContainerName], hostIf)
-
-		settings.Speed = v.To.Interface.Speed
-		link.AddPortToContainer(brName, v.To.Interface.IfName, v.To.Router.ContainerName, settings, &hostIf, true)
-
-		if _, ok := p.AllLinks[v.To.Router.ContainerName]; !ok {
-			p.AllLinks[v.To.Router.ContainerName] = make([]ovsdocker.OVSInterface, 0, len(p.Ext))
-		}
-		p.AllLinks[v.To.Router.ContainerName] = append(p.AllLinks[v.To.Router.ContainerName], hostIf)
-	}
+func (p *Project) ApplyExternalLinks(ctx context.Context) error {
+	return p.applyLinks(ctx, LinkExternal)
 }
 
 // RemoveExternalLinks removes all external links
 func (p *Project) RemoveExternalLinks() {
-	for _, v := range p.Ext {
-		brName := fmt.Sprintf("ext-%d%s-%d%s",
-			v.From.ASN,
-			v.From.Router.Hostname,
-			v.To.ASN,
-			v.To.Router.Hostname,
-		)
-
-		link.DeleteBridge(brName)
-	}
+	p.removeLinks(LinkExternal)
 }
 
-func (p *Project) ApplyHostLinks() {
-	for n, as := range p.AS {
-		for _, v := range as.HostLinks {
-			brName := fmt.Sprintf("AS%d-%s-%s", n, v.Router.Router.Hostname, v.Host.Host.Hostname)
-			link.CreateBridge(brName)
-			settings := ovsdocker.DefaultParams()
-			hostIf := ovsdocker.OVSInterface{}
-
-			settings.Speed = v.Router.Interface.Speed
-			link.AddPortToContainer(brName, v.Router.Interface.IfName, v.Router.Router.ContainerName, settings, &hostIf, true)
-			if _, ok := p.AllLinks[v.Router.Router.ContainerName]; !ok {
-				p.AllLinks[v.Router.Router.ContainerName] = make([]ovsdocker.OVSInterface, 0, len(p.Ext))
-			}
-			p.AllLinks[v.Router.Router.ContainerName] = append(p.AllLinks[v.Router.Router.ContainerName], hostIf)
-
-			settings.Speed = v.Host.Interface.Speed
-			settings.IP = v.Host.Interface.IP.String()
-			settings.Routes = []ovsdocker.IPRoute{{
-				IP:     "0.0.0.0/0",
-				Via:    v.Router.Interface.IP.IP.String(),
-				IfName: v.Host.Interface.IfName,
-			}}
-			link.AddPortToContainer(brName, v.Host.Interface.IfName, v.Host.Host.ContainerName, settings, &hostIf, true)
-
-			if _, ok := p.AllLinks[v.Host.Host.ContainerName]; !ok {
-				p.AllLinks[v.Host.Host.ContainerName] = make([]ovsdocker.OVSInterface, 0, len(p.Ext))
-			}
-			p.AllLinks[v.Host.Host.ContainerName] = append(p.AllLinks[v.Host.Host.ContainerName], hostIf)
-		}
-	}
+func (p *Project) ApplyHostLinks(ctx context.Context) error {
+	return p.applyLinks(ctx, LinkHost)
 }
 
 func (p *Project) RemoveHostLinks() {
-	for n, as := range p.AS {
-		for _, v := range as.HostLinks {
-			brName := fmt.Sprintf("AS%d-%s-%s", n, v.Router.Router.Hostname, v.Host.Host.Hostname)
-			link.DeleteBridge(brName)
-		}
-	}
+	p.removeLinks(LinkHost)
 }
 
 func (p *Project) linkExternal() {
@@ -759,22 +655,17 @@ func getRouteMaps(relation int, inMaps []string, outMaps []string) ([]string, []
 	return append(in, inMaps...), append(out, outMaps...)
 }
 
-func (p *Project) saveLinks() {
-	// Save the interfaces configuration in json for restarts
+// saveLinks saves the interfaces configuration in json for restarts
+func (p *Project) saveLinks() error {
 	j, err := json.Marshal(p.AllLinks)
 	if err != nil {
-		utils.Fatalln(err)
+		return err
 	}
-	f, err := os.Create(utils.GetDirectoryFromKey("MainDir", "") + "/links.json")
-	if err != nil {
-		utils.Fatalln(err)
-	}
-	defer f.Close()
-	f.Write(j)
-	f2, err := os.Create(utils.GetDirectoryFromKey("ConfigDir", "") + "/links.json")
-	if err != nil {
-		utils.Fatalln(err)
+	for _, key := range []string{"MainDir", "ConfigDir"} {
+		path := utils.GetDirectoryFromKey(key, "") + "/links.json"
+		if err := ioutil.WriteFile(path, j, 0644); err != nil {
+			return fmt.Errorf("saveLinks: %w", err)
+		}
 	}
-	defer f2.Close()
-	f2.Write(j)
+	return nil
 }
@@ -0,0 +1,233 @@
+package project
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rahveiz/topomate/internal/link"
+	"github.com/rahveiz/topomate/internal/ovsdocker"
+)
+
+// Link kinds
+const (
+	LinkInternal = "internal"
+	LinkExternal = "external"
+	LinkIXP      = "ixp"
+	LinkHost     = "host"
+)
+
+// LinkEnd is an end of a link, identified by its container and interface
+type LinkEnd struct {
+	ContainerName string
+	IfName        string
+	// Shared is true if the interface is also used by other links (the
+	// route server interface on an IXP)
+	Shared bool
+	// Settings used to create the port
+	Settings ovsdocker.PortSettings
+}
+
+func (e LinkEnd) String() string {
+	return e.ContainerName + ":" + e.IfName
+}
+
+// ProjectLink is a point-to-point link of the project. On an IXP, each peer
+// is linked to the route server.
+type ProjectLink struct {
+	A, B   LinkEnd
+	Kind   string
+	Bridge string
+	// Flows is true if the traffic is forwarded using OpenFlow rules
+	Flows bool
+}
+
+func (l ProjectLink) String() string {
+	return l.A.String() + " <-> " + l.B.String()
+}
+
+// portSettings returns the settings used to create the port of an interface
+func portSettings(iface *NetInterface) ovsdocker.PortSettings {
+	settings := ovsdocker.DefaultParams()
+	settings.Speed = iface.Speed
+	settings.Netem = iface.Netem
+	settings.MTU = iface.MTU
+	return settings
+}
+
+// coreMPLS returns true if MPLS is enabled on the interface of the router
+// r. Only core interfaces of routers of MPLS AS forward labels: neither the
+// PE interfaces linked to customers nor the customer routers, whose
+// namespace has no labels (see Router.MPLS).
+func coreMPLS(r *Router, iface *NetInterface) bool {
+	return r.MPLS && !iface.External
+}
+
+// ListLinks returns all the links of the project, with the settings used by
+// the Apply*Links functions
+func (p *Project) ListLinks() []ProjectLink {
+	res := make([]ProjectLink, 0, 64)
+	for _, asn := range p.sortedASN() {
+		as := p.AS[asn]
+		for _, l := range as.Links {
+			a := LinkEnd{ContainerName: l.First.Router.ContainerName, IfName: l.First.Interface.IfName,
+				Settings: portSettings(l.First.Interface)}
+			a.Settings.VRF = l.First.Interface.VRF
+			a.Settings.MPLS = coreMPLS(l.First.Router, l.First.Interface)
+			b := LinkEnd{ContainerName: l.Second.Router.ContainerName, IfName: l.Second.Interface.IfName,
+				Settings: portSettings(l.Second.Interface)}
+			b.Settings.VRF = l.Second.Interface.VRF
+			b.Settings.MPLS = coreMPLS(l.Second.Router, l.Second.Interface)
+			pl := ProjectLink{
+				A:      a,
+				B:      b,
+				Kind:   LinkInternal,
+				Bridge: fmt.Sprintf("int-%d", asn),
+				Flows:  true,
+			}
+			if !p.sharedInternalBridge() {
+				// one segment per link without OVS
+				pl.Bridge = fmt.Sprintf("int-%d-%s-%s", asn, a, b)
+				pl.Flows = false
+			}
+			res = append(res, pl)
+		}
+		for _, l := range as.HostLinks {
+			b := LinkEnd{ContainerName: l.Host.Host.ContainerName, IfName: l.Host.Interface.IfName,
+				Settings: portSettings(l.Host.Interface)}
+			b.Settings.IP = l.Host.Interface.IP.String()
+			b.Settings.Routes = []ovsdocker.IPRoute{{
+				IP:     "0.0.0.0/0",
+				Via:    l.Router.Interface.IP.IP.String(),
+				IfName: l.Host.Interface.IfName,
+			}}
+			res = append(res, ProjectLink{
+				A: LinkEnd{ContainerName: l.Router.Router.ContainerName, IfName: l.Router.Interface.IfName,
+					Settings: portSettings(l.Router.Interface)},
+				B:      b,
+				Kind:   LinkHost,
+				Bridge: fmt.Sprintf("AS%d-%s-%s", asn, l.Router.Router.Hostname, l.Host.Host.Hostname),
+			})
+		}
+	}
+	for _, l := range p.Ext {
+		res = append(res, ProjectLink{
+			A: LinkEnd{ContainerName: l.From.Router.ContainerName, IfName: l.From.Interface.IfName,
+				Settings: portSettings(l.From.Interface)},
+			B: LinkEnd{ContainerName: l.To.Router.ContainerName, IfName: l.To.Interface.IfName,
+				Settings: portSettings(l.To.Interface)},
+			Kind: LinkExternal,
+			Bridge: fmt.Sprintf("ext-%d%s-%d%s",
+				l.From.ASN, l.From.Router.Hostname, l.To.ASN, l.To.Router.Hostname),
+		})
+	}
+	for _, ixp := range p.IXPs {
+		rs := ixp.Links[0]
+		for _, l := range ixp.Links[1:] {
+			res = append(res, ProjectLink{
+				A: LinkEnd{ContainerName: l.Router.ContainerName, IfName: l.Interface.IfName,
+					Settings: portSettings(l.Interface)},
+				B: LinkEnd{ContainerName: rs.Router.ContainerName, IfName: rs.Interface.IfName,
+					Settings: portSettings(rs.Interface), Shared: true},
+				Kind:   LinkIXP,
+				Bridge: fmt.Sprintf("ixp-%d", ixp.ASN),
+			})
+		}
+	}
+	return res
+}
+
+// parseEndpoint parses an endpoint using the format <node>[:<interface>]
+func (p *Project) parseEndpoint(s string) (string, string, error) {
+	name, ifName := s, ""
+	if idx := strings.LastIndex(s, ":"); idx >= 0 {
+		name, ifName = s[:idx], s[idx+1:]
+	}
+	n, ok := p.FindNode(name)
+	if !ok {
+		return "", "", fmt.Errorf("node %s not found", name)
+	}
+	return n.ContainerName, ifName, nil
+}
+
+// FindLink returns the link between the endpoints a and b, using the format
+// <node>[:<interface>]. The interface is needed only if the nodes are linked
+// more than once.
+func (p *Project) FindLink(a, b string) (ProjectLink, error) {
+	cA, ifA, err := p.parseEndpoint(a)
+	if err != nil {
+		return ProjectLink{}, err
+	}
+	cB, ifB, err := p.parseEndpoint(b)
+	if err != nil {
+		return ProjectLink{}, err
+	}
+	match := func(e LinkEnd, c, ifName string) bool {
+		return e.ContainerName == c && (ifName == "" || e.IfName == ifName)
+	}
+
+	res := make([]ProjectLink, 0, 1)
+	for _, l := range p.ListLinks() {
+		switch {
+		case match(l.A, cA, ifA) && match(l.B, cB, ifB):
+			res = append(res, l)
+		case match(l.A, cB, ifB) && match(l.B, cA, ifA):
+			res = append(res, ProjectLink{A: l.B, B: l.A, Kind: l.Kind, Bridge: l.Bridge, Flows: l.Flows})
+		}
+	}
+	switch len(res) {
+	case 0:
+		return ProjectLink{}, fmt.Errorf("no link between %s and %s", a, b)
+	case 1:
+		return res[0], nil
+	default:
+		return ProjectLink{}, fmt.Errorf("%d links between %s and %s, specify the interfaces (<node>:<interface>)", len(res), a, b)
+	}
+}
+
+// LinkPorts returns the ports on the host of the link between the endpoints a
+// and b of a running topology, and whether the link uses OpenFlow rules
+func (p *Project) LinkPorts(a, b string) (link.Port, link.Port, bool, error) {
+	l, err := p.FindLink(a, b)
+	if err != nil {
+		return link.Port{}, link.Port{}, false, err
+	}
+	m, err := link.ReadSaved()
+	if err != nil {
+		return link.Port{}, link.Port{}, false, err
+	}
+	pA, err := link.FindPort(m, l.A.ContainerName, l.A.IfName)
+	if err != nil {
+		return link.Port{}, link.Port{}, false, err
+	}
+	pB, err := link.FindPort(m, l.B.ContainerName, l.B.IfName)
+	if err != nil {
+		return link.Port{}, link.Port{}, false, err
+	}
+	pA.Shared, pB.Shared = l.A.Shared, l.B.Shared
+	return pA, pB, l.Flows, nil
+}
+
+// InterfacePort returns the port on the host of an interface of a running
+// topology, using the format <node>[:<interface>]. The interface is needed
+// only if the node has more than one link.
+func (p *Project) InterfacePort(endpoint string) (link.Port, error) {
+	c, ifName, err := p.parseEndpoint(endpoint)
+	if err != nil {
+		return link.Port{}, err
+	}
+	m, err := link.ReadSaved()
+	if err != nil {
+		return link.Port{}, err
+	}
+	if ifName == "" {
+		switch len(m[c]) {
+		case 0:
+			return link.Port{}, fmt.Errorf("%s has no link", endpoint)
+		case 1:
+			ifName = m[c][0].ContainerIface
+		default:
+			return link.Port{}, fmt.Errorf("%s has %d links, specify the interface (<node>:<interface>)", endpoint, len(m[c]))
+		}
+	}
+	return link.FindPort(m, c, ifName)
+}
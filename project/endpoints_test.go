@@ -0,0 +1,38 @@
+package project
+
+import "testing"
+
+func TestCoreMPLS(t *testing.T) {
+	p1 := &Router{ID: 1, Hostname: "R1", ContainerName: "AS1-R1", MPLS: true}
+	p2 := &Router{ID: 2, Hostname: "R2", ContainerName: "AS1-R2", MPLS: true}
+	ce := &Router{ID: 1, Hostname: "C1", ContainerName: "AS1-Cust-C1"}
+
+	core := Link{First: NewLinkItem(p1), Second: NewLinkItem(p2)}
+	customer := Link{First: NewLinkItem(p1), Second: NewLinkItem(ce)}
+	customer.First.Interface.External = true
+
+	p := &Project{AS: map[int]*AutonomousSystem{1: {
+		ASN:     1,
+		MPLS:    true,
+		Routers: []*Router{p1, p2},
+		Links:   []Link{core, customer},
+	}}}
+
+	want := map[string]bool{
+		"AS1-R1:eth0":      true,
+		"AS1-R2:eth0":      true,
+		"AS1-R1:eth1":      false,
+		"AS1-Cust-C1:eth0": false,
+	}
+	got := make(map[string]bool, len(want))
+	for _, l := range p.ListLinks() {
+		for _, e := range []LinkEnd{l.A, l.B} {
+			got[e.String()] = e.Settings.MPLS
+		}
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s: MPLS = %v, want %v", k, got[k], v)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package project
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/rahveiz/topomate/internal/runtime"
+	"github.com/rahveiz/topomate/utils"
+)
+
+// DefaultParallelism is the default number of commands executed at the same
+// time when running a command on multiple nodes
+const DefaultParallelism = 16
+
+// ExecResult contains the result of a command executed on a node
+type ExecResult struct {
+	Node     string          `json:"node"`
+	Output   string          `json:"output,omitempty"`
+	JSON     json.RawMessage `json:"json,omitempty"`
+	ExitCode int             `json:"exit_code"`
+	Err      error           `json:"-"`
+}
+
+// Failed returns true if the command could not be run or returned a non-zero
+// exit code
+func (r ExecResult) Failed() bool {
+	return r.Err != nil || r.ExitCode != 0
+}
+
+// ParseJSON tries to parse the output of the command as JSON. On success,
+// the parsed document is stored in the JSON field.
+func (r *ExecResult) ParseJSON() error {
+	out := strings.TrimSpace(r.Output)
+	if !json.Valid([]byte(out)) {
+		return errors.New(r.Node + ": output is not valid JSON")
+	}
+	r.JSON = json.RawMessage(out)
+	return nil
+}
+
+// Exec runs a command inside the container of the node
+func (n Node) Exec(arg ...string) ExecResult {
+	out, code, err := runtime.Exec(n.ContainerName, arg...)
+	return ExecResult{
+		Node:     n.ContainerName,
+		Output:   out,
+		ExitCode: code,
+		Err:      err,
+	}
+}
+
+// Vtysh runs the vtysh commands inside the container of the node.
+// It fails if the node does not run FRR.
+func (n Node) Vtysh(commands ...string) ExecResult {
+	if !n.IsRouter() {
+		return ExecResult{
+			Node:     n.ContainerName,
+			ExitCode: -1,
+			Err:      errors.New(n.ContainerName + ": vtysh not available on hosts"),
+		}
+	}
+	args := make([]string, 1, 2*len(commands)+1)
+	args[0] = "vtysh"
+	for _, c := range commands {
+		args = append(args, "-c", c)
+	}
+	return n.Exec(args...)
+}
+
+// ExecAll runs fn on every node, with at most parallel executions at the same
+// time. Results are returned in the same order as nodes, a fatal error
+// raised by fn is the error of its node.
+func ExecAll(nodes []Node, parallel int, fn func(Node) ExecResult) []ExecResult {
+	res := make([]ExecResult, len(nodes))
+	parallelDo(len(nodes), parallel, func(i int) {
+		if err := utils.Catch(func() { res[i] = fn(nodes[i]) }); err != nil {
+			res[i] = ExecResult{Node: nodes[i].ContainerName, Err: err}
+		}
+	})
+	return res
+}
+
+// parallelDo calls fn for every index from 0 to n-1, with at most parallel
+// calls at the same time
+func parallelDo(n, parallel int, fn func(i int)) {
+	if parallel < 1 {
+		parallel = DefaultParallelism
+	}
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/rahveiz/topomate/config"
+	"github.com/rahveiz/topomate/internal/ovsdocker"
 	"github.com/rahveiz/topomate/utils"
 )
 
@@ -42,6 +43,7 @@ func NewExtLinkItem(asn int, router *Router) *ExternalLinkItem {
 			IfName:   ifName,
 			IP:       net.IPNet{},
 			Speed:    10000,
+			MTU:      DefaultMTU,
 			Cost:     10000,
 			External: true,
 		},
@@ -81,10 +83,28 @@ func (p *Project) parseExternal(k config.ExternalLink) {
 	default:
 		break
 	}
+	context := fmt.Sprintf("External link AS%d-AS%d", k.From.ASN, k.To.ASN)
+	l.SetNetem(mustParseNetem(k.Netem, context))
+
+	// the MTU of each end can be overridden to test mismatches
+	mtu := mustParseMTU(k.MTU, context)
+	l.From.Interface.MTU, l.To.Interface.MTU = mtu, mtu
+	if k.From.MTU != 0 {
+		l.From.Interface.MTU = mustParseMTU(k.From.MTU, context)
+	}
+	if k.To.MTU != 0 {
+		l.To.Interface.MTU = mustParseMTU(k.To.MTU, context)
+	}
 	l.setupExternal(&p.AS[k.From.ASN].Network)
 	p.Ext = append(p.Ext, l)
 }
 
+// SetNetem sets the emulated conditions on both ends of the link
+func (e *ExternalLink) SetNetem(n ovsdocker.Netem) {
+	e.From.Interface.Netem = n
+	e.To.Interface.Netem = n
+}
+
 func (e *ExternalLink) setupExternal(p *Net) {
 	if !p.AutoAddress {
 		return
@@ -7,10 +7,11 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/rahveiz/topomate/config"
 	"github.com/rahveiz/topomate/utils"
 )
 
-func (a *AutonomousSystem) internalFromFile(path string) []Link {
+func (a *AutonomousSystem) internalFromFile(path string, lm config.InternalLinks) []Link {
 	f, err := os.Open(path)
 	if err != nil {
 		utils.Fatalln("internalFromFile:", err)
@@ -24,7 +25,7 @@ func (a *AutonomousSystem) internalFromFile(path string) []Link {
 		if line[:1] == "#" {
 			continue
 		}
-		fields := strings.Fields(line)
+		fields, opts := splitFields(strings.Fields(line))
 
 		if len(fields) < 2 {
 			utils.Fatalln("internalFromFile: not enough fields (must be at least 2)")
@@ -70,6 +71,17 @@ func (a *AutonomousSystem) internalFromFile(path string) []Link {
 			l.Second.Interface.Cost = l.First.Interface.Cost
 		}
 
+		netemCfg, err := netemOptions(lm.Netem, opts)
+		if err != nil {
+			utils.Fatalf("internalFromFile: error parsing link options at line %d, %v\n", current, err)
+		}
+		l.SetNetem(mustParseNetem(netemCfg, fmt.Sprintf("internalFromFile: line %d", current)))
+		mtu, err := mtuOption(lm.MTU, opts)
+		if err != nil {
+			utils.Fatalf("internalFromFile: error parsing link options at line %d, %v\n", current, err)
+		}
+		l.SetMTU(mtu)
+
 		l.First.Interface.Description = fmt.Sprintf("linked to %s", l.Second.Router.Hostname)
 		l.Second.Interface.Description = fmt.Sprintf("linked to %s", l.First.Router.Hostname)
 		res = append(res, l)
@@ -93,7 +105,7 @@ func (p *Project) externalFromFile(path string) {
 		if line[:1] == "#" {
 			continue
 		}
-		fields := strings.Fields(line)
+		fields, opts := splitFields(strings.Fields(line))
 
 		if len(fields) < 2 {
 			utils.Fatalln("internalFromFile: not enough fields (must be at least 2)")
@@ -157,6 +169,18 @@ func (p *Project) externalFromFile(path string) {
 				break
 			}
 		}
+		netemCfg, err := netemOptions(config.NetemConfig{}, opts)
+		if err != nil {
+			utils.Fatalf("externalFromFile: error parsing link options at line %d, %v\n", current, err)
+		}
+		l.SetNetem(mustParseNetem(netemCfg, fmt.Sprintf("externalFromFile: line %d", current)))
+		mtu, err := mtuOption(0, opts)
+		if err != nil {
+			utils.Fatalf("externalFromFile: error parsing link options at line %d, %v\n", current, err)
+		}
+		l.From.Interface.MTU = mtu
+		l.To.Interface.MTU = mtu
+
 		l.setupExternal(&p.AS[fromASN].Network)
 		p.Ext = append(p.Ext, l)
 	}
@@ -0,0 +1,119 @@
+package project
+
+import (
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/rahveiz/topomate/internal/flows"
+	"github.com/rahveiz/topomate/internal/link"
+	"github.com/rahveiz/topomate/internal/ovsdocker"
+)
+
+// ExportBridges returns the OVS bridges on which the flows are exported:
+// the internal, external and IXP bridges
+func (p *Project) ExportBridges() ([]string, error) {
+	if !p.sharedInternalBridge() {
+		return nil, fmt.Errorf("flow export needs the ovs link backend (link_backend: %s)", p.LinkBackend)
+	}
+	seen := make(map[string]bool, 16)
+	res := make([]string, 0, 16)
+	for _, l := range p.ListLinks() {
+		if l.Kind == LinkHost || seen[l.Bridge] {
+			continue
+		}
+		seen[l.Bridge] = true
+		res = append(res, l.Bridge)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// LinkNames returns the name of the link of each interface. The shared
+// route server interface of an IXP is named after the IXP bridge.
+func (p *Project) LinkNames() map[string]string {
+	res := make(map[string]string, 64)
+	for _, l := range p.ListLinks() {
+		for _, e := range []LinkEnd{l.A, l.B} {
+			if e.Shared {
+				res[e.String()] = l.Bridge
+			} else {
+				res[e.String()] = l.String()
+			}
+		}
+	}
+	return res
+}
+
+// PortSpeeds returns the speed in Mbps of each interface linked
+// (<container>:<interface>)
+func (p *Project) PortSpeeds() map[string]int {
+	res := make(map[string]int, 64)
+	for _, l := range p.ListLinks() {
+		res[l.A.String()] = l.A.Settings.Speed
+		res[l.B.String()] = l.B.Settings.Speed
+	}
+	return res
+}
+
+type exportIface struct {
+	domain, iface uint32
+}
+
+// FlowResolver returns the function mapping the interfaces of the exported
+// samples to the links of a running topology. sFlow uses the ifindex of the
+// host interfaces, IPFIX the OpenFlow ports of each bridge.
+func (p *Project) FlowResolver(protocol string, bridges []string) (flows.Resolver, error) {
+	names := p.LinkNames()
+	ports := make(map[exportIface]flows.Port, 64)
+
+	switch protocol {
+	case flows.SFlow:
+		m, err := link.ReadSaved()
+		if err != nil {
+			return nil, err
+		}
+		for c, ifaces := range m {
+			for _, i := range ifaces {
+				if i.HostIface == "" {
+					continue
+				}
+				h, err := net.InterfaceByName(i.HostIface)
+				if err != nil {
+					continue
+				}
+				name := ovsdocker.PortKey(c, i.ContainerIface)
+				ports[exportIface{0, uint32(h.Index)}] = flows.Port{Name: name, Link: names[name]}
+			}
+		}
+	case flows.IPFIX:
+		info, err := ovsdocker.ListPorts()
+		if err != nil {
+			return nil, err
+		}
+		domains := make(map[string]uint32, len(bridges))
+		for i, br := range bridges {
+			domains[br] = link.ExportDomain(i)
+		}
+		for _, l := range p.ListLinks() {
+			d, ok := domains[l.Bridge]
+			if !ok {
+				continue
+			}
+			for _, e := range []LinkEnd{l.A, l.B} {
+				pi, ok := info[e.String()]
+				if !ok || pi.OFPort <= 0 {
+					continue
+				}
+				ports[exportIface{d, uint32(pi.OFPort)}] = flows.Port{Name: e.String(), Link: names[e.String()]}
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown flow export protocol %s (sflow or ipfix)", protocol)
+	}
+
+	return func(domain, iface uint32) (flows.Port, bool) {
+		port, ok := ports[exportIface{domain, iface}]
+		return port, ok
+	}, nil
+}
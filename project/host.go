@@ -1,18 +1,12 @@
 package project
 
 import (
-	"context"
 	"fmt"
 	"net"
-	"os/exec"
-	"sync"
 
-	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/filters"
-	"github.com/docker/docker/client"
 	"github.com/rahveiz/topomate/config"
-	"github.com/rahveiz/topomate/utils"
+	"github.com/rahveiz/topomate/internal/journal"
+	"github.com/rahveiz/topomate/internal/runtime"
 )
 
 type Host struct {
@@ -49,87 +43,64 @@ func NewHostLinkItem(host *Host) *HostLinkItem {
 			IfName: ifName,
 			IP:     net.IPNet{},
 			Speed:  10000,
+			MTU:    DefaultMTU,
 		},
 	}
 }
 
 // StartContainer starts the container
-func (host *Host) StartContainer(wg *sync.WaitGroup) {
-	ctx := context.Background()
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-	utils.Check(err)
-
-	if wg != nil {
-		defer wg.Done()
-	}
+func (host *Host) StartContainer() error {
+	rt := runtime.Current()
 
 	// Check if container already exists
-	var containerID string
-	flt := filters.NewArgs(filters.Arg("name", host.ContainerName))
-	li, err := cli.ContainerList(ctx, types.ContainerListOptions{
-		All:     true,
-		Filters: flt,
-	})
+	exists, err := rt.Exists(host.ContainerName)
 	if err != nil {
-		utils.Fatalln(err)
+		return err
 	}
-	if len(li) == 0 { // container does not exist yet
-		hostCfg := &container.HostConfig{
-			CapAdd: []string{"SYS_ADMIN", "NET_ADMIN"},
-		}
-		image := host.DockerImage
+	if !exists {
 		// -ssh.bind :8083
-		contCfg := &container.Config{
-			Image:           image,
-			Hostname:        host.Hostname,
-			NetworkDisabled: true,
-			Cmd:             host.Command,
+		err := rt.Create(runtime.Spec{
+			Name:     host.ContainerName,
+			Image:    host.DockerImage,
+			Hostname: host.Hostname,
+			Cmd:      host.Command,
+			CapAdd:   []string{"SYS_ADMIN", "NET_ADMIN"},
+		})
+		if err != nil {
+			return err
 		}
-		resp, err := cli.ContainerCreate(ctx,
-			contCfg, hostCfg, nil, nil, host.ContainerName)
-		utils.Check(err)
-		containerID = resp.ID
-	} else { // container exists
-		containerID = li[0].ID
+		journal.Record(journal.Container, host.ContainerName)
 	}
+	stopped := exists && isStopped(rt, host.ContainerName)
 
 	// Copy files
-	host.CopyFiles()
+	if err := host.CopyFiles(); err != nil {
+		return err
+	}
 
 	// Start container
-	if err := cli.ContainerStart(ctx, containerID, types.ContainerStartOptions{}); err != nil {
-		panic(err)
+	if err := rt.Start(host.ContainerName); err != nil {
+		return err
+	}
+	if stopped {
+		journal.Record(journal.Started, host.ContainerName)
 	}
 
 	if config.VFlag {
 		fmt.Println(host.ContainerName, "started.")
 	}
+	return nil
 }
 
-func (host *Host) StopContainer(wg *sync.WaitGroup) {
-	ctx := context.Background()
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-	utils.Check(err)
-
-	if wg != nil {
-		defer wg.Done()
-	}
-
-	if err := cli.ContainerStop(ctx, host.ContainerName, nil); err != nil {
-		panic(err)
-	}
+func (host *Host) StopContainer() error {
+	return runtime.Current().Stop(host.ContainerName)
 }
 
-func (host *Host) CopyFiles() {
+func (host *Host) CopyFiles() error {
 	for _, f := range host.Files {
-		out, err := exec.Command(
-			"docker",
-			"cp",
-			f.HostPath,
-			host.ContainerName+":"+f.ContainerPath,
-		).CombinedOutput()
-		if err != nil {
-			utils.Fatalln(string(out), err)
+		if err := runtime.Current().CopyTo(host.ContainerName, f.HostPath, f.ContainerPath); err != nil {
+			return err
 		}
 	}
+	return nil
 }
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/rahveiz/topomate/config"
+	"github.com/rahveiz/topomate/internal/ovsdocker"
 	"github.com/rahveiz/topomate/utils"
 )
 
@@ -27,10 +28,12 @@ type NetInterface struct {
 	Description string
 	IP          net.IPNet
 	Speed       int
+	MTU         int
 	External    bool
 	Cost        int
 	VRF         string
 	IGP         IGPSettings
+	Netem       ovsdocker.Netem
 }
 
 type LinkItem struct {
@@ -52,6 +55,7 @@ func NewLinkItem(router *Router) *LinkItem {
 			IfName: ifName,
 			IP:     net.IPNet{},
 			Speed:  10000,
+			MTU:    DefaultMTU,
 			Cost:   10000,
 		},
 	}
@@ -75,9 +79,9 @@ func (a *AutonomousSystem) SetupManual(lm config.InternalLinks, noCost bool) []L
 			utils.Fatalln("Manual link setup error: please provide either a file or specs")
 		}
 		if filepath.IsAbs(lm.Filepath) {
-			links = a.internalFromFile(lm.Filepath)
+			links = a.internalFromFile(lm.Filepath, lm)
 		} else {
-			links = a.internalFromFile(config.ConfigDir + "/" + lm.Filepath)
+			links = a.internalFromFile(config.ConfigDir+"/"+lm.Filepath, lm)
 		}
 	} else {
 		links = make([]Link, len(lm.Specs))
@@ -98,6 +102,16 @@ func (a *AutonomousSystem) SetupManual(lm config.InternalLinks, noCost bool) []L
 			}
 			l.First.Interface.Description = fmt.Sprintf("linked to %s", s.Hostname)
 			l.Second.Interface.Description = fmt.Sprintf("linked to %s", f.Hostname)
+			netemCfg, err := netemOptions(lm.Netem, v)
+			if err != nil {
+				utils.Fatalln("Manual link setup error:", err)
+			}
+			l.SetNetem(mustParseNetem(netemCfg, "Manual link setup error"))
+			mtu, err := mtuOption(lm.MTU, v)
+			if err != nil {
+				utils.Fatalln("Manual link setup error:", err)
+			}
+			l.SetMTU(mtu)
 			links[idx] = l
 		}
 	}
@@ -122,6 +136,8 @@ func (a *AutonomousSystem) SetupRing(lm config.InternalLinks, noCost bool) []Lin
 	if nbRouters < 3 {
 		utils.Fatalln("Cannot create ring topology with less than 3 routers.")
 	}
+	netem := mustParseNetem(lm.Netem, fmt.Sprintf("AS%d links", a.ASN))
+	mtu := mustParseMTU(lm.MTU, fmt.Sprintf("AS%d links", a.ASN))
 	links := make([]Link, nbRouters)
 	for i := 1; i <= nbRouters; i++ {
 		f := a.getRouter(i)
@@ -150,6 +166,8 @@ func (a *AutonomousSystem) SetupRing(lm config.InternalLinks, noCost bool) []Lin
 
 		links[i-1].First.Interface.Description = fmt.Sprintf("linked to %s", s.Hostname)
 		links[i-1].Second.Interface.Description = fmt.Sprintf("linked to %s", f.Hostname)
+		links[i-1].SetNetem(netem)
+		links[i-1].SetMTU(mtu)
 	}
 	return links
 }
@@ -160,6 +178,8 @@ func (a *AutonomousSystem) SetupFullMesh(lm config.InternalLinks, noCost bool) [
 	// if nbRouters < 2 {
 	// 	return nil
 	// }
+	netem := mustParseNetem(lm.Netem, fmt.Sprintf("AS%d links", a.ASN))
+	mtu := mustParseMTU(lm.MTU, fmt.Sprintf("AS%d links", a.ASN))
 	links := make([]Link, nbRouters*(nbRouters-1)/2)
 	counter := 0
 	for i := 1; i <= nbRouters; i++ {
@@ -189,6 +209,8 @@ func (a *AutonomousSystem) SetupFullMesh(lm config.InternalLinks, noCost bool) [
 			}
 			links[counter].First.Interface.Description = fmt.Sprintf("linked to %s", s.Hostname)
 			links[counter].Second.Interface.Description = fmt.Sprintf("linked to %s", f.Hostname)
+			links[counter].SetNetem(netem)
+			links[counter].SetMTU(mtu)
 			counter++
 		}
 	}
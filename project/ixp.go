@@ -1,6 +1,7 @@
 package project
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"strconv"
@@ -8,8 +9,6 @@ import (
 
 	"github.com/apparentlymart/go-cidr/cidr"
 	"github.com/rahveiz/topomate/config"
-	"github.com/rahveiz/topomate/internal/link"
-	"github.com/rahveiz/topomate/internal/ovsdocker"
 	"github.com/rahveiz/topomate/utils"
 )
 
@@ -61,9 +60,10 @@ func (p *Project) parseIXPConfig(cfg config.IXPConfig) IXP {
 
 	ixp.Links = append(ixp.Links, NewExtLinkItem(ixp.ASN, ixp.RouteServer))
 	ixp.Links[0].Interface.IP = ixp.Network.NextIP()
+	ixp.Links[0].Interface.MTU = mustParseMTU(cfg.MTU, name)
 
 	for _, peer := range cfg.Peers {
-		fields := strings.Fields(peer)
+		fields, opts := splitFields(strings.Fields(peer))
 		if len(fields) == 0 {
 			continue
 		}
@@ -89,6 +89,15 @@ func (p *Project) parseIXPConfig(cfg config.IXPConfig) IXP {
 			l.Interface.SetSpeedAndCost(speed)
 		}
 
+		netemCfg, err := netemOptions(cfg.Netem, opts)
+		if err != nil {
+			utils.Fatalf("IXP link error: peer entry %s: %v\n", fields[0], err)
+		}
+		l.Interface.Netem = mustParseNetem(netemCfg, "IXP link error: peer entry "+fields[0])
+		if l.Interface.MTU, err = mtuOption(cfg.MTU, opts); err != nil {
+			utils.Fatalf("IXP link error: peer entry %s: %v\n", fields[0], err)
+		}
+
 		l.Interface.IP = ixp.Network.NextIP()
 		l.Interface.Description = fmt.Sprint("Linked to IXP ", ixp.ASN)
 		ixp.Links = append(ixp.Links, l)
@@ -143,32 +152,10 @@ func (ixp *IXP) linkIXP() {
 	}
 }
 
-func (p *Project) ApplyIXPLinks() {
-	for _, ixp := range p.IXPs {
-		brName := fmt.Sprintf("ixp-%d", ixp.ASN)
-		link.CreateBridge(brName)
-
-		for _, lnk := range ixp.Links {
-			settings := ovsdocker.DefaultParams()
-			hostIf := ovsdocker.OVSInterface{}
-
-			settings.Speed = lnk.Interface.Speed
-			link.AddPortToContainer(brName,
-				lnk.Interface.IfName,
-				lnk.Router.ContainerName,
-				settings, &hostIf, true)
-			if _, ok := p.AllLinks[lnk.Router.ContainerName]; !ok {
-				p.AllLinks[lnk.Router.ContainerName] = make([]ovsdocker.OVSInterface, 0, len(p.Ext))
-			}
-			p.AllLinks[lnk.Router.ContainerName] = append(p.AllLinks[lnk.Router.ContainerName], hostIf)
-		}
-
-	}
+func (p *Project) ApplyIXPLinks(ctx context.Context) error {
+	return p.applyLinks(ctx, LinkIXP)
 }
 
 func (p *Project) RemoveIXPLinks() {
-	for _, ixp := range p.IXPs {
-		brName := fmt.Sprintf("ixp-%d", ixp.ASN)
-		link.DeleteBridge(brName)
-	}
+	p.removeLinks(LinkIXP)
 }
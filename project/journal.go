@@ -0,0 +1,110 @@
+package project
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rahveiz/topomate/internal/journal"
+	"github.com/rahveiz/topomate/internal/link"
+	"github.com/rahveiz/topomate/internal/ovsdocker"
+	"github.com/rahveiz/topomate/internal/runtime"
+	"github.com/rahveiz/topomate/utils"
+)
+
+// JournalFile returns the path of the file where the resources created
+// while starting the project name are recorded
+func JournalFile(name string) string {
+	name = strings.Replace(name, string(filepath.Separator), "_", -1)
+	return filepath.Join(utils.GetDirectoryFromKey("MainDir", ""), "journal", name+".jsonl")
+}
+
+// openJournal opens the journal of the project and makes it active
+func (p *Project) openJournal() *journal.Journal {
+	path := JournalFile(p.Name)
+	if _, err := os.Stat(path); err == nil {
+		fmt.Fprintf(os.Stderr, "A previous start of %s did not complete, its resources are kept in the journal\n", p.Name)
+	}
+	j, err := journal.Open(path)
+	if err != nil {
+		utils.PrintError("cannot open the journal, resources will not be rolled back:", err)
+		return nil
+	}
+	journal.SetActive(j)
+	return j
+}
+
+// isStopped returns true if the existing container name is not running, it
+// is recorded once started so that a rollback stops it again
+func isStopped(rt runtime.Runtime, name string) bool {
+	if !journal.Enabled() {
+		return false
+	}
+	pid, err := rt.PID(name)
+	return err == nil && pid == 0
+}
+
+// rollbackEntry removes a resource recorded in a journal
+func rollbackEntry(e journal.Entry) error {
+	switch e.Kind {
+	case journal.Container:
+		return runtime.Current().Remove(e.Name)
+	case journal.Started:
+		return runtime.Current().Stop(e.Name)
+	case journal.OVSBridge:
+		return utils.OVSClient().VSwitch.DeleteBridge(e.Name)
+	case journal.LinuxBridge, journal.Iface:
+		// interfaces are removed with the namespace they were moved to
+		if err := ovsdocker.ExecLink("show", e.Name); err != nil {
+			return nil
+		}
+		return ovsdocker.ExecLink("del", e.Name)
+	case journal.NetNS:
+		return utils.Run(utils.ExecSudo("rm", "-f", e.Name))
+	}
+	return fmt.Errorf("unknown resource kind %s", e.Kind)
+}
+
+// Rollback removes the resources of a journal in reverse order. All the
+// resources are processed, the errors are returned together.
+func Rollback(entries []journal.Entry) error {
+	done := make(map[journal.Entry]bool, len(entries))
+	errs := make([]string, 0)
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if done[e] {
+			continue
+		}
+		done[e] = true
+		if err := rollbackEntry(e); err != nil {
+			errs = append(errs, fmt.Sprintf("%s %s: %v", e.Kind, e.Name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("rollback: %s", strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+// CleanupJournal removes the resources recorded in the journal of the
+// project name, left by a start which did not complete. The journal is
+// deleted if all resources are removed.
+func CleanupJournal(name string) error {
+	path := JournalFile(name)
+	entries, err := journal.Read(path)
+	if err != nil {
+		return err
+	}
+	if err := Rollback(entries); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// removeSavedFiles removes the files describing the running topology
+func removeSavedFiles() {
+	os.Remove(utils.GetDirectoryFromKey("MainDir", "") + "/links.json")
+	os.Remove(StateFile())
+	link.ClearStates()
+}
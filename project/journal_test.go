@@ -0,0 +1,187 @@
+package project
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rahveiz/topomate/internal/link"
+	"github.com/rahveiz/topomate/internal/ovsdocker"
+	"github.com/rahveiz/topomate/internal/runtime"
+	"github.com/spf13/viper"
+)
+
+// fakeRuntime keeps the containers in memory
+type fakeRuntime struct {
+	mu      sync.Mutex
+	created map[string]bool
+	running map[string]bool
+	removed []string
+	stopped []string
+}
+
+func (*fakeRuntime) Name() string         { return "fake" }
+func (*fakeRuntime) Pull(string) error    { return nil }
+func (*fakeRuntime) Restart(string) error { return nil }
+
+func (r *fakeRuntime) Exists(name string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.created[name], nil
+}
+
+func (r *fakeRuntime) Create(s runtime.Spec) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.created[s.Name] = true
+	return nil
+}
+
+func (r *fakeRuntime) Start(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.running[name] = true
+	return nil
+}
+
+func (r *fakeRuntime) Stop(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.running, name)
+	r.stopped = append(r.stopped, name)
+	return nil
+}
+
+func (r *fakeRuntime) Remove(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.created, name)
+	delete(r.running, name)
+	r.removed = append(r.removed, name)
+	return nil
+}
+
+func (*fakeRuntime) Exec(string, ...string) (string, int, error) { return "", 0, nil }
+func (*fakeRuntime) CopyTo(string, string, string) error         { return nil }
+func (*fakeRuntime) CopyFrom(string, string, string) error       { return nil }
+
+func (r *fakeRuntime) PID(name string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running[name] {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func (*fakeRuntime) List(map[string]string) ([]runtime.Info, error) {
+	return nil, nil
+}
+
+var errAddPort = errors.New("injected AddPort failure")
+
+// failingBackend fails to add the ports
+type failingBackend struct{}
+
+func (failingBackend) Name() string { return "failing" }
+
+func (failingBackend) Connect(segment string, a, b link.Endpoint) ([]ovsdocker.OVSInterface, error) {
+	return nil, &ovsdocker.LinkError{Op: "add", Iface: a.IfName, Err: errAddPort}
+}
+
+func (failingBackend) Attach(segment string, e link.Endpoint) (ovsdocker.OVSInterface, error) {
+	return ovsdocker.OVSInterface{}, &ovsdocker.LinkError{Op: "add", Iface: e.IfName, Err: errAddPort}
+}
+
+func (failingBackend) Detach(link.Port) error     { return nil }
+func (failingBackend) RemoveSegment(string) error { return nil }
+func (failingBackend) Flush() error               { return nil }
+
+func TestStartAllRollback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "topomate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	viper.Set("MainDir", dir)
+	viper.Set("ConfigDir", dir)
+	defer viper.Set("MainDir", nil)
+	defer viper.Set("ConfigDir", nil)
+
+	newLinkBackend = func(string) (link.Backend, error) { return failingBackend{}, nil }
+	defer func() { newLinkBackend = link.NewBackend }()
+	// the fake PIDs must not be used to enter a namespace
+	sysctls := setNamespaceSysctls
+	setNamespaceSysctls = func(string, map[string]string) error { return nil }
+	defer func() { setNamespaceSysctls = sysctls }()
+
+	tests := []struct {
+		name     string
+		existing map[string]bool // existing containers, true if running
+		removed  []string
+		stopped  []string
+	}{
+		{
+			name:    "created",
+			removed: []string{"AS1-R1", "AS1-R2"},
+		},
+		{
+			name:     "existing stopped",
+			existing: map[string]bool{"AS1-R1": false},
+			removed:  []string{"AS1-R2"},
+			stopped:  []string{"AS1-R1"},
+		},
+		{
+			name:     "existing running",
+			existing: map[string]bool{"AS1-R1": true, "AS1-R2": true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := &fakeRuntime{created: make(map[string]bool), running: make(map[string]bool)}
+			for name, running := range tt.existing {
+				rt.created[name] = true
+				rt.running[name] = running
+			}
+			runtime.SetCurrent(rt)
+
+			r1 := &Router{ID: 1, Hostname: "R1", ContainerName: "AS1-R1"}
+			r2 := &Router{ID: 2, Hostname: "R2", ContainerName: "AS1-R2"}
+			p := &Project{
+				Name:        "rollback",
+				LinkBackend: link.BackendVeth,
+				AS: map[int]*AutonomousSystem{1: {
+					ASN:     1,
+					Routers: []*Router{r1, r2},
+					Links:   []Link{{First: NewLinkItem(r1), Second: NewLinkItem(r2)}},
+				}},
+			}
+
+			err := p.StartAll(context.Background(), "all")
+			if err == nil || !strings.Contains(err.Error(), errAddPort.Error()) {
+				t.Fatalf("StartAll error = %v, want %v", err, errAddPort)
+			}
+			sort.Strings(rt.removed)
+			if !reflect.DeepEqual(rt.removed, tt.removed) {
+				t.Errorf("removed containers = %v, want %v", rt.removed, tt.removed)
+			}
+			if !reflect.DeepEqual(rt.stopped, tt.stopped) {
+				t.Errorf("stopped containers = %v, want %v", rt.stopped, tt.stopped)
+			}
+			for name, running := range tt.existing {
+				if rt.running[name] != running {
+					t.Errorf("%s running = %v, want %v", name, rt.running[name], running)
+				}
+			}
+			if _, err := os.Stat(JournalFile(p.Name)); !os.IsNotExist(err) {
+				t.Errorf("journal not removed after the rollback: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package project
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/rahveiz/topomate/utils"
+)
+
+// MTU bounds
+const (
+	DefaultMTU = 1500
+	minMTU     = 68
+	maxMTU     = 65535
+)
+
+// parseMTU validates an MTU value, 0 meaning the default MTU
+func parseMTU(v int) (int, error) {
+	if v == 0 {
+		return DefaultMTU, nil
+	}
+	if v < minMTU || v > maxMTU {
+		return 0, fmt.Errorf("mtu must be between %d and %d (%d)", minMTU, maxMTU, v)
+	}
+	return v, nil
+}
+
+// mustParseMTU validates an MTU value and exits on error
+func mustParseMTU(v int, context string) int {
+	mtu, err := parseMTU(v)
+	if err != nil {
+		utils.Fatalf("%s: %v\n", context, err)
+	}
+	return mtu
+}
+
+// mtuOption overrides base with the mtu option present in opts
+func mtuOption(base int, opts map[string]string) (int, error) {
+	if v, ok := opts["mtu"]; ok {
+		mtu, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, fmt.Errorf("mtu: %v", err)
+		}
+		base = mtu
+	}
+	return parseMTU(base)
+}
+
+// SetMTU sets the MTU on both ends of the link
+func (l Link) SetMTU(v int) {
+	l.First.Interface.MTU = v
+	l.Second.Interface.MTU = v
+}
+
+// MTUMismatches returns a description of every link whose ends do not use
+// the same MTU. On an IXP, each peer is compared to the route server.
+func (p *Project) MTUMismatches() []string {
+	res := make([]string, 0, 4)
+	check := func(c1 string, i1 *NetInterface, c2 string, i2 *NetInterface) {
+		if i1.MTU != i2.MTU {
+			res = append(res, fmt.Sprintf("%s %s (%d) <-> %s %s (%d)",
+				c1, i1.IfName, i1.MTU, c2, i2.IfName, i2.MTU))
+		}
+	}
+	for _, asn := range p.sortedASN() {
+		as := p.AS[asn]
+		for _, l := range as.Links {
+			check(l.First.Router.ContainerName, l.First.Interface,
+				l.Second.Router.ContainerName, l.Second.Interface)
+		}
+		for _, l := range as.HostLinks {
+			check(l.Router.Router.ContainerName, l.Router.Interface,
+				l.Host.Host.ContainerName, l.Host.Interface)
+		}
+	}
+	for _, l := range p.Ext {
+		check(l.From.Router.ContainerName, l.From.Interface,
+			l.To.Router.ContainerName, l.To.Interface)
+	}
+	for _, ixp := range p.IXPs {
+		rs := ixp.Links[0]
+		for _, l := range ixp.Links[1:] {
+			check(rs.Router.ContainerName, rs.Interface,
+				l.Router.ContainerName, l.Interface)
+		}
+	}
+	return res
+}
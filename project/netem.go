@@ -0,0 +1,105 @@
+package project
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rahveiz/topomate/config"
+	"github.com/rahveiz/topomate/internal/ovsdocker"
+	"github.com/rahveiz/topomate/utils"
+)
+
+var rateRegexp = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?(bit|kbit|mbit|gbit|tbit|bps|kbps|mbps|gbps|tbps)?$`)
+
+// ParseNetem converts a link emulation configuration
+func ParseNetem(cfg config.NetemConfig) (ovsdocker.Netem, error) {
+	n := ovsdocker.Netem{
+		Loss:    cfg.Loss,
+		Corrupt: cfg.Corrupt,
+		Rate:    strings.ToLower(cfg.Rate),
+	}
+	var err error
+	if cfg.Delay != "" {
+		if n.Delay, err = time.ParseDuration(cfg.Delay); err != nil {
+			return n, fmt.Errorf("delay: %v", err)
+		}
+	}
+	if cfg.Jitter != "" {
+		if n.Jitter, err = time.ParseDuration(cfg.Jitter); err != nil {
+			return n, fmt.Errorf("jitter: %v", err)
+		}
+		if n.Delay == 0 {
+			return n, fmt.Errorf("jitter needs a delay")
+		}
+	}
+	if n.Loss < 0 || n.Loss > 100 {
+		return n, fmt.Errorf("loss must be between 0 and 100 (%v)", n.Loss)
+	}
+	if n.Corrupt < 0 || n.Corrupt > 100 {
+		return n, fmt.Errorf("corrupt must be between 0 and 100 (%v)", n.Corrupt)
+	}
+	if n.Rate != "" && !rateRegexp.MatchString(n.Rate) {
+		return n, fmt.Errorf("invalid rate %s", cfg.Rate)
+	}
+	return n, nil
+}
+
+// mustParseNetem converts a link emulation configuration and exits on error
+func mustParseNetem(cfg config.NetemConfig, context string) ovsdocker.Netem {
+	n, err := ParseNetem(cfg)
+	if err != nil {
+		utils.Fatalf("%s: %v\n", context, err)
+	}
+	return n
+}
+
+// splitFields separates the positional fields of a line from the key=value
+// options
+func splitFields(fields []string) (positional []string, options map[string]string) {
+	positional = make([]string, 0, len(fields))
+	options = make(map[string]string, 4)
+	for _, f := range fields {
+		if kv := strings.SplitN(f, "=", 2); len(kv) == 2 {
+			options[strings.ToLower(kv[0])] = kv[1]
+		} else {
+			positional = append(positional, f)
+		}
+	}
+	return
+}
+
+// netemOptions overrides the settings of base with the options (delay,
+// jitter, loss, corrupt, rate) present in opts
+func netemOptions(base config.NetemConfig, opts map[string]string) (config.NetemConfig, error) {
+	res := base
+	for k, v := range opts {
+		var err error
+		switch k {
+		case "delay":
+			res.Delay = v
+		case "jitter":
+			res.Jitter = v
+		case "loss":
+			res.Loss, err = strconv.ParseFloat(strings.TrimSuffix(v, "%"), 64)
+		case "corrupt":
+			res.Corrupt, err = strconv.ParseFloat(strings.TrimSuffix(v, "%"), 64)
+		case "rate":
+			res.Rate = v
+		default:
+			continue
+		}
+		if err != nil {
+			return res, fmt.Errorf("%s: %v", k, err)
+		}
+	}
+	return res, nil
+}
+
+// SetNetem sets the emulated conditions on both ends of the link
+func (l Link) SetNetem(n ovsdocker.Netem) {
+	l.First.Interface.Netem = n
+	l.Second.Interface.Netem = n
+}
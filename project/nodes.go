@@ -0,0 +1,306 @@
+package project
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/rahveiz/topomate/utils"
+)
+
+// Roles of the nodes of a project
+const (
+	RoleP    = "P"
+	RolePE   = "PE"
+	RoleCE   = "CE"
+	RoleRS   = "RS"
+	RoleHost = "host"
+)
+
+// Node represents a container of the project, with the informations needed
+// to select it. Either Router or Host is set depending on the role.
+type Node struct {
+	ContainerName string
+	Hostname      string
+	ASN           int
+	Role          string
+	Router        *Router
+	Host          *Host
+}
+
+// NodeFilter is used to select nodes of a project. Empty fields match
+// all the nodes.
+type NodeFilter struct {
+	ASN      []int
+	Roles    []string
+	Patterns []string
+}
+
+// IsRouter returns true if the node runs FRR
+func (n Node) IsRouter() bool {
+	return n.Router != nil
+}
+
+// ConfigPath returns the path of the FRR configuration file of the node in
+// the configuration directory, or an empty string for hosts
+func (n Node) ConfigPath() string {
+	dir := utils.GetDirectoryFromKey("ConfigDir", "")
+	switch n.Role {
+	case RoleHost:
+		return ""
+	case RoleCE:
+		return fmt.Sprintf("%s/conf_cust_%s", dir, n.Hostname)
+	default:
+		return fmt.Sprintf("%s/conf_%d_%s", dir, n.ASN, n.Hostname)
+	}
+}
+
+// sortedASN returns the ASN of the project in ascending order
+func (p *Project) sortedASN() []int {
+	res := make([]int, 0, len(p.AS))
+	for asn := range p.AS {
+		res = append(res, asn)
+	}
+	sort.Ints(res)
+	return res
+}
+
+// Nodes returns all the nodes (routers, customers, route-servers and hosts)
+// of the project, sorted by AS then by container name.
+func (p *Project) Nodes() []Node {
+	res := make([]Node, 0, 64)
+	for _, asn := range p.sortedASN() {
+		as := p.AS[asn]
+
+		pe := make(map[*Router]bool, len(as.Routers))
+		for _, vpn := range as.VPN {
+			for _, c := range vpn.Customers {
+				pe[c.Parent] = true
+			}
+		}
+
+		for _, r := range as.Routers {
+			role := RoleP
+			if pe[r] {
+				role = RolePE
+			}
+			res = append(res, Node{
+				ContainerName: r.ContainerName,
+				Hostname:      r.Hostname,
+				ASN:           asn,
+				Role:          role,
+				Router:        r,
+			})
+		}
+		for _, vpn := range as.VPN {
+			for _, c := range vpn.Customers {
+				res = append(res, Node{
+					ContainerName: c.Router.ContainerName,
+					Hostname:      c.Router.Hostname,
+					ASN:           asn,
+					Role:          RoleCE,
+					Router:        c.Router,
+				})
+			}
+		}
+		for _, h := range as.Hosts {
+			res = append(res, Node{
+				ContainerName: h.ContainerName,
+				Hostname:      h.Hostname,
+				ASN:           asn,
+				Role:          RoleHost,
+				Host:          h,
+			})
+		}
+	}
+	for _, ixp := range p.IXPs {
+		res = append(res, Node{
+			ContainerName: ixp.RouteServer.ContainerName,
+			Hostname:      ixp.RouteServer.Hostname,
+			ASN:           ixp.ASN,
+			Role:          RoleRS,
+			Router:        ixp.RouteServer,
+		})
+	}
+	return res
+}
+
+// Match returns true if the node n is selected by the filter
+func (f NodeFilter) Match(n Node) bool {
+	if len(f.ASN) > 0 {
+		found := false
+		for _, asn := range f.ASN {
+			if asn == n.ASN {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.Roles) > 0 {
+		found := false
+		for _, role := range f.Roles {
+			if strings.EqualFold(role, n.Role) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.Patterns) > 0 {
+		for _, pattern := range f.Patterns {
+			if ok, _ := path.Match(pattern, n.ContainerName); ok {
+				return true
+			}
+			if ok, _ := path.Match(pattern, n.Hostname); ok {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// SelectNodes returns the nodes of the project matching the filter
+func (p *Project) SelectNodes(f NodeFilter) []Node {
+	all := p.Nodes()
+	res := make([]Node, 0, len(all))
+	for _, n := range all {
+		if f.Match(n) {
+			res = append(res, n)
+		}
+	}
+	return res
+}
+
+// OnlyAS returns a copy of the project restricted to the AS asns (the whole
+// project if asns is empty). External links are kept if both ends are
+// selected, IXPs if their AS is selected, with their selected members.
+func (p *Project) OnlyAS(asns []int) *Project {
+	if len(asns) == 0 {
+		return p
+	}
+	selected := make(map[int]bool, len(asns))
+	for _, asn := range asns {
+		selected[asn] = true
+	}
+	res := *p
+	res.AS = make(map[int]*AutonomousSystem, len(asns))
+	for asn, as := range p.AS {
+		if selected[asn] {
+			res.AS[asn] = as
+		}
+	}
+	res.Ext = make([]*ExternalLink, 0, len(p.Ext))
+	for _, l := range p.Ext {
+		if selected[l.From.ASN] && selected[l.To.ASN] {
+			res.Ext = append(res.Ext, l)
+		}
+	}
+	res.IXPs = make([]IXP, 0, len(p.IXPs))
+	for _, ixp := range p.IXPs {
+		if !selected[ixp.ASN] {
+			continue
+		}
+		// the first link is the one of the route server
+		links := ixp.Links[:1:1]
+		for _, l := range ixp.Links[1:] {
+			if selected[l.ASN] {
+				links = append(links, l)
+			}
+		}
+		ixp.Links = links
+		res.IXPs = append(res.IXPs, ixp)
+	}
+	return &res
+}
+
+// FindNode returns the node whose container name or hostname is name
+func (p *Project) FindNode(name string) (Node, bool) {
+	for _, n := range p.Nodes() {
+		if n.ContainerName == name {
+			return n, true
+		}
+	}
+	for _, n := range p.Nodes() {
+		if n.Hostname == name {
+			return n, true
+		}
+	}
+	return Node{}, false
+}
+
+// Addresses returns the IP addresses of the node (loopbacks first)
+func (n Node) Addresses() []net.IP {
+	res := make([]net.IP, 0, 8)
+	var links []*NetInterface
+	if n.Router != nil {
+		for _, lo := range n.Router.Loopback {
+			res = append(res, lo.IP)
+		}
+		links = n.Router.Links
+	} else if n.Host != nil {
+		links = n.Host.Links
+	}
+	for _, l := range links {
+		if len(l.IP.IP) > 0 {
+			res = append(res, l.IP.IP)
+		}
+	}
+	return res
+}
+
+// HasAddress returns true if ip is one of the addresses of the node
+func (n Node) HasAddress(ip net.IP) bool {
+	for _, a := range n.Addresses() {
+		if a.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveAddress returns the address designated by s, which can be either
+// an IP address or a node name. For a node, its first loopback is used, or
+// its first interface address if it has no loopback.
+func (p *Project) ResolveAddress(s string) (string, error) {
+	if ip := net.ParseIP(s); ip != nil {
+		return ip.String(), nil
+	}
+	n, ok := p.FindNode(s)
+	if !ok {
+		return "", fmt.Errorf("node %s not found", s)
+	}
+	addrs := n.Addresses()
+	if len(addrs) == 0 {
+		return "", fmt.Errorf("node %s has no address", s)
+	}
+	return addrs[0].String(), nil
+}
+
+// NeighborAddress returns the address used by the router n to peer with
+// the node designated by s (an IP address or a node name)
+func (p *Project) NeighborAddress(n Node, s string) (string, error) {
+	if !n.IsRouter() {
+		return "", fmt.Errorf("%s is not a router", n.ContainerName)
+	}
+	if ip := net.ParseIP(s); ip != nil {
+		return ip.String(), nil
+	}
+	remote, ok := p.FindNode(s)
+	if !ok {
+		return "", fmt.Errorf("node %s not found", s)
+	}
+	for ip := range n.Router.Neighbors {
+		if remote.HasAddress(net.ParseIP(ip)) {
+			return ip, nil
+		}
+	}
+	return "", fmt.Errorf("%s has no BGP session with %s", n.ContainerName, remote.ContainerName)
+}
@@ -0,0 +1,295 @@
+package project
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/rahveiz/topomate/utils"
+)
+
+// globalDomain is the reachability domain of the nodes which are not in a VPN
+const globalDomain = ""
+
+var rttRegexp = regexp.MustCompile(`= [0-9.]+/([0-9.]+)/`)
+
+// ReachResult is the result of the pings between two nodes
+type ReachResult struct {
+	Tested bool    `json:"tested"`
+	OK     bool    `json:"ok"`
+	RTT    float64 `json:"rtt_ms,omitempty"`
+	Error  string  `json:"error,omitempty"`
+}
+
+// ReachMatrix contains the results of the pings between all the nodes of
+// a project. Results[i][j] is the result of the pings from Nodes[i] to the
+// addresses of Nodes[j], listed in Targets[j] (comma-separated).
+type ReachMatrix struct {
+	Nodes   []string        `json:"nodes"`
+	Targets []string        `json:"targets"`
+	Results [][]ReachResult `json:"results"`
+}
+
+type reachNode struct {
+	node      Node
+	addresses []net.IP
+	domains   []string
+}
+
+// reachAddresses returns the addresses pinged on a node: the loopbacks of a
+// router, or all the addresses of a node without loopback
+func reachAddresses(n Node) []net.IP {
+	if n.IsRouter() && len(n.Router.Loopback) > 0 {
+		res := make([]net.IP, len(n.Router.Loopback))
+		for i, lo := range n.Router.Loopback {
+			res[i] = lo.IP
+		}
+		return res
+	}
+	return n.Addresses()
+}
+
+// sourceAddress returns the loopback of a router in the family of dst, or
+// nil if it has none
+func (n reachNode) sourceAddress(dst net.IP) net.IP {
+	if !n.node.IsRouter() {
+		return nil
+	}
+	for _, lo := range n.node.Router.Loopback {
+		if (lo.IP.To4() == nil) == (dst.To4() == nil) {
+			return lo.IP
+		}
+	}
+	return nil
+}
+
+// reachDomains returns the reachability domains of the node. CE routers can
+// only reach the other CE routers of their VPNs, other nodes are in the
+// global domain.
+func (p *Project) reachDomains(n Node) []string {
+	if n.Role != RoleCE {
+		return []string{globalDomain}
+	}
+	res := make([]string, 0, 1)
+	for _, vpn := range p.AS[n.ASN].VPN {
+		for _, c := range vpn.Customers {
+			if c.Router == n.Router {
+				res = append(res, fmt.Sprintf("AS%d-%s", n.ASN, vpn.VRF))
+			}
+		}
+	}
+	return res
+}
+
+func sameDomain(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Reachability pings the addresses of every node (router loopbacks or host
+// addresses) from every other node of the same reachability domain, with at
+// most parallel pings at the same time.
+func (p *Project) Reachability(count, parallel int) *ReachMatrix {
+	all := p.Nodes()
+	nodes := make([]reachNode, 0, len(all))
+	for _, n := range all {
+		addrs := reachAddresses(n)
+		if len(addrs) == 0 {
+			continue
+		}
+		nodes = append(nodes, reachNode{
+			node:      n,
+			addresses: addrs,
+			domains:   p.reachDomains(n),
+		})
+	}
+
+	m := &ReachMatrix{
+		Nodes:   make([]string, len(nodes)),
+		Targets: make([]string, len(nodes)),
+		Results: make([][]ReachResult, len(nodes)),
+	}
+	type pair struct{ src, dst int }
+	pairs := make([]pair, 0, len(nodes)*len(nodes))
+	for i, src := range nodes {
+		m.Nodes[i] = src.node.ContainerName
+		targets := make([]string, len(src.addresses))
+		for k, a := range src.addresses {
+			targets[k] = a.String()
+		}
+		m.Targets[i] = strings.Join(targets, ",")
+		m.Results[i] = make([]ReachResult, len(nodes))
+		for j, dst := range nodes {
+			if i != j && sameDomain(src.domains, dst.domains) {
+				pairs = append(pairs, pair{i, j})
+			}
+		}
+	}
+
+	parallelDo(len(pairs), parallel, func(k int) {
+		src, dst := nodes[pairs[k].src], nodes[pairs[k].dst]
+		r := &m.Results[pairs[k].src][pairs[k].dst]
+		if err := utils.Catch(func() { *r = pingAll(src, dst.addresses, count) }); err != nil {
+			*r = ReachResult{Tested: true, Error: err.Error()}
+		}
+	})
+	return m
+}
+
+// pingAll pings every address from the node src. The result is OK if all
+// the addresses are reachable, its RTT is the highest one.
+func pingAll(src reachNode, addresses []net.IP, count int) ReachResult {
+	res := ReachResult{Tested: true, OK: true}
+	var errs []string
+	for _, a := range addresses {
+		r := pingFrom(src, a, count)
+		if !r.OK {
+			res.OK = false
+			errs = append(errs, a.String()+": "+r.Error)
+		} else if r.RTT > res.RTT {
+			res.RTT = r.RTT
+		}
+	}
+	if !res.OK {
+		res.RTT = 0
+		res.Error = strings.Join(errs, ", ")
+	}
+	return res
+}
+
+// pingFrom pings address from the node src. Routers use their loopback as
+// source address so the return path is tested as well.
+func pingFrom(src reachNode, address net.IP, count int) ReachResult {
+	args := []string{"ping", "-q", "-c", strconv.Itoa(count), "-W", "1"}
+	if address.To4() == nil {
+		args[0] = "ping6"
+	}
+	if lo := src.sourceAddress(address); lo != nil {
+		args = append(args, "-I", lo.String())
+	}
+	args = append(args, address.String())
+
+	out := src.node.Exec(args...)
+	r := ReachResult{Tested: true}
+	if out.Err != nil {
+		r.Error = out.Err.Error()
+		return r
+	}
+	if out.ExitCode != 0 {
+		r.Error = "unreachable"
+		return r
+	}
+	r.OK = true
+	if m := rttRegexp.FindStringSubmatch(out.Output); m != nil {
+		r.RTT, _ = strconv.ParseFloat(m[1], 64)
+	}
+	return r
+}
+
+// Failures returns the number of failed pings
+func (m *ReachMatrix) Failures() (failed, total int) {
+	for _, row := range m.Results {
+		for _, r := range row {
+			if !r.Tested {
+				continue
+			}
+			total++
+			if !r.OK {
+				failed++
+			}
+		}
+	}
+	return
+}
+
+func (r ReachResult) String() string {
+	switch {
+	case !r.Tested:
+		return "-"
+	case r.OK:
+		return strconv.FormatFloat(r.RTT, 'f', 2, 64)
+	default:
+		return "FAIL"
+	}
+}
+
+// WriteText writes the matrix as a table, with sources as rows and
+// destinations as columns (RTT in ms)
+func (m *ReachMatrix) WriteText(dst io.Writer) {
+	width := 6
+	for _, n := range m.Nodes {
+		if len(n) > width {
+			width = len(n)
+		}
+	}
+	fmt.Fprintf(dst, "%-*s", width+2, "src \\ dst")
+	for j := range m.Nodes {
+		fmt.Fprintf(dst, " %6d", j+1)
+	}
+	fmt.Fprintln(dst)
+	for i, n := range m.Nodes {
+		fmt.Fprintf(dst, "%2d %-*s", i+1, width-1, n)
+		for j := range m.Nodes {
+			fmt.Fprintf(dst, " %6s", m.Results[i][j])
+		}
+		fmt.Fprintln(dst)
+	}
+	fmt.Fprintln(dst)
+	for j, n := range m.Nodes {
+		fmt.Fprintf(dst, "%2d: %s (%s)\n", j+1, n, m.Targets[j])
+	}
+	failed, total := m.Failures()
+	fmt.Fprintf(dst, "\n%d/%d pings failed\n", failed, total)
+	if failed > 0 {
+		for i, row := range m.Results {
+			for j, r := range row {
+				if r.Tested && !r.OK {
+					fmt.Fprintf(dst, "  %s -> %s (%s): %s\n", m.Nodes[i], m.Nodes[j], m.Targets[j], r.Error)
+				}
+			}
+		}
+	}
+}
+
+// WriteCSV writes the matrix in CSV format (one line per source)
+func (m *ReachMatrix) WriteCSV(dst io.Writer) error {
+	w := csv.NewWriter(dst)
+	header := make([]string, len(m.Nodes)+1)
+	header[0] = "source"
+	for j, n := range m.Nodes {
+		header[j+1] = n + " (" + m.Targets[j] + ")"
+	}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	for i, n := range m.Nodes {
+		line := make([]string, len(m.Nodes)+1)
+		line[0] = n
+		for j, r := range m.Results[i] {
+			line[j+1] = r.String()
+		}
+		if err := w.Write(line); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// WriteJSON writes the matrix in JSON format
+func (m *ReachMatrix) WriteJSON(dst io.Writer) error {
+	enc := json.NewEncoder(dst)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
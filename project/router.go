@@ -1,20 +1,14 @@
 package project
 
 import (
-	"context"
 	"fmt"
 	"net"
 	"os"
-	"os/exec"
-	"sync"
 
 	"github.com/rahveiz/topomate/config"
-
-	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/filters"
-	"github.com/docker/docker/client"
-	"github.com/rahveiz/topomate/utils"
+	"github.com/rahveiz/topomate/internal/journal"
+	"github.com/rahveiz/topomate/internal/ovsdocker"
+	"github.com/rahveiz/topomate/internal/runtime"
 )
 
 type AddressFamily struct {
@@ -51,6 +45,9 @@ type Router struct {
 	Hostname      string
 	ContainerName string
 	CustomImage   string
+	// MPLS is true for routers of MPLS AS, labels are enabled in their
+	// namespace
+	MPLS          bool
 	Loopback      []net.IPNet
 	Links         []*NetInterface
 	Neighbors     map[string]*BGPNbr
@@ -101,128 +98,132 @@ func (r *Router) NeighborsAF() (af AddressFamily) {
 // StartContainer starts the container for the router. If configPath is set,
 // it also copies the configuration file from the configured directory to
 // the container
-func (r *Router) StartContainer(wg *sync.WaitGroup, configPath string) {
-	ctx := context.Background()
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-	utils.Check(err)
-
-	if wg != nil {
-		defer wg.Done()
-	}
+func (r *Router) StartContainer(configPath string) error {
+	rt := runtime.Current()
 
 	// Check if container already exists
-	var containerID string
-	flt := filters.NewArgs(filters.Arg("name", r.ContainerName))
-	li, err := cli.ContainerList(ctx, types.ContainerListOptions{
-		All:     true,
-		Filters: flt,
-	})
+	exists, err := rt.Exists(r.ContainerName)
 	if err != nil {
-		utils.Fatalln(err)
+		return err
 	}
-	if len(li) == 0 { // container does not exist yet
-		hostCfg := &container.HostConfig{
-			CapAdd: []string{"SYS_ADMIN", "NET_ADMIN"},
-		}
-		// if configPath != "" {
-		// 	hostCfg.Mounts = []mount.Mount{
-		// 		{
-		// 			Type:   mount.TypeBind,
-		// 			Source: configPath,
-		// 			Target: "/etc/frr/frr.conf",
-		// 		},
-		// 	}
-		// }
+	if !exists {
 		image := config.DockerRouterImage
 		if r.CustomImage != "" {
 			image = r.CustomImage
 		}
-		resp, err := cli.ContainerCreate(ctx, &container.Config{
-			Image:           image,
-			Hostname:        r.Hostname,
-			NetworkDisabled: true, // docker networking disabled as we use OVS
-		}, hostCfg, nil, nil, r.ContainerName)
-		utils.Check(err)
-		containerID = resp.ID
-	} else { // container exists
-		containerID = li[0].ID
+		err := rt.Create(runtime.Spec{
+			Name:     r.ContainerName,
+			Image:    image,
+			Hostname: r.Hostname,
+			CapAdd:   []string{"SYS_ADMIN", "NET_ADMIN"},
+			Sysctls:  ovsdocker.NamespaceSysctls(r.MPLS),
+		})
+		if err != nil {
+			return err
+		}
+		journal.Record(journal.Container, r.ContainerName)
 	}
+	stopped := exists && isStopped(rt, r.ContainerName)
 
 	// If configPath is set, copy the configuration into the container
 	if configPath != "" {
-		r.CopyConfig(configPath)
+		if err := r.CopyConfig(configPath); err != nil {
+			return err
+		}
 	}
 
 	// Start container
-	if err := cli.ContainerStart(ctx, containerID, types.ContainerStartOptions{}); err != nil {
-		panic(err)
+	if err := rt.Start(r.ContainerName); err != nil {
+		return err
+	}
+	if stopped {
+		journal.Record(journal.Started, r.ContainerName)
+	}
+
+	// the sysctls of new containers are set by the runtime, existing ones
+	// may have been created without them
+	if exists {
+		if err := setNamespaceSysctls(r.ContainerName, ovsdocker.NamespaceSysctls(r.MPLS)); err != nil {
+			return err
+		}
 	}
 
 	if config.VFlag {
 		fmt.Println(r.ContainerName, "started.")
 	}
-
+	return nil
 }
 
-// StopContainer stops the router container
-func (r *Router) StopContainer(wg *sync.WaitGroup, configPath string) {
-	ctx := context.Background()
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-	utils.Check(err)
-
-	if wg != nil {
-		defer wg.Done()
+// setNamespaceSysctls sets sysctls in the namespace of a running container
+var setNamespaceSysctls = func(container string, values map[string]string) error {
+	c, err := ovsdocker.New(container)
+	if err != nil {
+		return err
 	}
+	return c.SetSysctls(values)
+}
 
+// StopContainer stops the router container
+func (r *Router) StopContainer(configPath string) error {
 	if configPath != "" {
-		r.SaveConfig(configPath)
+		if err := r.SaveConfig(configPath); err != nil {
+			return err
+		}
 	}
 
-	if err := cli.ContainerStop(ctx, r.ContainerName, nil); err != nil {
-		panic(err)
-	}
+	return runtime.Current().Stop(r.ContainerName)
 }
 
 // CopyConfig copies the configuration file configPath to the configuration
 // directory in the container file system.
-func (r *Router) CopyConfig(configPath string) {
-	_, err := exec.Command(
-		"docker",
-		"cp",
-		configPath,
-		r.ContainerName+":/etc/frr/frr.conf",
-	).CombinedOutput()
-	if err != nil {
-		utils.Fatalln(err)
-	}
+func (r *Router) CopyConfig(configPath string) error {
+	return runtime.Current().CopyTo(r.ContainerName, configPath, "/etc/frr/frr.conf")
 }
 
-func (r *Router) SaveConfig(configPath string) {
-	_, err := exec.Command(
-		"docker",
-		"cp",
-		r.ContainerName+":/etc/frr/frr.conf",
-		configPath,
-	).CombinedOutput()
-	if err != nil {
-		utils.Fatalln(err)
-	}
+// SaveConfig copies the configuration file of the container to configPath
+func (r *Router) SaveConfig(configPath string) error {
+	return runtime.Current().CopyFrom(r.ContainerName, "/etc/frr/frr.conf", configPath)
 }
 
 func (r *Router) ReloadConfig() {
-	out, err := exec.Command(
-		"docker",
-		"exec",
-		r.ContainerName,
-		"vtysh",
-		"-b",
-	).CombinedOutput()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s: %s %v\n", r.ContainerName, string(out), err)
+	out, code, err := runtime.Exec(r.ContainerName, "vtysh", "-b")
+	if err != nil || code != 0 {
+		fmt.Fprintf(os.Stderr, "%s: %s %v\n", r.ContainerName, out, err)
 	}
 }
 
 // StartFRR launches the init script inside the container
-func (r *Router) StartFRR() {
-	utils.StartFrr(r.ContainerName)
+func (r *Router) StartFRR() error {
+	return runtime.StartFRR(r.ContainerName)
+}
+
+// HotReload applies the configuration file of the container to the running
+// daemons. frr-reload.py is used if available, so only the differences are
+// applied, otherwise the file is loaded with "vtysh -b".
+func (r *Router) HotReload() error {
+	out, code, err := runtime.Exec(r.ContainerName,
+		"/usr/lib/frr/frr-reload.py", "--reload", "/etc/frr/frr.conf")
+	if err != nil {
+		return err
+	}
+	// 126 and 127 are returned by docker exec if the script is not found
+	if code == 126 || code == 127 {
+		out, code, err = runtime.Exec(r.ContainerName, "vtysh", "-b")
+		if err != nil {
+			return err
+		}
+	}
+	if code != 0 {
+		return fmt.Errorf("%s: reload failed: %s", r.ContainerName, out)
+	}
+	return nil
+}
+
+// RemoveContainer stops and removes the container of the router
+func (r *Router) RemoveContainer() error {
+	return removeContainer(r.ContainerName)
+}
+
+func removeContainer(name string) error {
+	return runtime.Current().Remove(name)
 }
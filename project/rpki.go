@@ -45,6 +45,13 @@ func (p *Project) parseRPKIConfig(rpkiConfig map[string]config.RPKIConfig) {
 
 		linkRTR.Interface.IP, linkRouter.Interface.IP = currentAS.Network.NextLinkIPs()
 
+		netem := mustParseNetem(cfg.RouterLink.Netem, "RPKI server "+hostname)
+		linkRouter.Interface.Netem = netem
+		linkRTR.Interface.Netem = netem
+		mtu := mustParseMTU(cfg.RouterLink.MTU, "RPKI server "+hostname)
+		linkRouter.Interface.MTU = mtu
+		linkRTR.Interface.MTU = mtu
+
 		currentAS.HostLinks = append(currentAS.HostLinks, HostLink{
 			Router: linkRouter,
 			Host:   linkRTR,
@@ -71,6 +78,7 @@ func (p *Project) parseRPKIConfig(rpkiConfig map[string]config.RPKIConfig) {
 			ContainerPath: "/rpki.json",
 		}}
 
+		rtr.Links = append(rtr.Links, linkRTR.Interface)
 		currentAS.Hosts = append(currentAS.Hosts, rtr)
 
 		router.Links = append(router.Links, linkRouter.Interface)
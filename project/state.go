@@ -0,0 +1,128 @@
+package project
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rahveiz/topomate/utils"
+)
+
+// NodeState is the saved state of a running node
+type NodeState struct {
+	Role       string `json:"role"`
+	ASN        int    `json:"asn"`
+	ConfigHash string `json:"config_hash,omitempty"`
+}
+
+// RunningState describes a running topology. It is saved when the topology
+// is started and used to compute the changes applied by the apply command.
+type RunningState struct {
+	Name  string               `json:"name"`
+	Nodes map[string]NodeState `json:"nodes"`
+	Links []ProjectLink        `json:"links"`
+}
+
+// StateFile returns the path of the file where the running state is saved
+func StateFile() string {
+	return filepath.Join(utils.GetDirectoryFromKey("MainDir", ""), "state.json")
+}
+
+// configBlock is a line of an FRR configuration with the indented lines
+// following it
+type configBlock struct {
+	indent   int
+	line     string
+	children []*configBlock
+}
+
+// canonical returns the block with its children sorted, so the order of the
+// siblings does not matter but the block of each line does
+func (b *configBlock) canonical() string {
+	children := make([]string, len(b.children))
+	for i, c := range b.children {
+		children[i] = c.canonical()
+	}
+	sort.Strings(children)
+	return b.line + "{" + strings.Join(children, ";") + "}"
+}
+
+// ConfigFingerprint returns a hash of an FRR configuration. The generated
+// files are not ordered, so the order of the lines of a block (and of the
+// blocks) does not change the hash, but moving a line to another block does.
+func ConfigFingerprint(content string) string {
+	root := &configBlock{indent: -1}
+	stack := []*configBlock{root}
+	for _, l := range strings.Split(content, "\n") {
+		l = strings.TrimRight(l, " ")
+		line := strings.TrimLeft(l, " ")
+		if line == "" || line == "!" {
+			continue
+		}
+		b := &configBlock{indent: len(l) - len(line), line: line}
+		for stack[len(stack)-1].indent >= b.indent {
+			stack = stack[:len(stack)-1]
+		}
+		parent := stack[len(stack)-1]
+		parent.children = append(parent.children, b)
+		stack = append(stack, b)
+	}
+	h := sha256.Sum256([]byte(root.canonical()))
+	return hex.EncodeToString(h[:])
+}
+
+// State returns the state of the project. configs contains the FRR
+// configurations indexed by path, if it is nil the configuration files are
+// read from the configuration directory.
+func (p *Project) State(configs map[string]string) *RunningState {
+	st := &RunningState{
+		Name:  p.Name,
+		Nodes: make(map[string]NodeState, 64),
+		Links: p.ListLinks(),
+	}
+	for _, n := range p.Nodes() {
+		ns := NodeState{Role: n.Role, ASN: n.ASN}
+		if path := n.ConfigPath(); path != "" {
+			if configs != nil {
+				if c, ok := configs[path]; ok {
+					ns.ConfigHash = ConfigFingerprint(c)
+				}
+			} else if c, err := ioutil.ReadFile(path); err == nil {
+				ns.ConfigHash = ConfigFingerprint(string(c))
+			}
+		}
+		st.Nodes[n.ContainerName] = ns
+	}
+	return st
+}
+
+// Save writes the state in the state file
+func (st *RunningState) Save() error {
+	j, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(StateFile(), j, 0644)
+}
+
+// LoadState reads the saved state of the running topology
+func LoadState() (*RunningState, error) {
+	content, err := ioutil.ReadFile(StateFile())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no running state found (start the topology with this version first)")
+		}
+		return nil, err
+	}
+	st := &RunningState{}
+	if err := json.Unmarshal(content, st); err != nil {
+		return nil, fmt.Errorf("%s: %v", StateFile(), err)
+	}
+	return st, nil
+}
@@ -0,0 +1,125 @@
+package project
+
+import "testing"
+
+func TestConfigFingerprint(t *testing.T) {
+	base := `interface eth0
+ ip address 10.0.0.1/30
+ ip ospf cost 10
+!
+interface eth1
+ ip address 10.0.0.5/30
+ ip ospf cost 20
+!
+router bgp 1
+ neighbor 10.1.1.1 remote-as 1
+ neighbor 10.1.1.2 remote-as 1
+ address-family ipv4 unicast
+  neighbor 10.1.1.1 activate
+ exit-address-family
+ address-family ipv6 unicast
+  neighbor 10.1.1.2 activate
+ exit-address-family
+!
+`
+	tests := []struct {
+		name    string
+		content string
+		same    bool
+	}{
+		{
+			name: "blocks and lines reordered",
+			content: `router bgp 1
+ address-family ipv6 unicast
+  neighbor 10.1.1.2 activate
+ exit-address-family
+ neighbor 10.1.1.2 remote-as 1
+ neighbor 10.1.1.1 remote-as 1
+ address-family ipv4 unicast
+  neighbor 10.1.1.1 activate
+ exit-address-family
+!
+interface eth1
+ ip ospf cost 20
+ ip address 10.0.0.5/30
+!
+interface eth0
+ ip ospf cost 10
+ ip address 10.0.0.1/30
+`,
+			same: true,
+		},
+		{
+			name: "costs swapped between interfaces",
+			content: `interface eth0
+ ip address 10.0.0.1/30
+ ip ospf cost 20
+!
+interface eth1
+ ip address 10.0.0.5/30
+ ip ospf cost 10
+!
+router bgp 1
+ neighbor 10.1.1.1 remote-as 1
+ neighbor 10.1.1.2 remote-as 1
+ address-family ipv4 unicast
+  neighbor 10.1.1.1 activate
+ exit-address-family
+ address-family ipv6 unicast
+  neighbor 10.1.1.2 activate
+ exit-address-family
+`,
+		},
+		{
+			name: "addresses swapped between interfaces",
+			content: `interface eth0
+ ip address 10.0.0.5/30
+ ip ospf cost 10
+!
+interface eth1
+ ip address 10.0.0.1/30
+ ip ospf cost 20
+!
+router bgp 1
+ neighbor 10.1.1.1 remote-as 1
+ neighbor 10.1.1.2 remote-as 1
+ address-family ipv4 unicast
+  neighbor 10.1.1.1 activate
+ exit-address-family
+ address-family ipv6 unicast
+  neighbor 10.1.1.2 activate
+ exit-address-family
+`,
+		},
+		{
+			name: "neighbor activated in another address family",
+			content: `interface eth0
+ ip address 10.0.0.1/30
+ ip ospf cost 10
+!
+interface eth1
+ ip address 10.0.0.5/30
+ ip ospf cost 20
+!
+router bgp 1
+ neighbor 10.1.1.1 remote-as 1
+ neighbor 10.1.1.2 remote-as 1
+ address-family ipv4 unicast
+ exit-address-family
+ address-family ipv6 unicast
+  neighbor 10.1.1.1 activate
+  neighbor 10.1.1.2 activate
+ exit-address-family
+`,
+		},
+	}
+
+	ref := ConfigFingerprint(base)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if same := ConfigFingerprint(tt.content) == ref; same != tt.same {
+				t.Errorf("same fingerprint = %v, want %v", same, tt.same)
+			}
+		})
+	}
+}
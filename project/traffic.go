@@ -0,0 +1,137 @@
+package project
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/rahveiz/topomate/config"
+	"github.com/rahveiz/topomate/utils"
+)
+
+// Traffic protocols
+const (
+	ProtoTCP = "tcp"
+	ProtoUDP = "udp"
+)
+
+// DefaultFlowDuration is the duration of a flow without explicit duration
+const DefaultFlowDuration = 10 * time.Second
+
+// TrafficFlow is a flow generated with iperf3 from Source to the Address of
+// Destination
+type TrafficFlow struct {
+	Name        string
+	Source      Node
+	Destination Node
+	Address     string
+	Protocol    string
+	// Rate is the target bitrate in the iperf3 format (empty for TCP means
+	// unlimited)
+	Rate     string
+	Duration time.Duration
+	// Start is the offset from the start of the traffic run
+	Start time.Duration
+}
+
+func (f TrafficFlow) String() string {
+	return fmt.Sprintf("%s -> %s (%s)", f.Source.ContainerName, f.Address, f.Protocol)
+}
+
+// nodeByAddress returns the node owning an address
+func (p *Project) nodeByAddress(ip net.IP) (Node, bool) {
+	for _, n := range p.Nodes() {
+		if n.HasAddress(ip) {
+			return n, true
+		}
+	}
+	return Node{}, false
+}
+
+func (p *Project) parseFlow(c config.TrafficFlow) (TrafficFlow, error) {
+	f := TrafficFlow{
+		Name:     c.Name,
+		Protocol: strings.ToLower(c.Protocol),
+		Rate:     c.Rate,
+		Duration: DefaultFlowDuration,
+	}
+	var ok bool
+	if f.Source, ok = p.FindNode(c.Source); !ok {
+		return f, fmt.Errorf("source node %s not found", c.Source)
+	}
+
+	if ip := net.ParseIP(c.Destination); ip != nil {
+		if f.Destination, ok = p.nodeByAddress(ip); !ok {
+			return f, fmt.Errorf("no node has the address %s", c.Destination)
+		}
+		f.Address = ip.String()
+	} else {
+		if f.Destination, ok = p.FindNode(c.Destination); !ok {
+			return f, fmt.Errorf("destination node %s not found", c.Destination)
+		}
+		addrs := f.Destination.Addresses()
+		if len(addrs) == 0 {
+			return f, fmt.Errorf("destination node %s has no address", c.Destination)
+		}
+		f.Address = addrs[0].String()
+	}
+
+	switch f.Protocol {
+	case "":
+		f.Protocol = ProtoTCP
+	case ProtoTCP, ProtoUDP:
+	default:
+		return f, fmt.Errorf("unknown protocol %s (tcp or udp)", c.Protocol)
+	}
+
+	var err error
+	if c.Duration != "" {
+		if f.Duration, err = time.ParseDuration(c.Duration); err != nil {
+			return f, err
+		}
+		if f.Duration < time.Second {
+			return f, fmt.Errorf("duration %s is shorter than 1s", c.Duration)
+		}
+	}
+	if c.Start != "" {
+		if f.Start, err = time.ParseDuration(c.Start); err != nil {
+			return f, err
+		}
+		if f.Start < 0 {
+			return f, fmt.Errorf("negative start %s", c.Start)
+		}
+	}
+	return f, nil
+}
+
+// parseTraffic parses the traffic flows of the configuration. Flows without
+// name are named after their index.
+func (p *Project) parseTraffic(flows []config.TrafficFlow) {
+	p.Traffic = make([]TrafficFlow, 0, len(flows))
+	names := make(map[string]bool, len(flows))
+	for i, c := range flows {
+		f, err := p.parseFlow(c)
+		if err != nil {
+			utils.Fatalf("traffic flow %d: %v\n", i+1, err)
+		}
+		if f.Name == "" {
+			f.Name = fmt.Sprintf("flow%d", i+1)
+		}
+		if names[f.Name] {
+			utils.Fatalf("traffic flow %d: duplicate name %s\n", i+1, f.Name)
+		}
+		names[f.Name] = true
+		p.Traffic = append(p.Traffic, f)
+	}
+}
+
+// FindFlow returns the traffic flow name
+func (p *Project) FindFlow(name string) (TrafficFlow, bool) {
+	for _, f := range p.Traffic {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return TrafficFlow{}, false
+}
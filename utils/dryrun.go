@@ -0,0 +1,182 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	containertypes "github.com/docker/docker/api/types/container"
+	networktypes "github.com/docker/docker/api/types/network"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Operation types
+const (
+	OpExec   = "exec"
+	OpDocker = "docker"
+)
+
+// Operation is a command or a Docker operation recorded in dry-run mode
+type Operation struct {
+	Seq       int      `json:"seq"`
+	Type      string   `json:"type"`
+	Command   []string `json:"command,omitempty"`
+	Action    string   `json:"action,omitempty"`
+	Container string   `json:"container,omitempty"`
+	Details   string   `json:"details,omitempty"`
+	// Input is the standard input of the command
+	Input string `json:"input,omitempty"`
+}
+
+func (o Operation) String() string {
+	if o.Type == OpExec {
+		return strings.Join(o.Command, " ")
+	}
+	s := "docker-api " + o.Action
+	if o.Container != "" {
+		s += " " + o.Container
+	}
+	if o.Details != "" {
+		s += " (" + o.Details + ")"
+	}
+	return s
+}
+
+// Recorder is a Runner recording the operations instead of running them.
+// Commands produce no output and Docker queries return empty results, so
+// the operations recorded are the ones needed to start a topology from
+// scratch. Container PIDs are unknown and reported as 0.
+type Recorder struct {
+	mu  sync.Mutex
+	ops []Operation
+}
+
+// NewRecorder returns an empty Recorder
+func NewRecorder() *Recorder {
+	return &Recorder{ops: make([]Operation, 0, 256)}
+}
+
+func (r *Recorder) add(op Operation) {
+	r.mu.Lock()
+	op.Seq = len(r.ops) + 1
+	r.ops = append(r.ops, op)
+	r.mu.Unlock()
+}
+
+// Operations returns the operations recorded, in order
+func (r *Recorder) Operations() []Operation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res := make([]Operation, len(r.ops))
+	copy(res, r.ops)
+	return res
+}
+
+// Write displays the operations recorded, one per line
+func (r *Recorder) Write(dst io.Writer) {
+	for _, op := range r.Operations() {
+		fmt.Fprintf(dst, "%4d  %s\n", op.Seq, op)
+		for _, l := range strings.Split(strings.TrimSuffix(op.Input, "\n"), "\n") {
+			if l != "" {
+				fmt.Fprintf(dst, "        %s\n", l)
+			}
+		}
+	}
+}
+
+// WriteJSON writes the operations recorded in JSON format
+func (r *Recorder) WriteJSON(dst io.Writer) error {
+	j, err := json.MarshalIndent(r.Operations(), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = dst.Write(append(j, '\n'))
+	return err
+}
+
+// Run records the command
+func (r *Recorder) Run(cmd *exec.Cmd) error {
+	op := Operation{Type: OpExec, Command: cmd.Args}
+	if cmd.Stdin != nil {
+		if b, err := ioutil.ReadAll(cmd.Stdin); err == nil {
+			op.Input = string(b)
+		}
+	}
+	r.add(op)
+	return nil
+}
+
+// Docker returns the Recorder itself, which records the Docker operations
+func (r *Recorder) Docker(host string) (DockerAPI, error) {
+	return r, nil
+}
+
+func (r *Recorder) ContainerCreate(ctx context.Context, config *containertypes.Config, hostConfig *containertypes.HostConfig, networkingConfig *networktypes.NetworkingConfig, platform *specs.Platform, containerName string) (containertypes.ContainerCreateCreatedBody, error) {
+	var details bytes.Buffer
+	fmt.Fprintf(&details, "image=%s hostname=%s", config.Image, config.Hostname)
+	if len(config.Cmd) > 0 {
+		fmt.Fprintf(&details, " cmd=%q", strings.Join(config.Cmd, " "))
+	}
+	if hostConfig != nil && len(hostConfig.CapAdd) > 0 {
+		fmt.Fprintf(&details, " cap-add=%s", strings.Join(hostConfig.CapAdd, ","))
+	}
+	if hostConfig != nil && len(hostConfig.Sysctls) > 0 {
+		keys := make([]string, 0, len(hostConfig.Sysctls))
+		for k, v := range hostConfig.Sysctls {
+			keys = append(keys, k+"="+v)
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(&details, " sysctl=%s", strings.Join(keys, ","))
+	}
+	r.add(Operation{Type: OpDocker, Action: "create", Container: containerName, Details: details.String()})
+	return containertypes.ContainerCreateCreatedBody{ID: containerName}, nil
+}
+
+func (r *Recorder) ContainerInspect(ctx context.Context, container string) (types.ContainerJSON, error) {
+	r.add(Operation{Type: OpDocker, Action: "inspect", Container: container})
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			Name:  container,
+			State: &types.ContainerState{},
+		},
+	}, nil
+}
+
+func (r *Recorder) ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error) {
+	r.add(Operation{Type: OpDocker, Action: "list", Details: strings.Join(options.Filters.Get("name"), ",")})
+	return nil, nil
+}
+
+func (r *Recorder) ContainerRemove(ctx context.Context, container string, options types.ContainerRemoveOptions) error {
+	r.add(Operation{Type: OpDocker, Action: "remove", Container: container})
+	return nil
+}
+
+func (r *Recorder) ContainerRestart(ctx context.Context, container string, timeout *time.Duration) error {
+	r.add(Operation{Type: OpDocker, Action: "restart", Container: container})
+	return nil
+}
+
+func (r *Recorder) ContainerStart(ctx context.Context, container string, options types.ContainerStartOptions) error {
+	r.add(Operation{Type: OpDocker, Action: "start", Container: container})
+	return nil
+}
+
+func (r *Recorder) ContainerStop(ctx context.Context, container string, timeout *time.Duration) error {
+	r.add(Operation{Type: OpDocker, Action: "stop", Container: container})
+	return nil
+}
+
+func (r *Recorder) ImagePull(ctx context.Context, ref string, options types.ImagePullOptions) (io.ReadCloser, error) {
+	r.add(Operation{Type: OpDocker, Action: "pull", Details: ref})
+	return ioutil.NopCloser(&bytes.Buffer{}), nil
+}
@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	containertypes "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+)
+
+func TestRecorder(t *testing.T) {
+	rec := NewRecorder()
+	SetRunner(rec)
+	defer SetRunner(execRunner{})
+	if !DryRun() {
+		t.Fatal("DryRun() = false with a Recorder")
+	}
+
+	// commands are recorded with their input and produce no output
+	if out, err := Output(exec.Command("ip", "link", "add", "br0", "type", "bridge")); err != nil || len(out) != 0 {
+		t.Fatalf("Output = %q, %v, want no output", out, err)
+	}
+	cmd := exec.Command("ovs-vsctl", "--", "add-br", "br1")
+	cmd.Stdin = strings.NewReader("line 1\n\nline 2\n")
+	if err := Run(cmd); err != nil {
+		t.Fatal(err)
+	}
+
+	cli, err := DockerClient("unix:///var/run/docker.sock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := cli.ImagePull(ctx, "topomate/router:latest", types.ImagePullOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cli.ContainerCreate(ctx,
+		&containertypes.Config{Image: "topomate/router", Hostname: "R1", Cmd: []string{"/sbin/init", "-v"}},
+		&containertypes.HostConfig{
+			CapAdd:  []string{"NET_ADMIN", "SYS_ADMIN"},
+			Sysctls: map[string]string{"net.ipv6.conf.all.forwarding": "1", "net.ipv4.ip_forward": "1"},
+		},
+		nil, nil, "AS1-R1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.ContainerStart(ctx, "AS1-R1", types.ContainerStartOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	list, err := cli.ContainerList(ctx, types.ContainerListOptions{Filters: filters.NewArgs(filters.Arg("name", "AS1-R1"))})
+	if err != nil || len(list) != 0 {
+		t.Fatalf("ContainerList = %v, %v, want no container", list, err)
+	}
+	info, err := cli.ContainerInspect(ctx, "AS1-R1")
+	if err != nil || info.State.Pid != 0 {
+		t.Fatalf("ContainerInspect = %+v, %v, want PID 0", info.State, err)
+	}
+
+	var text bytes.Buffer
+	rec.Write(&text)
+	want := `   1  ip link add br0 type bridge
+   2  ovs-vsctl -- add-br br1
+        line 1
+        line 2
+   3  docker-api pull (topomate/router:latest)
+   4  docker-api create AS1-R1 (image=topomate/router hostname=R1 cmd="/sbin/init -v" cap-add=NET_ADMIN,SYS_ADMIN sysctl=net.ipv4.ip_forward=1,net.ipv6.conf.all.forwarding=1)
+   5  docker-api start AS1-R1
+   6  docker-api list (AS1-R1)
+   7  docker-api inspect AS1-R1
+`
+	if text.String() != want {
+		t.Errorf("Write:\n%s\nwant:\n%s", text.String(), want)
+	}
+
+	var j bytes.Buffer
+	if err := rec.WriteJSON(&j); err != nil {
+		t.Fatal(err)
+	}
+	var ops []Operation
+	if err := json.Unmarshal(j.Bytes(), &ops); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ops, rec.Operations()) {
+		t.Errorf("WriteJSON = %+v, want %+v", ops, rec.Operations())
+	}
+	if op := ops[1]; op.Type != OpExec || op.Input != "line 1\n\nline 2\n" {
+		t.Errorf("second operation = %+v, want the command input", op)
+	}
+	if op := ops[3]; op.Type != OpDocker || op.Action != "create" || op.Container != "AS1-R1" || op.Seq != 4 {
+		t.Errorf("fourth operation = %+v, want the container creation", op)
+	}
+}
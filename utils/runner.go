@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/digitalocean/go-openvswitch/ovs"
+	"github.com/docker/docker/api/types"
+	containertypes "github.com/docker/docker/api/types/container"
+	networktypes "github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// DockerAPI contains the Docker client operations used by topomate
+type DockerAPI interface {
+	ContainerCreate(ctx context.Context, config *containertypes.Config, hostConfig *containertypes.HostConfig, networkingConfig *networktypes.NetworkingConfig, platform *specs.Platform, containerName string) (containertypes.ContainerCreateCreatedBody, error)
+	ContainerInspect(ctx context.Context, container string) (types.ContainerJSON, error)
+	ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error)
+	ContainerRemove(ctx context.Context, container string, options types.ContainerRemoveOptions) error
+	ContainerRestart(ctx context.Context, container string, timeout *time.Duration) error
+	ContainerStart(ctx context.Context, container string, options types.ContainerStartOptions) error
+	ContainerStop(ctx context.Context, container string, timeout *time.Duration) error
+	ImagePull(ctx context.Context, ref string, options types.ImagePullOptions) (io.ReadCloser, error)
+}
+
+// Runner executes the external commands and the Docker operations. The
+// default runner executes them, a Recorder only records them (dry-run).
+type Runner interface {
+	// Run runs a command, its outputs are set by the caller
+	Run(cmd *exec.Cmd) error
+	// Docker returns a Docker client using the API socket host (the
+	// environment is used if host is empty)
+	Docker(host string) (DockerAPI, error)
+}
+
+type execRunner struct{}
+
+func (execRunner) Run(cmd *exec.Cmd) error {
+	return cmd.Run()
+}
+
+func (execRunner) Docker(host string) (DockerAPI, error) {
+	if host != "" {
+		return client.NewClientWithOpts(client.FromEnv, client.WithHost(host), client.WithAPIVersionNegotiation())
+	}
+	return client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+}
+
+var (
+	runner   Runner = execRunner{}
+	runnerMu sync.RWMutex
+)
+
+// SetRunner replaces the runner used for all the operations
+func SetRunner(r Runner) {
+	runnerMu.Lock()
+	runner = r
+	runnerMu.Unlock()
+}
+
+func currentRunner() Runner {
+	runnerMu.RLock()
+	defer runnerMu.RUnlock()
+	return runner
+}
+
+// DryRun returns true if the operations are only recorded
+func DryRun() bool {
+	_, ok := currentRunner().(*Recorder)
+	return ok
+}
+
+// Run runs a command with the current runner
+func Run(cmd *exec.Cmd) error {
+	return currentRunner().Run(cmd)
+}
+
+// CombinedOutput runs a command with the current runner and returns its
+// combined standard output and standard error
+func CombinedOutput(cmd *exec.Cmd) ([]byte, error) {
+	var b bytes.Buffer
+	cmd.Stdout = &b
+	cmd.Stderr = &b
+	err := Run(cmd)
+	return b.Bytes(), err
+}
+
+// Output runs a command with the current runner and returns its standard
+// output
+func Output(cmd *exec.Cmd) ([]byte, error) {
+	var b bytes.Buffer
+	cmd.Stdout = &b
+	err := Run(cmd)
+	return b.Bytes(), err
+}
+
+// DockerClient returns a Docker client from the current runner, using the
+// API socket host
+func DockerClient(host string) (DockerAPI, error) {
+	return currentRunner().Docker(host)
+}
+
+// OVSClient returns an Open vSwitch client running its commands with sudo
+// through the current runner
+func OVSClient() *ovs.Client {
+	return ovs.New(ovs.Sudo(), ovs.Exec(func(cmd string, args ...string) ([]byte, error) {
+		return CombinedOutput(exec.Command(cmd, args...))
+	}))
+}
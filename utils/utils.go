@@ -1,16 +1,16 @@
 package utils
 
 import (
-	"context"
+	"bufio"
+	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"os/exec"
 	"os/user"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 
-	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/client"
 	"github.com/mitchellh/go-homedir"
 	"github.com/rahveiz/topomate/config"
 	"github.com/spf13/viper"
@@ -26,12 +26,57 @@ func PrintError(args ...interface{}) (n int, err error) {
 	return fmt.Fprintln(os.Stderr, args...)
 }
 
+// FatalError is the value of the panic raised by Fatalln and Fatalf when
+// fatal errors are recoverable
+type FatalError struct {
+	Message string
+}
+
+func (e FatalError) Error() string {
+	return e.Message
+}
+
+// fatalPanics is set when the fatal errors must not exit the program
+var fatalPanics int32
+
+// SetFatalRecoverable makes Fatalln and Fatalf panic with a FatalError
+// instead of exiting, so a long-running process can use Catch to turn them
+// into errors
+func SetFatalRecoverable(b bool) {
+	var v int32
+	if b {
+		v = 1
+	}
+	atomic.StoreInt32(&fatalPanics, v)
+}
+
+// Catch calls fn and returns the fatal error or the panic raised by fn
+func Catch(fn func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(FatalError); ok {
+				err = e
+				return
+			}
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	fn()
+	return nil
+}
+
 func Fatalln(args ...interface{}) {
+	if atomic.LoadInt32(&fatalPanics) != 0 {
+		panic(FatalError{strings.TrimSuffix(fmt.Sprintln(args...), "\n")})
+	}
 	fmt.Fprintln(os.Stderr, args...)
 	os.Exit(1)
 }
 
 func Fatalf(format string, args ...interface{}) {
+	if atomic.LoadInt32(&fatalPanics) != 0 {
+		panic(FatalError{strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")})
+	}
 	fmt.Fprintf(os.Stderr, format, args...)
 	os.Exit(1)
 }
@@ -41,16 +86,6 @@ func ExecSudo(arg ...string) *exec.Cmd {
 	return exec.Command("sudo", arg...)
 }
 
-func ExecDocker(cName string, arg ...string) {
-	args := []string{"exec", cName}
-	args = append(args, arg...)
-	out, err := exec.Command("docker", args...).CombinedOutput()
-	if err != nil {
-		fmt.Println(args)
-		Fatalln(string(out), err)
-	}
-}
-
 // GetHome returns the home directory of the user. If sudo is used, it returns
 // the original user home directory.
 func GetHome() string {
@@ -108,62 +143,37 @@ func GetDirectoryFromKey(key, defaultPath string) string {
 	return defaultDir
 }
 
-// PullImages pulls the latest version of docker images used by topomate
-func PullImages() {
-	ctx := context.Background()
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-	if err != nil {
-		panic(err)
-	}
-
-	if config.VFlag {
-		fmt.Print("Pulling latest router image... ")
-	}
-	out, err := cli.ImagePull(ctx, config.DockerRouterImage, types.ImagePullOptions{})
-	if err != nil {
-		panic(err)
-	}
-	if config.VFlag {
-		fmt.Println("Done.")
-	}
-
-	if config.VFlag {
-		fmt.Print("Pulling latest route-server image... ")
-	}
-	out, err = cli.ImagePull(ctx, config.DockerRSImage, types.ImagePullOptions{})
-	if err != nil {
-		panic(err)
-	}
-	if config.VFlag {
-		fmt.Println("Done.")
-	}
-
-	defer out.Close()
-	if _, err := ioutil.ReadAll(out); err != nil {
-		panic(err)
+func ResolveFilePath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
 	}
+	return config.ConfigDir + "/" + path
 }
 
-func StartFrr(cName string) {
-	cmd := exec.Command(
-		"docker",
-		"exec",
-		cName,
-		"/usr/lib/frr/frrinit.sh",
-		"start",
-	)
-	out, err := cmd.CombinedOutput()
-	if config.VFlag {
-		fmt.Println(cmd)
+// AppendJSONLines appends values as JSON lines to the file path, which is
+// created with its directory if needed
+func AppendJSONLines(path string, values []interface{}) error {
+	if len(values) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModeDir|os.ModePerm); err != nil {
+		return err
 	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s: %s %v\n", cName, string(out), err)
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, v := range values {
+		if err := enc.Encode(v); err != nil {
+			f.Close()
+			return err
+		}
 	}
-}
-
-func ResolveFilePath(path string) string {
-	if filepath.IsAbs(path) {
-		return path
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
 	}
-	return config.ConfigDir + "/" + path
+	return f.Close()
 }